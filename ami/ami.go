@@ -2,6 +2,8 @@ package ami
 
 import (
 	"context"
+	goerrors "errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -9,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	gctx "github.com/baetyl/baetyl-go/v2/context"
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
@@ -21,6 +24,18 @@ import (
 
 //go:generate mockgen -destination=../mock/ami.go -package=mock -source=ami.go AMI
 
+// RunModeDocker runs applications on a plain docker engine, which is not known by baetyl-go context
+const RunModeDocker = "docker"
+
+// AppDependsOn the app label to list the apps which must be running before the app is applied, such as "broker,adapter"
+const AppDependsOn = "baetyl-depends-on"
+
+// AppMaintenanceWindow the app label of the maintenance windows to apply the updates of the app, which overrides the node windows
+const AppMaintenanceWindow = "baetyl-maintenance-window"
+
+// NodeStatsAllocatable the key of the node stats extension of the resources allocatable to the apps, the capacity is taken if not reported
+const NodeStatsAllocatable = "allocatable"
+
 const (
 	BaetylGPUStatsExtension  = "baetyl_gpu_stats_extension"
 	BaetylNodeStatsExtension = "baetyl_node_stats_extension"
@@ -43,6 +58,14 @@ type Pipe struct {
 	OutWriter *io.PipeWriter
 	Ctx       context.Context
 	Cancel    context.CancelFunc
+	// Resize receives the window size changes of the terminal
+	Resize chan TerminalSize
+}
+
+// TerminalSize the window size of a terminal
+type TerminalSize struct {
+	Rows uint16
+	Cols uint16
 }
 
 // AMI app model interfaces
@@ -53,7 +76,8 @@ type AMI interface {
 	GetModeInfo() (interface{}, error)
 
 	// app
-	ApplyApp(string, specv1.Application, map[string]specv1.Configuration, map[string]specv1.Secret) error
+	// ApplyApp applies the app, which stops at the steps checking the context once it is done
+	ApplyApp(context.Context, string, specv1.Application, map[string]specv1.Configuration, map[string]specv1.Secret) error
 	DeleteApp(string, specv1.AppInfo) error
 	StatsApps(string) ([]specv1.AppStats, error)
 
@@ -77,6 +101,50 @@ type AMI interface {
 	RPCApp(url string, req *specv1.RPCRequest) (*specv1.RPCResponse, error)
 }
 
+// Watcher is implemented by the AMI which notifies the changes of the apps,
+// so that the app stats are reported without waiting for the next report interval
+type Watcher interface {
+	Changes() <-chan struct{}
+}
+
+// the actions of the objects in the diff of an app
+const (
+	DiffCreate    = "create"
+	DiffUpdate    = "update"
+	DiffUnchanged = "unchanged"
+	DiffPrune     = "prune"
+)
+
+// Differ is implemented by the AMI which previews the changes of an app without applying it
+type Differ interface {
+	DiffApp(ns string, app specv1.Application, cfgs map[string]specv1.Configuration) (*AppDiff, error)
+}
+
+// AppDiff the changes of the objects to apply an app
+type AppDiff struct {
+	App     specv1.AppInfo `yaml:"app" json:"app"`
+	Objects []ObjectDiff   `yaml:"objects" json:"objects"`
+}
+
+// ObjectDiff the change of an object, Fields are the paths of the fields to update
+type ObjectDiff struct {
+	APIVersion string   `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string   `yaml:"kind" json:"kind"`
+	Namespace  string   `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	Name       string   `yaml:"name" json:"name"`
+	Action     string   `yaml:"action" json:"action"`
+	Fields     []string `yaml:"fields,omitempty" json:"fields,omitempty"`
+}
+
+// Summary counts the objects by action, such as "1 to create, 2 to update, 0 to prune"
+func (d *AppDiff) Summary() string {
+	counts := map[string]int{}
+	for _, o := range d.Objects {
+		counts[o.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d to prune", counts[DiffCreate], counts[DiffUpdate], counts[DiffPrune])
+}
+
 type DebugOptions struct {
 	KubeDebugOptions
 	NativeDebugOptions
@@ -100,6 +168,8 @@ type NativeDebugOptions struct {
 	Port     string
 	Username string
 	Password string
+	// Local runs the command on a local pseudo-terminal of the service instance instead of ssh
+	Local bool
 }
 
 type LogsOptions struct {
@@ -120,6 +190,63 @@ type ProcessInfo struct {
 	Ppid int32
 }
 
+// RollbackError is returned by ApplyApp if the new version of an app failed
+// and the previous version is kept running instead, the previous version is
+// empty if there is no version to roll back to, and the failed one is left
+type RollbackError struct {
+	App      specv1.AppInfo
+	Previous specv1.AppInfo
+	Err      error
+}
+
+func (e *RollbackError) Error() string {
+	if e.Previous.Version == "" {
+		return fmt.Sprintf("app (%s) version (%s) failed without a version to roll back to: %s", e.App.Name, e.App.Version, e.Err.Error())
+	}
+	return fmt.Sprintf("app (%s) version (%s) rolled back to version (%s): %s", e.App.Name, e.App.Version, e.Previous.Version, e.Err.Error())
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// AsRollbackError finds the rollback error in the error chain
+func AsRollbackError(err error) (*RollbackError, bool) {
+	var rb *RollbackError
+	if goerrors.As(err, &rb) {
+		return rb, true
+	}
+	return nil, false
+}
+
+// PendingError is returned by ApplyApp if the app is not applied yet, such as waiting for
+// the images to be pulled, the app is applied again in the next round
+type PendingError struct {
+	App    specv1.AppInfo
+	Reason string
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("app (%s) version (%s) is pending: %s", e.App.Name, e.App.Version, e.Reason)
+}
+
+// AsPendingError finds the pending error in the error chain
+func AsPendingError(err error) (*PendingError, bool) {
+	var pe *PendingError
+	if goerrors.As(err, &pe) {
+		return pe, true
+	}
+	return nil, false
+}
+
+// RunMode returns the running mode, including the modes only supported by baetyl
+func RunMode() string {
+	if mode := os.Getenv(gctx.KeyRunMode); mode == RunModeDocker {
+		return mode
+	}
+	return gctx.RunMode()
+}
+
 func NewAMI(mode string, cfg config.AmiConfig, sto *bh.Store) (AMI, error) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	gctx "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/http"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/docker/client"
+	bh "github.com/timshannon/bolthold"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+type dockerImpl struct {
+	cli         client.APIClient
+	runHostPath string
+	usages      map[string]usageSample // container id -> last cpu sample
+	mu          sync.Mutex
+	store       *bh.Store
+	conf        *config.DockerConfig
+	log         *log.Logger
+}
+
+func init() {
+	ami.Register(ami.RunModeDocker, newDockerImpl)
+}
+
+func newDockerImpl(cfg config.AmiConfig, sto *bh.Store) (ami.AMI, error) {
+	cli, err := newClient(cfg.Docker)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	hostPathLib, err := gctx.HostPathLib()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &dockerImpl{
+		cli:         cli,
+		runHostPath: filepath.Join(hostPathLib, "run"),
+		usages:      map[string]usageSample{},
+		store:       sto,
+		conf:        &cfg.Docker,
+		log:         log.With(log.Any("ami", "docker")),
+	}, nil
+}
+
+func newClient(cfg config.DockerConfig) (*client.Client, error) {
+	opts := []client.Opt{client.WithHost(cfg.Host)}
+	if cfg.APIVersion != "" {
+		opts = append(opts, client.WithVersion(cfg.APIVersion))
+	} else {
+		opts = append(opts, client.WithAPIVersionNegotiation())
+	}
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cli, nil
+}
+
+func (d *dockerImpl) GetModeInfo() (interface{}, error) {
+	return d.cli.ServerVersion(context.TODO())
+}
+
+// TODO: impl docker UpdateNodeLabels
+func (d *dockerImpl) UpdateNodeLabels(string, map[string]string) error {
+	return errors.New("failed to update node label, function has not been implemented")
+}
+
+func (d *dockerImpl) RemoteWebsocket(ctx context.Context, option *ami.DebugOptions, pipe ami.Pipe) error {
+	return ami.RemoteWebsocket(ctx, option, pipe)
+}
+
+// RemoteDescribe returns the inspect result of the container
+func (d *dockerImpl) RemoteDescribe(_, _, n string) (string, error) {
+	_, raw, err := d.cli.ContainerInspectWithRaw(context.TODO(), n, false)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var out bytes.Buffer
+	if err = json.Indent(&out, raw, "", "  "); err != nil {
+		return "", errors.Trace(err)
+	}
+	return out.String(), nil
+}
+
+// RPCApp use http client to call baetyl app
+func (d *dockerImpl) RPCApp(url string, req *specv1.RPCRequest) (*specv1.RPCResponse, error) {
+	ops := http.NewClientOptions()
+	ops.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	cli := http.NewClient(ops)
+	d.log.Debug("rpc http start", log.Any("url", url), log.Any("method", req.Method))
+
+	var buf []byte
+	if req.Body != nil {
+		buf = []byte(fmt.Sprintf("%v", req.Body))
+	}
+	res, err := cli.SendUrl(strings.ToUpper(req.Method), url, bytes.NewReader(buf), req.Header)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	response := &specv1.RPCResponse{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+	}
+	response.Body, err = http.HandleResponse(res)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return response, nil
+}
@@ -0,0 +1,560 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	AppName       = "baetyl-app-name"
+	AppVersion    = "baetyl-app-version"
+	AppNamespace  = "baetyl-app-namespace"
+	ServiceName   = "baetyl-service-name"
+	InstanceIndex = "baetyl-instance-index"
+	InitService   = "baetyl-init-service"
+	PrefixBaetyl  = "baetyl-"
+
+	RegistryAddress  = "address"
+	RegistryUsername = "username"
+	RegistryPassword = "password"
+
+	NetworkHost         = "host"
+	ServiceTypeNodePort = "NodePort"
+	MediumMemory        = "Memory"
+	HostPathOrCreate    = "DirectoryOrCreate"
+
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNo        = "no"
+
+	volumesDir  = "volumes"
+	emptyDirDir = "empty"
+)
+
+var (
+	ErrAppTypeNotSupported = errors.New("app type is not supported in docker mode")
+	ErrVolumeNotFound      = errors.New("volume not found in app volumes")
+)
+
+func (d *dockerImpl) ApplyApp(ctx context.Context, ns string, app specv1.Application, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) error {
+	if app.Type == specv1.AppTypeHelm || app.Type == specv1.AppTypeYaml {
+		return errors.Errorf("%s: %s", ErrAppTypeNotSupported.Error(), app.Type)
+	}
+	d.compatibleDeprecatedField(&app)
+
+	auths, err := registryAuths(secs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// remove app's secrets which are image-pull secret actually
+	for i, v := range app.Volumes {
+		if v.Secret != nil {
+			if sec, ok := secs[v.Secret.Name]; ok && isRegistrySecret(sec) {
+				app.Volumes[i].Secret = nil
+			}
+		}
+	}
+	for _, svc := range app.InitServices {
+		if err = d.pullImage(ctx, svc.Image, auths); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, svc := range app.Services {
+		if err = d.pullImage(ctx, svc.Image, auths); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// the containers are swapped without the cancellation once the images are pulled, not to be left half swapped
+	if err = ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	ctx = context.TODO()
+	if !app.HostNetwork {
+		if err = d.checkAndCreateNetwork(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	old, err := d.listContainers(ctx, ns, app.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	appDir := filepath.Join(d.runHostPath, ns, app.Name, app.Version)
+	if err = writeVolumes(appDir, app.Volumes, cfgs, secs); err != nil {
+		return errors.Trace(err)
+	}
+	// the new containers are created before the old ones are removed, so the app keeps running
+	// if the new version fails to be created, then the new containers take the names of the old ones
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var created []createdContainer
+	for i := 0; i < replicaOf(&app); i++ {
+		for _, svc := range app.InitServices {
+			c, err := d.createContainer(ctx, ns, appDir, &app, &svc, i, true, suffix)
+			if err != nil {
+				d.removeContainers(ctx, created)
+				return errors.Trace(err)
+			}
+			created = append(created, *c)
+		}
+		for _, svc := range app.Services {
+			c, err := d.createContainer(ctx, ns, appDir, &app, &svc, i, false, suffix)
+			if err != nil {
+				d.removeContainers(ctx, created)
+				return errors.Trace(err)
+			}
+			created = append(created, *c)
+		}
+	}
+	if err = d.removeOld(ctx, ns, app.Name, app.Version, old); err != nil {
+		d.removeContainers(ctx, created)
+		return errors.Trace(err)
+	}
+	for _, c := range created {
+		if err = d.cli.ContainerRename(ctx, c.id, c.name); err != nil {
+			return errors.Trace(err)
+		}
+		if c.init {
+			err = d.runInitService(ctx, c)
+		} else {
+			err = d.cli.ContainerStart(ctx, c.id, types.ContainerStartOptions{})
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	d.log.Info("ami apply app", log.Any("app", app))
+	return nil
+}
+
+// createdContainer the container created for the new version, which is renamed to name when it is started
+type createdContainer struct {
+	id      string
+	name    string
+	service string
+	init    bool
+}
+
+// removeContainers removes the containers created for the new version which fails to be applied
+func (d *dockerImpl) removeContainers(ctx context.Context, cs []createdContainer) {
+	for _, c := range cs {
+		err := d.cli.ContainerRemove(ctx, c.id, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			d.log.Warn("failed to remove container", log.Any("name", c.name), log.Error(err))
+		}
+	}
+}
+
+// removeOld removes the containers and the volume dirs of the versions replaced
+func (d *dockerImpl) removeOld(ctx context.Context, ns, name, version string, old []types.Container) error {
+	for _, c := range old {
+		err := d.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			return errors.Trace(err)
+		}
+	}
+	dirs, err := os.ReadDir(filepath.Join(d.runHostPath, ns, name))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, dir := range dirs {
+		if dir.Name() == version {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(d.runHostPath, ns, name, dir.Name())); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (d *dockerImpl) DeleteApp(ns string, app specv1.AppInfo) error {
+	if err := d.deleteApplication(ns, app.Name); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.RemoveAll(filepath.Join(d.runHostPath, ns, app.Name)))
+}
+
+func (d *dockerImpl) deleteApplication(ns, name string) error {
+	ctx := context.TODO()
+	cs, err := d.listContainers(ctx, ns, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, c := range cs {
+		err = d.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			return errors.Trace(err)
+		}
+	}
+	d.log.Info("ami delete app", log.Any("name", name))
+	return nil
+}
+
+// listContainers lists all containers of the namespace, or only those of the app if name is not empty
+func (d *dockerImpl) listContainers(ctx context.Context, ns, name string) ([]types.Container, error) {
+	args := filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", AppNamespace, ns)))
+	if name != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", AppName, name))
+	}
+	cs, err := d.cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cs, nil
+}
+
+func (d *dockerImpl) runInitService(ctx context.Context, c createdContainer) error {
+	if err := d.cli.ContainerStart(ctx, c.id, types.ContainerStartOptions{}); err != nil {
+		return errors.Trace(err)
+	}
+	resC, errC := d.cli.ContainerWait(ctx, c.id, container.WaitConditionNotRunning)
+	select {
+	case res := <-resC:
+		if res.StatusCode != 0 {
+			return errors.Errorf("init service (%s) exited with code %d", c.service, res.StatusCode)
+		}
+	case err := <-errC:
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// createContainer creates the container with the name suffixed, which is renamed when the old containers are removed
+func (d *dockerImpl) createContainer(ctx context.Context, ns, appDir string, app *specv1.Application, svc *specv1.Service, index int, init bool, suffix string) (*createdContainer, error) {
+	mounts, err := prepareMounts(appDir, index, app.Volumes, svc)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg, hostCfg, netCfg, err := d.prepareContainer(ns, app, svc, mounts, index, init)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	name := containerName(ns, app.Name, svc.Name, index)
+	res, err := d.cli.ContainerCreate(ctx, cfg, hostCfg, netCfg, nil, name+"."+suffix)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, w := range res.Warnings {
+		d.log.Warn("container created with warning", log.Any("name", svc.Name), log.Any("warning", w))
+	}
+	return &createdContainer{id: res.ID, name: name, service: svc.Name, init: init}, nil
+}
+
+func (d *dockerImpl) prepareContainer(ns string, app *specv1.Application, svc *specv1.Service, mounts []mount.Mount, index int, init bool) (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
+	labels := map[string]string{}
+	for k, v := range app.Labels {
+		labels[k] = v
+	}
+	labels[AppName] = app.Name
+	labels[AppVersion] = app.Version
+	labels[AppNamespace] = ns
+	labels[ServiceName] = svc.Name
+	labels[InstanceIndex] = strconv.Itoa(index)
+	if init {
+		labels[InitService] = "true"
+	}
+
+	var env []string
+	for _, e := range svc.Env {
+		env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for _, p := range svc.Ports {
+		proto := strings.ToLower(p.Protocol)
+		if proto == "" {
+			proto = "tcp"
+		}
+		port, err := nat.NewPort(proto, strconv.Itoa(int(p.ContainerPort)))
+		if err != nil {
+			return nil, nil, nil, errors.Trace(err)
+		}
+		exposed[port] = struct{}{}
+		// host ports can only be bound once, so only the first replica publishes them
+		if index > 0 || app.HostNetwork {
+			continue
+		}
+		hostPort := p.HostPort
+		if p.ServiceType == ServiceTypeNodePort && p.NodePort != 0 {
+			hostPort = p.NodePort
+		}
+		if hostPort != 0 {
+			bindings[port] = append(bindings[port], nat.PortBinding{HostPort: strconv.Itoa(int(hostPort))})
+		}
+	}
+
+	cfg := &container.Config{
+		Image:        svc.Image,
+		Cmd:          svc.Args,
+		Env:          env,
+		Labels:       labels,
+		ExposedPorts: exposed,
+	}
+	if len(svc.Command) > 0 {
+		cfg.Entrypoint = svc.Command
+	}
+
+	hostCfg := &container.HostConfig{
+		Mounts:        mounts,
+		PortBindings:  bindings,
+		RestartPolicy: restartPolicy(app, init),
+	}
+	if sc := svc.SecurityContext; sc != nil {
+		hostCfg.Privileged = sc.Privileged
+	}
+	if svc.Resources != nil {
+		for n, value := range svc.Resources.Limits {
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, nil, nil, errors.Trace(err)
+			}
+			switch n {
+			case "cpu":
+				hostCfg.NanoCPUs = quantity.MilliValue() * 1e6
+			case "memory":
+				hostCfg.Memory = quantity.Value()
+			}
+		}
+	}
+
+	var netCfg *network.NetworkingConfig
+	if app.HostNetwork {
+		hostCfg.NetworkMode = NetworkHost
+	} else {
+		hostCfg.NetworkMode = container.NetworkMode(d.conf.Network)
+		// the aliases play the role of kube service, which resolve to all replicas
+		alias := cutSysServiceRandSuffix(app.Name)
+		netCfg = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				d.conf.Network: {Aliases: []string{alias, alias + "." + ns, svc.Name}},
+			},
+		}
+	}
+	return cfg, hostCfg, netCfg, nil
+}
+
+func (d *dockerImpl) checkAndCreateNetwork(ctx context.Context) error {
+	_, err := d.cli.NetworkInspect(ctx, d.conf.Network, types.NetworkInspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return errors.Trace(err)
+	}
+	d.log.Debug("network not found, will be created", log.Any("network", d.conf.Network))
+	_, err = d.cli.NetworkCreate(ctx, d.conf.Network, types.NetworkCreate{CheckDuplicate: true, Driver: "bridge"})
+	return errors.Trace(err)
+}
+
+func (d *dockerImpl) pullImage(ctx context.Context, image string, auths map[string]string) error {
+	if _, _, err := d.cli.ImageInspectWithRaw(ctx, image); err == nil {
+		return nil
+	}
+	opts := types.ImagePullOptions{}
+	for server, auth := range auths {
+		if strings.HasPrefix(image, server+"/") {
+			opts.RegistryAuth = auth
+			break
+		}
+	}
+	d.log.Info("pull image", log.Any("image", image))
+	reader, err := d.cli.ImagePull(ctx, image, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+	// the pull is finished until the progress stream is drained
+	_, err = io.Copy(io.Discard, reader)
+	return errors.Trace(err)
+}
+
+// writeVolumes writes the data of configs and secrets to the app dir, so that they can be mounted into containers
+func writeVolumes(dir string, vols []specv1.Volume, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) error {
+	for _, v := range vols {
+		if v.Config == nil && v.Secret == nil {
+			continue
+		}
+		vd := filepath.Join(dir, volumesDir, v.Name)
+		if err := os.MkdirAll(vd, 0755); err != nil {
+			return errors.Trace(err)
+		}
+		if v.Config != nil {
+			for name, data := range cfgs[v.Config.Name].Data {
+				if err := os.WriteFile(filepath.Join(vd, name), []byte(data), 0644); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		} else {
+			for name, data := range secs[v.Secret.Name].Data {
+				if err := os.WriteFile(filepath.Join(vd, name), data, 0600); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// prepareMounts maps the volume mounts of the service to docker mounts,
+// empty dirs are shared by the services of the same replica like a kube pod
+func prepareMounts(dir string, index int, vols []specv1.Volume, svc *specv1.Service) ([]mount.Mount, error) {
+	avs := map[string]specv1.Volume{}
+	for _, v := range vols {
+		avs[v.Name] = v
+	}
+	var mounts []mount.Mount
+	for _, vm := range svc.VolumeMounts {
+		av, ok := avs[vm.Name]
+		if !ok {
+			return nil, errors.Errorf("%s: %s", ErrVolumeNotFound.Error(), vm.Name)
+		}
+		m := mount.Mount{
+			Type:     mount.TypeBind,
+			Target:   vm.MountPath,
+			ReadOnly: vm.ReadOnly,
+		}
+		if av.Config != nil || av.Secret != nil {
+			m.Source = filepath.Join(dir, volumesDir, av.Name, vm.SubPath)
+			m.ReadOnly = true
+		} else if av.HostPath != nil {
+			if av.HostPath.Type == HostPathOrCreate {
+				if err := os.MkdirAll(av.HostPath.Path, 0755); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			m.Source = filepath.Join(av.HostPath.Path, vm.SubPath)
+		} else if av.EmptyDir != nil && av.EmptyDir.Medium == MediumMemory {
+			m.Type = mount.TypeTmpfs
+			if len(av.EmptyDir.SizeLimit) > 0 {
+				quantity, err := resource.ParseQuantity(av.EmptyDir.SizeLimit)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: quantity.Value()}
+			}
+		} else if av.EmptyDir != nil {
+			ed := filepath.Join(dir, emptyDirDir, strconv.Itoa(index), av.Name)
+			if err := os.MkdirAll(ed, 0755); err != nil {
+				return nil, errors.Trace(err)
+			}
+			m.Source = filepath.Join(ed, vm.SubPath)
+		} else {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+func registryAuths(secs map[string]specv1.Secret) (map[string]string, error) {
+	auths := map[string]string{}
+	for _, sec := range secs {
+		if !isRegistrySecret(sec) {
+			continue
+		}
+		server := string(sec.Data[RegistryAddress])
+		auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      string(sec.Data[RegistryUsername]),
+			Password:      string(sec.Data[RegistryPassword]),
+			ServerAddress: server,
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		auths[strings.TrimSuffix(server, "/")] = auth
+	}
+	return auths, nil
+}
+
+func restartPolicy(app *specv1.Application, init bool) container.RestartPolicy {
+	if init {
+		return container.RestartPolicy{Name: RestartNo}
+	}
+	if app.Workload != specv1.WorkloadJob {
+		return container.RestartPolicy{Name: RestartAlways}
+	}
+	if app.JobConfig != nil && app.JobConfig.RestartPolicy == "OnFailure" {
+		return container.RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: app.JobConfig.BackoffLimit}
+	}
+	return container.RestartPolicy{Name: RestartNo}
+}
+
+// replicaOf returns the instance count, a daemon set or a job only runs once on the single docker node
+func replicaOf(app *specv1.Application) int {
+	if app.Workload == specv1.WorkloadDaemonSet || app.Workload == specv1.WorkloadJob || app.Replica < 1 {
+		return 1
+	}
+	return app.Replica
+}
+
+func containerName(ns, app, svc string, index int) string {
+	return fmt.Sprintf("%s.%s.%s.%d", ns, app, svc, index)
+}
+
+func isRegistrySecret(secret specv1.Secret) bool {
+	registry, ok := secret.Labels[specv1.SecretLabel]
+	return ok && registry == specv1.SecretRegistry
+}
+
+func cutSysServiceRandSuffix(s string) string {
+	if strings.HasPrefix(s, PrefixBaetyl) {
+		sub := s[len(PrefixBaetyl):]
+		if idx := strings.LastIndex(sub, "-"); idx != -1 {
+			return PrefixBaetyl + sub[:idx]
+		}
+	}
+	return s
+}
+
+func (d *dockerImpl) compatibleDeprecatedField(app *specv1.Application) {
+	// Workload
+	if app.Workload == "" {
+		// compatible with the original one service corresponding to one workload
+		if len(app.Services) > 0 && app.Services[0].Type != "" {
+			app.Workload = app.Services[0].Type
+		} else {
+			app.Workload = specv1.WorkloadDeployment
+		}
+	}
+
+	// HostNetwork
+	if !app.HostNetwork && len(app.Services) > 0 && app.Services[0].HostNetwork {
+		app.HostNetwork = true
+	}
+
+	// Replica
+	if app.Replica == 0 && len(app.Services) > 0 && app.Services[0].Replica != 0 {
+		app.Replica = app.Services[0].Replica
+	}
+
+	// JobConfig
+	if (app.JobConfig == nil || app.JobConfig.RestartPolicy == "") && len(app.Services) > 0 && app.Services[0].JobConfig != nil {
+		app.JobConfig = &specv1.AppJobConfig{
+			Completions:   app.Services[0].JobConfig.Completions,
+			Parallelism:   app.Services[0].JobConfig.Parallelism,
+			BackoffLimit:  app.Services[0].JobConfig.BackoffLimit,
+			RestartPolicy: app.Services[0].JobConfig.RestartPolicy,
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func genApplyApp() (specv1.Application, map[string]specv1.Configuration, map[string]specv1.Secret) {
+	app := specv1.Application{
+		Name:     "app1",
+		Version:  "v1",
+		Workload: specv1.WorkloadDeployment,
+		Replica:  2,
+		InitServices: []specv1.Service{{
+			Name:  "init1",
+			Image: "busybox:latest",
+		}},
+		Services: []specv1.Service{{
+			Name:  "svc1",
+			Image: "registry.baetyl.io/svc1:v1",
+			Args:  []string{"-c", "conf/conf.yml"},
+			Env:   []specv1.Environment{{Name: "k1", Value: "v1"}},
+			Ports: []specv1.ContainerPort{
+				{HostPort: 8080, ContainerPort: 80},
+				{ContainerPort: 1883, Protocol: "UDP"},
+				{ContainerPort: 443, NodePort: 30443, ServiceType: ServiceTypeNodePort},
+			},
+			VolumeMounts: []specv1.VolumeMount{
+				{Name: "cfg", MountPath: "/etc/conf"},
+				{Name: "sec", MountPath: "/etc/sec"},
+				{Name: "host", MountPath: "/var/host", ReadOnly: true},
+				{Name: "empty", MountPath: "/tmp/empty"},
+				{Name: "mem", MountPath: "/tmp/mem"},
+			},
+			Resources:       &specv1.Resources{Limits: map[string]string{"cpu": "500m", "memory": "64Mi"}},
+			SecurityContext: &specv1.SecurityContext{Privileged: true},
+		}},
+		Volumes: []specv1.Volume{
+			{Name: "cfg", VolumeSource: specv1.VolumeSource{Config: &specv1.ObjectReference{Name: "cfg1"}}},
+			{Name: "sec", VolumeSource: specv1.VolumeSource{Secret: &specv1.ObjectReference{Name: "sec1"}}},
+			{Name: "reg", VolumeSource: specv1.VolumeSource{Secret: &specv1.ObjectReference{Name: "reg1"}}},
+			{Name: "host", VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/baetyl"}}},
+			{Name: "empty", VolumeSource: specv1.VolumeSource{EmptyDir: &specv1.EmptyDirVolumeSource{}}},
+			{Name: "mem", VolumeSource: specv1.VolumeSource{EmptyDir: &specv1.EmptyDirVolumeSource{Medium: MediumMemory, SizeLimit: "1Mi"}}},
+		},
+	}
+	cfgs := map[string]specv1.Configuration{
+		"cfg1": {Name: "cfg1", Data: map[string]string{"conf.yml": "a: b"}},
+	}
+	secs := map[string]specv1.Secret{
+		"sec1": {Name: "sec1", Data: map[string][]byte{"token": []byte("abc")}},
+		"reg1": {
+			Name:   "reg1",
+			Labels: map[string]string{specv1.SecretLabel: specv1.SecretRegistry},
+			Data: map[string][]byte{
+				RegistryAddress:  []byte("https://registry.baetyl.io"),
+				RegistryUsername: []byte("user"),
+				RegistryPassword: []byte("pass"),
+			},
+		},
+	}
+	return app, cfgs, secs
+}
+
+func TestApplyApp(t *testing.T) {
+	am, fe := initDockerAMI(t)
+	ns := "baetyl-edge"
+	app, cfgs, secs := genApplyApp()
+
+	err := am.ApplyApp(context.Background(), ns, app, cfgs, secs)
+	assert.NoError(t, err)
+
+	// images are pulled with the registry auth of the image-pull secret
+	assert.Len(t, fe.images, 2)
+	assert.NotEmpty(t, fe.images["registry.baetyl.io/svc1:v1"])
+	assert.Empty(t, fe.images["busybox:latest"])
+	assert.True(t, fe.networks["baetyl"])
+
+	// an init container and a service container for each replica
+	assert.Len(t, fe.containers, 4)
+	c0, ok := fe.containers["baetyl-edge.app1.svc1.0"]
+	assert.True(t, ok)
+	assert.Equal(t, StateRunning, c0.state)
+	assert.Equal(t, "registry.baetyl.io/svc1:v1", c0.Image)
+	assert.Equal(t, []string{"-c", "conf/conf.yml"}, []string(c0.Cmd))
+	assert.Equal(t, []string{"k1=v1"}, c0.Env)
+	assert.Equal(t, "app1", c0.Labels[AppName])
+	assert.Equal(t, "v1", c0.Labels[AppVersion])
+	assert.Equal(t, ns, c0.Labels[AppNamespace])
+	assert.Equal(t, "svc1", c0.Labels[ServiceName])
+	assert.Equal(t, "0", c0.Labels[InstanceIndex])
+	assert.Len(t, c0.ExposedPorts, 3)
+	assert.Equal(t, []nat.PortBinding{{HostPort: "8080"}}, c0.HostConfig.PortBindings["80/tcp"])
+	assert.Equal(t, []nat.PortBinding{{HostPort: "30443"}}, c0.HostConfig.PortBindings["443/tcp"])
+	assert.Len(t, c0.HostConfig.PortBindings, 2)
+	assert.True(t, c0.HostConfig.Privileged)
+	assert.Equal(t, int64(500000000), c0.HostConfig.NanoCPUs)
+	assert.Equal(t, int64(64*1024*1024), c0.HostConfig.Memory)
+	assert.Equal(t, RestartAlways, c0.HostConfig.RestartPolicy.Name)
+	assert.Equal(t, container.NetworkMode("baetyl"), c0.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"app1", "app1.baetyl-edge", "svc1"}, c0.NetworkingConfig.EndpointsConfig["baetyl"].Aliases)
+
+	appDir := filepath.Join(am.runHostPath, ns, "app1", "v1")
+	assert.Len(t, c0.HostConfig.Mounts, 5)
+	assert.Equal(t, mount.Mount{Type: mount.TypeBind, Source: filepath.Join(appDir, volumesDir, "cfg"), Target: "/etc/conf", ReadOnly: true}, c0.HostConfig.Mounts[0])
+	assert.Equal(t, mount.Mount{Type: mount.TypeBind, Source: filepath.Join(appDir, volumesDir, "sec"), Target: "/etc/sec", ReadOnly: true}, c0.HostConfig.Mounts[1])
+	assert.Equal(t, mount.Mount{Type: mount.TypeBind, Source: "/var/lib/baetyl", Target: "/var/host", ReadOnly: true}, c0.HostConfig.Mounts[2])
+	assert.Equal(t, mount.Mount{Type: mount.TypeBind, Source: filepath.Join(appDir, emptyDirDir, "0", "empty"), Target: "/tmp/empty"}, c0.HostConfig.Mounts[3])
+	assert.Equal(t, mount.Mount{Type: mount.TypeTmpfs, Target: "/tmp/mem", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1024 * 1024}}, c0.HostConfig.Mounts[4])
+
+	data, err := os.ReadFile(filepath.Join(appDir, volumesDir, "cfg", "conf.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a: b", string(data))
+	data, err = os.ReadFile(filepath.Join(appDir, volumesDir, "sec", "token"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	assert.NoDirExists(t, filepath.Join(appDir, volumesDir, "reg"))
+
+	// host ports are only published by the first replica
+	c1, ok := fe.containers["baetyl-edge.app1.svc1.1"]
+	assert.True(t, ok)
+	assert.Len(t, c1.HostConfig.PortBindings, 0)
+	assert.Equal(t, filepath.Join(appDir, emptyDirDir, "1", "empty"), c1.HostConfig.Mounts[3].Source)
+
+	i0, ok := fe.containers["baetyl-edge.app1.init1.0"]
+	assert.True(t, ok)
+	assert.Equal(t, "true", i0.Labels[InitService])
+	assert.Equal(t, RestartNo, i0.HostConfig.RestartPolicy.Name)
+	assert.Equal(t, StateExited, i0.state)
+
+	stats, err := am.StatsApps(ns)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "app1", stats[0].Name)
+	assert.Equal(t, "v1", stats[0].Version)
+	assert.Equal(t, specv1.Running, stats[0].Status)
+	assert.Len(t, stats[0].InstanceStats, 2)
+	ins := stats[0].InstanceStats["baetyl-edge.app1.svc1.0"]
+	assert.Equal(t, "svc1", ins.ServiceName)
+	assert.Equal(t, "172.18.0.2", ins.IP)
+	assert.Equal(t, "200", ins.Usage["memory"])
+
+	// the old version keeps running if the new one fails to be created
+	app.Version = "v2"
+	app.Services[0].Image = "registry.baetyl.io/svc1:v2"
+	fe.failImage = "registry.baetyl.io/svc1:v2"
+	assert.Error(t, am.ApplyApp(context.Background(), ns, app, cfgs, secs))
+	assert.Len(t, fe.containers, 4)
+	assert.Equal(t, "v1", fe.containers["baetyl-edge.app1.svc1.0"].Labels[AppVersion])
+	assert.Equal(t, StateRunning, fe.containers["baetyl-edge.app1.svc1.0"].state)
+	assert.DirExists(t, appDir)
+	fe.failImage = ""
+
+	// apply a new version replaces the old one
+	app.Replica = 1
+	app.InitServices = nil
+	err = am.ApplyApp(context.Background(), ns, app, cfgs, secs)
+	assert.NoError(t, err)
+	assert.Len(t, fe.containers, 1)
+	assert.Equal(t, "v2", fe.containers["baetyl-edge.app1.svc1.0"].Labels[AppVersion])
+	assert.Equal(t, StateRunning, fe.containers["baetyl-edge.app1.svc1.0"].state)
+	assert.NoDirExists(t, appDir)
+
+	err = am.DeleteApp(ns, specv1.AppInfo{Name: "app1", Version: "v2"})
+	assert.NoError(t, err)
+	assert.Len(t, fe.containers, 0)
+	assert.NoDirExists(t, filepath.Join(am.runHostPath, ns, "app1"))
+
+	stats, err = am.StatsApps(ns)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 0)
+}
+
+func TestApplyAppNotSupported(t *testing.T) {
+	am, _ := initDockerAMI(t)
+	app := specv1.Application{Name: "app1", Type: specv1.AppTypeHelm}
+	err := am.ApplyApp(context.Background(), "baetyl-edge", app, nil, nil)
+	assert.Error(t, err)
+
+	app = specv1.Application{
+		Name:     "app2",
+		Services: []specv1.Service{{Name: "svc", Image: "busybox", VolumeMounts: []specv1.VolumeMount{{Name: "none"}}}},
+	}
+	err = am.ApplyApp(context.Background(), "baetyl-edge", app, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestPrepareContainerHostNetwork(t *testing.T) {
+	am, _ := initDockerAMI(t)
+	app := &specv1.Application{
+		Name:     "baetyl-broker-abc",
+		Version:  "v1",
+		Workload: specv1.WorkloadJob,
+		JobConfig: &specv1.AppJobConfig{
+			RestartPolicy: "OnFailure",
+			BackoffLimit:  3,
+		},
+	}
+	svc := &specv1.Service{
+		Name:    "broker",
+		Image:   "broker",
+		Command: []string{"broker"},
+		Ports:   []specv1.ContainerPort{{HostPort: 1883, ContainerPort: 1883}},
+	}
+	cfg, hostCfg, netCfg, err := am.prepareContainer("baetyl-edge-system", app, svc, nil, 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"broker"}, []string(cfg.Entrypoint))
+	assert.Equal(t, container.RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}, hostCfg.RestartPolicy)
+	assert.Equal(t, []string{"baetyl-broker", "baetyl-broker.baetyl-edge-system", "broker"}, netCfg.EndpointsConfig["baetyl"].Aliases)
+
+	app.HostNetwork = true
+	_, hostCfg, netCfg, err = am.prepareContainer("baetyl-edge-system", app, svc, nil, 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, container.NetworkMode(NetworkHost), hostCfg.NetworkMode)
+	assert.Len(t, hostCfg.PortBindings, 0)
+	assert.Nil(t, netCfg)
+
+	svc.Resources = &specv1.Resources{Limits: map[string]string{"cpu": "x"}}
+	_, _, _, err = am.prepareContainer("baetyl-edge-system", app, svc, nil, 0, false)
+	assert.Error(t, err)
+}
+
+func TestReplicaOf(t *testing.T) {
+	assert.Equal(t, 1, replicaOf(&specv1.Application{Workload: specv1.WorkloadDeployment}))
+	assert.Equal(t, 3, replicaOf(&specv1.Application{Workload: specv1.WorkloadDeployment, Replica: 3}))
+	assert.Equal(t, 1, replicaOf(&specv1.Application{Workload: specv1.WorkloadDaemonSet, Replica: 3}))
+	assert.Equal(t, 1, replicaOf(&specv1.Application{Workload: specv1.WorkloadJob, Replica: 3}))
+}
@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	gctx "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/shirou/gopsutil/v3/cpu"
+	gdisk "github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+const (
+	StateCreated    = "created"
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StatePaused     = "paused"
+	StateExited     = "exited"
+	StateDead       = "dead"
+)
+
+// usageSample the cumulative cpu usage of a container at the read time
+type usageSample struct {
+	total uint64
+	read  time.Time
+}
+
+func (d *dockerImpl) CollectNodeInfo() (map[string]interface{}, error) {
+	info, err := d.cli.Info(context.TODO())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	plat := gctx.Platform()
+	ias, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var addrs []string
+	for _, ia := range ias {
+		if ipnet, ok := ia.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ipnet.IP.To4() != nil {
+				addrs = append(addrs, ipnet.IP.String())
+			}
+		}
+	}
+	return map[string]interface{}{
+		info.Name: &specv1.NodeInfo{
+			Hostname:         info.Name,
+			Address:          strings.Join(addrs, ","),
+			Arch:             runtime.GOARCH,
+			Variant:          plat.Variant,
+			KernelVersion:    info.KernelVersion,
+			OS:               info.OSType,
+			OSImage:          info.OperatingSystem,
+			ContainerRuntime: "docker://" + info.ServerVersion,
+			MachineID:        info.ID,
+			Role:             "master",
+			Labels:           parseLabels(info.Labels),
+		},
+	}, nil
+}
+
+func (d *dockerImpl) CollectNodeStats() (map[string]interface{}, error) {
+	info, err := d.cli.Info(context.TODO())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	stats := &specv1.NodeStats{
+		Usage:    map[string]string{},
+		Capacity: map[string]string{},
+		Percent:  map[string]string{},
+		Ready:    true,
+	}
+	stats.Capacity["cpu"] = strconv.Itoa(info.NCPU)
+	stats.Capacity["memory"] = strconv.FormatInt(info.MemTotal, 10)
+	if percent, err := cpu.Percent(0, false); err == nil && len(percent) >= 1 {
+		usage := float64(info.NCPU) * percent[0] / 100
+		stats.Usage["cpu"] = strconv.FormatFloat(usage, 'f', 3, 64)
+	}
+	if me, err := mem.VirtualMemory(); err == nil {
+		stats.Usage["memory"] = strconv.FormatUint(me.Used, 10)
+	}
+	if disk, err := gdisk.Usage(info.DockerRootDir); err == nil && disk.Total > 0 {
+		stats.Capacity["disk"] = strconv.FormatUint(disk.Total, 10)
+		stats.Usage["disk"] = strconv.FormatUint(disk.Used, 10)
+		stats.Percent["disk"] = strconv.FormatFloat(float64(disk.Used)/float64(disk.Total), 'f', -1, 64)
+	}
+
+	var gpuExts map[string]interface{}
+	if extension, ok := ami.Hooks[ami.BaetylGPUStatsExtension]; ok {
+		collectStatsExt, ok := extension.(ami.CollectStatsExtFunc)
+		if ok {
+			gpuExts, err = collectStatsExt(ami.RunModeDocker)
+			if err != nil {
+				d.log.Warn("failed to collect gpu stats", log.Error(errors.Trace(err)))
+			}
+			d.log.Debug("collect gpu stats successfully", log.Any("gpuStats", gpuExts))
+		} else {
+			d.log.Warn("invalid collecting gpu stats function")
+		}
+	}
+	if len(gpuExts) > 0 {
+		if ext, ok := gpuExts[info.Name]; ok && ext != nil {
+			stats.Extension = ext.(map[string]interface{})
+		}
+	}
+	return map[string]interface{}{info.Name: stats}, nil
+}
+
+func (d *dockerImpl) StatsApps(ns string) ([]specv1.AppStats, error) {
+	ctx := context.TODO()
+	cs, err := d.listContainers(ctx, ns, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var keys []string
+	appStats := map[string]specv1.AppStats{}
+	alive := map[string]bool{}
+	for _, c := range cs {
+		alive[c.ID] = true
+		if c.Labels[InitService] == "true" {
+			continue
+		}
+		appName, appVersion := c.Labels[AppName], c.Labels[AppVersion]
+		key := utils.MakeKey(specv1.KindApplication, appName, appVersion)
+		stats, ok := appStats[key]
+		if !ok {
+			stats = specv1.AppStats{
+				AppInfo:       specv1.AppInfo{Name: appName, Version: appVersion},
+				InstanceStats: map[string]specv1.InstanceStats{},
+			}
+			keys = append(keys, key)
+		}
+		ins := d.collectInstanceStats(ctx, c)
+		stats.InstanceStats[ins.Name] = ins
+		appStats[key] = stats
+	}
+	d.cleanupUsages(alive)
+
+	var res []specv1.AppStats
+	for _, key := range keys {
+		stats := appStats[key]
+		stats.Status = getAppStatus(stats.InstanceStats)
+		res = append(res, stats)
+	}
+	return res, nil
+}
+
+func (d *dockerImpl) collectInstanceStats(ctx context.Context, c types.Container) specv1.InstanceStats {
+	ins := specv1.InstanceStats{
+		Name:        containerNameOf(c),
+		ServiceName: c.Labels[ServiceName],
+		AppName:     c.Labels[AppName],
+		CreateTime:  time.Unix(c.Created, 0),
+		Usage:       map[string]string{},
+	}
+	ci := specv1.ContainerInfo{Name: ins.ServiceName}
+	js, err := d.cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		ins.Status = specv1.Unknown
+		ins.Cause = err.Error()
+		return ins
+	}
+	if js.State != nil {
+		ins.Pid = int32(js.State.Pid)
+		ins.Status, ci.State, ci.Reason = getContainerStatus(js.State)
+		if ins.Status != specv1.Running {
+			ins.Cause = ci.Reason
+		}
+	}
+	if js.NetworkSettings != nil {
+		for _, n := range js.NetworkSettings.Networks {
+			if n != nil && n.IPAddress != "" {
+				ins.IP = n.IPAddress
+				break
+			}
+		}
+	}
+	if ins.Status == specv1.Running {
+		usage, err := d.getContainerUsage(ctx, c.ID)
+		if err != nil {
+			d.log.Warn("failed to collect container usage", log.Any("name", ins.Name), log.Error(err))
+		} else {
+			ins.Usage = usage
+			ci.Usage = usage
+		}
+	}
+	ins.Containers = []specv1.ContainerInfo{ci}
+	return ins
+}
+
+// getContainerUsage computes the cpu cores by the usage delta against the last sample
+func (d *dockerImpl) getContainerUsage(ctx context.Context, id string) (map[string]string, error) {
+	res, err := d.cli.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	var st types.StatsJSON
+	if err = json.NewDecoder(res.Body).Decode(&st); err != nil {
+		return nil, errors.Trace(err)
+	}
+	usage := map[string]string{}
+	mu := st.MemoryStats.Usage
+	// the page cache is not counted in like docker stats does
+	if v, ok := st.MemoryStats.Stats["inactive_file"]; ok && v < mu {
+		mu -= v
+	} else if v, ok = st.MemoryStats.Stats["cache"]; ok && v < mu {
+		mu -= v
+	}
+	usage["memory"] = strconv.FormatUint(mu, 10)
+
+	cur := usageSample{total: st.CPUStats.CPUUsage.TotalUsage, read: st.Read}
+	d.mu.Lock()
+	last, ok := d.usages[id]
+	d.usages[id] = cur
+	d.mu.Unlock()
+	if ok && cur.read.After(last.read) && cur.total >= last.total {
+		cores := float64(cur.total-last.total) / float64(cur.read.Sub(last.read).Nanoseconds())
+		usage["cpu"] = strconv.FormatFloat(cores, 'f', 3, 64)
+	}
+	return usage, nil
+}
+
+func (d *dockerImpl) cleanupUsages(alive map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id := range d.usages {
+		if !alive[id] {
+			delete(d.usages, id)
+		}
+	}
+}
+
+func getContainerStatus(state *types.ContainerState) (specv1.Status, specv1.ContainerState, string) {
+	switch state.Status {
+	case StateRunning:
+		return specv1.Running, specv1.ContainerRunning, ""
+	case StateCreated, StateRestarting, StatePaused:
+		reason := state.Status
+		if state.Error != "" {
+			reason = state.Error
+		}
+		return specv1.Pending, specv1.ContainerWaiting, reason
+	case StateExited, StateDead:
+		reason := "exit code " + strconv.Itoa(state.ExitCode)
+		if state.Error != "" {
+			reason = state.Error
+		}
+		if state.ExitCode == 0 && state.Status == StateExited {
+			return specv1.Succeeded, specv1.ContainerTerminated, reason
+		}
+		return specv1.Failed, specv1.ContainerTerminated, reason
+	default:
+		return specv1.Unknown, specv1.ContainerWaiting, "status unknown"
+	}
+}
+
+func getAppStatus(infos map[string]specv1.InstanceStats) specv1.Status {
+	var pending = false
+	for _, info := range infos {
+		switch info.Status {
+		case specv1.Pending, specv1.Failed:
+			pending = true
+		case specv1.Unknown:
+			return info.Status
+		}
+	}
+	if pending {
+		return specv1.Pending
+	}
+	return specv1.Running
+}
+
+func containerNameOf(c types.Container) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
+
+// parseLabels parses the docker engine labels in the form of key=value
+func parseLabels(ls []string) map[string]string {
+	labels := map[string]string{}
+	for _, l := range ls {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	return labels
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContainerStatus(t *testing.T) {
+	status, state, _ := getContainerStatus(&types.ContainerState{Status: StateRunning})
+	assert.Equal(t, specv1.Running, status)
+	assert.Equal(t, specv1.ContainerRunning, state)
+
+	status, state, reason := getContainerStatus(&types.ContainerState{Status: StateRestarting})
+	assert.Equal(t, specv1.Pending, status)
+	assert.Equal(t, specv1.ContainerWaiting, state)
+	assert.Equal(t, StateRestarting, reason)
+
+	status, state, _ = getContainerStatus(&types.ContainerState{Status: StateExited})
+	assert.Equal(t, specv1.Succeeded, status)
+	assert.Equal(t, specv1.ContainerTerminated, state)
+
+	status, _, reason = getContainerStatus(&types.ContainerState{Status: StateExited, ExitCode: 2})
+	assert.Equal(t, specv1.Failed, status)
+	assert.Equal(t, "exit code 2", reason)
+
+	status, _, reason = getContainerStatus(&types.ContainerState{Status: StateDead, Error: "oom"})
+	assert.Equal(t, specv1.Failed, status)
+	assert.Equal(t, "oom", reason)
+
+	status, _, _ = getContainerStatus(&types.ContainerState{Status: "removing"})
+	assert.Equal(t, specv1.Unknown, status)
+}
+
+func TestGetAppStatus(t *testing.T) {
+	infos := map[string]specv1.InstanceStats{
+		"ins-1": {Status: specv1.Running},
+		"ins-2": {Status: specv1.Running},
+	}
+	assert.Equal(t, specv1.Running, getAppStatus(infos))
+
+	infos["ins-2"] = specv1.InstanceStats{Status: specv1.Failed}
+	assert.Equal(t, specv1.Pending, getAppStatus(infos))
+
+	infos["ins-1"] = specv1.InstanceStats{Status: specv1.Unknown}
+	assert.Equal(t, specv1.Unknown, getAppStatus(infos))
+}
+
+func TestGetContainerUsage(t *testing.T) {
+	am, fe := initDockerAMI(t)
+	fe.containers["c1"] = &fakeContainer{name: "c1", state: StateRunning}
+	usage, err := am.getContainerUsage(context.TODO(), "c1")
+	assert.NoError(t, err)
+	assert.Equal(t, "200", usage["memory"])
+	// the cpu usage needs two samples
+	assert.NotContains(t, usage, "cpu")
+	assert.Contains(t, am.usages, "c1")
+
+	am.cleanupUsages(map[string]bool{})
+	assert.NotContains(t, am.usages, "c1")
+}
+
+func TestParseLabels(t *testing.T) {
+	assert.Equal(t, map[string]string{"a": "b", "c": "d=e"}, parseLabels([]string{"a=b", "c=d=e", "f"}))
+}
@@ -0,0 +1,51 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/docker/docker/api/types"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+var defaultCommand = []string{"sh"}
+
+func (d *dockerImpl) RemoteCommand(option *ami.DebugOptions, pipe ami.Pipe) error {
+	ctx := context.TODO()
+	cmd := option.Command
+	if len(cmd) == 0 {
+		cmd = defaultCommand
+	}
+	exec, err := d.cli.ContainerExecCreate(ctx, option.Name, types.ExecConfig{
+		Cmd:          cmd,
+		Tty:          true,
+		AttachStdin:  pipe.InReader != nil,
+		AttachStdout: pipe.OutWriter != nil,
+		AttachStderr: pipe.OutWriter != nil,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := d.cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Close()
+
+	if pipe.InReader != nil {
+		go func() {
+			io.Copy(resp.Conn, pipe.InReader)
+			resp.CloseWrite()
+		}()
+	}
+	// output is not multiplexed with tty
+	if pipe.OutWriter != nil {
+		_, err = io.Copy(pipe.OutWriter, resp.Reader)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
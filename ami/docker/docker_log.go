@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+func (d *dockerImpl) FetchLog(_, pod, _ string, tailLines, sinceSeconds int64) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, err := d.cli.ContainerLogs(ctx, pod, toLogOptions(tailLines, sinceSeconds, false, false))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	var buf bytes.Buffer
+	// containers are created without tty, so stdout and stderr are multiplexed
+	if _, err = stdcopy.StdCopy(&buf, &buf, reader); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *dockerImpl) RemoteLogs(option *ami.LogsOptions, pipe ami.Pipe) error {
+	var tailLines, sinceSeconds int64
+	if option.TailLines != nil {
+		tailLines = *option.TailLines
+	}
+	if option.SinceSeconds != nil {
+		sinceSeconds = *option.SinceSeconds
+	}
+	ctx := pipe.Ctx
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	reader, err := d.cli.ContainerLogs(ctx, option.Name, toLogOptions(tailLines, sinceSeconds, option.Follow, option.Timestamps))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+	_, err = stdcopy.StdCopy(pipe.OutWriter, pipe.OutWriter, reader)
+	if err != nil && ctx.Err() == nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func toLogOptions(tailLines, sinceSeconds int64, follow, timestamps bool) types.ContainerLogsOptions {
+	opts := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Timestamps: timestamps,
+	}
+	if tailLines > 0 {
+		opts.Tail = strconv.FormatInt(tailLines, 10)
+	}
+	if sinceSeconds > 0 {
+		opts.Since = strconv.FormatInt(time.Now().Add(-time.Duration(sinceSeconds)*time.Second).Unix(), 10)
+	}
+	return opts
+}
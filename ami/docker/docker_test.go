@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+type fakeContainer struct {
+	container.Config
+	HostConfig       *container.HostConfig
+	NetworkingConfig *network.NetworkingConfig
+	id               string
+	name             string
+	state            string
+}
+
+// fakeEngine serves a subset of the docker engine api on a unix socket
+type fakeEngine struct {
+	containers map[string]*fakeContainer
+	networks   map[string]bool
+	images     map[string]string // image -> registry auth
+	failImage  string            // the image which fails to create a container
+	ids        int
+	logs       string
+	mu         sync.Mutex
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// strip the api version prefix
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/v") {
+		path = path[strings.Index(path[1:], "/")+1:]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/info":
+		writeJSON(w, types.Info{ID: "id", Name: "node1", NCPU: 2, MemTotal: 1024, OSType: "linux",
+			OperatingSystem: "ubuntu", KernelVersion: "kernel", ServerVersion: "24.0.6", Labels: []string{"a=b"}})
+	case path == "/networks/create":
+		var req types.NetworkCreateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.networks[req.Name] = true
+		writeJSON(w, types.NetworkCreateResponse{ID: req.Name})
+	case parts[0] == "networks":
+		if !f.networks[parts[1]] {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, types.NetworkResource{Name: parts[1]})
+	case path == "/images/create":
+		f.images[r.URL.Query().Get("fromImage")+":"+r.URL.Query().Get("tag")] = r.Header.Get("X-Registry-Auth")
+		writeJSON(w, map[string]string{"status": "done"})
+	case parts[0] == "images":
+		writeError(w, http.StatusNotFound)
+	case path == "/containers/create":
+		c := &fakeContainer{name: r.URL.Query().Get("name"), state: StateCreated}
+		json.NewDecoder(r.Body).Decode(c)
+		if c.Image == f.failImage {
+			writeError(w, http.StatusInternalServerError)
+			return
+		}
+		f.ids++
+		c.id = fmt.Sprintf("id%d", f.ids)
+		f.containers[c.name] = c
+		writeJSON(w, container.CreateResponse{ID: c.id})
+	case path == "/containers/json":
+		args, _ := filters.FromJSON(r.URL.Query().Get("filters"))
+		var cs []types.Container
+		for _, c := range f.containers {
+			match := true
+			for _, l := range args.Get("label") {
+				kv := strings.SplitN(l, "=", 2)
+				if c.Labels[kv[0]] != kv[1] {
+					match = false
+				}
+			}
+			if match {
+				cs = append(cs, types.Container{ID: c.key(), Names: []string{"/" + c.name}, Labels: c.Labels, State: c.state})
+			}
+		}
+		writeJSON(w, cs)
+	case parts[0] == "containers" && len(parts) >= 2:
+		c := f.find(parts[1])
+		if c == nil {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.containers, c.name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		switch parts[len(parts)-1] {
+		case "rename":
+			delete(f.containers, c.name)
+			c.name = r.URL.Query().Get("name")
+			f.containers[c.name] = c
+			w.WriteHeader(http.StatusNoContent)
+		case "start":
+			c.state = StateRunning
+			if c.Labels[InitService] == "true" {
+				c.state = StateExited
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "wait":
+			writeJSON(w, container.WaitResponse{StatusCode: 0})
+		case "json":
+			writeJSON(w, types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{ID: c.name, Name: "/" + c.name, State: &types.ContainerState{Status: c.state, Pid: 10}},
+				NetworkSettings:   &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{"baetyl": {IPAddress: "172.18.0.2"}}},
+			})
+		case "stats":
+			st := types.StatsJSON{}
+			st.Read = time.Now()
+			st.CPUStats.CPUUsage.TotalUsage = 1000
+			st.MemoryStats.Usage = 300
+			st.MemoryStats.Stats = map[string]uint64{"inactive_file": 100}
+			writeJSON(w, st)
+		case "logs":
+			w.WriteHeader(http.StatusOK)
+			stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(f.logs))
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+// key the id of the container, which is the name if the container is not created by the api
+func (c *fakeContainer) key() string {
+	if c.id != "" {
+		return c.id
+	}
+	return c.name
+}
+
+// find finds the container by the id or the name
+func (f *fakeEngine) find(key string) *fakeContainer {
+	if c, ok := f.containers[key]; ok {
+		return c
+	}
+	for _, c := range f.containers {
+		if c.id == key {
+			return c
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": "not found"})
+}
+
+func initDockerAMI(t *testing.T) (*dockerImpl, *fakeEngine) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	fe := &fakeEngine{
+		containers: map[string]*fakeContainer{},
+		networks:   map[string]bool{},
+		images:     map[string]string{},
+	}
+	srv := httptest.NewUnstartedServer(fe)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	cfg := &config.DockerConfig{Host: "unix://" + sock, APIVersion: "1.41", Network: "baetyl"}
+	cli, err := newClient(*cfg)
+	assert.NoError(t, err)
+	runHostPath := filepath.Join(dir, "run")
+	assert.NoError(t, os.MkdirAll(runHostPath, 0755))
+	return &dockerImpl{
+		cli:         cli,
+		runHostPath: runHostPath,
+		usages:      map[string]usageSample{},
+		conf:        cfg,
+		log:         log.With(log.Any("ami", "docker")),
+	}, fe
+}
+
+func TestCollectNodeInfo(t *testing.T) {
+	am, _ := initDockerAMI(t)
+	res, err := am.CollectNodeInfo()
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	info, ok := res["node1"]
+	assert.True(t, ok)
+	assert.NotNil(t, info)
+
+	stats, err := am.CollectNodeStats()
+	assert.NoError(t, err)
+	assert.Contains(t, stats, "node1")
+}
+
+func TestFetchLog(t *testing.T) {
+	am, fe := initDockerAMI(t)
+	fe.containers["c1"] = &fakeContainer{name: "c1", state: StateRunning}
+	fe.logs = "hello baetyl\n"
+	res, err := am.FetchLog("baetyl-edge", "c1", "", 10, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello baetyl\n", string(res))
+
+	_, err = am.FetchLog("baetyl-edge", "c2", "", 10, 10)
+	assert.Error(t, err)
+}
+
+func TestRemoteDescribe(t *testing.T) {
+	am, fe := initDockerAMI(t)
+	fe.containers["c1"] = &fakeContainer{name: "c1", state: StateRunning}
+	res, err := am.RemoteDescribe("pod", "baetyl-edge", "c1")
+	assert.NoError(t, err)
+	assert.Contains(t, res, fmt.Sprintf("%q", StateRunning))
+}
@@ -1,6 +1,7 @@
 package kube
 
 import (
+	gocontext "context"
 	"log"
 	"os"
 
@@ -23,6 +24,7 @@ type kubeImpl struct {
 	helm  *action.Configuration
 	store *bh.Store
 	conf  *config.KubeConfig
+	cache *informerCache
 	log   *logv2.Logger
 }
 
@@ -52,12 +54,13 @@ func newKubeImpl(cfg config.AmiConfig, sto *bh.Store) (ami.AMI, error) {
 		conf:  &cfg.Kube,
 		log:   logv2.With(logv2.Any("ami", "kube")),
 	}
+	model.cache = newInformerCache(cli.clientset, cfg.Kube.Cache, model.cronJobAvailable)
 	return model, nil
 }
 
-func (k *kubeImpl) ApplyApp(ns string, app specv1.Application, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) error {
+func (k *kubeImpl) ApplyApp(ctx gocontext.Context, ns string, app specv1.Application, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) error {
 	if app.Type == specv1.AppTypeHelm {
-		return k.ApplyHelm(ns, app, cfgs)
+		return k.ApplyHelm(ctx, ns, app, cfgs)
 	}
 	if app.Type == specv1.AppTypeYaml {
 		ns = app.Labels[specv1.CustomAppNsLabel]
@@ -82,7 +85,19 @@ func (k *kubeImpl) ApplyApp(ns string, app specv1.Application, cfgs map[string]s
 			imagePullSecs = append(imagePullSecs, n)
 		}
 	}
-	if !app.PreserveUpdates {
+	// the running version is kept until the new images are pulled
+	if err = k.prePullImages(ns, app, imagePullSecs); err != nil {
+		return errors.Trace(err)
+	}
+	// the running version is kept if the task is cancelled while pulling
+	if err = ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	rolled, err := k.rolledOut(ns, app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !app.PreserveUpdates && !rolled {
 		err = k.deleteApplication(ns, app.Name)
 		if err != nil {
 			return errors.Trace(err)
@@ -112,7 +127,17 @@ func (k *kubeImpl) DeleteApp(ns string, app specv1.AppInfo) error {
 	if delApp.Type == specv1.AppTypeYaml {
 		return k.DeleteYaml(delApp)
 	}
-	return k.deleteApplication(ns, app.Name)
+	if err = k.deleteApplication(ns, app.Name); err != nil {
+		return errors.Trace(err)
+	}
+	// the networking is kept by the updates of the app, which only recreate the workloads
+	if err = k.deleteNetworking(ns, app.Name); err != nil {
+		return errors.Trace(err)
+	}
+	if err = k.deletePrePull(ns, app.Name); err != nil {
+		return errors.Trace(err)
+	}
+	return k.deleteVolumeClaims(ns, delApp)
 }
 
 func (k *kubeImpl) StatsApps(ns string) ([]specv1.AppStats, error) {
@@ -146,6 +171,16 @@ func (k *kubeImpl) StatsApps(ns string) ([]specv1.AppStats, error) {
 		return nil, errors.Trace(err)
 	}
 	res = append(res, js...)
+	sss, err := k.collectStatefulSetStats(ns, qpsExts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res = append(res, sss...)
+	cjs, err := k.collectCronJobStats(ns, qpsExts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res = append(res, cjs...)
 
 	if ns == context.EdgeNamespace() {
 		helmStats, err := k.StatsHelm(ns)
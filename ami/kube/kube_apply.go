@@ -49,7 +49,7 @@ var (
 func (k *kubeImpl) createNamespace(ns string) (*corev1.Namespace, error) {
 	defer utils.Trace(k.log.Debug, "applyNamespace")()
 	return k.cli.core.Namespaces().Create(context.TODO(), &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{Name: ns},
+		ObjectMeta: metav1.ObjectMeta{Name: ns, Labels: map[string]string{namespaceNameKey: ns}},
 	}, metav1.CreateOptions{})
 }
 
@@ -58,9 +58,11 @@ func (k *kubeImpl) getNamespace(ns string) (*corev1.Namespace, error) {
 	return k.cli.core.Namespaces().Get(context.TODO(), ns, metav1.GetOptions{})
 }
 
+// checkAndCreateNamespace creates the namespace not found, the namespace is labeled by its name
+// for the namespace selectors of the network policies, which the clusters older than 1.21 don't do
 func (k *kubeImpl) checkAndCreateNamespace(ns string) error {
 	defer utils.Trace(k.log.Debug, "checkAndCreateNamespace")()
-	_, err := k.getNamespace(ns)
+	namespace, err := k.getNamespace(ns)
 	if err != nil && strings.Contains(err.Error(), "not found") {
 		k.log.Debug("namespace not found, will be created", log.Any("ns", ns))
 		_, err = k.createNamespace(ns)
@@ -69,7 +71,15 @@ func (k *kubeImpl) checkAndCreateNamespace(ns string) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return nil
+	if namespace.Labels[namespaceNameKey] == ns {
+		return nil
+	}
+	if namespace.Labels == nil {
+		namespace.Labels = map[string]string{}
+	}
+	namespace.Labels[namespaceNameKey] = ns
+	_, err = k.cli.core.Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{})
+	return errors.Trace(err)
 }
 
 func (k *kubeImpl) applyConfigurations(ns string, cfgs map[string]specv1.Configuration) error {
@@ -153,6 +163,8 @@ func (k *kubeImpl) applyApplication(ns string, app specv1.Application, imagePull
 	deploys := make(map[string]*appv1.Deployment)
 	daemons := make(map[string]*appv1.DaemonSet)
 	jobs := make(map[string]*batchv1.Job)
+	statefulSets := make(map[string]*appv1.StatefulSet)
+	cronJobs := make(map[string]*batchv1.CronJob)
 
 	if app.Labels == nil {
 		app.Labels = map[string]string{}
@@ -181,17 +193,51 @@ func (k *kubeImpl) applyApplication(ns string, app specv1.Application, imagePull
 		} else {
 			jobs[job.Name] = job
 		}
+	case specv1.WorkloadStatefulSet:
+		if sts, err := prepareStatefulSet(ns, &app, imagePullSecrets); err != nil {
+			return errors.Trace(err)
+		} else {
+			statefulSets[sts.Name] = sts
+		}
+	case WorkloadCronJob:
+		if cj, err := prepareCronJob(ns, &app, imagePullSecrets); err != nil {
+			return errors.Trace(err)
+		} else {
+			cronJobs[cj.Name] = cj
+		}
 	default:
 		k.log.Warn("service type not support", log.Any("type", app.Workload), log.Any("name", app.Name))
 	}
 
-	if service := k.prepareService(ns, app); service != nil {
+	service := k.prepareService(ns, app)
+	if service != nil {
 		services[service.Name] = service
 	}
-	if nodePortSvc := k.prepareNodePortService(ns, app); nodePortSvc != nil {
+	nodePortSvc := k.prepareNodePortService(ns, app)
+	if nodePortSvc != nil {
 		services[nodePortSvc.Name] = nodePortSvc
 	}
+	if headlessSvc := k.prepareHeadlessService(ns, app); headlessSvc != nil {
+		services[headlessSvc.Name] = headlessSvc
+	}
+
+	claims, err := prepareVolumeClaims(ns, &app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ing, err := k.prepareIngress(ns, app, service)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	np := k.prepareNetworkPolicy(ns, app, ing, service, nodePortSvc)
 
+	if err := k.applyVolumeClaims(ns, claims); err != nil {
+		return errors.Trace(err)
+	}
+	// the pods are isolated by the policy as soon as they start
+	if err := k.applyNetworkPolicy(ns, app.Name, np); err != nil {
+		return errors.Trace(err)
+	}
 	if err := k.applyDeploys(ns, deploys); err != nil {
 		return errors.Trace(err)
 	}
@@ -201,9 +247,21 @@ func (k *kubeImpl) applyApplication(ns string, app specv1.Application, imagePull
 	if err := k.applyServices(ns, services); err != nil {
 		return errors.Trace(err)
 	}
+	if err := k.applyIngress(ns, app.Name, ing); err != nil {
+		return errors.Trace(err)
+	}
 	if err := k.applyJobs(ns, jobs); err != nil {
 		return errors.Trace(err)
 	}
+	if err := k.applyStatefulSets(ns, statefulSets); err != nil {
+		return errors.Trace(err)
+	}
+	if err := k.applyStatefulSetClaimPolicy(ns, &app); err != nil {
+		return errors.Trace(err)
+	}
+	if err := k.applyCronJobs(ns, cronJobs); err != nil {
+		return errors.Trace(err)
+	}
 	if hpa := k.prepareHPA(ns, app); hpa != nil {
 		err := k.applyHPA(ns, hpa)
 		if err != nil {
@@ -394,10 +452,7 @@ func prepareJob(ns string, app *specv1.Application, imagePullSecrets []corev1.Lo
 		jobSpec.BackoffLimit = &backoffLimit
 	}
 	jobSpec.Template.Spec = *podSpec
-	labels := map[string]string{}
-	for k, v := range app.Labels {
-		labels[k] = v
-	}
+	labels := objectLabels(app.Labels)
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      app.Name,
@@ -428,10 +483,7 @@ func prepareDaemon(ns string, app *specv1.Application, imagePullSecrets []corev1
 		return nil, errors.Trace(ErrSetPodSpec)
 	}
 
-	labels := map[string]string{}
-	for k, v := range app.Labels {
-		labels[k] = v
-	}
+	labels := objectLabels(app.Labels)
 	ds := &appv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      app.Name,
@@ -446,10 +498,8 @@ func prepareDaemon(ns string, app *specv1.Application, imagePullSecrets []corev1
 			},
 		},
 	}
-	if app.Labels != nil {
-		for k, v := range app.Labels {
-			ds.Spec.Template.Labels[k] = v
-		}
+	for k, v := range labels {
+		ds.Spec.Template.Labels[k] = v
 	}
 	return ds, nil
 }
@@ -473,11 +523,12 @@ func prepareDeploy(ns string, app *specv1.Application, imagePullSecrets []corev1
 	}
 	replica := new(int32)
 	*replica = int32(app.Replica)
-
-	labels := map[string]string{}
-	for k, v := range app.Labels {
-		labels[k] = v
+	ro, err := parseRollout(app)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
+
+	labels := objectLabels(app.Labels)
 	deploy := &appv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      app.Name,
@@ -485,21 +536,19 @@ func prepareDeploy(ns string, app *specv1.Application, imagePullSecrets []corev1
 			Labels:    labels,
 		},
 		Spec: appv1.DeploymentSpec{
-			Replicas: replica,
-			Strategy: appv1.DeploymentStrategy{
-				Type: appv1.RecreateDeploymentStrategyType,
-			},
-			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: app.Name}},
+			Replicas:                replica,
+			Strategy:                ro.strategy,
+			MinReadySeconds:         ro.minReadySeconds,
+			ProgressDeadlineSeconds: ro.progressDeadline,
+			Selector:                &metav1.LabelSelector{MatchLabels: map[string]string{AppName: app.Name}},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{AppName: app.Name}},
 				Spec:       *podSpec,
 			},
 		},
 	}
-	if app.Labels != nil {
-		for k, v := range app.Labels {
-			deploy.Spec.Template.Labels[k] = v
-		}
+	for k, v := range labels {
+		deploy.Spec.Template.Labels[k] = v
 	}
 	if strings.Contains(app.Name, specv1.BaetylCore) || strings.Contains(app.Name, specv1.BaetylInit) {
 		deploy.Spec.Template.Spec.ServiceAccountName = ServiceAccountName
@@ -529,11 +578,17 @@ func prepareInfo(app *specv1.Application, imagePullSecrets []corev1.LocalObjectR
 		}
 		containers = append(containers, c)
 	}
+	claimed := claimedVolumes(app)
 	for _, v := range app.Volumes {
 		volume := corev1.Volume{
 			Name: v.Name,
 		}
-		if v.Config != nil {
+		if claimed[v.Name] {
+			volume.VolumeSource.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: volumeClaimName(app.Name, v.Name),
+			}
+			delete(claimed, v.Name)
+		} else if v.Config != nil {
 			volume.VolumeSource.ConfigMap = &corev1.ConfigMapVolumeSource{
 				LocalObjectReference: corev1.LocalObjectReference{Name: v.VolumeSource.Config.Name},
 			}
@@ -561,6 +616,15 @@ func prepareInfo(app *specv1.Application, imagePullSecrets []corev1.LocalObjectR
 		}
 		volumes = append(volumes, volume)
 	}
+	// the claimed volumes only declared by the labels
+	for _, name := range sortedNames(claimed) {
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: volumeClaimName(app.Name, name),
+			}},
+		})
+	}
 
 	return &corev1.PodSpec{
 		Volumes:          volumes,
@@ -617,6 +681,17 @@ func (k *kubeImpl) deleteApplication(ns, name string) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	statefulSets, err := k.cli.app.StatefulSets(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cronJobs := &batchv1.CronJobList{}
+	if k.cronJobAvailable() {
+		cronJobs, err = k.cli.batch.CronJobs(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
 	if k.hpaAvailable() {
 		hpas, err := k.cli.autoscale.HorizontalPodAutoscalers(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
 		if err != nil {
@@ -655,7 +730,19 @@ func (k *kubeImpl) deleteApplication(ns, name string) error {
 			return errors.Trace(err)
 		}
 	}
-
+	// the claims are deleted with the app by their reclaim policy
+	stsInterface := k.cli.app.StatefulSets(ns)
+	for _, s := range statefulSets.Items {
+		if err = stsInterface.Delete(context.TODO(), s.Name, metav1.DeleteOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	cjInterface := k.cli.batch.CronJobs(ns)
+	for _, c := range cronJobs.Items {
+		if err = cjInterface.Delete(context.TODO(), c.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	k.log.Info("ami delete app", log.Any("name", name))
 	return nil
 }
@@ -48,6 +48,17 @@ func TestCheckAndCreateNamespace(t *testing.T) {
 	ns := "ns"
 	err := am.checkAndCreateNamespace(ns)
 	assert.NoError(t, err)
+	res, err := am.getNamespace(ns)
+	assert.NoError(t, err)
+	assert.Equal(t, ns, res.Labels[namespaceNameKey])
+
+	// the namespace of the clusters older than 1.21 is labeled by its name
+	_, err = am.cli.core.Namespaces().Create(context.TODO(), &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "old"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, am.checkAndCreateNamespace("old"))
+	res, err = am.getNamespace("old")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", res.Labels[namespaceNameKey])
 }
 
 func TestApplyDeploys(t *testing.T) {
@@ -365,12 +376,17 @@ func genApplyRuntime() []runtime.Object {
 
 func initApplyKubeAMI(t *testing.T) *kubeImpl {
 	fc := fake.NewSimpleClientset(genApplyRuntime()...)
+	fc.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{Name: "cronjobs", Kind: "CronJob", Namespaced: true}},
+	}}
 	cli := client{
-		core:      fc.CoreV1(),
-		app:       fc.AppsV1(),
-		batch:     fc.BatchV1(),
-		autoscale: fc.AutoscalingV2(),
-		discovery: fc.Discovery(),
+		core:       fc.CoreV1(),
+		app:        fc.AppsV1(),
+		batch:      fc.BatchV1(),
+		autoscale:  fc.AutoscalingV2(),
+		discovery:  fc.Discovery(),
+		networking: fc.NetworkingV1(),
 	}
 	f, err := os.CreateTemp("", t.Name())
 	assert.NoError(t, err)
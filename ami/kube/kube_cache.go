@@ -0,0 +1,369 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+// informerCache keeps the shared informers of the kinds managed by baetyl for each namespace,
+// the stats are collected from the cache instead of listing from the api server
+type informerCache struct {
+	cs      kubernetes.Interface
+	cfg     config.KubeCacheConfig
+	cronJob func() bool
+	mu      sync.Mutex
+	nss     map[string]*nsCache
+	changes chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+	log     *log.Logger
+}
+
+// the resources of the informers, each of them falls back to the api server alone if its informer is not synced
+const (
+	cacheDeployments  = "deployments"
+	cacheReplicaSets  = "replicasets"
+	cacheDaemonSets   = "daemonsets"
+	cacheStatefulSets = "statefulsets"
+	cacheJobs         = "jobs"
+	cacheCronJobs     = "cronjobs"
+	cachePods         = "pods"
+	cacheClaims       = "persistentvolumeclaims"
+	cacheEvents       = "events"
+)
+
+type nsCache struct {
+	deploys      appslisters.DeploymentLister
+	replicaSets  appslisters.ReplicaSetLister
+	daemons      appslisters.DaemonSetLister
+	statefulSets appslisters.StatefulSetLister
+	jobs         batchlisters.JobLister
+	cronJobs     batchlisters.CronJobLister
+	pods         corelisters.PodLister
+	claims       corelisters.PersistentVolumeClaimLister
+	events       toolscache.Indexer
+	synced       map[string]toolscache.InformerSynced
+}
+
+func newInformerCache(cs kubernetes.Interface, cfg config.KubeCacheConfig, cronJob func() bool) *informerCache {
+	if cs == nil || cfg.Disable {
+		return nil
+	}
+	return &informerCache{
+		cs:      cs,
+		cfg:     cfg,
+		cronJob: cronJob,
+		nss:     map[string]*nsCache{},
+		changes: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		log:     log.With(log.Any("ami", "kube"), log.Any("cache", "informer")),
+	}
+}
+
+// get returns the cache of the namespace if the informer of the resource is synced, the informers are
+// started on the first call, nil is returned for the resource not synced, which is listed from the api server
+func (c *informerCache) get(ns, resource string) *nsCache {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	nc, ok := c.nss[ns]
+	if !ok {
+		nc = c.start(ns)
+		c.nss[ns] = nc
+	}
+	c.mu.Unlock()
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.SyncTimeout)
+		defer cancel()
+		synced := make([]toolscache.InformerSynced, 0, len(nc.synced))
+		for _, s := range nc.synced {
+			synced = append(synced, s)
+		}
+		if toolscache.WaitForCacheSync(ctx.Done(), synced...) {
+			c.log.Info("informer cache synced", log.Any("namespace", ns))
+		} else {
+			c.log.Warn("informer cache is not synced in time, list the resources from api server instead",
+				log.Any("namespace", ns), log.Any("resources", nc.unsynced()))
+		}
+	}
+	if synced, ok := nc.synced[resource]; !ok || !synced() {
+		return nil
+	}
+	return nc
+}
+
+func (c *informerCache) start(ns string) *nsCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.cs, c.cfg.Resync, informers.WithNamespace(ns))
+	deploys := factory.Apps().V1().Deployments()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	daemons := factory.Apps().V1().DaemonSets()
+	statefulSets := factory.Apps().V1().StatefulSets()
+	jobs := factory.Batch().V1().Jobs()
+	pods := factory.Core().V1().Pods()
+	claims := factory.Core().V1().PersistentVolumeClaims()
+	events := factory.Core().V1().Events()
+	// the events are looked up by the involved objects
+	err := events.Informer().AddIndexers(toolscache.Indexers{eventObjectIndex: indexEventObject})
+	if err != nil {
+		c.log.Warn("failed to index events", log.Any("namespace", ns), log.Error(err))
+	}
+	nc := &nsCache{
+		deploys:      deploys.Lister(),
+		replicaSets:  replicaSets.Lister(),
+		daemons:      daemons.Lister(),
+		statefulSets: statefulSets.Lister(),
+		jobs:         jobs.Lister(),
+		pods:         pods.Lister(),
+		claims:       claims.Lister(),
+		events:       events.Informer().GetIndexer(),
+		synced: map[string]toolscache.InformerSynced{
+			cacheDeployments:  deploys.Informer().HasSynced,
+			cacheReplicaSets:  replicaSets.Informer().HasSynced,
+			cacheDaemonSets:   daemons.Informer().HasSynced,
+			cacheStatefulSets: statefulSets.Informer().HasSynced,
+			cacheJobs:         jobs.Informer().HasSynced,
+			cachePods:         pods.Informer().HasSynced,
+			cacheClaims:       claims.Informer().HasSynced,
+			cacheEvents:       events.Informer().HasSynced,
+		},
+	}
+	// the informer never syncs if the api server does not serve batch/v1 cron jobs
+	if c.cronJob != nil && c.cronJob() {
+		cronJobs := factory.Batch().V1().CronJobs()
+		nc.cronJobs = cronJobs.Lister()
+		nc.synced[cacheCronJobs] = cronJobs.Informer().HasSynced
+	}
+	_, err = pods.Informer().AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) { c.notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// the periodic resyncs do not change the pods
+			o, ok1 := oldObj.(*corev1.Pod)
+			n, ok2 := newObj.(*corev1.Pod)
+			if ok1 && ok2 && o.ResourceVersion == n.ResourceVersion {
+				return
+			}
+			c.notify()
+		},
+		DeleteFunc: func(_ interface{}) { c.notify() },
+	})
+	if err != nil {
+		c.log.Warn("failed to watch pod changes", log.Any("namespace", ns), log.Error(err))
+	}
+	factory.Start(c.stop)
+	return nc
+}
+
+// notify signals the pod changes without blocking, the changes not received yet are merged
+func (c *informerCache) notify() {
+	select {
+	case c.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Changes the pods of the managed namespaces are changed
+func (k *kubeImpl) Changes() <-chan struct{} {
+	if k.cache == nil {
+		return nil
+	}
+	return k.cache.changes
+}
+
+// close stops the informers of all the namespaces
+func (c *informerCache) close() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() { close(c.stop) })
+}
+
+// Close stops the informer cache
+func (k *kubeImpl) Close() error {
+	k.cache.close()
+	return nil
+}
+
+// unsynced returns the resources whose informers are not synced
+func (nc *nsCache) unsynced() []string {
+	var res []string
+	for resource, synced := range nc.synced {
+		if !synced() {
+			res = append(res, resource)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (k *kubeImpl) listDeployments(ns string) ([]appv1.Deployment, error) {
+	if nc := k.cache.get(ns, cacheDeployments); nc != nil {
+		items, err := nc.deploys.Deployments(ns).List(labels.Everything())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]appv1.Deployment, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.app.Deployments(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listReplicaSets(ns string, set labels.Set) ([]appv1.ReplicaSet, error) {
+	selector := labels.SelectorFromSet(set)
+	if nc := k.cache.get(ns, cacheReplicaSets); nc != nil {
+		items, err := nc.replicaSets.ReplicaSets(ns).List(selector)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]appv1.ReplicaSet, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.app.ReplicaSets(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listDaemonSets(ns string) ([]appv1.DaemonSet, error) {
+	if nc := k.cache.get(ns, cacheDaemonSets); nc != nil {
+		items, err := nc.daemons.DaemonSets(ns).List(labels.Everything())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]appv1.DaemonSet, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.app.DaemonSets(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listStatefulSets(ns string) ([]appv1.StatefulSet, error) {
+	if nc := k.cache.get(ns, cacheStatefulSets); nc != nil {
+		items, err := nc.statefulSets.StatefulSets(ns).List(labels.Everything())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]appv1.StatefulSet, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.app.StatefulSets(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listJobs(ns string) ([]batchv1.Job, error) {
+	if nc := k.cache.get(ns, cacheJobs); nc != nil {
+		items, err := nc.jobs.Jobs(ns).List(labels.Everything())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]batchv1.Job, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.batch.Jobs(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listCronJobs(ns string) ([]batchv1.CronJob, error) {
+	if nc := k.cache.get(ns, cacheCronJobs); nc != nil {
+		items, err := nc.cronJobs.CronJobs(ns).List(labels.Everything())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]batchv1.CronJob, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.batch.CronJobs(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listPods(ns string, set labels.Set) ([]corev1.Pod, error) {
+	selector := labels.SelectorFromSet(set)
+	if nc := k.cache.get(ns, cachePods); nc != nil {
+		items, err := nc.pods.Pods(ns).List(selector)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]corev1.Pod, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.core.Pods(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+func (k *kubeImpl) listVolumeClaims(ns string, set labels.Set) ([]corev1.PersistentVolumeClaim, error) {
+	selector := labels.SelectorFromSet(set)
+	if nc := k.cache.get(ns, cacheClaims); nc != nil {
+		items, err := nc.claims.PersistentVolumeClaims(ns).List(selector)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res := make([]corev1.PersistentVolumeClaim, 0, len(items))
+		for _, item := range items {
+			res = append(res, *item)
+		}
+		return res, nil
+	}
+	list, err := k.cli.core.PersistentVolumeClaims(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,122 @@
+package kube
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+func TestInformerCache(t *testing.T) {
+	ns := "baetyl-edge"
+	replicas := int32(1)
+	fc := fake.NewSimpleClientset(
+		&appv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: ns, Labels: map[string]string{AppName: "app1", AppVersion: "v1"}},
+			Spec: appv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: "app1"}},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app1-0", Namespace: ns, Labels: map[string]string{AppName: "app1"}},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app2-0", Namespace: ns, Labels: map[string]string{AppName: "app2"}},
+		},
+	)
+	cfg := config.KubeCacheConfig{Resync: time.Minute, SyncTimeout: 5 * time.Second}
+	assert.Nil(t, newInformerCache(fc, config.KubeCacheConfig{Disable: true}, nil))
+	assert.Nil(t, newInformerCache(nil, cfg, nil))
+
+	am := &kubeImpl{
+		cli:   &client{core: fc.CoreV1(), app: fc.AppsV1(), batch: fc.BatchV1()},
+		cache: newInformerCache(fc, cfg, func() bool { return true }),
+	}
+	defer am.cache.close()
+	assert.NotNil(t, am.Changes())
+
+	deploys, err := am.listDeployments(ns)
+	assert.NoError(t, err)
+	assert.Len(t, deploys, 1)
+	assert.Equal(t, "app1", deploys[0].Name)
+	pods, err := am.listPods(ns, labels.Set{AppName: "app1"})
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	assert.Equal(t, "app1-0", pods[0].Name)
+	jobs, err := am.listJobs(ns)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 0)
+
+	// drain the changes of the initial pods
+	select {
+	case <-am.Changes():
+	case <-time.After(time.Second):
+	}
+	_, err = fc.CoreV1().Pods(ns).Create(context.TODO(), &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app1-1", Namespace: ns, Labels: map[string]string{AppName: "app1"}},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	select {
+	case <-am.Changes():
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "pod change is not notified")
+	}
+	assert.Eventually(t, func() bool {
+		pods, err = am.listPods(ns, labels.Set{AppName: "app1"})
+		return err == nil && len(pods) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	am.cache = nil
+	assert.Nil(t, am.Changes())
+	deploys, err = am.listDeployments(ns)
+	assert.NoError(t, err)
+	assert.Len(t, deploys, 1)
+}
+
+func TestInformerCacheUnsynced(t *testing.T) {
+	ns := "baetyl-edge"
+	fc := fake.NewSimpleClientset(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "job1", Namespace: ns}})
+	// the jobs are denied to list until the informer cache is started
+	var denied int32 = 1
+	fc.PrependReactor("list", "jobs", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.LoadInt32(&denied) == 1 {
+			return true, nil, kerrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "", nil)
+		}
+		return false, nil, nil
+	})
+	cfg := config.KubeCacheConfig{Resync: time.Minute, SyncTimeout: 300 * time.Millisecond}
+	am := &kubeImpl{
+		cli:   &client{core: fc.CoreV1(), app: fc.AppsV1(), batch: fc.BatchV1()},
+		cache: newInformerCache(fc, cfg, nil),
+	}
+	defer am.Close()
+
+	// only the jobs are listed from the api server
+	assert.NotNil(t, am.cache.get(ns, cacheDeployments))
+	assert.Nil(t, am.cache.get(ns, cacheJobs))
+	assert.Nil(t, am.cache.get(ns, cacheCronJobs))
+	assert.Equal(t, []string{cacheJobs}, am.cache.nss[ns].unsynced())
+	atomic.StoreInt32(&denied, 0)
+	jobs, err := am.listJobs(ns)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	// the informers are stopped once
+	assert.NoError(t, am.Close())
+	assert.NoError(t, am.Close())
+}
@@ -9,6 +9,7 @@ import (
 	v2 "k8s.io/client-go/kubernetes/typed/autoscaling/v2"
 	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	networkingv1 "k8s.io/client-go/kubernetes/typed/networking/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientset "k8s.io/metrics/pkg/client/clientset/versioned"
@@ -19,13 +20,15 @@ import (
 
 type client struct {
 	kubeConfig *rest.Config
+	clientset  kubernetes.Interface
 	core       corev1.CoreV1Interface
 	app        appv1.AppsV1Interface
 	batch      batchv1.BatchV1Interface
 	metrics    metricsv1beta1.MetricsV1beta1Interface
 	discovery  discovery.DiscoveryInterface
 	autoscale  v2.AutoscalingV2Interface
-	dynamic    *dynamic.DynamicClient
+	networking networkingv1.NetworkingV1Interface
+	dynamic    dynamic.Interface
 }
 
 func newClient(cfg config.KubeConfig) (*client, error) {
@@ -54,12 +57,14 @@ func newClient(cfg config.KubeConfig) (*client, error) {
 	}
 	return &client{
 		kubeConfig: kubeConfig,
+		clientset:  kubeClient,
 		core:       kubeClient.CoreV1(),
 		app:        kubeClient.AppsV1(),
 		batch:      kubeClient.BatchV1(),
 		metrics:    metricsCli.MetricsV1beta1(),
 		discovery:  kubeClient.Discovery(),
 		autoscale:  kubeClient.AutoscalingV2(),
+		networking: kubeClient.NetworkingV1(),
 		dynamic:    dynamicClient,
 	}, nil
 }
@@ -2,6 +2,7 @@ package kube
 
 import (
 	"context"
+	"strings"
 
 	gctx "github.com/baetyl/baetyl-go/v2/context"
 	"github.com/baetyl/baetyl-go/v2/errors"
@@ -23,8 +24,11 @@ type appInfo struct {
 	// Deprecated: Field svcName is no longer used.
 	svcName  string
 	typ      string
+	workload string // the name of the workload object
 	replicas int32
 	set      labels.Set
+	rollout  string // the progress of the deployment rollout not completed
+	revision string // the revision of the deployment, whose replica set creates the current pods
 }
 
 func (k *kubeImpl) GetModeInfo() (interface{}, error) {
@@ -168,6 +172,17 @@ func (k *kubeImpl) CollectNodeStats() (map[string]interface{}, error) {
 			}
 		}
 
+		// the apps are admitted against the allocatable resources, the capacity includes the reserved ones of the system
+		if len(node.Status.Allocatable) > 0 {
+			if nodeStatsMerge == nil {
+				nodeStatsMerge = make(map[string]interface{}, 0)
+			}
+			allocatable := map[string]string{}
+			for res, quan := range node.Status.Allocatable {
+				allocatable[string(res)] = quan.String()
+			}
+			nodeStatsMerge[ami.NodeStatsAllocatable] = allocatable
+		}
 		nodeStats.Extension = nodeStatsMerge
 		infos[node.Name] = nodeStats
 	}
@@ -185,39 +200,68 @@ func (k *kubeImpl) collectAppStats(appStats map[string]specv1.AppStats, qps map[
 			DeployType: info.typ,
 		}
 	}
-	pods, err := k.cli.core.Pods(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(info.set).String()})
+	pods, err := k.listPods(ns, info.set)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if pods == nil || len(pods.Items) == 0 {
+	claimCause := k.volumeClaimCause(ns, info.name)
+	if len(pods) == 0 {
+		cause := k.workloadWarnings(ns, info)
+		if cause != "" && claimCause != "" {
+			cause += "; "
+		}
+		cause += claimCause
+		if cause != "" {
+			stats.Status = specv1.Pending
+			stats.Cause = cause
+			appStats[info.name] = stats
+		}
 		return nil
 	}
 	if stats.InstanceStats == nil {
 		stats.InstanceStats = map[string]specv1.InstanceStats{}
 	}
 	insStats := map[string]specv1.InstanceStats{}
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
 		stats.InstanceStats[pod.Name] = k.collectInstanceStats(ns, info.name, qps, &pod)
 		insStats[pod.Name] = stats.InstanceStats[pod.Name]
 
 	}
-	stats.Status = getAppStatus(stats.Status, info.replicas, insStats)
+	replicas := info.replicas
+	if info.typ == WorkloadCronJob {
+		// the pods of the scheduled jobs come and go, all the existing ones are expected to run or succeed
+		replicas = int32(len(pods))
+	}
+	stats.Status = getAppStatus(stats.Status, replicas, insStats)
+	// the pods wait for the volumes to bind, or the new version is rolling out
+	var causes []string
+	for _, cause := range []string{claimCause, info.rollout} {
+		if cause != "" {
+			causes = append(causes, cause)
+		}
+	}
+	if len(causes) > 0 {
+		stats.Cause = strings.Join(causes, "; ")
+	}
 	appStats[info.name] = stats
 	return nil
 }
 
 func (k *kubeImpl) collectDeploymentStats(ns string, qps map[string]interface{}) ([]specv1.AppStats, error) {
-	deploys, err := k.cli.app.Deployments(ns).List(context.TODO(), metav1.ListOptions{})
+	deploys, err := k.listDeployments(ns)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	appStats := map[string]specv1.AppStats{}
 	info := appInfo{typ: specv1.WorkloadDeployment}
-	for _, deploy := range deploys.Items {
+	for _, deploy := range deploys {
 		info.name = deploy.Labels[AppName]
+		info.workload = deploy.Name
 		info.version = deploy.Labels[AppVersion]
 		info.replicas = *deploy.Spec.Replicas
 		info.set = deploy.Spec.Selector.MatchLabels
+		info.rollout = deploymentRollout(&deploy)
+		info.revision = deploy.Annotations[DeploymentRevision]
 		err = k.collectAppStats(appStats, qps, ns, info)
 		if err != nil {
 			return nil, errors.Trace(err)
@@ -231,14 +275,15 @@ func (k *kubeImpl) collectDeploymentStats(ns string, qps map[string]interface{})
 }
 
 func (k *kubeImpl) collectDaemonSetStats(ns string, qps map[string]interface{}) ([]specv1.AppStats, error) {
-	daemons, err := k.cli.app.DaemonSets(ns).List(context.TODO(), metav1.ListOptions{})
+	daemons, err := k.listDaemonSets(ns)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	appStats := map[string]specv1.AppStats{}
 	info := appInfo{typ: specv1.WorkloadDaemonSet}
-	for _, daemon := range daemons.Items {
+	for _, daemon := range daemons {
 		info.name = daemon.Labels[AppName]
+		info.workload = daemon.Name
 		info.version = daemon.Labels[AppVersion]
 		info.set = daemon.Spec.Selector.MatchLabels
 		info.replicas = daemon.Status.DesiredNumberScheduled
@@ -255,14 +300,18 @@ func (k *kubeImpl) collectDaemonSetStats(ns string, qps map[string]interface{})
 }
 
 func (k *kubeImpl) collectJobStats(ns string, qps map[string]interface{}) ([]specv1.AppStats, error) {
-	jobs, err := k.cli.batch.Jobs(ns).List(context.TODO(), metav1.ListOptions{})
+	jobs, err := k.listJobs(ns)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	appStats := map[string]specv1.AppStats{}
 	info := appInfo{typ: specv1.WorkloadJob}
-	for _, job := range jobs.Items {
+	for _, job := range jobs {
+		if ownedByCronJob(&job) {
+			continue
+		}
 		info.name = job.Labels[AppName]
+		info.workload = job.Name
 		info.version = job.Labels[AppVersion]
 		info.replicas = *job.Spec.Completions
 		info.set = job.Spec.Selector.MatchLabels
@@ -278,15 +327,84 @@ func (k *kubeImpl) collectJobStats(ns string, qps map[string]interface{}) ([]spe
 	return res, nil
 }
 
+func (k *kubeImpl) collectStatefulSetStats(ns string, qps map[string]interface{}) ([]specv1.AppStats, error) {
+	statefulSets, err := k.listStatefulSets(ns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	appStats := map[string]specv1.AppStats{}
+	info := appInfo{typ: specv1.WorkloadStatefulSet}
+	for _, sts := range statefulSets {
+		info.name = sts.Labels[AppName]
+		info.workload = sts.Name
+		info.version = sts.Labels[AppVersion]
+		info.replicas = *sts.Spec.Replicas
+		info.set = sts.Spec.Selector.MatchLabels
+		err = k.collectAppStats(appStats, qps, ns, info)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	var res []specv1.AppStats
+	for _, stats := range appStats {
+		res = append(res, stats)
+	}
+	return res, nil
+}
+
+func (k *kubeImpl) collectCronJobStats(ns string, qps map[string]interface{}) ([]specv1.AppStats, error) {
+	if !k.cronJobAvailable() {
+		return nil, nil
+	}
+	cronJobs, err := k.listCronJobs(ns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	appStats := map[string]specv1.AppStats{}
+	info := appInfo{typ: WorkloadCronJob}
+	for _, cj := range cronJobs {
+		info.name = cj.Labels[AppName]
+		info.workload = cj.Name
+		info.version = cj.Labels[AppVersion]
+		info.set = labels.Set{AppName: info.name}
+		err = k.collectAppStats(appStats, qps, ns, info)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// the cron job is running on schedule before its first job
+		if _, ok := appStats[info.name]; !ok && info.name != "" {
+			appStats[info.name] = specv1.AppStats{
+				AppInfo:    specv1.AppInfo{Name: info.name, Version: info.version},
+				DeployType: info.typ,
+				Status:     specv1.Running,
+			}
+		}
+	}
+	var res []specv1.AppStats
+	for _, stats := range appStats {
+		res = append(res, stats)
+	}
+	return res, nil
+}
+
 func (k *kubeImpl) collectInstanceStats(ns, appName string, qps map[string]interface{}, pod *corev1.Pod) specv1.InstanceStats {
 	stats := specv1.InstanceStats{Name: pod.Name, AppName: appName, Usage: map[string]string{}}
 	stats.CreateTime = pod.CreationTimestamp.Local()
 	stats.Status = specv1.Status(pod.Status.Phase)
 	stats.Cause = pod.Status.Reason
+	if !podHealthy(pod) {
+		if warnings := k.warningsOf(ns, podRefs(pod)...); warnings != "" {
+			if stats.Cause != "" {
+				stats.Cause += ": "
+			}
+			stats.Cause += warnings
+		}
+	}
 
 	for _, initStatus := range pod.Status.InitContainerStatuses {
 		containerInfo := specv1.ContainerInfo{Name: initStatus.Name}
 		containerInfo.State, containerInfo.Reason = getContainerStatus(&initStatus)
+		containerInfo.Reason = containerReason(&initStatus, containerInfo.Reason)
 		stats.InitContainers = append(stats.InitContainers, containerInfo)
 	}
 
@@ -321,6 +439,7 @@ func (k *kubeImpl) collectInstanceStats(ns, appName string, qps map[string]inter
 	for _, containerStatus := range pod.Status.ContainerStatuses {
 		containerInfo := specv1.ContainerInfo{Name: containerStatus.Name}
 		containerInfo.State, containerInfo.Reason = getContainerStatus(&containerStatus)
+		containerInfo.Reason = containerReason(&containerStatus, containerInfo.Reason)
 		if metrics, ok := metricsStatus[containerStatus.Name]; ok {
 			containerInfo.Usage = metrics.Usage
 		}
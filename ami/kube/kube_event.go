@@ -0,0 +1,247 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+const (
+	// DeploymentRevision the annotation of the revision set on the deployment and its replica sets by kubernetes
+	DeploymentRevision = "deployment.kubernetes.io/revision"
+	// eventObjectIndex the index of the events by the kind and name of the involved object
+	eventObjectIndex = "involvedObject"
+	// maxWarnings the most recent warnings reported for each instance
+	maxWarnings = 5
+)
+
+// objectRef the object involved in events
+type objectRef struct {
+	kind string
+	name string
+}
+
+func (r objectRef) key() string {
+	return r.kind + "/" + r.name
+}
+
+func indexEventObject(obj interface{}) ([]string, error) {
+	e, ok := obj.(*corev1.Event)
+	if !ok {
+		return nil, nil
+	}
+	return []string{objectRef{kind: e.InvolvedObject.Kind, name: e.InvolvedObject.Name}.key()}, nil
+}
+
+// listWarnings lists the warning events of the object
+func (k *kubeImpl) listWarnings(ns string, ref objectRef) ([]corev1.Event, error) {
+	var events []corev1.Event
+	if nc := k.cache.get(ns, cacheEvents); nc != nil {
+		items, err := nc.events.ByIndex(eventObjectIndex, ref.key())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, item := range items {
+			if e, ok := item.(*corev1.Event); ok {
+				events = append(events, *e)
+			}
+		}
+	} else {
+		selector := fields.Set{
+			"involvedObject.kind": ref.kind,
+			"involvedObject.name": ref.name,
+			"type":                corev1.EventTypeWarning,
+		}.AsSelector().String()
+		list, err := k.cli.core.Events(ns).List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		events = list.Items
+	}
+	var res []corev1.Event
+	for _, e := range events {
+		if e.Type == corev1.EventTypeWarning && e.InvolvedObject.Kind == ref.kind && e.InvolvedObject.Name == ref.name {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// warningsOf summarizes the warnings of the objects, the failures to list events are logged only
+func (k *kubeImpl) warningsOf(ns string, refs ...objectRef) string {
+	var events []corev1.Event
+	for _, ref := range refs {
+		es, err := k.listWarnings(ns, ref)
+		if err != nil {
+			k.log.Warn("failed to list warning events", log.Any("namespace", ns), log.Any("object", ref.key()), log.Error(err))
+			continue
+		}
+		events = append(events, es...)
+	}
+	return summarizeWarnings(events)
+}
+
+// summarizeWarnings deduplicates the warnings by reason and message, the most recent ones are listed first
+func summarizeWarnings(events []corev1.Event) string {
+	type warning struct {
+		reason  string
+		message string
+		count   int32
+		last    time.Time
+	}
+	var warnings []*warning
+	index := map[string]*warning{}
+	for _, e := range events {
+		key := e.Reason + "\n" + e.Message
+		w, ok := index[key]
+		if !ok {
+			w = &warning{reason: e.Reason, message: strings.TrimSpace(e.Message)}
+			index[key] = w
+			warnings = append(warnings, w)
+		}
+		w.count += eventCount(&e)
+		if t := eventTime(&e); t.After(w.last) {
+			w.last = t
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].last.After(warnings[j].last)
+	})
+	if len(warnings) > maxWarnings {
+		warnings = warnings[:maxWarnings]
+	}
+	var items []string
+	for _, w := range warnings {
+		item := w.reason
+		if w.message != "" {
+			item += ": " + w.message
+		}
+		if w.count > 1 {
+			item += fmt.Sprintf(" (x%d)", w.count)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, "; ")
+}
+
+func eventCount(e *corev1.Event) int32 {
+	count := e.Count
+	if e.Series != nil && e.Series.Count > count {
+		count = e.Series.Count
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	if e.Series != nil && !e.Series.LastObservedTime.IsZero() {
+		return e.Series.LastObservedTime.Time
+	}
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.FirstTimestamp.Time
+}
+
+// podRefs the pod and its controllers, such as the replica set or the job, whose events explain the pod failures
+func podRefs(pod *corev1.Pod) []objectRef {
+	refs := []objectRef{{kind: "Pod", name: pod.Name}}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			refs = append(refs, objectRef{kind: owner.Kind, name: owner.Name})
+		}
+	}
+	return refs
+}
+
+// podHealthy whether the pod runs or succeeds with all containers ready, the warnings of a healthy pod are outdated
+func podHealthy(pod *corev1.Pod) bool {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return true
+	case corev1.PodRunning:
+		for _, cs := range pod.Status.ContainerStatuses {
+			if !cs.Ready {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// workloadWarnings summarizes the warnings of the workload which creates no pods, such as exceeding the quota
+func (k *kubeImpl) workloadWarnings(ns string, info appInfo) string {
+	var refs []objectRef
+	switch info.typ {
+	case specv1.WorkloadDeployment:
+		// the pods are created by the replica set of the current revision, the warnings of the old ones are outdated
+		rss, err := k.listReplicaSets(ns, info.set)
+		if err != nil {
+			k.log.Warn("failed to list replica sets", log.Any("namespace", ns), log.Any("app", info.name), log.Error(err))
+			return ""
+		}
+		for _, rs := range rss {
+			owner := metav1.GetControllerOf(&rs)
+			if owner == nil || owner.Kind != "Deployment" || owner.Name != info.workload {
+				continue
+			}
+			if info.revision != "" && rs.Annotations[DeploymentRevision] != info.revision {
+				continue
+			}
+			refs = append(refs, objectRef{kind: "ReplicaSet", name: rs.Name})
+		}
+	case specv1.WorkloadDaemonSet:
+		refs = append(refs, objectRef{kind: "DaemonSet", name: info.workload})
+	case specv1.WorkloadStatefulSet:
+		refs = append(refs, objectRef{kind: "StatefulSet", name: info.workload})
+	case specv1.WorkloadJob:
+		refs = append(refs, objectRef{kind: "Job", name: info.workload})
+	default:
+		return ""
+	}
+	return k.warningsOf(ns, refs...)
+}
+
+// containerReason adds the message, restart count and last termination of the container to the reason
+func containerReason(info *corev1.ContainerStatus, reason string) string {
+	items := []string{reason}
+	if info.State.Waiting != nil && info.State.Waiting.Message != "" {
+		items[0] = fmt.Sprintf("%s: %s", reason, info.State.Waiting.Message)
+	}
+	if t := info.State.Terminated; t != nil {
+		items[0] = fmt.Sprintf("%s (exit code %d)", reason, t.ExitCode)
+		if t.Message != "" {
+			items[0] += ": " + strings.TrimSpace(t.Message)
+		}
+	}
+	if info.RestartCount > 0 {
+		items = append(items, fmt.Sprintf("restarts: %d", info.RestartCount))
+	}
+	if t := info.LastTerminationState.Terminated; t != nil {
+		last := fmt.Sprintf("last terminated: %s (exit code %d)", t.Reason, t.ExitCode)
+		if !t.FinishedAt.IsZero() {
+			last += " at " + t.FinishedAt.UTC().Format(time.RFC3339)
+		}
+		items = append(items, last)
+	}
+	if items[0] == "" {
+		items = items[1:]
+	}
+	return strings.Join(items, "; ")
+}
@@ -0,0 +1,200 @@
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	appv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+func genWarning(name, kind, objName, reason, message string, count int32, last time.Time) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "baetyl-edge"},
+		InvolvedObject: v1.ObjectReference{Kind: kind, Name: objName, Namespace: "baetyl-edge"},
+		Type:           v1.EventTypeWarning,
+		Reason:         reason,
+		Message:        message,
+		Count:          count,
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+func TestSummarizeWarnings(t *testing.T) {
+	now := time.Now()
+	events := []v1.Event{
+		*genWarning("e1", "Pod", "p1", "Failed", "Failed to pull image \"x\"", 2, now.Add(-time.Minute)),
+		*genWarning("e2", "Pod", "p1", "BackOff", "Back-off pulling image \"x\"", 1, now),
+		*genWarning("e3", "Pod", "p1", "Failed", "Failed to pull image \"x\"", 3, now.Add(-2*time.Minute)),
+	}
+	assert.Equal(t, "BackOff: Back-off pulling image \"x\"; Failed: Failed to pull image \"x\" (x5)", summarizeWarnings(events))
+	assert.Equal(t, "", summarizeWarnings(nil))
+
+	events = nil
+	for i := 0; i < maxWarnings+2; i++ {
+		events = append(events, *genWarning("e", "Pod", "p1", "Unhealthy", string(rune('a'+i)), 1, now.Add(time.Duration(i)*time.Second)))
+	}
+	assert.Equal(t, "Unhealthy: g; Unhealthy: f; Unhealthy: e; Unhealthy: d; Unhealthy: c", summarizeWarnings(events))
+}
+
+func TestContainerReason(t *testing.T) {
+	finished := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := &v1.ContainerStatus{
+		RestartCount: 3,
+		State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 40s restarting failed container"},
+		},
+		LastTerminationState: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "Error", ExitCode: 1, FinishedAt: metav1.NewTime(finished)},
+		},
+	}
+	_, reason := getContainerStatus(info)
+	assert.Equal(t, "CrashLoopBackOff: back-off 40s restarting failed container; restarts: 3; last terminated: Error (exit code 1) at 2026-01-02T03:04:05Z", containerReason(info, reason))
+
+	info = &v1.ContainerStatus{
+		RestartCount:         1,
+		State:                v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+	}
+	_, reason = getContainerStatus(info)
+	assert.Equal(t, "restarts: 1; last terminated: OOMKilled (exit code 137)", containerReason(info, reason))
+
+	info = &v1.ContainerStatus{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Completed"}}}
+	_, reason = getContainerStatus(info)
+	assert.Equal(t, "Completed (exit code 0)", containerReason(info, reason))
+
+	info = &v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}
+	_, reason = getContainerStatus(info)
+	assert.Equal(t, "", containerReason(info, reason))
+}
+
+func TestCollectWarnings(t *testing.T) {
+	ns := "baetyl-edge"
+	now := time.Now()
+	controller := true
+	replicas := int32(1)
+	fc := fake.NewSimpleClientset(
+		&appv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: ns, Labels: map[string]string{AppName: "app1"}},
+			Spec: appv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: "app1"}},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "app1-rs1-x",
+				Namespace:       ns,
+				Labels:          map[string]string{AppName: "app1"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app1-rs1", Controller: &controller}},
+			},
+			Status: v1.PodStatus{Phase: v1.PodPending},
+		},
+		genWarning("e1", "Pod", "app1-rs1-x", "FailedScheduling", "0/1 nodes are available: 1 Insufficient cpu.", 4, now),
+		genWarning("e2", "Pod", "other", "FailedScheduling", "not mine", 1, now),
+		&appv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app2",
+				Namespace:   ns,
+				Labels:      map[string]string{AppName: "app2"},
+				Annotations: map[string]string{DeploymentRevision: "2"},
+			},
+			Spec: appv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: "app2"}},
+			},
+		},
+		&appv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "app2-rs1",
+				Namespace:       ns,
+				Labels:          map[string]string{AppName: "app2"},
+				Annotations:     map[string]string{DeploymentRevision: "2"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "app2", Controller: &controller}},
+			},
+		},
+		genWarning("e3", "ReplicaSet", "app2-rs1", "FailedCreate", "exceeded quota: pods", 2, now),
+		// the warnings of the old revision and the replica set not owned by the deployment are outdated
+		&appv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "app2-rs0",
+				Namespace:       ns,
+				Labels:          map[string]string{AppName: "app2"},
+				Annotations:     map[string]string{DeploymentRevision: "1"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "app2", Controller: &controller}},
+			},
+		},
+		genWarning("e4", "ReplicaSet", "app2-rs0", "FailedCreate", "old revision", 1, now),
+		&appv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "app2-other", Namespace: ns, Labels: map[string]string{AppName: "app2"}},
+		},
+		genWarning("e5", "ReplicaSet", "app2-other", "FailedCreate", "not owned", 1, now),
+		&appv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app3", Namespace: ns, Labels: map[string]string{AppName: "app3"}},
+			Spec: appv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: "app3"}},
+			},
+		},
+	)
+	am := &kubeImpl{
+		cli: &client{
+			core:    fc.CoreV1(),
+			app:     fc.AppsV1(),
+			batch:   fc.BatchV1(),
+			metrics: metricsfake.NewSimpleClientset().MetricsV1beta1(),
+		},
+		log: log.With(),
+	}
+	stats, err := am.collectDeploymentStats(ns, nil)
+	assert.NoError(t, err)
+	res := map[string]specv1.AppStats{}
+	for _, s := range stats {
+		res[s.Name] = s
+	}
+	// the app without pods and warnings is not reported
+	assert.Len(t, res, 2)
+	assert.Equal(t, specv1.Pending, res["app1"].Status)
+	assert.Equal(t, "FailedScheduling: 0/1 nodes are available: 1 Insufficient cpu. (x4)", res["app1"].InstanceStats["app1-rs1-x"].Cause)
+	assert.Equal(t, specv1.Pending, res["app2"].Status)
+	assert.Equal(t, "FailedCreate: exceeded quota: pods (x2)", res["app2"].Cause)
+
+	pods, err := am.listPods(ns, labels.Set{AppName: "app1"})
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	pods[0].Status.Phase = v1.PodRunning
+	assert.True(t, podHealthy(&pods[0]))
+	ins := am.collectInstanceStats(ns, "app1", nil, &pods[0])
+	assert.Equal(t, "", ins.Cause)
+
+	// the events are looked up from the cache by the involved objects
+	am.cache = newInformerCache(fc, config.KubeCacheConfig{Resync: time.Minute, SyncTimeout: 5 * time.Second}, nil)
+	defer am.cache.close()
+	events, err := am.listWarnings(ns, objectRef{kind: "Pod", name: "app1-rs1-x"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "e1", events[0].Name)
+	events, err = am.listWarnings(ns, objectRef{kind: "ReplicaSet", name: "app2-rs1"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	// and the replica sets are read from the cache
+	rss, err := am.listReplicaSets(ns, labels.Set{AppName: "app2"})
+	assert.NoError(t, err)
+	assert.Len(t, rss, 3)
+	stats, err = am.collectDeploymentStats(ns, nil)
+	assert.NoError(t, err)
+	for _, s := range stats {
+		if s.Name == "app2" {
+			assert.Equal(t, "FailedCreate: exceeded quota: pods (x2)", s.Cause)
+		}
+	}
+}
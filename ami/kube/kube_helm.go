@@ -4,19 +4,23 @@ package kube
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 	logv2 "github.com/baetyl/baetyl-go/v2/log"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"gopkg.in/yaml.v3"
 	"helm.sh/helm/v3/pkg/action"
-	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/release"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
 )
 
 const (
@@ -27,6 +31,8 @@ const (
 	AnnotationReleaseKey = "meta.helm.sh/release-name"
 
 	ErrNotHelmApp = "not a helm app"
+
+	defaultHelmTimeout = 5 * time.Minute
 )
 
 // collectHelmStats collects the stats of helm by namespace and app names
@@ -152,6 +158,7 @@ func (k *kubeImpl) StatsHelm(_ string) ([]specv1.AppStats, error) {
 				Version: h.Labels[BaetylHelmVersion],
 			},
 			Status:     transStatus(h.Info.Status),
+			Cause:      releaseCause(h),
 			DeployType: specv1.WorkloadCustom,
 		}
 		if _, ok := nsMap[h.Namespace]; !ok {
@@ -196,7 +203,7 @@ func (k *kubeImpl) DeleteHelmByCfg(cfg *action.Configuration, app string) error
 }
 
 // ApplyHelm apply the helm release
-func (k *kubeImpl) ApplyHelm(ns string, app specv1.Application, cfgs map[string]specv1.Configuration) error {
+func (k *kubeImpl) ApplyHelm(ctx context.Context, ns string, app specv1.Application, cfgs map[string]specv1.Configuration) error {
 	ns, ok := app.Labels[specv1.CustomAppNsLabel]
 	if !ok || ns == "" {
 		ns = DefaultHelmNamespace
@@ -205,6 +212,10 @@ func (k *kubeImpl) ApplyHelm(ns string, app specv1.Application, cfgs map[string]
 	if err := helmCfg.Init(&genericclioptions.ConfigFlags{Namespace: &ns}, ns, os.Getenv(HelmDriver), log.Printf); err != nil {
 		return errors.Trace(err)
 	}
+	return k.applyHelm(ctx, helmCfg, ns, app, cfgs)
+}
+
+func (k *kubeImpl) applyHelm(ctx context.Context, helmCfg *action.Configuration, ns string, app specv1.Application, cfgs map[string]specv1.Configuration) error {
 	old, err := k.GetHelm(helmCfg, app.Name)
 	// already exists, check version
 	if err == nil {
@@ -217,14 +228,18 @@ func (k *kubeImpl) ApplyHelm(ns string, app specv1.Application, cfgs map[string]
 				return errors.Trace(err)
 			}
 		} else {
-			return k.UpdateHelm(helmCfg, app, cfgs, old)
+			return k.UpdateHelm(ctx, helmCfg, app, cfgs, old)
 		}
 	}
+	conf := k.helmConf()
 	cli := action.NewInstall(helmCfg)
 	cli.Namespace = ns
 	cli.ReleaseName = app.Name
 	cli.CreateNamespace = true
 	cli.Labels = map[string]string{BaetylHelmVersion: app.Version}
+	// the failed release is uninstalled
+	cli.Atomic = true
+	cli.Timeout = conf.Timeout
 	if len(app.Services) != 1 || len(app.Volumes) < 1 {
 		return errors.Trace(errors.New("helm chart only support one service"))
 	}
@@ -237,22 +252,39 @@ func (k *kubeImpl) ApplyHelm(ns string, app specv1.Application, cfgs map[string]
 	if err != nil {
 		return errors.Trace(err)
 	}
-	chart, err := loader.Load(dir)
+	chart, err := k.loadChart(app, dir)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	rel, err := cli.Run(chart, vals)
+	// the atomic release is uninstalled once the context is done
+	rel, err := cli.RunWithContext(ctx, chart, vals)
 	if rel != nil {
 		k.log.Debug("helm install", logv2.Any("release", rel.Name))
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	if err = pruneChartCache(conf.ChartCache, app); err != nil {
+		k.log.Warn("failed to prune chart cache", logv2.Any("app", app.Name), logv2.Error(err))
+	}
+	return nil
 }
 
 // UpdateHelm updates the helm release
-func (k *kubeImpl) UpdateHelm(cfg *action.Configuration, app specv1.Application, cfgs map[string]specv1.Configuration, old *release.Release) error {
+func (k *kubeImpl) UpdateHelm(ctx context.Context, cfg *action.Configuration, app specv1.Application, cfgs map[string]specv1.Configuration, old *release.Release) error {
+	conf := k.helmConf()
 	cli := action.NewUpgrade(cfg)
-	old.Labels[BaetylHelmVersion] = app.Version
-	cli.Labels = old.Labels
+	// the failed upgrade is rolled back to the last deployed revision
+	cli.Atomic = true
+	cli.Timeout = conf.Timeout
+	cli.MaxHistory = conf.HistoryMax
+	previous := old.Labels[BaetylHelmVersion]
+	labels := map[string]string{}
+	for key, val := range old.Labels {
+		labels[key] = val
+	}
+	labels[BaetylHelmVersion] = app.Version
+	cli.Labels = labels
 	if len(app.Services) != 1 || len(app.Volumes) < 1 {
 		return errors.Trace(errors.New("helm chart only support one service"))
 	}
@@ -265,15 +297,34 @@ func (k *kubeImpl) UpdateHelm(cfg *action.Configuration, app specv1.Application,
 	if err != nil {
 		return errors.Trace(err)
 	}
-	chart, err := loader.Load(dir)
+	chart, err := k.loadChart(app, dir)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	rel, err := cli.Run(app.Name, chart, vals)
+	rel, err := cli.RunWithContext(ctx, app.Name, chart, vals)
 	if rel != nil {
-		k.log.Debug("helm upgrade", logv2.Any("release", rel.Name))
+		k.log.Debug("helm upgrade", logv2.Any("release", rel.Name), logv2.Any("revision", rel.Version))
 	}
-	return err
+	if err != nil {
+		// the previous version keeps running if the release is rolled back
+		if cur, e := k.GetHelm(cfg, app.Name); e == nil && cur.Info.Status == release.StatusDeployed && cur.Version > old.Version {
+			// the labels are not kept by the rollback release
+			cur.Labels = old.Labels
+			if e = cfg.Releases.Update(cur); e != nil {
+				k.log.Warn("failed to label the rollback release", logv2.Any("release", app.Name), logv2.Error(e))
+			}
+			return &ami.RollbackError{
+				App:      specv1.AppInfo{Name: app.Name, Version: app.Version},
+				Previous: specv1.AppInfo{Name: app.Name, Version: previous},
+				Err:      err,
+			}
+		}
+		return errors.Trace(err)
+	}
+	if err = pruneChartCache(conf.ChartCache, app); err != nil {
+		k.log.Warn("failed to prune chart cache", logv2.Any("app", app.Name), logv2.Error(err))
+	}
+	return nil
 }
 
 // DeleteHelm check if the helm release exists, if not delete it
@@ -295,7 +346,13 @@ func (k *kubeImpl) DeleteHelm(app string) error {
 	if err = helmCfg.Init(&genericclioptions.ConfigFlags{Namespace: &ns}, ns, os.Getenv(HelmDriver), log.Printf); err != nil {
 		return errors.Trace(err)
 	}
-	return k.DeleteHelmByCfg(helmCfg, app)
+	if err = k.DeleteHelmByCfg(helmCfg, app); err != nil {
+		return err
+	}
+	if err = removeChartCache(k.helmConf().ChartCache, app); err != nil {
+		k.log.Warn("failed to remove chart cache", logv2.Any("app", app), logv2.Error(err))
+	}
+	return nil
 }
 
 // setChartValues get helm chart path and values from app service
@@ -346,6 +403,37 @@ func setChartValues(app specv1.Application, cfgs map[string]specv1.Configuration
 	return dir, result, nil
 }
 
+// releaseCause describes the failed or rolled back revision of the release
+func releaseCause(rel *release.Release) string {
+	if rel.Info == nil {
+		return ""
+	}
+	switch rel.Info.Status {
+	case release.StatusFailed:
+		return fmt.Sprintf("revision (%d) failed: %s", rel.Version, rel.Info.Description)
+	case release.StatusDeployed:
+		if strings.HasPrefix(rel.Info.Description, "Rollback") {
+			return fmt.Sprintf("revision (%d): %s", rel.Version, rel.Info.Description)
+		}
+		return ""
+	case release.StatusPendingInstall, release.StatusPendingUpgrade, release.StatusPendingRollback:
+		return fmt.Sprintf("revision (%d) is %s", rel.Version, rel.Info.Status)
+	default:
+		return ""
+	}
+}
+
+func (k *kubeImpl) helmConf() config.HelmConfig {
+	if k.conf == nil {
+		return config.HelmConfig{Timeout: defaultHelmTimeout}
+	}
+	conf := k.conf.Helm
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultHelmTimeout
+	}
+	return conf
+}
+
 // transStatus transform helm status to baetyl app status
 func transStatus(status release.Status) specv1.Status {
 	switch status {
@@ -0,0 +1,223 @@
+package kube
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	logv2 "github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+const (
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+	ociBlobsDir        = "blobs/"
+	helmChartMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// loadChart loads the chart from a chart directory, a chart archive or an oci image layout archive.
+// The delivered archive is kept in the chart cache by the app name, version and digest of the archive,
+// and loaded from the cache of the app version if it is removed
+func (k *kubeImpl) loadChart(app specv1.Application, path string) (*chart.Chart, error) {
+	cache := k.helmConf().ChartCache
+	fromCache := false
+	if !utils.FileExists(path) && !utils.DirExists(path) && cache != "" {
+		cached, err := cachedChart(cache, app, filepath.Base(path))
+		if err != nil {
+			return nil, errors.Errorf("chart (%s) is not found: %s", path, err.Error())
+		}
+		k.log.Debug("load chart from cache", logv2.Any("chart", cached))
+		path, fromCache = cached, true
+	}
+	if utils.DirExists(path) {
+		c, err := loader.LoadDir(path)
+		return c, errors.Trace(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	archive := data
+	// the chart archive delivered as an oci image layout, such as saved by oras
+	if files, e := readTar(data); e == nil {
+		if _, ok := files[ociLayoutFile]; ok {
+			if archive, err = ociChartLayer(files); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	}
+	c, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cache != "" && !fromCache {
+		if err = cacheChart(cache, app, filepath.Base(path), data); err != nil {
+			k.log.Warn("failed to cache chart", logv2.Any("chart", path), logv2.Error(err))
+		}
+	}
+	return c, nil
+}
+
+// chartCacheDir the cache dir of the app version, such as <cache>/<app>/<version>
+func chartCacheDir(cache string, app specv1.Application) string {
+	return filepath.Join(cache, app.Name, app.Version)
+}
+
+// cachedChart finds the archive cached for the app version, the archive of another digest is never mixed up
+// since the cache of the app version only keeps the digest delivered last
+func cachedChart(cache string, app specv1.Application, name string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(chartCacheDir(cache, app), "*", name))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(matches) != 1 {
+		return "", errors.Errorf("%d archives cached for app (%s) version (%s)", len(matches), app.Name, app.Version)
+	}
+	return matches[0], nil
+}
+
+// cacheChart keeps the archive in <cache>/<app>/<version>/<digest>/<name>, the other digests of the app version are removed
+func cacheChart(cache string, app specv1.Application, name string, data []byte) error {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	dir := chartCacheDir(cache, app)
+	if err := os.MkdirAll(filepath.Join(dir, digest), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	tmp := filepath.Join(dir, digest, "."+name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, digest, name)); err != nil {
+		return errors.Trace(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, e := range entries {
+		if e.Name() != digest {
+			if err = os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// pruneChartCache removes the cache of the other versions of the app once the app version is released
+func pruneChartCache(cache string, app specv1.Application) error {
+	if cache == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(cache, app.Name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	for _, e := range entries {
+		if e.Name() != app.Version {
+			if err = os.RemoveAll(filepath.Join(cache, app.Name, e.Name())); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeChartCache removes the cache of all the versions of the app
+func removeChartCache(cache, app string) error {
+	if cache == "" || app == "" {
+		return nil
+	}
+	return errors.Trace(os.RemoveAll(filepath.Join(cache, app)))
+}
+
+// readTar reads the regular files of the tar archive, which may be gzipped
+func readTar(data []byte) (map[string][]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		files[strings.TrimPrefix(filepath.ToSlash(hdr.Name), "./")] = b
+	}
+}
+
+// ociChartLayer finds the chart layer of the first manifest in the oci image layout
+func ociChartLayer(files map[string][]byte) ([]byte, error) {
+	var index ociIndex
+	if err := json.Unmarshal(files[ociIndexFile], &index); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, desc := range index.Manifests {
+		var manifest ociManifest
+		if err := json.Unmarshal(files[ociBlob(desc.Digest)], &manifest); err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != helmChartMediaType {
+				continue
+			}
+			blob, ok := files[ociBlob(layer.Digest)]
+			if !ok {
+				return nil, errors.Errorf("chart layer (%s) is not found", layer.Digest)
+			}
+			return blob, nil
+		}
+	}
+	return nil, errors.New("no chart layer in the oci image layout")
+}
+
+// ociBlob the path of the blob, such as blobs/sha256/<hex>
+func ociBlob(digest string) string {
+	return ociBlobsDir + strings.Replace(digest, ":", "/", 1)
+}
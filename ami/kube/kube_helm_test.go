@@ -1,12 +1,31 @@
 package kube
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
 
+	"github.com/baetyl/baetyl-go/v2/log"
 	"github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
 )
 
 func TestSetChartValues(t *testing.T) {
@@ -136,3 +155,165 @@ func TestTransStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestReleaseCause(t *testing.T) {
+	rel := &release.Release{Version: 3, Info: &release.Info{Status: release.StatusFailed, Description: "Upgrade \"app1\" failed: timed out waiting for the condition"}}
+	assert.Equal(t, "revision (3) failed: Upgrade \"app1\" failed: timed out waiting for the condition", releaseCause(rel))
+	rel = &release.Release{Version: 4, Info: &release.Info{Status: release.StatusDeployed, Description: "Rollback to 2"}}
+	assert.Equal(t, "revision (4): Rollback to 2", releaseCause(rel))
+	rel = &release.Release{Version: 2, Info: &release.Info{Status: release.StatusDeployed, Description: "Upgrade complete"}}
+	assert.Equal(t, "", releaseCause(rel))
+	rel = &release.Release{Version: 5, Info: &release.Info{Status: release.StatusPendingUpgrade}}
+	assert.Equal(t, "revision (5) is pending-upgrade", releaseCause(rel))
+	assert.Equal(t, "", releaseCause(&release.Release{}))
+}
+
+func genChart(t *testing.T, dir, version string) string {
+	c := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "demo", Version: version}}
+	path, err := chartutil.Save(c, dir)
+	assert.NoError(t, err)
+	return path
+}
+
+// genOCILayout wraps the chart archive in an oci image layout archive
+func genOCILayout(t *testing.T, chartPath, path string) {
+	data, err := os.ReadFile(chartPath)
+	assert.NoError(t, err)
+	files := map[string]string{
+		"oci-layout":            `{"imageLayoutVersion":"1.0.0"}`,
+		"index.json":            `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:m1"}]}`,
+		"blobs/sha256/m1":       `{"schemaVersion":2,"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":"sha256:c1"}]}`,
+		"blobs/sha256/c1":       string(data),
+		"blobs/sha256/unrelate": "x",
+	}
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestLoadChart(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	am := &kubeImpl{conf: &config.KubeConfig{Helm: config.HelmConfig{ChartCache: cache}}, log: log.With()}
+
+	app := v1.Application{Name: "helm1", Version: "v1"}
+	path := genChart(t, dir, "0.1.0")
+	c, err := am.loadChart(app, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1.0", c.Metadata.Version)
+	cached, err := cachedChart(cache, app, filepath.Base(path))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(cache, "helm1", "v1"), filepath.Dir(filepath.Dir(cached)))
+
+	// the delivered chart is removed, loaded from the cache
+	assert.NoError(t, os.Remove(path))
+	c, err = am.loadChart(app, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1.0", c.Metadata.Version)
+	_, err = am.loadChart(app, filepath.Join(dir, "none.tgz"))
+	assert.Error(t, err)
+
+	// the archive of the same name is not shared by other apps or versions
+	_, err = am.loadChart(v1.Application{Name: "helm2", Version: "v1"}, path)
+	assert.Error(t, err)
+	_, err = am.loadChart(v1.Application{Name: "helm1", Version: "v2"}, path)
+	assert.Error(t, err)
+
+	// another archive of the same name and version replaces the cached one
+	assert.NoError(t, os.Rename(genChart(t, t.TempDir(), "0.1.1"), path))
+	_, err = am.loadChart(app, path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(path))
+	c, err = am.loadChart(app, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1.1", c.Metadata.Version)
+
+	oci := filepath.Join(dir, "demo-0.2.0.tar")
+	genOCILayout(t, genChart(t, t.TempDir(), "0.2.0"), oci)
+	c, err = am.loadChart(app, oci)
+	assert.NoError(t, err)
+	assert.Equal(t, "demo", c.Metadata.Name)
+	assert.Equal(t, "0.2.0", c.Metadata.Version)
+}
+
+// failOnceKubeClient fails to wait for the resources once
+type failOnceKubeClient struct {
+	kubefake.PrintingKubeClient
+	failed bool
+}
+
+func (c *failOnceKubeClient) Wait(_ kube.ResourceList, _ time.Duration) error {
+	if c.failed {
+		return nil
+	}
+	c.failed = true
+	return errors.New("timed out waiting for the condition")
+}
+
+func TestApplyHelmRollback(t *testing.T) {
+	dir := t.TempDir()
+	ns := "default"
+	kc := &failOnceKubeClient{failed: true}
+	cfg := &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   kc,
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+	cache := filepath.Join(t.TempDir(), "cache")
+	am := &kubeImpl{conf: &config.KubeConfig{Helm: config.HelmConfig{Timeout: time.Second, HistoryMax: 2, ChartCache: cache}}, log: log.With()}
+	app := v1.Application{
+		Name:            "helm1",
+		Version:         "v1",
+		PreserveUpdates: true,
+		Services:        []v1.Service{{Image: filepath.Base(genChart(t, dir, "0.1.0"))}},
+		Volumes:         []v1.Volume{{VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: dir}}}},
+	}
+	assert.NoError(t, am.applyHelm(context.Background(), cfg, ns, app, nil))
+	rel, err := am.GetHelm(cfg, app.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rel.Version)
+	assert.Equal(t, "v1", rel.Labels[BaetylHelmVersion])
+
+	// the upgrade fails and is rolled back to v1
+	kc.failed = false
+	app.Version = "v2"
+	err = am.applyHelm(context.Background(), cfg, ns, app, nil)
+	var rbe *ami.RollbackError
+	assert.True(t, errors.As(err, &rbe))
+	assert.Equal(t, "v1", rbe.Previous.Version)
+	rel, err = am.GetHelm(cfg, app.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rel.Version)
+	assert.Equal(t, "v1", rel.Labels[BaetylHelmVersion])
+	assert.Equal(t, release.StatusDeployed, rel.Info.Status)
+	assert.Equal(t, "revision (3): Rollback to 1", releaseCause(rel))
+	// the cache of the version rolled back to is kept
+	assert.DirExists(t, filepath.Join(cache, "helm1", "v1"))
+
+	// the history is limited
+	assert.NoError(t, am.applyHelm(context.Background(), cfg, ns, app, nil))
+	hist, err := cfg.Releases.History(app.Name)
+	assert.NoError(t, err)
+	assert.Len(t, hist, 2)
+	rel, err = am.GetHelm(cfg, app.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", rel.Labels[BaetylHelmVersion])
+	// the cache of the other versions is pruned once the upgrade succeeds
+	entries, err := os.ReadDir(filepath.Join(cache, "helm1"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "v2", entries[0].Name())
+
+	assert.NoError(t, removeChartCache(cache, "helm1"))
+	assert.NoDirExists(t, filepath.Join(cache, "helm1"))
+	assert.DirExists(t, cache)
+}
@@ -0,0 +1,334 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	bctx "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// the app labels to declare the http routes and the allowed peers of the app
+const (
+	// IngressRulePrefix the label prefix to declare a http route of the app,
+	// such as "baetyl-ingress.web: host=app.example.com,path=/api,port=8080,pathType=Prefix,tlsSecret=cert"
+	IngressRulePrefix = "baetyl-ingress."
+	// IngressClass the ingress class of the app, which overrides the one configured
+	IngressClass = "baetyl-ingress-class"
+	// NetworkPeers the apps allowed to connect to the app, such as "app2,baetyl-edge-system/app3",
+	// the app is open to all if "*" is declared
+	NetworkPeers = "baetyl-network-peers"
+
+	networkPeersAll = "*"
+	// namespaceNameKey the label of the namespace name, which is set by the clusters since 1.21,
+	// and by checkAndCreateNamespace for the namespaces of the apps on the older clusters
+	namespaceNameKey = "kubernetes.io/metadata.name"
+)
+
+// ingressRule a http route of the app to a port of its service
+type ingressRule struct {
+	name      string
+	host      string
+	path      string
+	pathType  networkingv1.PathType
+	port      int32
+	tlsSecret string
+}
+
+// ingressRules parses the http routes declared by the app labels, the routes are sorted by name
+func ingressRules(app *specv1.Application) ([]ingressRule, error) {
+	var rules []ingressRule
+	for k, v := range app.Labels {
+		if !strings.HasPrefix(k, IngressRulePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, IngressRulePrefix)
+		rule, err := parseIngressRule(v)
+		if err != nil {
+			return nil, errors.Errorf("ingress rule (%s) is invalid: %s", name, err.Error())
+		}
+		rule.name = name
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].name < rules[j].name
+	})
+	return rules, nil
+}
+
+// parseIngressRule parses the route like "host=app.example.com,path=/api,port=8080",
+// the port is required, the path is "/" and the path type is Prefix by default
+func parseIngressRule(value string) (*ingressRule, error) {
+	rule := &ingressRule{path: "/", pathType: networkingv1.PathTypePrefix}
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("item (%s) is not key=value", item)
+		}
+		switch kv[0] {
+		case "host":
+			rule.host = kv[1]
+		case "path":
+			rule.path = kv[1]
+		case "pathType":
+			rule.pathType = networkingv1.PathType(kv[1])
+		case "port":
+			port, err := strconv.ParseInt(kv[1], 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("port (%s) is not a number", kv[1])
+			}
+			rule.port = int32(port)
+		case "tlsSecret":
+			rule.tlsSecret = kv[1]
+		default:
+			return nil, errors.Errorf("key (%s) is not supported", kv[0])
+		}
+	}
+	if rule.port == 0 {
+		return nil, errors.New("port is required")
+	}
+	return rule, nil
+}
+
+// prepareIngress routes the http requests to the service of the app, the ports routed must be exposed by the service
+func (k *kubeImpl) prepareIngress(ns string, app specv1.Application, svc *corev1.Service) (*networkingv1.Ingress, error) {
+	rules, err := ingressRules(&app)
+	if err != nil || len(rules) == 0 {
+		return nil, errors.Trace(err)
+	}
+	if svc == nil {
+		return nil, errors.Errorf("app (%s) declares ingress rules but exposes no service ports", app.Name)
+	}
+	ports := map[int32]bool{}
+	for _, p := range svc.Spec.Ports {
+		ports[p.Port] = true
+	}
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cutSysServiceRandSuffix(app.Name),
+			Namespace: ns,
+			Labels:    map[string]string{AppName: app.Name},
+		},
+	}
+	class := app.Labels[IngressClass]
+	if class == "" && k.conf != nil {
+		class = k.conf.Network.IngressClass
+	}
+	if class != "" {
+		ing.Spec.IngressClassName = &class
+	}
+	tls := map[string][]string{}
+	for _, r := range rules {
+		if !ports[r.port] {
+			return nil, errors.Errorf("ingress rule (%s) routes to port (%d) which is not exposed by the service", r.name, r.port)
+		}
+		pathType := r.pathType
+		path := networkingv1.HTTPIngressPath{
+			Path:     r.path,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: svc.Name,
+					Port: networkingv1.ServiceBackendPort{Number: r.port},
+				},
+			},
+		}
+		ing.Spec.Rules = appendIngressPath(ing.Spec.Rules, r.host, path)
+		if r.tlsSecret != "" && r.host != "" {
+			tls[r.tlsSecret] = append(tls[r.tlsSecret], r.host)
+		}
+	}
+	var secrets []string
+	for s := range tls {
+		secrets = append(secrets, s)
+	}
+	sort.Strings(secrets)
+	for _, s := range secrets {
+		ing.Spec.TLS = append(ing.Spec.TLS, networkingv1.IngressTLS{Hosts: tls[s], SecretName: s})
+	}
+	return ing, nil
+}
+
+// appendIngressPath adds the path to the rule of the host, the paths of the same host are in one rule
+func appendIngressPath(rules []networkingv1.IngressRule, host string, path networkingv1.HTTPIngressPath) []networkingv1.IngressRule {
+	for i := range rules {
+		if rules[i].Host == host {
+			rules[i].HTTP.Paths = append(rules[i].HTTP.Paths, path)
+			return rules
+		}
+	}
+	return append(rules, networkingv1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{path}},
+		},
+	})
+}
+
+// prepareNetworkPolicy isolates the pods of the user app if the isolation is enabled, which only accept
+// the connections from the pods of the app itself, the system apps and the peers declared. The ports routed
+// by the ingress, the node ports and the host ports are open to all, the connections of which come from outside
+func (k *kubeImpl) prepareNetworkPolicy(ns string, app specv1.Application, ing *networkingv1.Ingress, svc, nodePortSvc *corev1.Service) *networkingv1.NetworkPolicy {
+	if ns != bctx.EdgeNamespace() || k.conf == nil || !k.conf.Network.EnableIsolation {
+		return nil
+	}
+	peers := []networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{AppName: app.Name}}},
+		{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameKey: bctx.EdgeSystemNamespace()}}},
+	}
+	var rules []networkingv1.NetworkPolicyIngressRule
+	for _, p := range strings.Split(app.Labels[NetworkPeers], ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if p == networkPeersAll {
+			// a rule without peers allows all
+			rules = append(rules, networkingv1.NetworkPolicyIngressRule{})
+			continue
+		}
+		peer := networkingv1.NetworkPolicyPeer{}
+		if parts := strings.SplitN(p, "/", 2); len(parts) == 2 {
+			peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameKey: parts[0]}}
+			p = parts[1]
+		}
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{AppName: p}}
+		peers = append(peers, peer)
+	}
+	rules = append([]networkingv1.NetworkPolicyIngressRule{{From: peers}}, rules...)
+	var open []networkingv1.NetworkPolicyPort
+	if ing != nil && svc != nil {
+		routed := map[int32]bool{}
+		for _, r := range ing.Spec.Rules {
+			for _, p := range r.HTTP.Paths {
+				routed[p.Backend.Service.Port.Number] = true
+			}
+		}
+		// the policy selects the ports of the pods, which the service ports target
+		for _, p := range svc.Spec.Ports {
+			if routed[p.Port] {
+				open = append(open, policyPort(p.Protocol, p.TargetPort.IntVal))
+			}
+		}
+	}
+	if nodePortSvc != nil {
+		for _, p := range nodePortSvc.Spec.Ports {
+			open = append(open, policyPort(p.Protocol, p.TargetPort.IntVal))
+		}
+	}
+	for _, s := range app.Services {
+		for _, p := range s.Ports {
+			if p.HostPort != 0 {
+				open = append(open, policyPort(corev1.Protocol(p.Protocol), p.ContainerPort))
+			}
+		}
+	}
+	if len(open) > 0 {
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{Ports: open})
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: ns,
+			Labels:    map[string]string{AppName: app.Name},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{AppName: app.Name}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+}
+
+func policyPort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	p := intstr.FromInt(int(port))
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
+
+// applyIngress creates or updates the ingress of the app, the ingress is deleted if no rules are declared
+func (k *kubeImpl) applyIngress(ns, appName string, ing *networkingv1.Ingress) error {
+	ingInterface := k.cli.networking.Ingresses(ns)
+	name := cutSysServiceRandSuffix(appName)
+	if ing == nil {
+		return errors.Trace(ignoreNotFound(ingInterface.Delete(context.TODO(), name, metav1.DeleteOptions{})))
+	}
+	old, err := ingInterface.Get(context.TODO(), ing.Name, metav1.GetOptions{})
+	if old != nil && err == nil {
+		ing.ResourceVersion = old.ResourceVersion
+		if _, err = ingInterface.Update(context.TODO(), ing, metav1.UpdateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		if _, err = ingInterface.Create(context.TODO(), ing, metav1.CreateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	k.log.Debug("ami apply ingress", log.Any("name", ing.Name))
+	return nil
+}
+
+// applyNetworkPolicy creates or updates the network policy of the app, the policy is deleted if the app is not isolated
+func (k *kubeImpl) applyNetworkPolicy(ns, appName string, np *networkingv1.NetworkPolicy) error {
+	npInterface := k.cli.networking.NetworkPolicies(ns)
+	if np == nil {
+		return errors.Trace(ignoreNotFound(npInterface.Delete(context.TODO(), appName, metav1.DeleteOptions{})))
+	}
+	old, err := npInterface.Get(context.TODO(), np.Name, metav1.GetOptions{})
+	if old != nil && err == nil {
+		np.ResourceVersion = old.ResourceVersion
+		if _, err = npInterface.Update(context.TODO(), np, metav1.UpdateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		if _, err = npInterface.Create(context.TODO(), np, metav1.CreateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	k.log.Debug("ami apply network policy", log.Any("name", np.Name))
+	return nil
+}
+
+// deleteNetworking deletes the ingresses and network policies of the app
+func (k *kubeImpl) deleteNetworking(ns, appName string) error {
+	selector := labels.SelectorFromSet(labels.Set{AppName: appName})
+	opts := metav1.ListOptions{LabelSelector: selector.String()}
+	ings, err := k.cli.networking.Ingresses(ns).List(context.TODO(), opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, i := range ings.Items {
+		if err = k.cli.networking.Ingresses(ns).Delete(context.TODO(), i.Name, metav1.DeleteOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	nps, err := k.cli.networking.NetworkPolicies(ns).List(context.TODO(), opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, np := range nps.Items {
+		if err = k.cli.networking.NetworkPolicies(ns).Delete(context.TODO(), np.Name, metav1.DeleteOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func ignoreNotFound(err error) error {
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
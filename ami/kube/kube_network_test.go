@@ -0,0 +1,193 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/action"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fakenetworking "k8s.io/client-go/kubernetes/typed/networking/v1/fake"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+func TestIngressRules(t *testing.T) {
+	app := &specv1.Application{Labels: map[string]string{
+		IngressRulePrefix + "web": "host=a.example.com,path=/api,port=8080,tlsSecret=cert",
+		IngressRulePrefix + "b":   "port=80",
+		"other":                   "x",
+	}}
+	rules, err := ingressRules(app)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, ingressRule{name: "b", path: "/", pathType: networkingv1.PathTypePrefix, port: 80}, rules[0])
+	assert.Equal(t, ingressRule{name: "web", host: "a.example.com", path: "/api", pathType: networkingv1.PathTypePrefix, port: 8080, tlsSecret: "cert"}, rules[1])
+
+	for _, v := range []string{"host=a.example.com", "port=x", "port", "size=1"} {
+		_, err = ingressRules(&specv1.Application{Labels: map[string]string{IngressRulePrefix + "web": v}})
+		assert.Error(t, err, v)
+	}
+}
+
+func TestPrepareIngress(t *testing.T) {
+	am := &kubeImpl{conf: &config.KubeConfig{Network: config.KubeNetworkConfig{IngressClass: "traefik"}}}
+	app := specv1.Application{Name: "app1", Labels: map[string]string{
+		IngressRulePrefix + "api": "host=a.example.com,path=/api,port=8080,tlsSecret=cert",
+		IngressRulePrefix + "web": "host=a.example.com,port=80,pathType=Exact",
+	}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app1"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}, {Port: 8080}}},
+	}
+	ing, err := am.prepareIngress("baetyl-edge", app, svc)
+	assert.NoError(t, err)
+	assert.Equal(t, "traefik", *ing.Spec.IngressClassName)
+	assert.Len(t, ing.Spec.Rules, 1)
+	paths := ing.Spec.Rules[0].HTTP.Paths
+	assert.Len(t, paths, 2)
+	assert.Equal(t, "/api", paths[0].Path)
+	assert.Equal(t, int32(8080), paths[0].Backend.Service.Port.Number)
+	assert.Equal(t, networkingv1.PathTypeExact, *paths[1].PathType)
+	assert.Equal(t, []networkingv1.IngressTLS{{Hosts: []string{"a.example.com"}, SecretName: "cert"}}, ing.Spec.TLS)
+
+	app.Labels[IngressClass] = "nginx"
+	ing, err = am.prepareIngress("baetyl-edge", app, svc)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx", *ing.Spec.IngressClassName)
+
+	app.Labels[IngressRulePrefix+"web"] = "port=81"
+	_, err = am.prepareIngress("baetyl-edge", app, svc)
+	assert.Error(t, err)
+	_, err = am.prepareIngress("baetyl-edge", app, nil)
+	assert.Error(t, err)
+
+	ing, err = am.prepareIngress("baetyl-edge", specv1.Application{Name: "app2"}, svc)
+	assert.NoError(t, err)
+	assert.Nil(t, ing)
+}
+
+func TestPrepareNetworkPolicy(t *testing.T) {
+	am := &kubeImpl{conf: &config.KubeConfig{}}
+	ns := "baetyl-edge"
+	app := specv1.Application{
+		Name:   "app1",
+		Labels: map[string]string{NetworkPeers: "app2, baetyl-edge-system/app3"},
+		Services: []specv1.Service{{Ports: []specv1.ContainerPort{
+			{ContainerPort: 8080},
+			{ContainerPort: 9090, HostPort: 19090},
+		}}},
+	}
+	// the apps are not isolated by default
+	assert.Nil(t, am.prepareNetworkPolicy(ns, app, nil, nil, nil))
+	am.conf.Network.EnableIsolation = true
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+		{Port: 80, TargetPort: intstr.FromInt(54000), Protocol: corev1.ProtocolTCP},
+		{Port: 90, TargetPort: intstr.FromInt(90), Protocol: corev1.ProtocolTCP},
+	}}}
+	nodePortSvc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+		{Port: 1883, TargetPort: intstr.FromInt(1883), Protocol: corev1.ProtocolUDP},
+	}}}
+	ing := &networkingv1.Ingress{Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+		IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{
+			Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Port: networkingv1.ServiceBackendPort{Number: 80}}},
+		}}}},
+	}}}}
+
+	np := am.prepareNetworkPolicy(ns, app, ing, svc, nodePortSvc)
+	assert.Equal(t, map[string]string{AppName: "app1"}, np.Spec.PodSelector.MatchLabels)
+	assert.Equal(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}, np.Spec.PolicyTypes)
+	assert.Len(t, np.Spec.Ingress, 2)
+	peers := np.Spec.Ingress[0].From
+	assert.Len(t, peers, 4)
+	assert.Equal(t, map[string]string{AppName: "app1"}, peers[0].PodSelector.MatchLabels)
+	assert.Equal(t, map[string]string{namespaceNameKey: "baetyl-edge-system"}, peers[1].NamespaceSelector.MatchLabels)
+	assert.Nil(t, peers[2].NamespaceSelector)
+	assert.Equal(t, map[string]string{AppName: "app2"}, peers[2].PodSelector.MatchLabels)
+	assert.Equal(t, map[string]string{namespaceNameKey: "baetyl-edge-system"}, peers[3].NamespaceSelector.MatchLabels)
+	assert.Equal(t, map[string]string{AppName: "app3"}, peers[3].PodSelector.MatchLabels)
+	open := np.Spec.Ingress[1]
+	assert.Nil(t, open.From)
+	assert.Len(t, open.Ports, 3)
+	assert.Equal(t, 54000, open.Ports[0].Port.IntValue())
+	assert.Equal(t, corev1.ProtocolUDP, *open.Ports[1].Protocol)
+	// the host port is open to the connections from outside the node
+	assert.Equal(t, 9090, open.Ports[2].Port.IntValue())
+	assert.Equal(t, corev1.ProtocolTCP, *open.Ports[2].Protocol)
+
+	app.Labels[NetworkPeers] = "*"
+	np = am.prepareNetworkPolicy(ns, app, nil, svc, nil)
+	assert.Len(t, np.Spec.Ingress, 3)
+	assert.Equal(t, networkingv1.NetworkPolicyIngressRule{}, np.Spec.Ingress[1])
+
+	// the system apps are not isolated
+	assert.Nil(t, am.prepareNetworkPolicy("baetyl-edge-system", app, nil, svc, nil))
+	am.conf.Network.EnableIsolation = false
+	assert.Nil(t, am.prepareNetworkPolicy(ns, app, nil, svc, nil))
+}
+
+func TestApplyNetworking(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	am.conf = &config.KubeConfig{Network: config.KubeNetworkConfig{EnableIsolation: true}}
+	ns := "baetyl-edge"
+	app := specv1.Application{
+		Name:    "app1",
+		Version: "v1",
+		Labels:  map[string]string{IngressRulePrefix + "web": "host=a.example.com,port=80"},
+		Services: []specv1.Service{{
+			Name:  "s1",
+			Image: "image1",
+			Ports: []specv1.ContainerPort{{ContainerPort: 80}},
+		}},
+	}
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	// the policy is created before the workloads
+	var created []string
+	for _, a := range am.cli.networking.(*fakenetworking.FakeNetworkingV1).Actions() {
+		if a.GetVerb() == "create" {
+			created = append(created, a.GetResource().Resource)
+		}
+	}
+	assert.Equal(t, []string{"networkpolicies", "deployments", "services", "ingresses"}, created)
+	ing, err := am.cli.networking.Ingresses(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "app1", ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+	_, err = am.cli.networking.NetworkPolicies(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	// the config labels are not set on the objects
+	d, err := am.cli.app.Deployments(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, d.Labels, IngressRulePrefix+"web")
+
+	// the ingress is removed with the rules
+	app.Version = "v2"
+	app.Labels = nil
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	_, err = am.cli.networking.Ingresses(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+
+	// the networking is kept by the updates
+	assert.NoError(t, am.deleteApplication(ns, app.Name))
+	_, err = am.cli.networking.NetworkPolicies(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// no helm release of the app
+	am.helm = &action.Configuration{
+		Releases:   storage.Init(driver.NewMemory()),
+		KubeClient: &kubefake.PrintingKubeClient{},
+		Log:        func(string, ...interface{}) {},
+	}
+	assert.NoError(t, am.store.Upsert(utils.MakeKey(specv1.KindApplication, app.Name, app.Version), app))
+	assert.NoError(t, am.DeleteApp(ns, specv1.AppInfo{Name: app.Name, Version: app.Version}))
+	_, err = am.cli.networking.NetworkPolicies(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+}
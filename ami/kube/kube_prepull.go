@@ -0,0 +1,366 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+const (
+	// PrePull the app label to disable pulling the images before rolling out the new version if "false"
+	PrePull = "baetyl-image-prepull"
+	// PrePullApp the label of the pre-pull daemon set and its pods, whose value is the app name
+	PrePullApp = "baetyl-prepull-app"
+
+	prePullSuffix       = "prepull"
+	defaultPrePullLimit = 10 * time.Minute
+	// prePullCommand the command of the pre-pull containers, which is not expected in the images,
+	// the containers fail to start once the images are pulled and never run the app or need a shell
+	prePullCommand = "/baetyl-prepull"
+)
+
+// the waiting reasons of the containers whose images are not pulled yet
+var pullingReasons = map[string]bool{
+	"":                  true,
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"ErrImageNeverPull": true,
+	"InvalidImageName":  true,
+}
+
+func prePullName(appName string) string {
+	return fmt.Sprintf("%s-%s", cutSysServiceRandSuffix(appName), prePullSuffix)
+}
+
+// appImages the distinct images of the services and init services of the app, sorted
+func appImages(app *specv1.Application) []string {
+	set := map[string]bool{}
+	for _, s := range app.InitServices {
+		set[s.Image] = true
+	}
+	for _, s := range app.Services {
+		set[s.Image] = true
+	}
+	delete(set, "")
+	return sortedNames(set)
+}
+
+// podImages the images of the running version, which are present on the nodes already
+func podImages(spec *corev1.PodSpec) map[string]bool {
+	set := map[string]bool{}
+	for _, c := range spec.InitContainers {
+		set[c.Image] = true
+	}
+	for _, c := range spec.Containers {
+		set[c.Image] = true
+	}
+	return set
+}
+
+// runningSpec the pod spec of the running version of the app, nil if the app is not running
+func (k *kubeImpl) runningSpec(ns string, app *specv1.Application) (*corev1.PodSpec, error) {
+	var spec *corev1.PodSpec
+	var err error
+	switch app.Workload {
+	case specv1.WorkloadDeployment:
+		var d *appv1.Deployment
+		if d, err = k.cli.app.Deployments(ns).Get(context.TODO(), app.Name, metav1.GetOptions{}); err == nil {
+			spec = &d.Spec.Template.Spec
+		}
+	case specv1.WorkloadDaemonSet:
+		var d *appv1.DaemonSet
+		if d, err = k.cli.app.DaemonSets(ns).Get(context.TODO(), app.Name, metav1.GetOptions{}); err == nil {
+			spec = &d.Spec.Template.Spec
+		}
+	case specv1.WorkloadStatefulSet:
+		var s *appv1.StatefulSet
+		if s, err = k.cli.app.StatefulSets(ns).Get(context.TODO(), app.Name, metav1.GetOptions{}); err == nil {
+			spec = &s.Spec.Template.Spec
+		}
+	default:
+		return nil, nil
+	}
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return spec, errors.Trace(err)
+}
+
+// prePullImages pulls the new images of the running app on the target nodes by a temporary daemon set,
+// the pending error is returned with the progress until the images are present on all the nodes
+func (k *kubeImpl) prePullImages(ns string, app specv1.Application, imagePullSecs []string) error {
+	if (k.conf != nil && k.conf.PrePull.Disable) || app.Labels[PrePull] == "false" {
+		return nil
+	}
+	k.compatibleDeprecatedField(&app)
+	running, err := k.runningSpec(ns, &app)
+	if err != nil || running == nil {
+		return errors.Trace(err)
+	}
+	present := podImages(running)
+	var images []string
+	for _, image := range appImages(&app) {
+		if !present[image] {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	info := specv1.AppInfo{Name: app.Name, Version: app.Version}
+	dsInterface := k.cli.app.DaemonSets(ns)
+	ds, err := dsInterface.Get(context.TODO(), prePullName(app.Name), metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Trace(err)
+	}
+	exists := err == nil
+	// the pre-pull of an older version is replaced
+	if exists && ds.Labels[AppVersion] != app.Version {
+		if err = k.deletePrePull(ns, app.Name); err != nil {
+			return errors.Trace(err)
+		}
+		exists = false
+	}
+	if !exists {
+		ds, err = k.preparePrePull(ns, &app, images, imagePullSecs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err = dsInterface.Create(context.TODO(), ds, metav1.CreateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+		k.log.Info("ami pre-pull images", log.Any("app", app.Name), log.Any("version", app.Version), log.Any("images", images))
+		return &ami.PendingError{App: info, Reason: fmt.Sprintf("pre-pulling images (%s)", strings.Join(images, ", "))}
+	}
+
+	pulled, total, cause, err := k.prePullProgress(ns, app.Name, ds)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// no node is selected for the app, whose pods are not scheduled either
+	if pulled >= total {
+		k.log.Info("ami images pre-pulled", log.Any("app", app.Name), log.Any("version", app.Version))
+		return errors.Trace(k.deletePrePull(ns, app.Name))
+	}
+	limit := defaultPrePullLimit
+	if k.conf != nil && k.conf.PrePull.Timeout > 0 {
+		limit = k.conf.PrePull.Timeout
+	}
+	if time.Since(ds.CreationTimestamp.Time) > limit {
+		// the new version is rolled out anyway, whose pods pull the images themselves
+		k.log.Warn("ami images are not pre-pulled in time", log.Any("app", app.Name), log.Any("version", app.Version), log.Any("cause", cause))
+		return errors.Trace(k.deletePrePull(ns, app.Name))
+	}
+	reason := fmt.Sprintf("pre-pulling images (%s): %d/%d nodes", strings.Join(images, ", "), pulled, total)
+	if cause != "" {
+		reason += "; " + cause
+	}
+	return &ami.PendingError{App: info, Reason: reason}
+}
+
+// preparePrePull the daemon set runs a container of each new image on the nodes selected for the app,
+// the containers are created with a command absent from the images, so only the images are pulled
+func (k *kubeImpl) preparePrePull(ns string, app *specv1.Application, images []string, imagePullSecs []string) (*appv1.DaemonSet, error) {
+	var imagePullSecrets []corev1.LocalObjectReference
+	for _, sec := range imagePullSecs {
+		imagePullSecrets = append(imagePullSecrets, corev1.LocalObjectReference{Name: sec})
+	}
+	spec := &corev1.PodSpec{ImagePullSecrets: imagePullSecrets}
+	// the nodes are selected as the app
+	if extension, ok := ami.Hooks[BaetylSetPodSpec]; ok {
+		setPodSpecExt, ok := extension.(SetPodSpecFunc)
+		if !ok {
+			return nil, errors.Trace(ErrSetPodSpec)
+		}
+		var err error
+		if spec, err = setPodSpecExt(spec, app); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	grace := int64(0)
+	spec.TerminationGracePeriodSeconds = &grace
+	for i, image := range images {
+		spec.Containers = append(spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("%s-%d", prePullSuffix, i),
+			Image:           image,
+			Command:         []string{prePullCommand},
+			ImagePullPolicy: corev1.PullIfNotPresent,
+		})
+	}
+	set := map[string]string{PrePullApp: app.Name}
+	return &appv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prePullName(app.Name),
+			Namespace: ns,
+			Labels:    map[string]string{PrePullApp: app.Name, AppVersion: app.Version},
+		},
+		Spec: appv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: set},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: set},
+				Spec:       *spec,
+			},
+		},
+	}, nil
+}
+
+// prePullProgress counts the nodes where all the images are pulled, with the failures to pull,
+// the nodes expected are the ones selected by the node selector, affinity and tolerations of the app
+func (k *kubeImpl) prePullProgress(ns, appName string, ds *appv1.DaemonSet) (int, int, string, error) {
+	total, err := k.prePullNodes(&ds.Spec.Template.Spec)
+	if err != nil {
+		return 0, 0, "", errors.Trace(err)
+	}
+	pods, err := k.listPods(ns, labels.Set{PrePullApp: appName})
+	if err != nil {
+		return 0, 0, "", errors.Trace(err)
+	}
+	pulled := 0
+	failures := map[string]bool{}
+	for _, pod := range pods {
+		done := len(pod.Status.ContainerStatuses) == len(pod.Spec.Containers)
+		for _, cs := range pod.Status.ContainerStatuses {
+			if imagePulled(&cs) {
+				continue
+			}
+			done = false
+			if w := cs.State.Waiting; w != nil && w.Message != "" {
+				failures[fmt.Sprintf("%s: %s", w.Reason, w.Message)] = true
+			}
+		}
+		if done {
+			pulled++
+		}
+	}
+	return pulled, total, strings.Join(sortedNames(failures), "; "), nil
+}
+
+// prePullNodes counts the nodes where the pods of the spec are scheduled
+func (k *kubeImpl) prePullNodes(spec *corev1.PodSpec) (int, error) {
+	nodes, err := k.cli.core.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	total := 0
+	for i := range nodes.Items {
+		if nodeSelected(spec, &nodes.Items[i]) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// nodeSelected whether the node matches the node selector and the required node affinity of the spec,
+// and tolerates the taints which repel the pods
+func nodeSelected(spec *corev1.PodSpec, node *corev1.Node) bool {
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	if a := spec.Affinity; a != nil && a.NodeAffinity != nil && a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		matched := false
+		for _, term := range a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			if nodeSelectorTermMatches(&term, node) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range spec.Tolerations {
+			if spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeSelectorTermMatches whether the node matches all the requirements of the term, an empty term matches no node
+func nodeSelectorTermMatches(term *corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, r := range term.MatchExpressions {
+		if !nodeSelectorRequirementMatches(r, labels.Set(node.Labels)) {
+			return false
+		}
+	}
+	for _, r := range term.MatchFields {
+		if !nodeSelectorRequirementMatches(r, labels.Set{"metadata.name": node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeSelectorRequirementMatches(r corev1.NodeSelectorRequirement, set labels.Set) bool {
+	var op selection.Operator
+	switch r.Operator {
+	case corev1.NodeSelectorOpIn:
+		op = selection.In
+	case corev1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case corev1.NodeSelectorOpExists:
+		op = selection.Exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case corev1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case corev1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return false
+	}
+	req, err := labels.NewRequirement(r.Key, op, r.Values)
+	if err != nil {
+		return false
+	}
+	return req.Matches(set)
+}
+
+// imagePulled whether the image of the container is present on the node by the status of the container,
+// the container fails to start with the pre-pull command after the image is pulled
+func imagePulled(cs *corev1.ContainerStatus) bool {
+	if cs.ImageID != "" || cs.State.Running != nil || cs.State.Terminated != nil || cs.LastTerminationState.Terminated != nil {
+		return true
+	}
+	if cs.State.Waiting == nil {
+		return false
+	}
+	return !pullingReasons[cs.State.Waiting.Reason]
+}
+
+func (k *kubeImpl) deletePrePull(ns, appName string) error {
+	err := k.cli.app.DaemonSets(ns).Delete(context.TODO(), prePullName(appName), metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+func TestImagePulled(t *testing.T) {
+	assert.True(t, imagePulled(&v1.ContainerStatus{ImageID: "docker-pullable://image@sha256:1"}))
+	assert.True(t, imagePulled(&v1.ContainerStatus{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "StartError"}}}))
+	assert.True(t, imagePulled(&v1.ContainerStatus{
+		State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+		LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "StartError"}},
+	}))
+	assert.True(t, imagePulled(&v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}))
+	assert.True(t, imagePulled(&v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CreateContainerError"}}}))
+	assert.False(t, imagePulled(&v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}}))
+	assert.False(t, imagePulled(&v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}))
+	assert.False(t, imagePulled(&v1.ContainerStatus{}))
+}
+
+func TestNodeSelected(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{"zone": "a", "cores": "8"}},
+		Spec:       v1.NodeSpec{Taints: []v1.Taint{{Key: "edge", Effect: v1.TaintEffectNoSchedule}}},
+	}
+	tolerations := []v1.Toleration{{Key: "edge", Operator: v1.TolerationOpExists}}
+	affinity := func(terms ...v1.NodeSelectorTerm) *v1.Affinity {
+		return &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: terms},
+		}}
+	}
+	assert.False(t, nodeSelected(&v1.PodSpec{}, node), "the taint is not tolerated")
+	assert.True(t, nodeSelected(&v1.PodSpec{Tolerations: tolerations}, node))
+	assert.True(t, nodeSelected(&v1.PodSpec{Tolerations: tolerations, NodeSelector: map[string]string{"zone": "a"}}, node))
+	assert.False(t, nodeSelected(&v1.PodSpec{Tolerations: tolerations, NodeSelector: map[string]string{"zone": "b"}}, node))
+	assert.True(t, nodeSelected(&v1.PodSpec{Tolerations: tolerations, Affinity: affinity(
+		v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"b"}}}},
+		v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "cores", Operator: v1.NodeSelectorOpGt, Values: []string{"4"}}}},
+	)}, node))
+	assert.False(t, nodeSelected(&v1.PodSpec{Tolerations: tolerations, Affinity: affinity(
+		v1.NodeSelectorTerm{MatchFields: []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpNotIn, Values: []string{"n1"}}}},
+	)}, node))
+}
+
+func TestPrePullImages(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	am.conf = &config.KubeConfig{PrePull: config.KubePrePullConfig{Timeout: time.Hour}}
+	ns := "baetyl-edge"
+	app := specv1.Application{
+		Name:     "app1",
+		Version:  "v1",
+		Workload: specv1.WorkloadDeployment,
+		Services: []specv1.Service{{Name: "s1", Image: "image:v1"}},
+	}
+	// the images of a new app are pulled by its pods
+	assert.NoError(t, am.prePullImages(ns, app, nil))
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+
+	app.Version = "v2"
+	assert.NoError(t, am.prePullImages(ns, app, nil), "the images are present")
+
+	app.Services[0].Image = "image:v2"
+	err := am.prePullImages(ns, app, []string{"registry"})
+	pe, ok := ami.AsPendingError(err)
+	assert.True(t, ok)
+	assert.Equal(t, "pre-pulling images (image:v2)", pe.Reason)
+	ds, err := am.cli.app.DaemonSets(ns).Get(context.TODO(), "app1-prepull", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", ds.Labels[AppVersion])
+	assert.Len(t, ds.Spec.Template.Spec.Containers, 1)
+	assert.Equal(t, "image:v2", ds.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, []string{prePullCommand}, ds.Spec.Template.Spec.Containers[0].Command)
+	assert.Equal(t, []v1.LocalObjectReference{{Name: "registry"}}, ds.Spec.Template.Spec.ImagePullSecrets)
+	assert.NotNil(t, ds.Spec.Template.Spec.Affinity)
+
+	// the fake client does not set the creation time
+	ds.CreationTimestamp = metav1.Now()
+	_, err = am.cli.app.DaemonSets(ns).Update(context.TODO(), ds, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	// the images are pulled on the nodes selected for the app
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{MasterRole: ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "n2", Labels: map[string]string{MasterRole: ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "n3"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "n4", Labels: map[string]string{MasterRole: ""}},
+			Spec:       v1.NodeSpec{Taints: []v1.Taint{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}}},
+		},
+	} {
+		_, err = am.cli.core.Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	genPod := func(name string, cs v1.ContainerStatus) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: map[string]string{PrePullApp: "app1"}},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "prepull-0", Image: "image:v2"}}},
+			Status:     v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{cs}},
+		}
+	}
+	_, err = am.cli.core.Pods(ns).Create(context.TODO(), genPod("p1", v1.ContainerStatus{ImageID: "image@sha256:2"}), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = am.cli.core.Pods(ns).Create(context.TODO(), genPod("p2", v1.ContainerStatus{State: v1.ContainerState{
+		Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image \"image:v2\""},
+	}}), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	pe, ok = ami.AsPendingError(am.prePullImages(ns, app, nil))
+	assert.True(t, ok)
+	assert.Equal(t, "pre-pulling images (image:v2): 1/2 nodes; ImagePullBackOff: Back-off pulling image \"image:v2\"", pe.Reason)
+	// the pending error is found through the traced error of the apply
+	_, ok = ami.AsPendingError(am.ApplyApp(context.Background(), ns, app, nil, nil))
+	assert.True(t, ok)
+
+	// the images are pulled on all the nodes
+	p2 := genPod("p2", v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}})
+	_, err = am.cli.core.Pods(ns).Update(context.TODO(), p2, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, am.prePullImages(ns, app, nil))
+	_, err = am.cli.app.DaemonSets(ns).Get(context.TODO(), "app1-prepull", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+
+	app.Labels = map[string]string{PrePull: "false"}
+	assert.NoError(t, am.prePullImages(ns, app, nil))
+	app.Labels = nil
+	am.conf.PrePull.Disable = true
+	assert.NoError(t, am.prePullImages(ns, app, nil))
+}
@@ -0,0 +1,159 @@
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Rollout the app label to configure the rollout of the deployment, such as
+// "strategy=RollingUpdate,maxSurge=0,maxUnavailable=1,minReadySeconds=10,progressDeadline=600",
+// the strategy is Recreate if not set. The deployment with the label is updated in place by the strategy,
+// the deployment without it is deleted before the update unless the app preserves the updates
+const Rollout = "baetyl-rollout"
+
+// the reason of the progressing condition if the deployment does not progress in the deadline
+const progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+type rollout struct {
+	strategy         appv1.DeploymentStrategy
+	minReadySeconds  int32
+	progressDeadline *int32
+}
+
+// parseRollout parses the rollout label of the app
+func parseRollout(app *specv1.Application) (*rollout, error) {
+	r := &rollout{strategy: appv1.DeploymentStrategy{Type: appv1.RecreateDeploymentStrategyType}}
+	value, ok := app.Labels[Rollout]
+	if !ok || strings.TrimSpace(value) == "" {
+		return r, nil
+	}
+	var rolling appv1.RollingUpdateDeployment
+	var rollingSet bool
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("item (%s) is not key=value", item)
+		}
+		switch kv[0] {
+		case "strategy":
+			switch typ := appv1.DeploymentStrategyType(kv[1]); typ {
+			case appv1.RecreateDeploymentStrategyType, appv1.RollingUpdateDeploymentStrategyType:
+				r.strategy.Type = typ
+			default:
+				return nil, errors.Errorf("strategy (%s) is not Recreate or RollingUpdate", kv[1])
+			}
+		case "maxSurge", "maxUnavailable":
+			v, err := parseIntOrPercent(kv[1])
+			if err != nil {
+				return nil, errors.Errorf("%s (%s) is not a number or percentage", kv[0], kv[1])
+			}
+			if kv[0] == "maxSurge" {
+				rolling.MaxSurge = v
+			} else {
+				rolling.MaxUnavailable = v
+			}
+			rollingSet = true
+		case "minReadySeconds", "progressDeadline":
+			n, err := strconv.ParseInt(kv[1], 10, 32)
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("%s (%s) is not a number of seconds", kv[0], kv[1])
+			}
+			seconds := int32(n)
+			if kv[0] == "minReadySeconds" {
+				r.minReadySeconds = seconds
+			} else {
+				r.progressDeadline = &seconds
+			}
+		default:
+			return nil, errors.Errorf("key (%s) is not supported", kv[0])
+		}
+	}
+	if rollingSet {
+		if r.strategy.Type != appv1.RollingUpdateDeploymentStrategyType {
+			return nil, errors.New("maxSurge and maxUnavailable require the strategy RollingUpdate")
+		}
+		if isZero(rolling.MaxSurge) && isZero(rolling.MaxUnavailable) {
+			return nil, errors.New("maxSurge and maxUnavailable cannot be both 0")
+		}
+		r.strategy.RollingUpdate = &rolling
+	}
+	if r.progressDeadline != nil && *r.progressDeadline <= r.minReadySeconds {
+		return nil, errors.New("progressDeadline must be greater than minReadySeconds")
+	}
+	return r, nil
+}
+
+// rolledOut whether the running deployment of the app is updated in place by the rollout strategy of the app
+func (k *kubeImpl) rolledOut(ns string, app specv1.Application) (bool, error) {
+	k.compatibleDeprecatedField(&app)
+	if app.Workload != specv1.WorkloadDeployment || strings.TrimSpace(app.Labels[Rollout]) == "" {
+		return false, nil
+	}
+	// the workload of another type is replaced
+	running, err := k.runningSpec(ns, &app)
+	return running != nil, errors.Trace(err)
+}
+
+func parseIntOrPercent(value string) (*intstr.IntOrString, error) {
+	v := intstr.Parse(value)
+	if v.Type == intstr.String {
+		if !strings.HasSuffix(v.StrVal, "%") {
+			return nil, errors.Errorf("value (%s) is invalid", value)
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(v.StrVal, "%")); err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else if v.IntVal < 0 {
+		return nil, errors.Errorf("value (%s) is negative", value)
+	}
+	return &v, nil
+}
+
+func isZero(v *intstr.IntOrString) bool {
+	if v == nil {
+		// the kubernetes default of maxSurge and maxUnavailable is 25%
+		return false
+	}
+	if v.Type == intstr.String {
+		return strings.TrimSuffix(v.StrVal, "%") == "0"
+	}
+	return v.IntVal == 0
+}
+
+// deploymentRollout describes the progress of the rollout not completed yet, and whether it is stalled.
+// Nothing is reported before the deployment controller observes the deployment
+func deploymentRollout(d *appv1.Deployment) string {
+	st := d.Status
+	if st.ObservedGeneration == 0 {
+		return ""
+	}
+	var desired int32 = 1
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	var stalled string
+	for _, c := range st.Conditions {
+		if c.Type == appv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == progressDeadlineExceeded {
+			stalled = c.Message
+		}
+	}
+	completed := st.ObservedGeneration >= d.Generation &&
+		st.UpdatedReplicas == desired &&
+		st.Replicas == st.UpdatedReplicas &&
+		st.AvailableReplicas == st.UpdatedReplicas
+	if completed && stalled == "" {
+		return ""
+	}
+	cause := fmt.Sprintf("rollout: %d/%d updated, %d ready, %d available", st.UpdatedReplicas, desired, st.ReadyReplicas, st.AvailableReplicas)
+	if stalled != "" {
+		cause += "; progress deadline exceeded: " + stalled
+	}
+	return cause
+}
@@ -0,0 +1,134 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fakeapps "k8s.io/client-go/kubernetes/typed/apps/v1/fake"
+)
+
+func TestParseRollout(t *testing.T) {
+	r, err := parseRollout(&specv1.Application{})
+	assert.NoError(t, err)
+	assert.Equal(t, appv1.RecreateDeploymentStrategyType, r.strategy.Type)
+	assert.Nil(t, r.progressDeadline)
+
+	r, err = parseRollout(&specv1.Application{Labels: map[string]string{
+		Rollout: "strategy=RollingUpdate, maxSurge=0, maxUnavailable=25%, minReadySeconds=10, progressDeadline=600",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, appv1.RollingUpdateDeploymentStrategyType, r.strategy.Type)
+	assert.Equal(t, intstr.FromInt(0), *r.strategy.RollingUpdate.MaxSurge)
+	assert.Equal(t, intstr.FromString("25%"), *r.strategy.RollingUpdate.MaxUnavailable)
+	assert.Equal(t, int32(10), r.minReadySeconds)
+	assert.Equal(t, int32(600), *r.progressDeadline)
+
+	for _, v := range []string{
+		"strategy=Canary",
+		"maxSurge=1",
+		"strategy=RollingUpdate,maxSurge=0,maxUnavailable=0",
+		"strategy=RollingUpdate,maxSurge=x",
+		"minReadySeconds=-1",
+		"minReadySeconds=10,progressDeadline=10",
+		"progressDeadline",
+		"pause=true",
+	} {
+		_, err = parseRollout(&specv1.Application{Labels: map[string]string{Rollout: v}})
+		assert.Error(t, err, v)
+	}
+}
+
+func TestDeploymentRollout(t *testing.T) {
+	replicas := int32(3)
+	d := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appv1.DeploymentSpec{Replicas: &replicas},
+	}
+	// not observed by the controller yet
+	assert.Equal(t, "", deploymentRollout(d))
+
+	d.Status = appv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 3, AvailableReplicas: 3}
+	assert.Equal(t, "", deploymentRollout(d))
+
+	d.Status = appv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, ReadyReplicas: 3, AvailableReplicas: 3}
+	assert.Equal(t, "rollout: 1/3 updated, 3 ready, 3 available", deploymentRollout(d))
+
+	d.Status.Conditions = []appv1.DeploymentCondition{{
+		Type:    appv1.DeploymentProgressing,
+		Status:  corev1.ConditionFalse,
+		Reason:  progressDeadlineExceeded,
+		Message: `ReplicaSet "app1-5d8f" has timed out progressing.`,
+	}}
+	assert.Equal(t, `rollout: 1/3 updated, 3 ready, 3 available; progress deadline exceeded: ReplicaSet "app1-5d8f" has timed out progressing.`, deploymentRollout(d))
+}
+
+func TestPrepareDeployRollout(t *testing.T) {
+	app := &specv1.Application{
+		Name:     "app1",
+		Replica:  2,
+		Labels:   map[string]string{Rollout: "strategy=RollingUpdate,maxSurge=0,progressDeadline=300"},
+		Services: []specv1.Service{{Name: "s1", Image: "image1"}},
+	}
+	d, err := prepareDeploy("baetyl-edge", app, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, appv1.RollingUpdateDeploymentStrategyType, d.Spec.Strategy.Type)
+	assert.Equal(t, intstr.FromInt(0), *d.Spec.Strategy.RollingUpdate.MaxSurge)
+	assert.Nil(t, d.Spec.Strategy.RollingUpdate.MaxUnavailable)
+	assert.Equal(t, int32(300), *d.Spec.ProgressDeadlineSeconds)
+	assert.NotContains(t, d.Labels, Rollout)
+
+	app.Labels[Rollout] = "strategy=Canary"
+	_, err = prepareDeploy("baetyl-edge", app, nil)
+	assert.Error(t, err)
+}
+
+func TestApplyAppRollout(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	app := specv1.Application{
+		Name:     "app1",
+		Version:  "v1",
+		Workload: specv1.WorkloadDeployment,
+		Labels:   map[string]string{Rollout: "strategy=RollingUpdate,maxUnavailable=0", PrePull: "false"},
+		Services: []specv1.Service{{Name: "s1", Image: "image:v1"}},
+	}
+	assert.NoError(t, am.ApplyApp(context.Background(), ns, app, nil, nil))
+
+	fc := am.cli.app.(*fakeapps.FakeAppsV1).Fake
+	deleted := func() bool {
+		for _, a := range fc.Actions() {
+			if a.GetVerb() == "delete" && a.GetResource().Resource == "deployments" {
+				return true
+			}
+		}
+		return false
+	}
+	// the deployment is updated in place by the rollout strategy
+	fc.ClearActions()
+	app.Version = "v2"
+	app.Services[0].Image = "image:v2"
+	assert.NoError(t, am.ApplyApp(context.Background(), ns, app, nil, nil))
+	assert.False(t, deleted())
+	d, err := am.cli.app.Deployments(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, appv1.RollingUpdateDeploymentStrategyType, d.Spec.Strategy.Type)
+	assert.Equal(t, intstr.FromInt(0), *d.Spec.Strategy.RollingUpdate.MaxUnavailable)
+	assert.Equal(t, "image:v2", d.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "v2", d.Labels[AppVersion])
+
+	// the deployment without the rollout label is recreated
+	fc.ClearActions()
+	app.Version = "v3"
+	app.Labels = map[string]string{PrePull: "false"}
+	assert.NoError(t, am.ApplyApp(context.Background(), ns, app, nil, nil))
+	assert.True(t, deleted())
+	d, err = am.cli.app.Deployments(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, appv1.RecreateDeploymentStrategyType, d.Spec.Strategy.Type)
+}
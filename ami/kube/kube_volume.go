@@ -0,0 +1,201 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// VolumeClaimReclaim the annotation of the claims created for the app, whether the claim is retained
+// or deleted with the app, which is set by the "reclaim" key of the volume claim label
+const VolumeClaimReclaim = "baetyl-volume-claim-reclaim"
+
+// volumeClaimName the claim of the volume shared by all the pods of the app
+func volumeClaimName(appName, volume string) string {
+	return fmt.Sprintf("%s-%s", appName, volume)
+}
+
+// claimedVolumes the volumes of the app declared as persistent volume claims
+func claimedVolumes(app *specv1.Application) map[string]bool {
+	claimed := map[string]bool{}
+	for k := range app.Labels {
+		if strings.HasPrefix(k, VolumeClaimPrefix) {
+			claimed[strings.TrimPrefix(k, VolumeClaimPrefix)] = true
+		}
+	}
+	return claimed
+}
+
+func sortedNames(set map[string]bool) []string {
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prepareVolumeClaims the claims shared by the pods of the app, the stateful set claims
+// a volume for each replica by its volume claim templates instead
+func prepareVolumeClaims(ns string, app *specv1.Application) ([]corev1.PersistentVolumeClaim, error) {
+	if app.Workload == specv1.WorkloadStatefulSet {
+		return nil, nil
+	}
+	claims, err := volumeClaims(app)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range claims {
+		claims[i].Name = volumeClaimName(app.Name, claims[i].Name)
+		claims[i].Namespace = ns
+	}
+	return claims, nil
+}
+
+// applyVolumeClaims creates the claims not found, the existing claims keep the bound volumes
+// and are only updated if the reclaim policy changes or the size is expanded
+func (k *kubeImpl) applyVolumeClaims(ns string, claims []corev1.PersistentVolumeClaim) error {
+	pvcInterface := k.cli.core.PersistentVolumeClaims(ns)
+	for _, c := range claims {
+		old, err := pvcInterface.Get(context.TODO(), c.Name, metav1.GetOptions{})
+		if err != nil {
+			if !kerrors.IsNotFound(err) {
+				return errors.Trace(err)
+			}
+			if _, err = pvcInterface.Create(context.TODO(), &c, metav1.CreateOptions{}); err != nil {
+				return errors.Trace(err)
+			}
+			k.log.Info("ami create volume claim", log.Any("name", c.Name))
+			continue
+		}
+		policy := c.Annotations[VolumeClaimReclaim]
+		size, oldSize := c.Spec.Resources.Requests[corev1.ResourceStorage], old.Spec.Resources.Requests[corev1.ResourceStorage]
+		expanded := size.Cmp(oldSize) > 0
+		if old.Annotations[VolumeClaimReclaim] == policy && !expanded {
+			continue
+		}
+		if old.Annotations == nil {
+			old.Annotations = map[string]string{}
+		}
+		old.Annotations[VolumeClaimReclaim] = policy
+		if expanded {
+			old.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		}
+		if _, err = pvcInterface.Update(context.TODO(), old, metav1.UpdateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+		k.log.Info("ami update volume claim", log.Any("name", c.Name))
+	}
+	return nil
+}
+
+// statefulSetClaimPolicy the reclaim policy of the claim created by the templates of the stateful set,
+// which is named <template>-<app>-<ordinal>, empty if the claim is not created by the templates
+func statefulSetClaimPolicy(claims []corev1.PersistentVolumeClaim, appName, pvcName string) string {
+	for _, c := range claims {
+		if strings.HasPrefix(pvcName, fmt.Sprintf("%s-%s-", c.Name, appName)) {
+			return c.Annotations[VolumeClaimReclaim]
+		}
+	}
+	return ""
+}
+
+// applyStatefulSetClaimPolicy sets the reclaim policy on the claims created by the templates of the stateful set,
+// the claims created later are deleted by the policy of the app
+func (k *kubeImpl) applyStatefulSetClaimPolicy(ns string, app *specv1.Application) error {
+	if app.Workload != specv1.WorkloadStatefulSet {
+		return nil
+	}
+	claims, err := volumeClaims(app)
+	if err != nil || len(claims) == 0 {
+		return errors.Trace(err)
+	}
+	pvcInterface := k.cli.core.PersistentVolumeClaims(ns)
+	selector := labels.SelectorFromSet(labels.Set{AppName: app.Name})
+	pvcs, err := pvcInterface.List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		policy := statefulSetClaimPolicy(claims, app.Name, pvc.Name)
+		if policy == "" || pvc.Annotations[VolumeClaimReclaim] == policy {
+			continue
+		}
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[VolumeClaimReclaim] = policy
+		if _, err = pvcInterface.Update(context.TODO(), pvc, metav1.UpdateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// deleteVolumeClaims deletes the claims of the app with the reclaim policy Delete, including the ones
+// created by the volume claim templates of the stateful set
+func (k *kubeImpl) deleteVolumeClaims(ns string, app *specv1.Application) error {
+	claims, err := volumeClaims(app)
+	if err != nil {
+		k.log.Warn("failed to parse volume claims, the claims not annotated are retained", log.Any("app", app.Name), log.Error(err))
+	}
+	pvcInterface := k.cli.core.PersistentVolumeClaims(ns)
+	selector := labels.SelectorFromSet(labels.Set{AppName: app.Name})
+	pvcs, err := pvcInterface.List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, pvc := range pvcs.Items {
+		policy, ok := pvc.Annotations[VolumeClaimReclaim]
+		if !ok {
+			policy = statefulSetClaimPolicy(claims, app.Name, pvc.Name)
+		}
+		if policy != string(corev1.PersistentVolumeReclaimDelete) {
+			k.log.Info("ami retain volume claim", log.Any("name", pvc.Name))
+			continue
+		}
+		if err = pvcInterface.Delete(context.TODO(), pvc.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return errors.Trace(err)
+		}
+		k.log.Info("ami delete volume claim", log.Any("name", pvc.Name))
+	}
+	return nil
+}
+
+// volumeClaimCause describes the claims of the app not bound, with the warnings such as failing to provision
+func (k *kubeImpl) volumeClaimCause(ns, appName string) string {
+	pvcs, err := k.listVolumeClaims(ns, labels.Set{AppName: appName})
+	if err != nil {
+		k.log.Warn("failed to list volume claims", log.Any("namespace", ns), log.Any("app", appName), log.Error(err))
+		return ""
+	}
+	sort.Slice(pvcs, func(i, j int) bool {
+		return pvcs[i].Name < pvcs[j].Name
+	})
+	var causes []string
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == corev1.ClaimBound {
+			continue
+		}
+		phase := pvc.Status.Phase
+		if phase == "" {
+			phase = corev1.ClaimPending
+		}
+		cause := fmt.Sprintf("volume claim (%s) is %s", pvc.Name, phase)
+		if w := k.warningsOf(ns, objectRef{kind: "PersistentVolumeClaim", name: pvc.Name}); w != "" {
+			cause += ": " + w
+		}
+		causes = append(causes, cause)
+	}
+	return strings.Join(causes, "; ")
+}
@@ -0,0 +1,140 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	fakecore "k8s.io/client-go/kubernetes/typed/core/v1/fake"
+)
+
+func TestApplyVolumeClaims(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	app := specv1.Application{
+		Name:    "app1",
+		Version: "v1",
+		Labels: map[string]string{
+			VolumeClaimPrefix + "data":  "size=1Gi,storageClass=local-path,reclaim=Delete",
+			VolumeClaimPrefix + "cache": "size=100Mi",
+		},
+		Services: []specv1.Service{{
+			Name:  "s1",
+			Image: "image1",
+			VolumeMounts: []specv1.VolumeMount{
+				{Name: "data", MountPath: "/data"},
+				{Name: "cache", MountPath: "/cache"},
+			},
+		}},
+		Volumes: []specv1.Volume{{
+			Name:         "data",
+			VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/app1"}},
+		}},
+	}
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	d, err := am.cli.app.Deployments(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	volumes := d.Spec.Template.Spec.Volumes
+	assert.Len(t, volumes, 2)
+	assert.Nil(t, volumes[0].HostPath)
+	assert.Equal(t, "app1-data", volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, "cache", volumes[1].Name)
+	assert.Equal(t, "app1-cache", volumes[1].PersistentVolumeClaim.ClaimName)
+	pvc, err := am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "app1-data", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "local-path", *pvc.Spec.StorageClassName)
+	assert.Equal(t, "app1", pvc.Labels[AppName])
+
+	// the claims not changed are not updated
+	fc := am.cli.core.(*fakecore.FakeCoreV1).Fake
+	fc.ClearActions()
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	for _, a := range fc.Actions() {
+		assert.False(t, a.GetVerb() == "update" && a.GetResource().Resource == "persistentvolumeclaims")
+	}
+
+	// the claim is expanded, but never shrunk
+	app.Labels[VolumeClaimPrefix+"data"] = "size=2Gi,storageClass=local-path,reclaim=Delete"
+	app.Labels[VolumeClaimPrefix+"cache"] = "size=10Mi"
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	pvc, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "app1-data", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, resource.MustParse("2Gi"), pvc.Spec.Resources.Requests[v1.ResourceStorage])
+	pvc, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "app1-cache", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, resource.MustParse("100Mi"), pvc.Spec.Resources.Requests[v1.ResourceStorage])
+
+	// the claims are deleted by the reclaim policy
+	assert.NoError(t, am.deleteApplication(ns, app.Name))
+	assert.NoError(t, am.deleteVolumeClaims(ns, &app))
+	_, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "app1-data", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "app1-cache", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// the stateful set claims by the templates
+	app.Workload = specv1.WorkloadStatefulSet
+	claims, err := prepareVolumeClaims(ns, &app)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 0)
+
+	// the reclaim policy is set on the claims created by the templates
+	for _, name := range []string{"data-app1-0", "cache-app1-0"} {
+		_, err = am.cli.core.PersistentVolumeClaims(ns).Create(context.TODO(), &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: map[string]string{AppName: app.Name}},
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, am.applyApplication(ns, app, nil))
+	sts, err := am.cli.app.StatefulSets(ns).Get(context.TODO(), "app1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, sts.Spec.VolumeClaimTemplates, 2)
+	assert.Nil(t, sts.Spec.VolumeClaimTemplates[0].Annotations)
+	pvc, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "data-app1-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Delete", pvc.Annotations[VolumeClaimReclaim])
+
+	// the claim created after the apply is deleted by the policy of the app
+	_, err = am.cli.core.PersistentVolumeClaims(ns).Create(context.TODO(), &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-app1-1", Namespace: ns, Labels: map[string]string{AppName: app.Name}},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, am.deleteApplication(ns, app.Name))
+	assert.NoError(t, am.deleteVolumeClaims(ns, &app))
+	for _, name := range []string{"data-app1-0", "data-app1-1"} {
+		_, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		assert.True(t, kerrors.IsNotFound(err), name)
+	}
+	_, err = am.cli.core.PersistentVolumeClaims(ns).Get(context.TODO(), "cache-app1-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestVolumeClaimCause(t *testing.T) {
+	ns := "baetyl-edge"
+	fc := fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "app1-data", Namespace: ns, Labels: map[string]string{AppName: "app1"}},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+		},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "app1-logs", Namespace: ns, Labels: map[string]string{AppName: "app1"}},
+		},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "app2-data", Namespace: ns, Labels: map[string]string{AppName: "app2"}},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimLost},
+		},
+		genWarning("e1", "PersistentVolumeClaim", "app1-logs", "ProvisioningFailed", "storageclass.storage.k8s.io \"fast\" not found", 3, time.Now()),
+	)
+	am := &kubeImpl{cli: &client{core: fc.CoreV1(), app: fc.AppsV1()}, log: log.With()}
+	assert.Equal(t, "volume claim (app1-logs) is Pending: ProvisioningFailed: storageclass.storage.k8s.io \"fast\" not found (x3)", am.volumeClaimCause(ns, "app1"))
+	assert.Equal(t, "volume claim (app2-data) is Lost", am.volumeClaimCause(ns, "app2"))
+	assert.Equal(t, "", am.volumeClaimCause(ns, "app3"))
+}
@@ -0,0 +1,374 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+// WorkloadCronJob the workload of the apps run on a schedule
+const WorkloadCronJob = "cronjob"
+
+// statefulSetRecreateTimeout how long to wait the orphaned stateful set to be deleted before it is recreated
+var statefulSetRecreateTimeout = 30 * time.Second
+
+// the app labels to configure the workloads, which are not set on the kubernetes objects
+const (
+	// CronSchedule the schedule of the cron job in cron format, such as "0 3 * * *"
+	CronSchedule = "baetyl-cron-schedule"
+	// CronConcurrencyPolicy how to treat the concurrent runs of the cron job, Allow, Forbid or Replace
+	CronConcurrencyPolicy = "baetyl-cron-concurrency-policy"
+	// VolumeClaimPrefix the label prefix to declare a volume of the app as a persistent volume claim,
+	// such as "baetyl-volume-claim.data: size=1Gi,storageClass=local-path,accessMode=ReadWriteOnce"
+	VolumeClaimPrefix = "baetyl-volume-claim."
+
+	headlessServiceSuffix = "headless"
+)
+
+var configLabelPrefixes = []string{CronSchedule, CronConcurrencyPolicy, VolumeClaimPrefix, IngressRulePrefix, IngressClass, NetworkPeers, PrePull, Rollout, ami.AppDependsOn, ami.AppMaintenanceWindow}
+
+// objectLabels returns the app labels to set on the kubernetes objects, the config labels are excluded
+func objectLabels(appLabels map[string]string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range appLabels {
+		if isConfigLabel(k) {
+			continue
+		}
+		labels[k] = v
+	}
+	return labels
+}
+
+func isConfigLabel(key string) bool {
+	for _, prefix := range configLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeClaims parses the persistent volume claims declared by the app labels, the claims are sorted by name
+func volumeClaims(app *specv1.Application) ([]corev1.PersistentVolumeClaim, error) {
+	var claims []corev1.PersistentVolumeClaim
+	for k, v := range app.Labels {
+		if !strings.HasPrefix(k, VolumeClaimPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, VolumeClaimPrefix)
+		claim, err := parseVolumeClaim(v)
+		if err != nil {
+			return nil, errors.Errorf("volume claim (%s) is invalid: %s", name, err.Error())
+		}
+		claim.Name = name
+		claim.Labels = map[string]string{AppName: app.Name}
+		claims = append(claims, *claim)
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		return claims[i].Name < claims[j].Name
+	})
+	return claims, nil
+}
+
+// parseVolumeClaim parses the claim like "size=1Gi,storageClass=local-path,accessMode=ReadWriteOnce,reclaim=Delete",
+// the size is required, the access mode is ReadWriteOnce and the claim is retained after the app is deleted by default
+func parseVolumeClaim(value string) (*corev1.PersistentVolumeClaim, error) {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{VolumeClaimReclaim: string(corev1.PersistentVolumeReclaimRetain)}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+	}
+	spec := &claim.Spec
+	var size string
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("item (%s) is not key=value", item)
+		}
+		switch kv[0] {
+		case "size":
+			size = kv[1]
+		case "storageClass":
+			storageClass := kv[1]
+			spec.StorageClassName = &storageClass
+		case "accessMode":
+			spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.PersistentVolumeAccessMode(kv[1])}
+		case "reclaim":
+			switch policy := corev1.PersistentVolumeReclaimPolicy(kv[1]); policy {
+			case corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete:
+				claim.Annotations[VolumeClaimReclaim] = string(policy)
+			default:
+				return nil, errors.Errorf("reclaim (%s) is not Retain or Delete", kv[1])
+			}
+		default:
+			return nil, errors.Errorf("key (%s) is not supported", kv[0])
+		}
+	}
+	if size == "" {
+		return nil, errors.New("size is required")
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: quantity}
+	return claim, nil
+}
+
+func prepareStatefulSet(ns string, app *specv1.Application, imagePullSecrets []corev1.LocalObjectReference) (*appv1.StatefulSet, error) {
+	podSpec, err := prepareInfo(app, imagePullSecrets)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if extension, ok := ami.Hooks[BaetylSetPodSpec]; ok {
+		setPodSpecExt, ok := extension.(SetPodSpecFunc)
+		if ok {
+			if podSpec, err = setPodSpecExt(podSpec, app); err != nil {
+				return nil, errors.Trace(err)
+			}
+		} else {
+			return nil, errors.Trace(ErrSetPodSpec)
+		}
+	} else {
+		return nil, errors.Trace(ErrSetPodSpec)
+	}
+	claims, err := volumeClaims(app)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the claimed volumes are provided by the volume claim templates for each replica
+	claimed := map[string]bool{}
+	for i := range claims {
+		claimed[claims[i].Name] = true
+		// the reclaim policy is set on the claims created by the templates, since the templates are immutable
+		claims[i].Annotations = nil
+	}
+	var volumes []corev1.Volume
+	for _, v := range podSpec.Volumes {
+		if !claimed[v.Name] {
+			volumes = append(volumes, v)
+		}
+	}
+	podSpec.Volumes = volumes
+
+	replica := new(int32)
+	*replica = int32(app.Replica)
+
+	labels := objectLabels(app.Labels)
+	sts := &appv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: appv1.StatefulSetSpec{
+			Replicas:    replica,
+			ServiceName: headlessServiceName(app.Name),
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{AppName: app.Name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{AppName: app.Name}},
+				Spec:       *podSpec,
+			},
+			VolumeClaimTemplates: claims,
+		},
+	}
+	for k, v := range labels {
+		sts.Spec.Template.Labels[k] = v
+	}
+	return sts, nil
+}
+
+func headlessServiceName(appName string) string {
+	return fmt.Sprintf("%s-%s", cutSysServiceRandSuffix(appName), headlessServiceSuffix)
+}
+
+// prepareHeadlessService the governing service of the stateful set, which gives each pod a stable network identity
+func (k *kubeImpl) prepareHeadlessService(ns string, app specv1.Application) *corev1.Service {
+	if app.Workload != specv1.WorkloadStatefulSet {
+		return nil
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      headlessServiceName(app.Name),
+			Namespace: ns,
+			Labels:    map[string]string{AppName: app.Name},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{AppName: app.Name},
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+func prepareCronJob(ns string, app *specv1.Application, imagePullSecrets []corev1.LocalObjectReference) (*batchv1.CronJob, error) {
+	schedule := app.Labels[CronSchedule]
+	if schedule == "" {
+		return nil, errors.Errorf("the schedule of cron job (%s) is not specified by label (%s)", app.Name, CronSchedule)
+	}
+	job, err := prepareJob(ns, app, imagePullSecrets)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cj := &batchv1.CronJob{
+		ObjectMeta: job.ObjectMeta,
+		Spec: batchv1.CronJobSpec{
+			Schedule: schedule,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: job.Labels},
+				Spec:       job.Spec,
+			},
+		},
+	}
+	if v, ok := app.Labels[CronConcurrencyPolicy]; ok {
+		switch policy := batchv1.ConcurrencyPolicy(v); policy {
+		case batchv1.AllowConcurrent, batchv1.ForbidConcurrent, batchv1.ReplaceConcurrent:
+			cj.Spec.ConcurrencyPolicy = policy
+		default:
+			return nil, errors.Errorf("the concurrency policy (%s) of cron job (%s) is invalid", v, app.Name)
+		}
+	}
+	return cj, nil
+}
+
+// applyStatefulSets updates the stateful sets in place, the claim templates and the selector are immutable,
+// so the stateful set is deleted with its pods and claims orphaned and recreated if they change,
+// then the new stateful set adopts the pods and claims
+func (k *kubeImpl) applyStatefulSets(ns string, statefulSets map[string]*appv1.StatefulSet) error {
+	stsInterface := k.cli.app.StatefulSets(ns)
+	for _, s := range statefulSets {
+		sts, err := stsInterface.Get(context.TODO(), s.Name, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.Trace(err)
+		}
+		if err == nil && !statefulSetRecreated(sts, s) {
+			// the templates read back carry the defaults filled by the server
+			s.Spec.VolumeClaimTemplates = sts.Spec.VolumeClaimTemplates
+			s.ResourceVersion = sts.ResourceVersion
+			if _, err = stsInterface.Update(context.TODO(), s, metav1.UpdateOptions{}); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if err == nil {
+			if err = k.deleteStatefulSetOrphan(ns, s.Name); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if _, err = stsInterface.Create(context.TODO(), s, metav1.CreateOptions{}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// statefulSetRecreated whether the immutable fields of the stateful set change
+func statefulSetRecreated(old, s *appv1.StatefulSet) bool {
+	if old.Spec.ServiceName != s.Spec.ServiceName || !reflect.DeepEqual(old.Spec.Selector, s.Spec.Selector) ||
+		len(old.Spec.VolumeClaimTemplates) != len(s.Spec.VolumeClaimTemplates) {
+		return true
+	}
+	olds := map[string]corev1.PersistentVolumeClaimSpec{}
+	for _, c := range old.Spec.VolumeClaimTemplates {
+		olds[c.Name] = c.Spec
+	}
+	for _, c := range s.Spec.VolumeClaimTemplates {
+		o, ok := olds[c.Name]
+		if !ok || !reflect.DeepEqual(o.AccessModes, c.Spec.AccessModes) ||
+			o.Resources.Requests.Storage().Cmp(*c.Spec.Resources.Requests.Storage()) != 0 {
+			return true
+		}
+		// the storage class is set to the default by the server if it is not specified
+		if c.Spec.StorageClassName != nil && (o.StorageClassName == nil || *o.StorageClassName != *c.Spec.StorageClassName) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteStatefulSetOrphan deletes the stateful set without its pods and claims, and waits it to be gone
+func (k *kubeImpl) deleteStatefulSetOrphan(ns, name string) error {
+	stsInterface := k.cli.app.StatefulSets(ns)
+	policy := metav1.DeletePropagationOrphan
+	err := stsInterface.Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Trace(err)
+	}
+	deadline := time.Now().Add(statefulSetRecreateTimeout)
+	for {
+		_, err = stsInterface.Get(context.TODO(), name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("stateful set (%s) is not deleted in %s to be recreated", name, statefulSetRecreateTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (k *kubeImpl) applyCronJobs(ns string, cronJobs map[string]*batchv1.CronJob) error {
+	if len(cronJobs) == 0 {
+		return nil
+	}
+	if !k.cronJobAvailable() {
+		return errors.Errorf("the cron jobs of %s are not served by the cluster", batchv1.SchemeGroupVersion)
+	}
+	cjInterface := k.cli.batch.CronJobs(ns)
+	for _, c := range cronJobs {
+		cj, err := cjInterface.Get(context.TODO(), c.Name, metav1.GetOptions{})
+		if cj != nil && err == nil {
+			c.ResourceVersion = cj.ResourceVersion
+			if _, err = cjInterface.Update(context.TODO(), c, metav1.UpdateOptions{}); err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			if _, err = cjInterface.Create(context.TODO(), c, metav1.CreateOptions{}); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// cronJobAvailable whether the cron jobs of batch/v1 are served, which are since kubernetes 1.21
+func (k *kubeImpl) cronJobAvailable() bool {
+	res, err := k.cli.discovery.ServerResourcesForGroupVersion(batchv1.SchemeGroupVersion.String())
+	if err != nil {
+		return false
+	}
+	for _, r := range res.APIResources {
+		if r.Kind == "CronJob" {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedByCronJob whether the job is created by a cron job, whose stats are collected with the cron job
+func ownedByCronJob(job *batchv1.Job) bool {
+	for _, ref := range job.OwnerReferences {
+		if ref.Kind == "CronJob" {
+			return true
+		}
+	}
+	return false
+}
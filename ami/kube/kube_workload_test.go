@@ -0,0 +1,288 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVolumeClaims(t *testing.T) {
+	app := &specv1.Application{
+		Name: "db",
+		Labels: map[string]string{
+			AppName:                    "db",
+			VolumeClaimPrefix + "logs": "size=100Mi",
+			VolumeClaimPrefix + "data": "size=1Gi,storageClass=local-path,accessMode=ReadWriteMany",
+		},
+	}
+	claims, err := volumeClaims(app)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+	assert.Equal(t, "data", claims[0].Name)
+	assert.Equal(t, "local-path", *claims[0].Spec.StorageClassName)
+	assert.Equal(t, []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, claims[0].Spec.AccessModes)
+	assert.Equal(t, resource.MustParse("1Gi"), claims[0].Spec.Resources.Requests[v1.ResourceStorage])
+	assert.Equal(t, "logs", claims[1].Name)
+	assert.Nil(t, claims[1].Spec.StorageClassName)
+	assert.Equal(t, []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}, claims[1].Spec.AccessModes)
+	assert.Equal(t, "Retain", claims[1].Annotations[VolumeClaimReclaim])
+
+	for _, v := range []string{"storageClass=x", "size", "size=1Gi,color=red", "size=abc", "size=1Gi,reclaim=Recycle"} {
+		app.Labels = map[string]string{VolumeClaimPrefix + "data": v}
+		_, err = volumeClaims(app)
+		assert.Error(t, err, v)
+	}
+
+	labels := objectLabels(map[string]string{
+		AppName:                    "db",
+		CronSchedule:               "0 3 * * *",
+		VolumeClaimPrefix + "data": "size=1Gi",
+	})
+	assert.Equal(t, map[string]string{AppName: "db"}, labels)
+}
+
+func TestPrepareStatefulSet(t *testing.T) {
+	initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	app := &specv1.Application{
+		Name:     "db",
+		Version:  "v1",
+		Replica:  2,
+		Workload: specv1.WorkloadStatefulSet,
+		Labels: map[string]string{
+			AppName:                    "db",
+			VolumeClaimPrefix + "data": "size=1Gi",
+		},
+		Services: []specv1.Service{{
+			Name:  "s1",
+			Image: "image1",
+			VolumeMounts: []specv1.VolumeMount{
+				{Name: "data", MountPath: "/data"},
+				{Name: "cfg1", MountPath: "/etc/cfg"},
+			},
+		}},
+		Volumes: []specv1.Volume{{
+			Name:         "data",
+			VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/db"}},
+		}, {
+			Name:         "cfg1",
+			VolumeSource: specv1.VolumeSource{Config: &specv1.ObjectReference{Name: "cfg1", Version: "c1"}},
+		}},
+	}
+	sts, err := prepareStatefulSet(ns, app, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "db", sts.Name)
+	assert.Equal(t, int32(2), *sts.Spec.Replicas)
+	assert.Equal(t, "db-headless", sts.Spec.ServiceName)
+	assert.Equal(t, map[string]string{AppName: "db"}, sts.Labels)
+	assert.Equal(t, map[string]string{AppName: "db"}, sts.Spec.Selector.MatchLabels)
+	assert.Equal(t, map[string]string{AppName: "db"}, sts.Spec.Template.Labels)
+	assert.Len(t, sts.Spec.VolumeClaimTemplates, 1)
+	assert.Equal(t, "data", sts.Spec.VolumeClaimTemplates[0].Name)
+	assert.Nil(t, sts.Spec.VolumeClaimTemplates[0].Annotations)
+	assert.Len(t, sts.Spec.Template.Spec.Volumes, 1)
+	assert.Equal(t, "cfg1", sts.Spec.Template.Spec.Volumes[0].Name)
+
+	am := &kubeImpl{}
+	svc := am.prepareHeadlessService(ns, *app)
+	assert.Equal(t, "db-headless", svc.Name)
+	assert.Equal(t, v1.ClusterIPNone, svc.Spec.ClusterIP)
+	assert.Equal(t, map[string]string{AppName: "db"}, svc.Spec.Selector)
+
+	app.Workload = specv1.WorkloadDeployment
+	assert.Nil(t, am.prepareHeadlessService(ns, *app))
+}
+
+func TestPrepareCronJob(t *testing.T) {
+	initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	app := &specv1.Application{
+		Name:     "backup",
+		Version:  "v1",
+		Workload: WorkloadCronJob,
+		Labels: map[string]string{
+			AppName:               "backup",
+			CronSchedule:          "0 3 * * *",
+			CronConcurrencyPolicy: "Forbid",
+		},
+		Services: []specv1.Service{{Name: "s1", Image: "image1"}},
+	}
+	cj, err := prepareCronJob(ns, app, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup", cj.Name)
+	assert.Equal(t, "0 3 * * *", cj.Spec.Schedule)
+	assert.Equal(t, batchv1.ForbidConcurrent, cj.Spec.ConcurrencyPolicy)
+	assert.Equal(t, map[string]string{AppName: "backup"}, cj.Labels)
+	assert.Equal(t, map[string]string{AppName: "backup"}, cj.Spec.JobTemplate.Labels)
+	assert.Equal(t, map[string]string{AppName: "backup"}, cj.Spec.JobTemplate.Spec.Template.Labels)
+
+	app.Labels[CronConcurrencyPolicy] = "Sometimes"
+	_, err = prepareCronJob(ns, app, nil)
+	assert.Error(t, err)
+
+	delete(app.Labels, CronSchedule)
+	_, err = prepareCronJob(ns, app, nil)
+	assert.Error(t, err)
+}
+
+func TestApplyWorkloads(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	sts := specv1.Application{
+		Name:     "db",
+		Version:  "v1",
+		Replica:  1,
+		Workload: specv1.WorkloadStatefulSet,
+		Labels: map[string]string{
+			AppName:                    "db",
+			VolumeClaimPrefix + "data": "size=1Gi",
+		},
+		Services: []specv1.Service{{
+			Name:         "s1",
+			Image:        "image1",
+			VolumeMounts: []specv1.VolumeMount{{Name: "data", MountPath: "/data"}},
+		}},
+		Volumes: []specv1.Volume{{
+			Name:         "data",
+			VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/db"}},
+		}},
+	}
+	cron := specv1.Application{
+		Name:     "backup",
+		Version:  "v1",
+		Workload: WorkloadCronJob,
+		Labels: map[string]string{
+			AppName:      "backup",
+			CronSchedule: "0 3 * * *",
+		},
+		Services: []specv1.Service{{Name: "s1", Image: "image1"}},
+	}
+	assert.NoError(t, am.applyApplication(ns, sts, nil))
+	assert.NoError(t, am.applyApplication(ns, cron, nil))
+	// applied again as updates
+	sts.Version, cron.Version = "v2", "v2"
+	assert.NoError(t, am.applyApplication(ns, sts, nil))
+	assert.NoError(t, am.applyApplication(ns, cron, nil))
+
+	s, err := am.cli.app.StatefulSets(ns).Get(context.TODO(), "db", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "db-headless", s.Spec.ServiceName)
+	_, err = am.cli.core.Services(ns).Get(context.TODO(), "db-headless", metav1.GetOptions{})
+	assert.NoError(t, err)
+	c, err := am.cli.batch.CronJobs(ns).Get(context.TODO(), "backup", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "0 3 * * *", c.Spec.Schedule)
+
+	// the cron job is running on schedule before its first job
+	stats, err := am.collectCronJobStats(ns, nil)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "backup", stats[0].Name)
+	assert.Equal(t, WorkloadCronJob, stats[0].DeployType)
+	assert.Equal(t, specv1.Running, stats[0].Status)
+	stats, err = am.collectStatefulSetStats(ns, nil)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 0)
+
+	assert.NoError(t, am.deleteApplication(ns, "db"))
+	assert.NoError(t, am.deleteApplication(ns, "backup"))
+	ss, err := am.cli.app.StatefulSets(ns).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, ss.Items, 0)
+	cs, err := am.cli.batch.CronJobs(ns).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, cs.Items, 0)
+}
+
+func TestOwnedByCronJob(t *testing.T) {
+	job := &batchv1.Job{}
+	assert.False(t, ownedByCronJob(job))
+	job.OwnerReferences = []metav1.OwnerReference{{Kind: "CronJob", Name: "backup"}}
+	assert.True(t, ownedByCronJob(job))
+}
+
+func TestApplyStatefulSetClaims(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	ns := "baetyl-edge"
+	sts := specv1.Application{
+		Name:     "db",
+		Version:  "v1",
+		Replica:  1,
+		Workload: specv1.WorkloadStatefulSet,
+		Labels: map[string]string{
+			AppName:                    "db",
+			VolumeClaimPrefix + "data": "size=1Gi",
+		},
+		Services: []specv1.Service{{
+			Name:         "s1",
+			Image:        "image1",
+			VolumeMounts: []specv1.VolumeMount{{Name: "data", MountPath: "/data"}},
+		}},
+		Volumes: []specv1.Volume{{
+			Name:         "data",
+			VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/db"}},
+		}},
+	}
+	assert.NoError(t, am.applyApplication(ns, sts, nil))
+	s, err := am.cli.app.StatefulSets(ns).Get(context.TODO(), "db", metav1.GetOptions{})
+	assert.NoError(t, err)
+	uid := s.UID
+	// the defaults filled by the server are kept in the templates updated in place
+	mode := v1.PersistentVolumeFilesystem
+	s.Spec.VolumeClaimTemplates[0].Spec.VolumeMode = &mode
+	s, err = am.cli.app.StatefulSets(ns).Update(context.TODO(), s, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	sts.Version = "v2"
+	sts.Services[0].Image = "image2"
+	assert.NoError(t, am.applyApplication(ns, sts, nil))
+	s, err = am.cli.app.StatefulSets(ns).Get(context.TODO(), "db", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, uid, s.UID)
+	assert.Equal(t, "image2", s.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, &mode, s.Spec.VolumeClaimTemplates[0].Spec.VolumeMode)
+
+	// the stateful set is recreated if its claims change
+	s.UID = "old"
+	_, err = am.cli.app.StatefulSets(ns).Update(context.TODO(), s, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	sts.Version = "v3"
+	sts.Labels[VolumeClaimPrefix+"data"] = "size=2Gi"
+	assert.NoError(t, am.applyApplication(ns, sts, nil))
+	s, err = am.cli.app.StatefulSets(ns).Get(context.TODO(), "db", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, types.UID("old"), s.UID)
+	assert.Equal(t, resource.MustParse("2Gi"), s.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[v1.ResourceStorage])
+}
+
+func TestCronJobUnavailable(t *testing.T) {
+	am := initApplyKubeAMI(t)
+	am.cli.discovery = fake.NewSimpleClientset().Discovery()
+	ns := "baetyl-edge"
+	cron := specv1.Application{
+		Name:     "backup",
+		Version:  "v1",
+		Workload: WorkloadCronJob,
+		Labels: map[string]string{
+			AppName:      "backup",
+			CronSchedule: "0 3 * * *",
+		},
+		Services: []specv1.Service{{Name: "s1", Image: "image1"}},
+	}
+	// the cron jobs are skipped if batch/v1 does not serve them
+	err := am.applyApplication(ns, cron, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not served")
+	stats, err := am.collectCronJobStats(ns, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+	assert.NoError(t, am.deleteApplication(ns, "backup"))
+}
@@ -2,14 +2,15 @@ package kube
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	bh "github.com/timshannon/bolthold"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
@@ -40,7 +41,7 @@ func (k *kubeImpl) ApplyYaml(app specv1.Application, cfgs map[string]specv1.Conf
 				return errors.Trace(err)
 			}
 		}
-		return k.applyYamlApp(customNs, cfgs)
+		return k.applyYamlApp(customNs, app.Name, cfgs)
 	}
 	return nil
 }
@@ -90,6 +91,14 @@ func (k *kubeImpl) DeleteYaml(app *specv1.Application) error {
 			return err
 		}
 	}
+	// delete the objects in the inventory, including the ones left the manifests
+	inv := k.getYamlInventory(app.Name)
+	if err = k.deleteYamlObjects(inv.Objects); err != nil {
+		return errors.Trace(err)
+	}
+	if err = k.store.Delete(yamlInventoryKey(app.Name), YamlInventory{}); err != nil && err != bh.ErrNotFound {
+		k.log.Warn("failed to delete yaml app inventory", log.Any("app", app.Name), log.Error(err))
+	}
 	// delete yaml app
 	if customNs, ok := app.Labels[specv1.CustomAppNsLabel]; customNs != "" && ok {
 		return k.deleteYamlApp(customNs, app.Name, cfgs)
@@ -131,7 +140,10 @@ func (k *kubeImpl) deleteYamlApp(ns string, appName string, cfgs map[string]spec
 			if !strings.Contains(name, "yaml") && !strings.Contains(name, "yml") {
 				continue
 			}
-			objs := parseK8SYaml(data)
+			objs, err := parseK8SYaml(data)
+			if err != nil {
+				k.log.Warn("failed to parse k8s objects, the objects decoded are deleted", log.Any("name", name), log.Error(err))
+			}
 			if len(objs) == 0 {
 				k.log.Info("no k8s object found in cfg data")
 				continue
@@ -142,9 +154,9 @@ func (k *kubeImpl) deleteYamlApp(ns string, appName string, cfgs map[string]spec
 					k.log.Info("failed to transfer k8s obj to metav1 obj", log.Any("error", err))
 					continue
 				}
-				rsc := k.getResourceMapping(obj, k.cli.discovery)
-				if rsc == nil {
-					k.log.Info("failed to get k8s server resource for obj")
+				rsc, err := k.getResourceMapping(obj, k.cli.discovery)
+				if err != nil {
+					k.log.Info("failed to get k8s server resource for obj", log.Error(err))
 					continue
 				}
 				deletePolicy := metav1.DeletePropagationForeground
@@ -160,55 +172,38 @@ func (k *kubeImpl) deleteYamlApp(ns string, appName string, cfgs map[string]spec
 	return nil
 }
 
-func (k *kubeImpl) applyYamlApp(ns string, cfgs map[string]specv1.Configuration) error {
-	for _, cfg := range cfgs {
-		for name, data := range cfg.Data {
-			if !strings.Contains(name, "yaml") && !strings.Contains(name, "yml") {
-				continue
-			}
-			objs := parseK8SYaml(data)
-			if len(objs) == 0 {
-				continue
-			}
-			for _, obj := range objs {
-				metaAccessor, err := meta.Accessor(obj)
-				if err != nil {
-					k.log.Error("failed to transfer k8s obj to metav1 obj", log.Any("error", err))
-					continue
-				}
-				metaAccessor.SetNamespace(ns)
-				u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
-				if err != nil {
-					k.log.Error("failed to transfer k8s obj to unstructured obj", log.Any("error", err))
-					continue
-				}
-				us := &unstructured.Unstructured{
-					Object: u,
-				}
-				rsc := k.getResourceMapping(obj, k.cli.discovery)
-				if rsc == nil {
-					k.log.Info("failed to get k8s server resource for obj")
-					continue
-				}
-				applyOptions := metav1.ApplyOptions{
-					FieldManager: "my-controller-name",
-				}
-				_, err = k.cli.dynamic.Resource(*rsc).Namespace(ns).Apply(context.Background(), metaAccessor.GetName(), us, applyOptions)
-				if err != nil {
-					k.log.Error("failed to apply k8s resource with dynamic client", log.Any("error", err))
-					continue
-				}
-			}
+// applyYamlApp applies the objects by server-side apply, and prunes the objects which leave the manifests,
+// nothing is pruned and the inventory is kept if any object fails, so the objects are not lost by a partial apply
+func (k *kubeImpl) applyYamlApp(ns, appName string, cfgs map[string]specv1.Configuration) error {
+	manifests, err := k.parseYamlApp(ns, appName, cfgs)
+	if err != nil {
+		return errors.Errorf("failed to apply yaml app (%s): %s", appName, err.Error())
+	}
+	var errs []string
+	for _, m := range manifests {
+		if _, err := k.applyYamlObject(m, false); err != nil {
+			k.log.Error("failed to apply k8s resource with dynamic client", log.Any("kind", m.Kind), log.Any("name", m.Name), log.Error(err))
+			errs = append(errs, fmt.Sprintf("%s (%s): %s", m.Kind, m.Name, err.Error()))
 		}
 	}
-	k.log.Info("Apply yaml app success")
+	if len(errs) > 0 {
+		return errors.Errorf("failed to apply yaml app (%s): %s", appName, strings.Join(errs, "; "))
+	}
+	if err := k.deleteYamlObjects(pruneCandidates(k.getYamlInventory(appName), manifests)); err != nil {
+		return errors.Trace(err)
+	}
+	if err := k.storeYamlInventory(appName, manifests); err != nil {
+		return errors.Trace(err)
+	}
+	k.log.Info("Apply yaml app success", log.Any("app", appName))
 	return nil
 }
 
-func parseK8SYaml(fileR string) []runtime.Object {
+// parseK8SYaml decodes the objects separated by "---", the objects decoded before an error are returned with it
+func parseK8SYaml(fileR string) ([]runtime.Object, error) {
 	sepYamlfiles := strings.Split(fileR, "---")
 	res := make([]runtime.Object, 0, len(sepYamlfiles))
-	for _, f := range sepYamlfiles {
+	for i, f := range sepYamlfiles {
 		if s := strings.TrimSpace(f); s == "" {
 			// ignore empty cases
 			continue
@@ -216,19 +211,18 @@ func parseK8SYaml(fileR string) []runtime.Object {
 		decode := scheme.Codecs.UniversalDeserializer().Decode
 		obj, _, err := decode([]byte(f), nil, nil)
 		if err != nil {
-			continue
+			return res, errors.Errorf("failed to decode object %d: %s", i+1, err.Error())
 		}
 		res = append(res, obj)
 	}
-	return res
+	return res, nil
 }
 
-func (k *kubeImpl) getResourceMapping(obj runtime.Object, discovery discovery.DiscoveryInterface) *schema.GroupVersionResource {
+func (k *kubeImpl) getResourceMapping(obj runtime.Object, discovery discovery.DiscoveryInterface) (*schema.GroupVersionResource, error) {
 	gvk := obj.GetObjectKind().GroupVersionKind()
 	apiResources, err := discovery.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
 	if err != nil {
-		k.log.Error("failed to get server api resources", log.Any("error", err))
-		return nil
+		return nil, errors.Trace(err)
 	}
 	for _, apiResource := range apiResources.APIResources {
 		if apiResource.Kind == gvk.Kind {
@@ -236,8 +230,8 @@ func (k *kubeImpl) getResourceMapping(obj runtime.Object, discovery discovery.Di
 				Group:    gvk.Group,
 				Version:  gvk.Version,
 				Resource: apiResource.Name,
-			}
+			}, nil
 		}
 	}
-	return nil
+	return nil, errors.Errorf("kind (%s) of %s is not served", gvk.Kind, gvk.GroupVersion())
 }
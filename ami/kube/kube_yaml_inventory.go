@@ -0,0 +1,287 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+const (
+	// YamlAppLabel the label of the objects applied for a yaml app, whose value is the app name
+	YamlAppLabel = "baetyl-yaml-app"
+	// YamlDryRun the app label to preview the changes of a yaml app instead of applying it
+	YamlDryRun = "baetyl-yaml-dry-run"
+
+	yamlFieldManager    = "baetyl"
+	yamlInventoryPrefix = "baetyl-yaml-inventory-"
+)
+
+// the fields set by the api server, which are not compared in the diff
+var yamlIgnoredFields = [][]string{
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "uid"},
+	{"metadata", "creationTimestamp"},
+	{"status"},
+}
+
+// YamlObject an object applied for a yaml app
+type YamlObject struct {
+	Group     string `yaml:"group,omitempty" json:"group,omitempty"`
+	Version   string `yaml:"version" json:"version"`
+	Resource  string `yaml:"resource" json:"resource"`
+	Kind      string `yaml:"kind" json:"kind"`
+	Namespace string `yaml:"namespace" json:"namespace"`
+	Name      string `yaml:"name" json:"name"`
+}
+
+// YamlInventory the objects applied for a yaml app, the objects which leave the manifests are pruned
+type YamlInventory struct {
+	Objects []YamlObject `yaml:"objects" json:"objects"`
+}
+
+type yamlManifest struct {
+	YamlObject
+	obj *unstructured.Unstructured
+}
+
+func (o YamlObject) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: o.Group, Version: o.Version, Resource: o.Resource}
+}
+
+func (o YamlObject) key() string {
+	return strings.Join([]string{o.Group, o.Resource, o.Namespace, o.Name}, "/")
+}
+
+func yamlInventoryKey(appName string) string {
+	return yamlInventoryPrefix + appName
+}
+
+// parseYamlApp parses the objects in the yaml configs of the app, labeled by the app name,
+// an error is returned if any object can't be decoded or mapped, since the objects left out would be pruned
+func (k *kubeImpl) parseYamlApp(ns, appName string, cfgs map[string]specv1.Configuration) ([]yamlManifest, error) {
+	var res []yamlManifest
+	for _, cfgName := range sortedKeys(cfgs) {
+		data := cfgs[cfgName].Data
+		var names []string
+		for name := range data {
+			if strings.Contains(name, "yaml") || strings.Contains(name, "yml") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			objs, err := parseK8SYaml(data[name])
+			if err != nil {
+				return nil, errors.Errorf("failed to parse (%s) of config (%s): %s", name, cfgName, err.Error())
+			}
+			for _, obj := range objs {
+				m, err := k.yamlManifestOf(ns, appName, obj)
+				if err != nil {
+					return nil, errors.Errorf("failed to parse (%s) of config (%s): %s", name, cfgName, err.Error())
+				}
+				res = append(res, *m)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (k *kubeImpl) yamlManifestOf(ns, appName string, obj runtime.Object) (*yamlManifest, error) {
+	metaAccessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	metaAccessor.SetNamespace(ns)
+	labels := metaAccessor.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[YamlAppLabel] = appName
+	metaAccessor.SetLabels(labels)
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rsc, err := k.getResourceMapping(obj, k.cli.discovery)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	return &yamlManifest{
+		YamlObject: YamlObject{
+			Group:     rsc.Group,
+			Version:   rsc.Version,
+			Resource:  rsc.Resource,
+			Kind:      gvk.Kind,
+			Namespace: ns,
+			Name:      metaAccessor.GetName(),
+		},
+		obj: &unstructured.Unstructured{Object: u},
+	}, nil
+}
+
+func (k *kubeImpl) applyYamlObject(m yamlManifest, dryRun bool) (*unstructured.Unstructured, error) {
+	opts := metav1.ApplyOptions{FieldManager: yamlFieldManager, Force: true}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	res, err := k.cli.dynamic.Resource(m.gvr()).Namespace(m.Namespace).Apply(context.Background(), m.Name, m.obj, opts)
+	return res, errors.Trace(err)
+}
+
+func (k *kubeImpl) getYamlInventory(appName string) *YamlInventory {
+	inv := &YamlInventory{}
+	if err := k.store.Get(yamlInventoryKey(appName), inv); err != nil {
+		return &YamlInventory{}
+	}
+	return inv
+}
+
+func (k *kubeImpl) storeYamlInventory(appName string, manifests []yamlManifest) error {
+	inv := &YamlInventory{}
+	for _, m := range manifests {
+		inv.Objects = append(inv.Objects, m.YamlObject)
+	}
+	return errors.Trace(k.store.Upsert(yamlInventoryKey(appName), inv))
+}
+
+// pruneCandidates the objects in the inventory which leave the manifests
+func pruneCandidates(inv *YamlInventory, manifests []yamlManifest) []YamlObject {
+	desired := map[string]bool{}
+	for _, m := range manifests {
+		desired[m.key()] = true
+	}
+	var res []YamlObject
+	for _, o := range inv.Objects {
+		if !desired[o.key()] {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// deleteYamlObjects deletes the objects, the objects already deleted are skipped
+func (k *kubeImpl) deleteYamlObjects(objs []YamlObject) error {
+	deletePolicy := metav1.DeletePropagationForeground
+	for _, o := range objs {
+		err := k.cli.dynamic.Resource(o.gvr()).Namespace(o.Namespace).Delete(context.Background(), o.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.Trace(err)
+		}
+		k.log.Info("yaml object deleted", log.Any("kind", o.Kind), log.Any("namespace", o.Namespace), log.Any("name", o.Name))
+	}
+	return nil
+}
+
+// DiffApp previews the changes of a yaml app by server-side dry-run, the objects leaving the manifests are pruned
+func (k *kubeImpl) DiffApp(_ string, app specv1.Application, cfgs map[string]specv1.Configuration) (*ami.AppDiff, error) {
+	if app.Type != specv1.AppTypeYaml {
+		return nil, errors.Errorf("the diff of app (%s) type (%s) is not supported", app.Name, app.Type)
+	}
+	ns := app.Labels[specv1.CustomAppNsLabel]
+	manifests, err := k.parseYamlApp(ns, app.Name, cfgs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	diff := &ami.AppDiff{App: specv1.AppInfo{Name: app.Name, Version: app.Version}}
+	for _, m := range manifests {
+		od := ami.ObjectDiff{
+			APIVersion: m.obj.GetAPIVersion(),
+			Kind:       m.Kind,
+			Namespace:  m.Namespace,
+			Name:       m.Name,
+		}
+		live, err := k.cli.dynamic.Resource(m.gvr()).Namespace(m.Namespace).Get(context.Background(), m.Name, metav1.GetOptions{})
+		if err != nil {
+			if !kerrors.IsNotFound(err) {
+				return nil, errors.Trace(err)
+			}
+			od.Action = ami.DiffCreate
+			diff.Objects = append(diff.Objects, od)
+			continue
+		}
+		merged, err := k.applyYamlObject(m, true)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		od.Fields = diffFields(live.Object, merged.Object)
+		od.Action = ami.DiffUnchanged
+		if len(od.Fields) > 0 {
+			od.Action = ami.DiffUpdate
+		}
+		diff.Objects = append(diff.Objects, od)
+	}
+	for _, o := range pruneCandidates(k.getYamlInventory(app.Name), manifests) {
+		diff.Objects = append(diff.Objects, ami.ObjectDiff{
+			APIVersion: schema.GroupVersion{Group: o.Group, Version: o.Version}.String(),
+			Kind:       o.Kind,
+			Namespace:  o.Namespace,
+			Name:       o.Name,
+			Action:     ami.DiffPrune,
+		})
+	}
+	return diff, nil
+}
+
+// diffFields returns the paths of the fields changed from the live object to the applied object
+func diffFields(live, applied map[string]interface{}) []string {
+	live, applied = runtime.DeepCopyJSON(live), runtime.DeepCopyJSON(applied)
+	for _, fields := range yamlIgnoredFields {
+		unstructured.RemoveNestedField(live, fields...)
+		unstructured.RemoveNestedField(applied, fields...)
+	}
+	var res []string
+	diffValues("", live, applied, &res)
+	sort.Strings(res)
+	return res
+}
+
+func diffValues(path string, a, b interface{}, res *[]string) {
+	am, ok1 := a.(map[string]interface{})
+	bm, ok2 := b.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if !reflect.DeepEqual(a, b) {
+			*res = append(*res, path)
+		}
+		return
+	}
+	keys := map[string]bool{}
+	for key := range am {
+		keys[key] = true
+	}
+	for key := range bm {
+		keys[key] = true
+	}
+	for key := range keys {
+		p := key
+		if path != "" {
+			p = fmt.Sprintf("%s.%s", path, key)
+		}
+		diffValues(p, am[key], bm[key], res)
+	}
+}
+
+func sortedKeys(cfgs map[string]specv1.Configuration) []string {
+	var keys []string
+	for key := range cfgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,193 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+const (
+	yamlV1 = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+data:
+  a: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm2
+data:
+  b: "2"
+`
+	yamlV2 = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+data:
+  a: "10"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: sec1
+`
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// initYamlKubeAMI the fake dynamic client creates the objects not found by server-side apply,
+// and stores nothing in dry run
+func initYamlKubeAMI(t *testing.T, dryRun *bool) (*kubeImpl, *dynamicfake.FakeDynamicClient) {
+	am := initApplyKubeAMI(t)
+	fc := fake.NewSimpleClientset()
+	fc.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			{Name: "secrets", Kind: "Secret", Namespaced: true},
+		},
+	}}
+	am.cli.discovery = fc.Discovery()
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dc.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pa := action.(k8stesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(pa.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		if *dryRun {
+			return true, obj, nil
+		}
+		_, err := dc.Tracker().Get(pa.GetResource(), pa.GetNamespace(), pa.GetName())
+		if kerrors.IsNotFound(err) {
+			err = dc.Tracker().Create(pa.GetResource(), obj, pa.GetNamespace())
+		} else if err == nil {
+			err = dc.Tracker().Update(pa.GetResource(), obj, pa.GetNamespace())
+		}
+		return true, obj, err
+	})
+	am.cli.dynamic = dc
+	return am, dc
+}
+
+func TestApplyYamlApp(t *testing.T) {
+	dryRun := false
+	am, dc := initYamlKubeAMI(t, &dryRun)
+	ns := "custom"
+	app := specv1.Application{
+		Name:    "yaml1",
+		Version: "v1",
+		Type:    specv1.AppTypeYaml,
+		Labels:  map[string]string{specv1.CustomAppNsLabel: ns},
+	}
+	cfgs := map[string]specv1.Configuration{"cfg1": {Name: "cfg1", Data: map[string]string{"app.yaml": yamlV1}}}
+
+	diff, err := am.DiffApp(ns, app, cfgs)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Objects, 2)
+	assert.Equal(t, ami.DiffCreate, diff.Objects[0].Action)
+	assert.Equal(t, "cm1", diff.Objects[0].Name)
+	assert.Equal(t, "2 to create, 0 to update, 0 to prune", diff.Summary())
+
+	assert.NoError(t, am.applyYamlApp(ns, app.Name, cfgs))
+	cm, err := dc.Resource(configMapGVR).Namespace(ns).Get(context.TODO(), "cm2", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, app.Name, cm.GetLabels()[YamlAppLabel])
+	assert.Len(t, am.getYamlInventory(app.Name).Objects, 2)
+
+	// cm2 leaves the manifests of the new version
+	app.Version = "v2"
+	cfgs["cfg1"] = specv1.Configuration{Name: "cfg1", Data: map[string]string{"app.yaml": yamlV2}}
+	dryRun = true
+	diff, err = am.DiffApp(ns, app, cfgs)
+	dryRun = false
+	assert.NoError(t, err)
+	assert.Equal(t, "1 to create, 1 to update, 1 to prune", diff.Summary())
+	assert.Equal(t, ami.ObjectDiff{APIVersion: "v1", Kind: "ConfigMap", Namespace: ns, Name: "cm1", Action: ami.DiffUpdate, Fields: []string{"data.a"}}, diff.Objects[0])
+	assert.Equal(t, ami.DiffCreate, diff.Objects[1].Action)
+	assert.Equal(t, ami.ObjectDiff{APIVersion: "v1", Kind: "ConfigMap", Namespace: ns, Name: "cm2", Action: ami.DiffPrune}, diff.Objects[2])
+
+	assert.NoError(t, am.applyYamlApp(ns, app.Name, cfgs))
+	_, err = dc.Resource(configMapGVR).Namespace(ns).Get(context.TODO(), "cm2", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	cm, err = dc.Resource(configMapGVR).Namespace(ns).Get(context.TODO(), "cm1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "10"}, cm.Object["data"])
+	assert.Len(t, am.getYamlInventory(app.Name).Objects, 2)
+
+	assert.NoError(t, am.DeleteYaml(&app))
+	_, err = dc.Resource(configMapGVR).Namespace(ns).Get(context.TODO(), "cm1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	assert.Len(t, am.getYamlInventory(app.Name).Objects, 0)
+
+	app.Type = specv1.AppTypeContainer
+	_, err = am.DiffApp(ns, app, cfgs)
+	assert.Error(t, err)
+}
+
+func TestApplyYamlAppFailed(t *testing.T) {
+	dryRun := false
+	am, dc := initYamlKubeAMI(t, &dryRun)
+	ns := "custom"
+	cfgs := map[string]specv1.Configuration{"cfg1": {Name: "cfg1", Data: map[string]string{"app.yaml": yamlV1}}}
+	assert.NoError(t, am.applyYamlApp(ns, "yaml1", cfgs))
+	assert.Len(t, am.getYamlInventory("yaml1").Objects, 2)
+
+	// the discovery serves nothing
+	discovery := am.cli.discovery
+	am.cli.discovery = fake.NewSimpleClientset().Discovery()
+	cfgs["cfg1"] = specv1.Configuration{Name: "cfg1", Data: map[string]string{"app.yaml": yamlV2}}
+	assert.Error(t, am.applyYamlApp(ns, "yaml1", cfgs))
+	_, err := am.DiffApp(ns, specv1.Application{Name: "yaml1", Type: specv1.AppTypeYaml}, cfgs)
+	assert.Error(t, err)
+	am.cli.discovery = discovery
+
+	// the object can't be decoded
+	cfgs["cfg1"] = specv1.Configuration{Name: "cfg1", Data: map[string]string{"app.yaml": yamlV2 + "---\nkind: [\n"}}
+	assert.Error(t, am.applyYamlApp(ns, "yaml1", cfgs))
+
+	// the apply of an object fails
+	dc.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "sec1", nil)
+	})
+	cfgs["cfg1"] = specv1.Configuration{Name: "cfg1", Data: map[string]string{"app.yaml": yamlV2}}
+	assert.Error(t, am.applyYamlApp(ns, "yaml1", cfgs))
+
+	// nothing is pruned and the inventory is kept
+	_, err = dc.Resource(configMapGVR).Namespace(ns).Get(context.TODO(), "cm2", metav1.GetOptions{})
+	assert.NoError(t, err)
+	inv := am.getYamlInventory("yaml1")
+	assert.Len(t, inv.Objects, 2)
+	assert.Equal(t, "cm2", inv.Objects[1].Name)
+}
+
+func TestDiffFields(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "d1", "resourceVersion": "1", "labels": map[string]interface{}{"a": "1"}},
+		"spec":     map[string]interface{}{"replicas": int64(1), "ports": []interface{}{int64(80)}},
+		"status":   map[string]interface{}{"ready": true},
+	}
+	applied := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "d1", "resourceVersion": "2", "labels": map[string]interface{}{"a": "1", "b": "2"}},
+		"spec":     map[string]interface{}{"replicas": int64(2), "ports": []interface{}{int64(80), int64(81)}},
+	}
+	assert.Equal(t, []string{"metadata.labels.b", "spec.ports", "spec.replicas"}, diffFields(live, applied))
+	assert.Len(t, diffFields(live, live), 0)
+}
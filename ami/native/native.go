@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	gctx "github.com/baetyl/baetyl-go/v2/context"
@@ -44,6 +45,7 @@ var (
 	ErrServiceNotRunning = errors.New("error : service is not running")
 	ErrServicePIDGet     = errors.New("failed to get svc pid")
 	ErrServicePPIDGet    = errors.New("failed to get svc ppid")
+	ErrReadinessProbe    = errors.New("readiness probe failed")
 )
 
 const (
@@ -70,6 +72,11 @@ type nativeImpl struct {
 	mapping       *native.ServiceMapping
 	portAllocator *native.PortAllocator
 	probeManager  prober.Manager
+	update        config.UpdateConfig
+	cgroup        config.CgroupConfig
+	unitDir       string // the directory of the systemd units, empty if the services are not managed by systemd
+	usages        map[string]cgroupSample
+	mu            sync.Mutex
 	store         *bh.Store
 	log           *log.Logger
 }
@@ -87,6 +94,10 @@ func newNativeImpl(cfg config.AmiConfig, store *bh.Store) (ami.AMI, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	var unitDir string
+	if service.Platform() == "linux-systemd" {
+		unitDir = systemdUnitDir
+	}
 	return &nativeImpl{
 		logHostPath:   filepath.Join(hostPathLib, "log"),
 		runHostPath:   filepath.Join(hostPathLib, "run"),
@@ -94,6 +105,10 @@ func newNativeImpl(cfg config.AmiConfig, store *bh.Store) (ami.AMI, error) {
 		mapping:       mapping,
 		portAllocator: portAllocator,
 		probeManager:  prober.NewManager(store),
+		update:        cfg.Native.Update,
+		cgroup:        cfg.Native.Cgroup,
+		unitDir:       unitDir,
+		usages:        map[string]cgroupSample{},
 		store:         store,
 		log:           log.With(log.Any("ami", "native")),
 	}, nil
@@ -110,6 +125,9 @@ func (impl *nativeImpl) RemoteWebsocket(ctx context.Context, option *ami.DebugOp
 
 // RemoteCommand Implement of native
 func (impl *nativeImpl) RemoteCommand(option *ami.DebugOptions, pipe ami.Pipe) error {
+	if option.Local {
+		return impl.localCommand(option, pipe)
+	}
 	cfg := &ssh.ClientConfig{
 		User: option.Username,
 		Auth: []ssh.AuthMethod{
@@ -140,10 +158,23 @@ func (impl *nativeImpl) RemoteCommand(option *ami.DebugOptions, pipe ami.Pipe) e
 		ssh.TTY_OP_OSPEED: TtySpeed, // output speed = 14.4kbaud
 	}
 
-	// TODO: support window resize
 	if err = session.RequestPty(Term, Rows, Cols, modes); err != nil {
 		return errors.Trace(err)
 	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case size := <-pipe.Resize:
+				if err := session.WindowChange(int(size.Rows), int(size.Cols)); err != nil {
+					impl.log.Warn("failed to resize terminal", log.Error(err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
 	// Start remote shell
 	if err = session.Shell(); err != nil {
 		return errors.Trace(err)
@@ -172,17 +203,40 @@ func (impl *nativeImpl) GetMasterNodeName() string {
 	return ho.Hostname
 }
 
-func (impl *nativeImpl) ApplyApp(ns string, app v1.Application, configs map[string]v1.Configuration, secrets map[string]v1.Secret) error {
-	var appInfo v1.AppInfo
-	appInfo.Name = app.Name
-	appInfo.Version = app.Version
-	err := impl.DeleteApp(ns, appInfo)
+// ApplyApp installs and starts the new version of the app next to the old versions, which are removed once the
+// new version is installed, or is ready if the update waits for it, otherwise the new version is rolled back
+func (impl *nativeImpl) ApplyApp(ctx context.Context, ns string, app v1.Application, configs map[string]v1.Configuration, secrets map[string]v1.Secret) error {
+	// the same version can not be installed side by side
+	err := impl.deleteAppVersion(ns, app.Name, app.Version, true)
 	if err != nil {
 		impl.log.Warn("failed to delete old app", log.Error(err))
 	}
+	prevs, err := impl.listAppVersions(ns, app.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
+	err = impl.installApp(ns, app, configs, secrets)
+	// the readiness is checked by the health trial of the engine unless the update waits for it
+	if err == nil && impl.update.Wait {
+		err = impl.waitAppReady(ctx, ns, &app)
+	}
+	if err != nil {
+		return impl.rollbackApp(ns, &app, prevs, err)
+	}
+	for _, ver := range prevs {
+		// the service ports in mapping files belong to the new version now
+		if err = impl.deleteAppVersion(ns, app.Name, ver, false); err != nil {
+			impl.log.Warn("failed to delete old app", log.Any("version", ver), log.Error(err))
+		}
+	}
+	impl.log.Info("apply an app", log.Any("app", app))
+	return nil
+}
+
+func (impl *nativeImpl) installApp(ns string, app v1.Application, configs map[string]v1.Configuration, secrets map[string]v1.Secret) error {
 	appDir := filepath.Join(impl.runHostPath, ns, app.Name, app.Version)
-	err = os.MkdirAll(appDir, 0755)
+	err := os.MkdirAll(appDir, 0755)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -272,10 +326,6 @@ func (impl *nativeImpl) ApplyApp(ns string, app v1.Application, configs map[stri
 			}
 
 			if prgExec == "" {
-				err = impl.DeleteApp(ns, appInfo)
-				if err != nil {
-					impl.log.Warn("failed to delete new app", log.Error(err))
-				}
 				return errors.Errorf("no program executable, the program config may not be mounted")
 			}
 
@@ -321,6 +371,15 @@ func (impl *nativeImpl) ApplyApp(ns string, app v1.Application, configs map[stri
 				Args:        s.Args,
 				Env:         env,
 			}
+			prgCfg.Restart.Policy, prgCfg.Restart.MaxRetries = restartPolicy(&app, &s)
+			prgCfg.StopSignal, prgCfg.StopTimeout, err = stopConfig(&app, s.Name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			prgCfg.Cgroup, err = impl.createCgroup(prgCfg.Name, s.Resources)
+			if err != nil {
+				return errors.Trace(err)
+			}
 			prgCfg.Logger = sysCfg.Logger
 			prgCfg.Logger.Filename = filepath.Join(impl.logHostPath, ns, app.Name, app.Version, fmt.Sprintf("%s-%d.log", s.Name, i))
 
@@ -358,132 +417,128 @@ func (impl *nativeImpl) ApplyApp(ns string, app v1.Application, configs map[stri
 					return errors.Trace(err)
 				}
 			}
-			impl.probeManager.AddApp(svc, &app)
+			impl.probeManager.AddApp(svc, &app, prober.Instance{Service: s.Name, ID: strconv.Itoa(i), Dir: insDir})
 		}
 
 		if len(ports) > 0 {
-			if app.Type == v1.AppTypeContainer {
-				err = impl.mapping.SetServicePorts(s.Name, ports)
-				if err != nil {
-					return errors.Trace(err)
-				}
-				impl.log.Debug("set applied service ports in mapping files", log.Any("applied service", s.Name), log.Any("ports", ports))
-			} else {
-				// native function use app name
-				err = impl.mapping.SetServicePorts(app.Name, ports)
-				if err != nil {
-					return errors.Trace(err)
-				}
-				impl.log.Debug("set applied service ports in mapping files", log.Any("applied service", app.Name), log.Any("ports", ports))
+			key := servicePortsKey(&app, s.Name)
+			err = impl.mapping.SetServicePorts(key, ports)
+			if err != nil {
+				return errors.Trace(err)
 			}
+			impl.log.Debug("set applied service ports in mapping files", log.Any("applied service", key), log.Any("ports", ports))
 		}
 	}
-	impl.log.Info("apply an app", log.Any("app", app))
 	return nil
 }
 
 func (impl *nativeImpl) DeleteApp(ns string, app v1.AppInfo) error {
-	// scan app version
-	curAppDir := filepath.Join(impl.runHostPath, ns, app.Name)
-	appVerFiles, err := os.ReadDir(curAppDir)
+	vers, err := impl.listAppVersions(ns, app.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ver := range vers {
+		if err = impl.deleteAppVersion(ns, app.Name, ver, true); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(os.RemoveAll(filepath.Join(impl.runHostPath, ns, app.Name)))
+}
+
+// deleteAppVersion stops and removes the instances of one app version,
+// the service ports in mapping files are kept if they are taken over by another version
+func (impl *nativeImpl) deleteAppVersion(ns, appName, appVer string, cleanPorts bool) error {
+	// scan service
+	curAppVerDir := filepath.Join(impl.runHostPath, ns, appName, appVer)
+	svcFiles, err := os.ReadDir(curAppVerDir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return errors.Trace(err)
 	}
-	for _, appVerFile := range appVerFiles {
-		if !appVerFile.IsDir() {
+	info := &v1.AppInfo{Name: appName, Version: appVer}
+	for _, svcFile := range svcFiles {
+		if !svcFile.IsDir() {
 			continue
 		}
-		// scan service
-		curAppVer := appVerFile.Name()
-		curAppVerDir := filepath.Join(curAppDir, curAppVer)
-		svcFiles, err := os.ReadDir(curAppVerDir)
+		// scan service instance
+		curSvcName := svcFile.Name()
+		curSvcDir := filepath.Join(curAppVerDir, curSvcName)
+		svcInsFiles, err := os.ReadDir(curSvcDir)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		for _, svcFile := range svcFiles {
-			if !svcFile.IsDir() {
+		for _, svcInsFile := range svcInsFiles {
+			if !svcInsFile.IsDir() {
 				continue
 			}
-			// scan service instance
-			curSvcName := svcFile.Name()
-			curSvcDir := filepath.Join(curAppVerDir, curSvcName)
-			svcInsFiles, err := os.ReadDir(curSvcDir)
+			curSvcIns := svcInsFile.Name()
+			curSvcInsDir := filepath.Join(curSvcDir, curSvcIns)
+			svc, err := service.New(nil, &service.Config{
+				Name:             genServiceInstanceName(ns, appName, appVer, curSvcName, curSvcIns),
+				WorkingDirectory: svcInsFile.Name(),
+			})
 			if err != nil {
 				return errors.Trace(err)
 			}
-			for _, svcInsFile := range svcInsFiles {
-				if !svcInsFile.IsDir() {
-					continue
-				}
-				curSvcIns := svcInsFile.Name()
-				curSvcInsDir := filepath.Join(curSvcDir, curSvcIns)
-				svc, err := service.New(nil, &service.Config{
-					Name:             genServiceInstanceName(ns, app.Name, curAppVer, curSvcName, curSvcIns),
-					WorkingDirectory: svcInsFile.Name(),
-				})
-				if err != nil {
-					return errors.Trace(err)
-				}
-				var childs *[]ami.ProcessInfo
-				if runtime.GOOS == "windows" {
-					status, err := svc.Status()
-					if err == nil {
-						if status == service.StatusRunning {
-							pid, err := svc.GetPid()
-							if err != nil {
-								impl.log.Warn("failed to get svc pid", log.Error(err))
-							}
-							childs, err = getChildProcessInfo(pid)
-							if err != nil {
-								impl.log.Warn("failed to get child pid", log.Error(err))
-							}
+			var childs *[]ami.ProcessInfo
+			if runtime.GOOS == "windows" {
+				status, err := svc.Status()
+				if err == nil {
+					if status == service.StatusRunning {
+						pid, err := svc.GetPid()
+						if err != nil {
+							impl.log.Warn("failed to get svc pid", log.Error(err))
 						}
-					}
-				}
-				impl.probeManager.RemoveApp(&app)
-				if err = svc.Stop(); err != nil {
-					impl.log.Warn("failed to stop old app", log.Error(err))
-				}
-				if err = svc.Uninstall(); err != nil {
-					impl.log.Warn("failed to uninstall old app", log.Error(err))
-				}
-
-				if runtime.GOOS == "windows" && childs != nil {
-					for _, p := range *childs {
-						proc, err := process.NewProcess(p.Pid)
-						if err == nil && proc != nil {
-							proc.Terminate()
-							impl.log.Warn("svc child process killed", log.Any("name", p.Name), log.Any("pid", p.Pid))
-							time.Sleep(100 * time.Millisecond)
+						childs, err = getChildProcessInfo(pid)
+						if err != nil {
+							impl.log.Warn("failed to get child pid", log.Error(err))
 						}
 					}
 				}
-
-				err = os.RemoveAll(curSvcInsDir)
-				if err != nil {
-					return errors.Trace(err)
-				}
 			}
-			err = os.RemoveAll(curSvcDir)
-			if err != nil {
-				return errors.Trace(err)
+			impl.probeManager.RemoveApp(info)
+			if err = svc.Stop(); err != nil {
+				impl.log.Warn("failed to stop old app", log.Error(err))
+			}
+			impl.waitStopped(svc, stopTimeoutOf(curSvcInsDir))
+			if err = svc.Uninstall(); err != nil {
+				impl.log.Warn("failed to uninstall old app", log.Error(err))
+			}
+			impl.removeCgroup(genServiceInstanceName(ns, appName, appVer, curSvcName, curSvcIns))
+
+			if runtime.GOOS == "windows" && childs != nil {
+				for _, p := range *childs {
+					proc, err := process.NewProcess(p.Pid)
+					if err == nil && proc != nil {
+						proc.Terminate()
+						impl.log.Warn("svc child process killed", log.Any("name", p.Name), log.Any("pid", p.Pid))
+						time.Sleep(100 * time.Millisecond)
+					}
+				}
 			}
 
-			err = impl.mapping.DeleteServicePorts(curSvcName)
+			err = os.RemoveAll(curSvcInsDir)
 			if err != nil {
 				return errors.Trace(err)
 			}
-			impl.log.Debug("delete applied service ports in mapping files", log.Any("applied service", curSvcName))
 		}
-		err = os.RemoveAll(curAppVerDir)
+		err = os.RemoveAll(curSvcDir)
 		if err != nil {
 			return errors.Trace(err)
 		}
+
+		if !cleanPorts {
+			continue
+		}
+		err = impl.mapping.DeleteServicePorts(curSvcName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		impl.log.Debug("delete applied service ports in mapping files", log.Any("applied service", curSvcName))
 	}
-	return errors.Trace(os.RemoveAll(curAppDir))
+	return errors.Trace(os.RemoveAll(curAppVerDir))
 }
 
 func (impl *nativeImpl) StatsApps(ns string) ([]v1.AppStats, error) {
@@ -576,7 +631,8 @@ func (impl *nativeImpl) StatsApps(ns string) ([]v1.AppStats, error) {
 						continue
 					}
 					apps[utilsV2.MakeKey(v1.KindApplication, curAppName, curAppVer)] = true
-					impl.probeManager.CheckAndStart(svc, &v1.AppInfo{Name: curAppName, Version: curAppVer})
+					curIns := prober.Instance{Service: curSvcName, ID: curSvcIns, Dir: filepath.Join(curSvcPath, curSvcIns)}
+					impl.probeManager.CheckAndStart(svc, &v1.AppInfo{Name: curAppName, Version: curAppVer}, curIns)
 
 					status, err := svc.Status()
 					if err != nil || status != service.StatusRunning {
@@ -587,6 +643,15 @@ func (impl *nativeImpl) StatsApps(ns string) ([]v1.AppStats, error) {
 						continue
 					}
 					mainInsStats.Status = prgStatusToSpecStatus(status)
+					// the program runner records the exits and restarts of the program
+					if prgStatus, err := program.LoadStatus(curIns.Dir); err == nil {
+						setProgramStatus(&mainInsStats, prgStatus)
+					}
+					if mainInsStats.Status != v1.Running {
+						curInsStats[curPrgName] = mainInsStats
+						curAppStats.InstanceStats = curInsStats
+						continue
+					}
 
 					pid, err = svc.GetPid()
 					if err != nil || pid == 0 || pid == 1 {
@@ -607,6 +672,19 @@ func (impl *nativeImpl) StatsApps(ns string) ([]v1.AppStats, error) {
 						continue
 					}
 					mainInsStats.PPid = ppid
+					// the instance is running but does not serve until its readiness probe succeeds
+					if !impl.probeManager.Ready(&v1.AppInfo{Name: curAppName, Version: curAppVer}, curIns) {
+						mainInsStats.Status = v1.Pending
+						mainInsStats.Cause = ErrReadinessProbe.Error()
+					}
+
+					// the usage of all processes of the instance is accounted in its cgroup
+					if usage, ok := impl.getCgroupUsage(curPrgName); ok {
+						mainInsStats.Usage = usage
+						curInsStats[curPrgName] = mainInsStats
+						curAppStats.InstanceStats = curInsStats
+						continue
+					}
 
 					usage, err := getServiceInsStats(pid)
 					if err != nil {
@@ -764,10 +842,53 @@ func prgStatusToSpecStatus(status service.Status) v1.Status {
 	}
 }
 
+// setProgramStatus surfaces the state, exit code and restart count recorded by the program runner
+func setProgramStatus(ins *v1.InstanceStats, st *program.Status) {
+	reason := fmt.Sprintf("restart count %d", st.RestartCount)
+	if !st.ExitTime.IsZero() {
+		reason += fmt.Sprintf(", last exit code %d", st.ExitCode)
+	}
+	if st.Error != "" {
+		reason += ": " + st.Error
+	}
+	ci := v1.ContainerInfo{Name: ins.ServiceName, Reason: reason}
+	switch st.State {
+	case program.StateRunning:
+		ci.State = v1.ContainerRunning
+	case program.StateBackOff, program.StateCrashLoopBackOff:
+		ci.State = v1.ContainerWaiting
+		ins.Status = v1.Pending
+		ins.Cause = st.State + ", " + reason
+	case program.StateCompleted:
+		ci.State = v1.ContainerTerminated
+		ins.Status = v1.Succeeded
+	case program.StateFailed:
+		ci.State = v1.ContainerTerminated
+		ins.Status = v1.Failed
+		ins.Cause = st.State + ", " + reason
+	}
+	ins.Containers = []v1.ContainerInfo{ci}
+}
+
+// restartPolicy the instances of jobs are restarted according to the job config, others are always restarted
+func restartPolicy(app *v1.Application, svc *v1.Service) (string, int) {
+	if app.Workload != v1.WorkloadJob {
+		return program.RestartAlways, 0
+	}
+	jc := svc.JobConfig
+	if jc == nil {
+		jc = app.JobConfig
+	}
+	if jc != nil && jc.RestartPolicy == program.RestartOnFailure {
+		return program.RestartOnFailure, jc.BackoffLimit
+	}
+	return program.RestartNever, 0
+}
+
 func getAppStatus(infos map[string]v1.InstanceStats) v1.Status {
 	var pending = false
 	for _, info := range infos {
-		if info.Status == v1.Pending {
+		if info.Status == v1.Pending || info.Status == v1.Failed {
 			pending = true
 		} else if info.Status == v1.Unknown {
 			return info.Status
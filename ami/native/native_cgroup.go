@@ -0,0 +1,173 @@
+package native
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+const (
+	ResourcePids = "pids"
+
+	systemdUnitDir    = "/etc/systemd/system"
+	systemdCgroupConf = "baetyl-cgroup.conf"
+)
+
+// cgroupSample the cumulative cpu usage of a cgroup at the read time
+type cgroupSample struct {
+	usage time.Duration
+	read  time.Time
+}
+
+// createCgroup creates the cgroup of a service instance and returns the path the program joins,
+// the instance runs without resource limits if cgroup v2 is unavailable. With systemd the service unit
+// of the instance is put into the slice with the limits by a drop-in, and systemd manages the cgroup
+func (impl *nativeImpl) createCgroup(name string, res *v1.Resources) (string, error) {
+	if impl.cgroup.Disable {
+		return "", nil
+	}
+	limits, err := cgroupLimits(res, impl.cgroup.PidsLimit)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if impl.unitDir != "" {
+		if err = impl.writeCgroupDropIn(name, limits); err != nil {
+			impl.log.Warn("failed to write cgroup drop-in, the instance runs without resource limits", log.Any("name", name), log.Error(err))
+		}
+		return "", nil
+	}
+	cg, err := program.NewCgroup(impl.cgroup.Root, impl.cgroup.Slice, name)
+	if err != nil {
+		impl.log.Warn("failed to create cgroup, the instance runs without resource limits", log.Any("name", name), log.Error(err))
+		return "", nil
+	}
+	if err = cg.SetLimits(limits); err != nil {
+		impl.log.Warn("failed to set cgroup limits, the instance runs without resource limits", log.Any("name", name), log.Error(err))
+		if err = cg.Remove(); err != nil {
+			impl.log.Warn("failed to remove cgroup", log.Any("name", name), log.Error(err))
+		}
+		return "", nil
+	}
+	return cg.Path, nil
+}
+
+// writeCgroupDropIn writes the drop-in of the service unit, which is loaded when the unit is installed
+func (impl *nativeImpl) writeCgroupDropIn(name string, limits program.CgroupLimits) error {
+	lines := []string{
+		"[Service]",
+		"Slice=" + impl.cgroup.Slice,
+		"CPUAccounting=yes",
+		"MemoryAccounting=yes",
+		"TasksAccounting=yes",
+	}
+	if limits.CPUQuota > 0 {
+		percent := limits.CPUQuota * 100 / program.CgroupCPUPeriod
+		if percent < 1 {
+			percent = 1
+		}
+		lines = append(lines, fmt.Sprintf("CPUQuota=%d%%", percent))
+	}
+	if limits.Memory > 0 {
+		lines = append(lines, fmt.Sprintf("MemoryMax=%d", limits.Memory))
+	}
+	if limits.Pids > 0 {
+		lines = append(lines, fmt.Sprintf("TasksMax=%d", limits.Pids))
+	}
+	dir := filepath.Join(impl.unitDir, name+".service.d")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.WriteFile(filepath.Join(dir, systemdCgroupConf), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+}
+
+func (impl *nativeImpl) removeCgroup(name string) {
+	impl.mu.Lock()
+	delete(impl.usages, name)
+	impl.mu.Unlock()
+	if impl.cgroup.Disable {
+		return
+	}
+	if impl.unitDir != "" {
+		// systemd removes the cgroup once the unit stops
+		if err := os.RemoveAll(filepath.Join(impl.unitDir, name+".service.d")); err != nil {
+			impl.log.Warn("failed to remove cgroup drop-in", log.Any("name", name), log.Error(err))
+		}
+		return
+	}
+	cg := &program.Cgroup{Path: impl.cgroupPath(name)}
+	if err := cg.Remove(); err != nil {
+		impl.log.Warn("failed to remove cgroup", log.Any("name", name), log.Error(err))
+	}
+}
+
+// cgroupPath the cgroup of a service instance, the slices of systemd are nested by the dashes of their names
+func (impl *nativeImpl) cgroupPath(name string) string {
+	if impl.unitDir == "" {
+		return filepath.Join(impl.cgroup.Root, impl.cgroup.Slice, name)
+	}
+	dirs := []string{impl.cgroup.Root}
+	parts := strings.Split(strings.TrimSuffix(impl.cgroup.Slice, ".slice"), "-")
+	for i := range parts {
+		dirs = append(dirs, strings.Join(parts[:i+1], "-")+".slice")
+	}
+	return filepath.Join(append(dirs, name+".service")...)
+}
+
+// getCgroupUsage reads the usage of a service instance from its cgroup,
+// the cpu cores are computed by the usage delta against the last sample
+func (impl *nativeImpl) getCgroupUsage(name string) (map[string]string, bool) {
+	if impl.cgroup.Disable {
+		return nil, false
+	}
+	cg := &program.Cgroup{Path: impl.cgroupPath(name)}
+	st, err := cg.Stats()
+	if err != nil {
+		return nil, false
+	}
+	usage := map[string]string{
+		"memory": strconv.FormatUint(st.Memory, 10),
+	}
+	cur := cgroupSample{usage: st.CPUUsage, read: time.Now()}
+	impl.mu.Lock()
+	last, ok := impl.usages[name]
+	impl.usages[name] = cur
+	impl.mu.Unlock()
+	if ok && cur.read.After(last.read) && cur.usage >= last.usage {
+		cores := float64(cur.usage-last.usage) / float64(cur.read.Sub(last.read))
+		usage["cpu"] = strconv.FormatFloat(cores, 'f', 3, 64)
+	}
+	return usage, true
+}
+
+// cgroupLimits converts the resource limits of a service, pids is supported besides cpu and memory
+func cgroupLimits(res *v1.Resources, pidsLimit int64) (program.CgroupLimits, error) {
+	limits := program.CgroupLimits{Pids: pidsLimit}
+	if res == nil {
+		return limits, nil
+	}
+	for n, value := range res.Limits {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return limits, errors.Trace(err)
+		}
+		switch n {
+		case "cpu":
+			limits.CPUQuota = quantity.MilliValue() * program.CgroupCPUPeriod / 1000
+		case "memory":
+			limits.Memory = quantity.Value()
+		case ResourcePids:
+			limits.Pids = quantity.Value()
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,112 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	limits, err := cgroupLimits(nil, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, program.CgroupLimits{Pids: 64}, limits)
+
+	limits, err = cgroupLimits(&v1.Resources{Limits: map[string]string{"cpu": "500m", "memory": "64Mi", "pids": "100"}}, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, program.CgroupLimits{CPUQuota: 50000, Memory: 64 << 20, Pids: 100}, limits)
+
+	_, err = cgroupLimits(&v1.Resources{Limits: map[string]string{"cpu": "x"}}, 0)
+	assert.Error(t, err)
+}
+
+func TestCreateCgroup(t *testing.T) {
+	impl := &nativeImpl{
+		cgroup: config.CgroupConfig{Root: t.TempDir(), Slice: "baetyl.slice"},
+		usages: map[string]cgroupSample{},
+		log:    log.With(log.Any("ami", "native")),
+	}
+	// no cgroup v2
+	p, err := impl.createCgroup("ins", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, p)
+	_, ok := impl.getCgroupUsage("ins")
+	assert.False(t, ok)
+
+	for _, dir := range []string{impl.cgroup.Root, filepath.Join(impl.cgroup.Root, "baetyl.slice")} {
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, program.CgroupControllers), []byte("cpu memory pids"), 0644))
+	}
+	_, err = impl.createCgroup("ins", &v1.Resources{Limits: map[string]string{"memory": "x"}})
+	assert.Error(t, err)
+	p, err = impl.createCgroup("ins", &v1.Resources{Limits: map[string]string{"memory": "1Ki"}})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(impl.cgroup.Root, "baetyl.slice", "ins"), p)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupCPUStat), []byte("usage_usec 1000\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupMemoryCur), []byte("512\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupPidsCur), []byte("1\n"), 0644))
+	usage, ok := impl.getCgroupUsage("ins")
+	assert.True(t, ok)
+	assert.Equal(t, "512", usage["memory"])
+	// the cpu usage needs two samples
+	assert.NotContains(t, usage, "cpu")
+	usage, ok = impl.getCgroupUsage("ins")
+	assert.True(t, ok)
+	assert.Contains(t, usage, "cpu")
+
+	impl.cgroup.Disable = true
+	p, err = impl.createCgroup("ins2", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, p)
+	impl.removeCgroup("ins")
+	assert.NotContains(t, impl.usages, "ins")
+}
+
+func TestCreateCgroupSystemd(t *testing.T) {
+	root := t.TempDir()
+	impl := &nativeImpl{
+		cgroup:  config.CgroupConfig{Root: root, Slice: "baetyl-apps.slice", PidsLimit: 64},
+		unitDir: t.TempDir(),
+		usages:  map[string]cgroupSample{},
+		log:     log.With(log.Any("ami", "native")),
+	}
+	// systemd creates the cgroup, nothing is written under the root
+	p, err := impl.createCgroup("ins", &v1.Resources{Limits: map[string]string{"cpu": "500m", "memory": "1Ki"}})
+	assert.NoError(t, err)
+	assert.Empty(t, p)
+	entries, err := os.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	dropIn := filepath.Join(impl.unitDir, "ins.service.d", systemdCgroupConf)
+	data, err := os.ReadFile(dropIn)
+	assert.NoError(t, err)
+	assert.Equal(t, "[Service]\nSlice=baetyl-apps.slice\nCPUAccounting=yes\nMemoryAccounting=yes\nTasksAccounting=yes\nCPUQuota=50%\nMemoryMax=1024\nTasksMax=64\n", string(data))
+
+	p = filepath.Join(root, "baetyl.slice", "baetyl-apps.slice", "ins.service")
+	assert.Equal(t, p, impl.cgroupPath("ins"))
+	assert.NoError(t, os.MkdirAll(p, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupCPUStat), []byte("usage_usec 1000\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupMemoryCur), []byte("512\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(p, program.CgroupPidsCur), []byte("1\n"), 0644))
+	usage, ok := impl.getCgroupUsage("ins")
+	assert.True(t, ok)
+	assert.Equal(t, "512", usage["memory"])
+
+	impl.removeCgroup("ins")
+	assert.NoFileExists(t, dropIn)
+	assert.DirExists(t, p)
+
+	// the instance is installed without the limits if the drop-in fails to write
+	impl.unitDir = filepath.Join(root, "unit")
+	assert.NoError(t, os.WriteFile(impl.unitDir, nil, 0644))
+	p, err = impl.createCgroup("ins", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, p)
+}
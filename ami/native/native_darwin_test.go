@@ -1,6 +1,7 @@
 package native
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -68,7 +69,7 @@ func TestAmiNativeImpl(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			impl, err := newNativeImpl(config.AmiConfig{}, nil)
 			assert.NoError(t, err)
-			err = impl.ApplyApp(tt.args.ns, tt.args.app, tt.args.configs, tt.args.secrets)
+			err = impl.ApplyApp(context.Background(), tt.args.ns, tt.args.app, tt.args.configs, tt.args.secrets)
 			assert.NoError(t, err)
 
 			stats, err := impl.StatsApps(tt.args.ns)
@@ -0,0 +1,103 @@
+package native
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/creack/pty"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+var defaultCommand = []string{"sh"}
+
+// localCommand runs the command on a local pseudo-terminal in the dir and with the env of the service instance
+func (impl *nativeImpl) localCommand(option *ami.DebugOptions, pipe ami.Pipe) error {
+	// the output of the terminal is read until the command exits
+	if pipe.OutWriter == nil {
+		return errors.New("the pipe has no output writer")
+	}
+	insDir, err := impl.findInstanceDir(option.Namespace, option.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var prgCfg program.Config
+	if err = utils.LoadYAML(filepath.Join(insDir, program.ProgramServiceYaml), &prgCfg); err != nil {
+		return errors.Trace(err)
+	}
+	command := option.Command
+	if len(command) == 0 {
+		command = defaultCommand
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = insDir
+	cmd.Env = append(append(os.Environ(), "TERM="+Term), prgCfg.Env...)
+	tty, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: Rows, Cols: Cols})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer tty.Close()
+
+	ctx := pipe.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	// the terminal is closed after the resizing stops
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case size := <-pipe.Resize:
+				if err := pty.Setsize(tty, &pty.Winsize{Rows: size.Rows, Cols: size.Cols}); err != nil {
+					impl.log.Warn("failed to resize terminal", log.Error(err))
+				}
+			case <-ctx.Done():
+				// the command is killed if the debug connection is closed
+				cmd.Process.Kill()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	if pipe.InReader != nil {
+		go io.Copy(tty, pipe.InReader)
+	}
+	// the read fails once the command exits and the terminal is closed
+	io.Copy(pipe.OutWriter, tty)
+	if err = cmd.Wait(); err != nil {
+		impl.log.Warn("local session log out with exception", log.Error(err))
+	}
+	return nil
+}
+
+// findInstanceDir finds the dir of the service instance by its name
+func (impl *nativeImpl) findInstanceDir(ns, name string) (string, error) {
+	insDirs, err := filepath.Glob(filepath.Join(impl.runHostPath, ns, "*", "*", "*", "*"))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	for _, insDir := range insDirs {
+		svcDir, ins := filepath.Split(insDir)
+		appVerDir, svc := filepath.Split(filepath.Clean(svcDir))
+		appDir, ver := filepath.Split(filepath.Clean(appVerDir))
+		app := filepath.Base(appDir)
+		if genServiceInstanceName(ns, app, ver, svc, ins) == name {
+			return insDir, nil
+		}
+	}
+	return "", errors.Errorf("service instance (%s) not found", name)
+}
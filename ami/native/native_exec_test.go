@@ -0,0 +1,64 @@
+//go:build !windows
+
+package native
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+func TestLocalCommand(t *testing.T) {
+	impl := &nativeImpl{runHostPath: t.TempDir(), log: log.With(log.Any("ami", "native"))}
+	insDir := filepath.Join(impl.runHostPath, "baetyl-edge", "app", "v1", "svc", "1")
+	assert.NoError(t, os.MkdirAll(insDir, 0755))
+	data, err := yaml.Marshal(program.Config{Dir: insDir, Env: []string{"EXEC_TEST=hello"}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(insDir, program.ProgramServiceYaml), data, 0644))
+
+	dir, err := impl.findInstanceDir("baetyl-edge", genServiceInstanceName("baetyl-edge", "app", "v1", "svc", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, insDir, dir)
+	_, err = impl.findInstanceDir("baetyl-edge", "baetyl-edge.app.v1.svc.2")
+	assert.Error(t, err)
+
+	pipe := ami.Pipe{Ctx: context.Background(), Resize: make(chan ami.TerminalSize, 1)}
+	pipe.InReader, pipe.InWriter = io.Pipe()
+	pipe.OutReader, pipe.OutWriter = io.Pipe()
+	pipe.Resize <- ami.TerminalSize{Rows: 30, Cols: 100}
+	option := &ami.DebugOptions{}
+	option.Namespace = "baetyl-edge"
+	option.Name = "baetyl-edge.app.v1.svc.1"
+	option.Local = true
+	option.Command = []string{"sh", "-c", "sleep 0.2; echo $EXEC_TEST; pwd; stty size"}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- impl.RemoteCommand(option, pipe)
+		pipe.OutWriter.Close()
+	}()
+	out, err := io.ReadAll(pipe.OutReader)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "hello")
+	assert.Contains(t, string(out), insDir)
+	assert.Contains(t, string(out), "30 100")
+	select {
+	case err = <-errs:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "local command is not finished")
+	}
+
+	// the command is not run without the output writer
+	assert.EqualError(t, impl.RemoteCommand(option, ami.Pipe{}), "the pipe has no output writer")
+}
@@ -2,12 +2,13 @@ package native
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"io"
 	"os"
-	"os/exec"
-	"runtime"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,141 +17,339 @@ import (
 	"github.com/baetyl/baetyl/v2/ami"
 )
 
-func (impl *nativeImpl) FetchLog(_, pod, _ string, tailLines, _ int64) ([]byte, error) {
-	logPath := impl.logHostPath
-	pathArr := strings.Split(pod, ".")
-	if len(pathArr) != 5 {
-		return nil, errors.Trace(errors.New("log path error" + pod))
-	}
-	logPath = logPath + "/" + pathArr[0] + "/" + pathArr[1] + "/" + pathArr[2] + "/" + pathArr[3] + "-" + pathArr[4] + ".log"
-	tailLines = 200
-	if tailLines != 0 {
-		tailLines = tailLines
-	}
-	// windows系统获取log 通过读取文件
-	if runtime.GOOS == "windows" {
-		return fetchLogForWindows(logPath, tailLines)
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "tail", "-n", strconv.FormatInt(tailLines, 10), logPath)
-	return cmd.Output()
+const (
+	defaultTailLines = 200
+	logPollInterval  = 500 * time.Millisecond
+	// backupTimeFormat the time format in the names of the backups rotated by lumberjack
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+// the time formats of the line prefixes to filter lines by the since time
+var lineTimeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05"}
+
+// logFile a log file of a service instance, which is the current one or a backup rotated by lumberjack
+type logFile struct {
+	path string
+	// the rotation time of the backup, zero for the current file
+	time time.Time
 }
 
-func fetchLogForWindows(logPath string, tailLines int64) ([]byte, error) {
-	file, err := os.Open(logPath)
+func (impl *nativeImpl) FetchLog(_, pod, _ string, tailLines, sinceSeconds int64) ([]byte, error) {
+	logPath, err := impl.logPath(pod)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer file.Close()
-	if err = seekLastN(file, tailLines); err != nil {
+	var buf bytes.Buffer
+	f, err := tailLog(logPath, tailLines, sinceOf(sinceSeconds), &buf)
+	if f != nil {
+		f.Close()
+	}
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	reader := bufio.NewReader(file)
-	bytes := make([]byte, 10240)
-	_, err = reader.Read(bytes)
-	return bytes, err
+	return buf.Bytes(), nil
 }
 
-// RemoteLogs use command tail -f
+// RemoteLogs writes the tail of the log, and follows the log across rotation if required
 func (impl *nativeImpl) RemoteLogs(option *ami.LogsOptions, pipe ami.Pipe) error {
-	logPath := impl.logHostPath
-	pathArr := strings.Split(option.Name, ".")
-	if len(pathArr) != 5 {
-		return errors.Trace(errors.New("log path error"))
+	logPath, err := impl.logPath(option.Name)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	logPath = logPath + "/" + pathArr[0] + "/" + pathArr[1] + "/" + pathArr[2] + "/" + pathArr[3] + "-" + pathArr[4] + ".log"
-	tailLines := int64(200)
+	var tailLines, sinceSeconds int64
 	if option.TailLines != nil {
 		tailLines = *option.TailLines
 	}
-	// windows系统获取log 通过读取文件
-	if runtime.GOOS == "windows" {
-		return getLogForWindows(logPath, tailLines, pipe)
+	if option.SinceSeconds != nil {
+		sinceSeconds = *option.SinceSeconds
 	}
-	cmd := exec.CommandContext(pipe.Ctx, "tail", "-n", strconv.FormatInt(tailLines, 10), "-f", logPath)
-	stdoutPipe, err := cmd.StdoutPipe()
+	f, err := tailLog(logPath, tailLines, sinceOf(sinceSeconds), pipe.OutWriter)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	defer stdoutPipe.Close()
-	err = cmd.Start()
-	if err != nil {
-		return errors.Trace(err)
+	if !option.Follow {
+		if f != nil {
+			f.Close()
+		}
+		return nil
+	}
+	ctx := pipe.Ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	_, err = io.Copy(pipe.OutWriter, stdoutPipe)
+	return errors.Trace(followLog(ctx, logPath, f, pipe.OutWriter))
+}
+
+// logPath returns the log file of the instance named {ns}.{app}.{version}.{service}.{index}
+func (impl *nativeImpl) logPath(name string) (string, error) {
+	pathArr := strings.Split(name, ".")
+	if len(pathArr) != 5 {
+		return "", errors.New("log path error " + name)
+	}
+	return filepath.Join(impl.logHostPath, pathArr[0], pathArr[1], pathArr[2], pathArr[3]+"-"+pathArr[4]+".log"), nil
+}
+
+func sinceOf(sinceSeconds int64) time.Time {
+	if sinceSeconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(sinceSeconds) * time.Second)
+}
+
+// tailLog writes the last lines since the time from the backups and the current file,
+// and returns the current file read to the end for following
+func tailLog(logPath string, tailLines int64, since time.Time, w io.Writer) (*os.File, error) {
+	if tailLines <= 0 {
+		tailLines = defaultTailLines
+	}
+	files, err := logFiles(logPath)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	var cur *os.File
+	var chunks [][][]byte
+	remain := int(tailLines)
+	// the newest file is read first, until enough lines are read
+	for i := len(files) - 1; i >= 0 && remain > 0; i-- {
+		if !files[i].time.IsZero() && files[i].time.Before(since) {
+			break
+		}
+		lines, f, err := readLastLines(files[i].path, remain, since)
+		if err != nil {
+			if cur != nil {
+				cur.Close()
+			}
+			return nil, errors.Trace(err)
+		}
+		if files[i].time.IsZero() {
+			cur = f
+		}
+		chunks = append(chunks, lines)
+		remain -= len(lines)
+	}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		for _, line := range chunks[i] {
+			if _, err = w.Write(line); err != nil {
+				if cur != nil {
+					cur.Close()
+				}
+				return nil, errors.Trace(err)
+			}
+		}
 	}
-	err = cmd.Wait()
+	return cur, nil
+}
+
+// logFiles lists the backups rotated by lumberjack from the oldest, and the current file at last if it exists
+func logFiles(logPath string) ([]logFile, error) {
+	dir, base := filepath.Split(logPath)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		if err.Error() == CmdKillErr {
-			return nil
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	return nil
+	var files []logFile
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), compressSuffix)
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, name[len(prefix):len(name)-len(ext)], time.UTC)
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{path: filepath.Join(dir, entry.Name()), time: t})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].time.Before(files[j].time)
+	})
+	if _, err = os.Stat(logPath); err == nil {
+		files = append(files, logFile{path: logPath})
+	}
+	return files, nil
 }
 
-func getLogForWindows(logPath string, tailLines int64, pipe ami.Pipe) error {
-	file, err := os.Open(logPath)
+// readLastLines reads at most n last lines since the time, the file is returned open at the end
+// unless it is a compressed backup
+func readLastLines(path string, n int, since time.Time) ([][]byte, *os.File, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, nil, errors.Trace(err)
 	}
-	defer file.Close()
-	if err = seekLastN(file, tailLines); err != nil {
-		return errors.Trace(err)
+	var r io.Reader = f
+	if strings.HasSuffix(path, compressSuffix) {
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		defer gr.Close()
+		r, f = gr, nil
 	}
-	reader := bufio.NewReader(file)
+	ring := make([][]byte, 0, n)
+	next := 0
+	var offset int64
+	filter := newSinceFilter(since)
+	reader := bufio.NewReader(r)
 	for {
-		select {
-		case <-pipe.Ctx.Done():
-			return nil
-		default:
-			data, _, err := reader.ReadLine()
-			if err != nil {
-				time.Sleep(time.Second)
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && f != nil && len(line) > 0 {
+			// the partial last line is read again by following once it is complete
+			if _, err = f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, nil, errors.Trace(err)
+			}
+			break
+		}
+		offset += int64(len(line))
+		if len(line) > 0 && filter.accept(line) {
+			if len(ring) < n {
+				ring = append(ring, line)
+			} else {
+				ring[next] = line
+				next = (next + 1) % n
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if f != nil {
+				f.Close()
+			}
+			return nil, nil, errors.Trace(err)
+		}
+	}
+	return append(ring[next:], ring[:next]...), f, nil
+}
+
+// followLog writes the new lines of the log until the context is done,
+// the rotated file is read to the end before the new file is opened
+func followLog(ctx context.Context, logPath string, f *os.File, w io.Writer) error {
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	var reader *bufio.Reader
+	if f != nil {
+		reader = bufio.NewReader(f)
+	}
+	var pending []byte
+	for ctx.Err() == nil {
+		if reader != nil {
+			line, err := reader.ReadBytes('\n')
+			pending = append(pending, line...)
+			if err == nil {
+				if _, err = w.Write(pending); err != nil {
+					return errors.Trace(err)
+				}
+				pending = nil
 				continue
 			}
-			data = append(data, '\n')
-			_, err = pipe.OutWriter.Write(data)
-			if err != nil {
+			if err != io.EOF {
 				return errors.Trace(err)
 			}
 		}
+		next, err := reopenLog(logPath, f)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if next != f {
+			if f != nil {
+				// the lines written before the rotation are not lost
+				rest, err := io.ReadAll(reader)
+				if err == nil {
+					_, err = w.Write(append(pending, rest...))
+				}
+				f.Close()
+				if err != nil {
+					f = next
+					return errors.Trace(err)
+				}
+			}
+			f, reader, pending = next, bufio.NewReader(next), nil
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logPollInterval):
+		}
 	}
+	return nil
 }
 
-func seekLastN(file *os.File, tailLines int64) error {
-	charBuff := make([]byte, 1)
-	cursor := int64(0)
-	cnt := int64(0)
-	// 逆向遍历字节,寻找倒数第n行数据
-	fileInfo, err := file.Stat()
+// reopenLog returns the new file if the log is rotated or truncated, otherwise the same file
+func reopenLog(logPath string, f *os.File) (*os.File, error) {
+	st, err := os.Stat(logPath)
 	if err != nil {
-		return errors.Trace(err)
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return nil, errors.Trace(err)
 	}
-	size := fileInfo.Size()
-	for {
-		cursor += 1
-		_, err = file.Seek(size-cursor, io.SeekStart)
+	if f != nil {
+		fst, err := f.Stat()
 		if err != nil {
-			_, err = file.Seek(0, 0)
+			return nil, errors.Trace(err)
+		}
+		if os.SameFile(st, fst) {
+			off, err := f.Seek(0, io.SeekCurrent)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
+			}
+			if st.Size() >= off {
+				return f, nil
 			}
-			break
 		}
-		_, err = file.Read(charBuff)
-		if err != nil {
-			return errors.Trace(err)
+	}
+	next, err := os.Open(logPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return next, nil
+}
+
+// sinceFilter accepts the lines since the time, the lines without time follow the previous line.
+// The program writes the output of services as it is, so the lines of a service which prints no time
+// are all accepted, and only the rotated files older than the time are skipped
+type sinceFilter struct {
+	since time.Time
+	last  bool
+}
+
+func newSinceFilter(since time.Time) *sinceFilter {
+	return &sinceFilter{since: since, last: true}
+}
+
+func (s *sinceFilter) accept(line []byte) bool {
+	if s.since.IsZero() {
+		return true
+	}
+	if t, ok := lineTime(line); ok {
+		s.last = !t.Before(s.since)
+	}
+	return s.last
+}
+
+func lineTime(line []byte) (time.Time, bool) {
+	for _, format := range lineTimeFormats {
+		n := len(format)
+		if format == time.RFC3339Nano {
+			n = bytes.IndexAny(line, " \t")
 		}
-		if charBuff[0] == '\n' {
-			cnt++
+		if n <= 0 || n > len(line) {
+			continue
 		}
-		if cnt >= tailLines+1 {
-			break
+		if t, err := time.ParseInLocation(format, string(line[:n]), time.Local); err == nil {
+			return t, true
 		}
 	}
-	return nil
+	return time.Time{}, false
 }
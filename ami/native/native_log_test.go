@@ -0,0 +1,123 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+func writeGzip(t *testing.T, path, content string) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestFetchLog(t *testing.T) {
+	impl := &nativeImpl{logHostPath: t.TempDir()}
+	dir := filepath.Join(impl.logHostPath, "baetyl-edge", "app", "v1")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	writeGzip(t, filepath.Join(dir, "svc-1-2023-01-01T00-00-00.000.log.gz"), "1\n2\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "svc-1-2023-01-02T00-00-00.000.log"), []byte("3\n4\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "svc-1.log"), []byte("5\n6\npartial"), 0644))
+	// the logs of other instances
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "svc-1-1.log"), []byte("x\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "svc-1-1-2023-01-03T00-00-00.000.log"), []byte("y\n"), 0644))
+
+	data, err := impl.FetchLog("", "baetyl-edge.app.v1.svc.1", "", 3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "4\n5\n6\n", string(data))
+	data, err = impl.FetchLog("", "baetyl-edge.app.v1.svc.1", "", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n5\n6\n", string(data))
+	// the backups rotated before the since time are skipped
+	data, err = impl.FetchLog("", "baetyl-edge.app.v1.svc.1", "", 0, 60)
+	assert.NoError(t, err)
+	assert.Equal(t, "5\n6\n", string(data))
+
+	_, err = impl.FetchLog("", "baetyl-edge.app", "", 0, 0)
+	assert.Error(t, err)
+	data, err = impl.FetchLog("", "baetyl-edge.app.v1.svc.2", "", 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestSinceFilter(t *testing.T) {
+	since := time.Date(2023, 1, 1, 12, 0, 0, 0, time.Local)
+	lines := []string{
+		"2023-01-01 11:59:59 old\n",
+		"  trace of old\n",
+		since.Format(time.RFC3339Nano) + " new\n",
+		"  trace of new\n",
+		"2023-01-01 12:00:01 newer\n",
+	}
+	filter := newSinceFilter(since)
+	var res []string
+	for _, line := range lines {
+		if filter.accept([]byte(line)) {
+			res = append(res, line)
+		}
+	}
+	assert.Equal(t, lines[2:], res)
+	assert.True(t, newSinceFilter(time.Time{}).accept([]byte("2000-01-01 00:00:00 old\n")))
+}
+
+func TestRemoteLogsFollow(t *testing.T) {
+	impl := &nativeImpl{logHostPath: t.TempDir()}
+	dir := filepath.Join(impl.logHostPath, "baetyl-edge", "app", "v1")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	logPath := filepath.Join(dir, "svc-1.log")
+	assert.NoError(t, os.WriteFile(logPath, []byte("1\n2"), 0644))
+
+	pipe := ami.Pipe{}
+	pipe.OutReader, pipe.OutWriter = io.Pipe()
+	pipe.Ctx, pipe.Cancel = context.WithCancel(context.Background())
+	tail := int64(10)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- impl.RemoteLogs(&ami.LogsOptions{Name: "baetyl-edge.app.v1.svc.1", TailLines: &tail, Follow: true}, pipe)
+	}()
+	reader := bufio.NewReader(pipe.OutReader)
+	readLine := func() string {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		return line
+	}
+	assert.Equal(t, "1\n", readLine())
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("3\n4\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "23\n", readLine())
+	assert.Equal(t, "4\n", readLine())
+
+	// rotate as lumberjack, the lines written to the old file before rotation are not lost
+	_, err = f.WriteString("5\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, os.Rename(logPath, filepath.Join(dir, "svc-1-2023-01-01T00-00-00.000.log")))
+	assert.NoError(t, os.WriteFile(logPath, []byte("6\n"), 0644))
+	assert.Equal(t, "5\n", readLine())
+	assert.Equal(t, "6\n", readLine())
+
+	pipe.Cancel()
+	select {
+	case err = <-errs:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "remote logs is not stopped")
+	}
+}
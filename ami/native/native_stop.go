@@ -0,0 +1,78 @@
+package native
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/kardianos/service"
+
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+// the app labels to stop service instances gracefully, the labels suffixed with .{service} apply to the service only
+const (
+	LabelStopSignal  = "baetyl-stop-signal"
+	LabelStopTimeout = "baetyl-stop-timeout"
+)
+
+const (
+	defaultStopTimeout = 10 * time.Second
+	// stopMargin the time for the program runner to exit after its program is killed
+	stopMargin       = 2 * time.Second
+	stopPollInterval = 100 * time.Millisecond
+)
+
+// stopConfig returns the stop signal and timeout of a service, the defaults of the program runner apply if they are empty
+func stopConfig(app *v1.Application, svcName string) (string, time.Duration, error) {
+	sig := labelOf(app, LabelStopSignal, svcName)
+	if sig != "" {
+		if _, err := program.ParseSignal(sig); err != nil {
+			return "", 0, errors.Trace(err)
+		}
+		sig = strings.ToUpper(sig)
+	}
+	var timeout time.Duration
+	if v := labelOf(app, LabelStopTimeout, svcName); v != "" {
+		var err error
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return "", 0, errors.Trace(err)
+		}
+	}
+	return sig, timeout, nil
+}
+
+func labelOf(app *v1.Application, key, svcName string) string {
+	if v, ok := app.Labels[key+"."+svcName]; ok {
+		return v
+	}
+	return app.Labels[key]
+}
+
+// stopTimeoutOf reads the stop timeout from the program config of a service instance
+func stopTimeoutOf(insDir string) time.Duration {
+	var prgCfg program.Config
+	err := utils.LoadYAML(filepath.Join(insDir, program.ProgramServiceYaml), &prgCfg)
+	if err != nil || prgCfg.StopTimeout <= 0 {
+		return defaultStopTimeout
+	}
+	return prgCfg.StopTimeout
+}
+
+// waitStopped waits for the program runner to stop its program gracefully, some service systems stop services asynchronously
+func (impl *nativeImpl) waitStopped(svc service.Service, timeout time.Duration) {
+	deadline := time.Now().Add(timeout + stopMargin)
+	for time.Now().Before(deadline) {
+		status, err := svc.Status()
+		if err != nil || status != service.StatusRunning {
+			return
+		}
+		time.Sleep(stopPollInterval)
+	}
+	impl.log.Warn("service is not stopped in time", log.Any("service", svc.String()), log.Any("timeout", timeout))
+}
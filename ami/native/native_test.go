@@ -0,0 +1,78 @@
+package native
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+func TestSetProgramStatus(t *testing.T) {
+	ins := v1.InstanceStats{ServiceName: "svc", Status: v1.Running}
+	setProgramStatus(&ins, &program.Status{State: program.StateRunning, RestartCount: 2, ExitCode: 1, ExitTime: time.Now()})
+	assert.Equal(t, v1.Running, ins.Status)
+	assert.Equal(t, []v1.ContainerInfo{{Name: "svc", State: v1.ContainerRunning, Reason: "restart count 2, last exit code 1"}}, ins.Containers)
+
+	setProgramStatus(&ins, &program.Status{State: program.StateCrashLoopBackOff, RestartCount: 5, ExitCode: 2, ExitTime: time.Now()})
+	assert.Equal(t, v1.Pending, ins.Status)
+	assert.Equal(t, "CrashLoopBackOff, restart count 5, last exit code 2", ins.Cause)
+	assert.Equal(t, v1.Pending, getAppStatus(map[string]v1.InstanceStats{"ins": ins}))
+
+	setProgramStatus(&ins, &program.Status{State: program.StateCompleted, ExitTime: time.Now()})
+	assert.Equal(t, v1.Succeeded, ins.Status)
+	assert.Equal(t, v1.ContainerTerminated, ins.Containers[0].State)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	app := &v1.Application{}
+	svc := &v1.Service{}
+	policy, retries := restartPolicy(app, svc)
+	assert.Equal(t, program.RestartAlways, policy)
+	assert.Equal(t, 0, retries)
+
+	app.Workload = v1.WorkloadJob
+	policy, _ = restartPolicy(app, svc)
+	assert.Equal(t, program.RestartNever, policy)
+
+	app.JobConfig = &v1.AppJobConfig{RestartPolicy: "OnFailure", BackoffLimit: 6}
+	policy, retries = restartPolicy(app, svc)
+	assert.Equal(t, program.RestartOnFailure, policy)
+	assert.Equal(t, 6, retries)
+
+	svc.JobConfig = &v1.ServiceJobConfig{RestartPolicy: "Never"}
+	policy, _ = restartPolicy(app, svc)
+	assert.Equal(t, program.RestartNever, policy)
+}
+
+func TestStopConfig(t *testing.T) {
+	app := &v1.Application{}
+	sig, timeout, err := stopConfig(app, "svc")
+	assert.NoError(t, err)
+	assert.Empty(t, sig)
+	assert.Zero(t, timeout)
+
+	app.Labels = map[string]string{
+		LabelStopSignal:           "sigint",
+		LabelStopTimeout:          "30s",
+		LabelStopTimeout + ".svc": "1m",
+	}
+	sig, timeout, err = stopConfig(app, "svc")
+	assert.NoError(t, err)
+	assert.Equal(t, "SIGINT", sig)
+	assert.Equal(t, time.Minute, timeout)
+	_, timeout, err = stopConfig(app, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	app.Labels[LabelStopTimeout] = "x"
+	_, _, err = stopConfig(app, "other")
+	assert.Error(t, err)
+	app.Labels[LabelStopSignal] = "SIGXXX"
+	_, _, err = stopConfig(app, "svc")
+	assert.Error(t, err)
+
+	assert.Equal(t, defaultStopTimeout, stopTimeoutOf(t.TempDir()))
+}
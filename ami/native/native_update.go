@@ -0,0 +1,215 @@
+package native
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v2context "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/kardianos/service"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/ami/native/prober"
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+const readyCheckInterval = time.Second
+
+// listAppVersions returns the installed versions of the app, the latest installed one is the last
+func (impl *nativeImpl) listAppVersions(ns, appName string) ([]string, error) {
+	appVerFiles, err := os.ReadDir(filepath.Join(impl.runHostPath, ns, appName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	var vers []string
+	modTimes := map[string]time.Time{}
+	for _, appVerFile := range appVerFiles {
+		if !appVerFile.IsDir() {
+			continue
+		}
+		fi, err := appVerFile.Info()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		vers = append(vers, appVerFile.Name())
+		modTimes[appVerFile.Name()] = fi.ModTime()
+	}
+	sort.SliceStable(vers, func(i, j int) bool {
+		return modTimes[vers[i]].Before(modTimes[vers[j]])
+	})
+	return vers, nil
+}
+
+// waitAppReady waits until all instances of the app keep running and passing probes for a while
+func (impl *nativeImpl) waitAppReady(ctx context.Context, ns string, app *v1.Application) error {
+	timeout := impl.update.Timeout
+	for _, s := range app.Services {
+		timeout += probeDuration(s.StartupProbe) + probeDuration(s.LivenessProbe) + probeDuration(s.ReadinessProbe)
+	}
+	return waitReady(ctx, timeout, impl.update.MinReady, readyCheckInterval, func() error {
+		return impl.checkAppReady(ns, app)
+	})
+}
+
+func (impl *nativeImpl) checkAppReady(ns string, app *v1.Application) error {
+	appVerDir := filepath.Join(impl.runHostPath, ns, app.Name, app.Version)
+	for _, s := range app.Services {
+		for i := 1; i <= s.Replica; i++ {
+			svc, err := service.New(nil, &service.Config{
+				Name: genServiceInstanceName(ns, app.Name, app.Version, s.Name, strconv.Itoa(i)),
+			})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			status, err := svc.Status()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if status != service.StatusRunning {
+				return errors.Errorf("service (%s) instance (%d) is not running", s.Name, i)
+			}
+			ins := prober.Instance{Service: s.Name, ID: strconv.Itoa(i), Dir: filepath.Join(appVerDir, s.Name, strconv.Itoa(i))}
+			if err = impl.probeManager.Probe(app, ins); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitReady returns once the check keeps passing for minReady, or the last check error after timeout or the context is done
+func waitReady(ctx context.Context, timeout, minReady, interval time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	var readySince time.Time
+	var lastErr error
+	for {
+		if err := check(); err != nil {
+			readySince = time.Time{}
+			lastErr = err
+		} else {
+			if readySince.IsZero() {
+				readySince = time.Now()
+			}
+			if time.Since(readySince) >= minReady {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if lastErr == nil {
+				lastErr = errors.Errorf("app is not ready in %s", timeout)
+			}
+			return lastErr
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+}
+
+// probeDuration the time the probe takes at most to fail
+func probeDuration(p *corev1.Probe) time.Duration {
+	if p == nil {
+		return 0
+	}
+	return time.Duration(p.InitialDelaySeconds+p.PeriodSeconds*p.FailureThreshold) * time.Second
+}
+
+// rollbackApp removes the failed version and hands the service ports back to the previous version which is still running.
+// The failed version of the first install is left as it is, which is not applied again by the engine until a new version is desired
+func (impl *nativeImpl) rollbackApp(ns string, app *v1.Application, prevs []string, cause error) error {
+	if len(prevs) == 0 {
+		impl.log.Warn("app failed without a version to roll back to", log.Any("app", app.Name), log.Any("version", app.Version), log.Error(cause))
+		return &ami.RollbackError{
+			App:      v1.AppInfo{Name: app.Name, Version: app.Version},
+			Previous: v1.AppInfo{Name: app.Name},
+			Err:      cause,
+		}
+	}
+	if err := impl.deleteAppVersion(ns, app.Name, app.Version, true); err != nil {
+		impl.log.Warn("failed to delete new app", log.Error(err))
+	}
+	prev := prevs[len(prevs)-1]
+	ports, err := loadServicePorts(filepath.Join(impl.runHostPath, ns, app.Name, prev))
+	if err != nil {
+		impl.log.Warn("failed to load service ports of previous app", log.Any("version", prev), log.Error(err))
+	}
+	for svcName, ps := range ports {
+		key := servicePortsKey(app, svcName)
+		if err = impl.mapping.SetServicePorts(key, ps); err != nil {
+			impl.log.Warn("failed to restore service ports in mapping files", log.Any("applied service", key), log.Error(err))
+		}
+	}
+	impl.log.Warn("roll back app", log.Any("app", app.Name), log.Any("version", app.Version), log.Any("previous", prev), log.Error(cause))
+	return &ami.RollbackError{
+		App:      v1.AppInfo{Name: app.Name, Version: app.Version},
+		Previous: v1.AppInfo{Name: app.Name, Version: prev},
+		Err:      cause,
+	}
+}
+
+// loadServicePorts reads the dynamic ports of service instances from the program configs of an app version
+func loadServicePorts(appVerDir string) (map[string][]int, error) {
+	svcFiles, err := os.ReadDir(appVerDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := map[string][]int{}
+	prefix := v2context.KeyServiceDynamicPort + "="
+	for _, svcFile := range svcFiles {
+		if !svcFile.IsDir() {
+			continue
+		}
+		svcDir := filepath.Join(appVerDir, svcFile.Name())
+		svcInsFiles, err := os.ReadDir(svcDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var ids []int
+		for _, svcInsFile := range svcInsFiles {
+			if id, err := strconv.Atoi(svcInsFile.Name()); err == nil && svcInsFile.IsDir() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			var prgCfg program.Config
+			err = utils.LoadYAML(filepath.Join(svcDir, strconv.Itoa(id), program.ProgramServiceYaml), &prgCfg)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			for _, env := range prgCfg.Env {
+				if !strings.HasPrefix(env, prefix) {
+					continue
+				}
+				port, err := strconv.Atoi(strings.TrimPrefix(env, prefix))
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				res[svcFile.Name()] = append(res[svcFile.Name()], port)
+			}
+		}
+	}
+	return res, nil
+}
+
+// servicePortsKey native functions use the app name as the key in mapping files
+func servicePortsKey(app *v1.Application, svcName string) string {
+	if app.Type == v1.AppTypeContainer {
+		return svcName
+	}
+	return app.Name
+}
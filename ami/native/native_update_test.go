@@ -0,0 +1,117 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v2context "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/program"
+)
+
+func TestWaitReady(t *testing.T) {
+	var count int
+	err := waitReady(context.Background(), time.Second, 20*time.Millisecond, 10*time.Millisecond, func() error {
+		count++
+		if count < 3 {
+			return errors.New("not running")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// the instance is checked again until it keeps ready for the min ready time
+	assert.True(t, count > 3)
+
+	err = waitReady(context.Background(), 50*time.Millisecond, 0, 10*time.Millisecond, func() error {
+		return errors.New("not running")
+	})
+	assert.EqualError(t, err, "not running")
+
+	// flapping instances are not ready
+	count = 0
+	err = waitReady(context.Background(), 100*time.Millisecond, 30*time.Millisecond, 10*time.Millisecond, func() error {
+		count++
+		if count%2 == 0 {
+			return errors.New("not running")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "not running")
+
+	// the wait stops once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = waitReady(ctx, time.Second, 0, 10*time.Millisecond, func() error {
+		return errors.New("not running")
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLoadServicePorts(t *testing.T) {
+	dir := t.TempDir()
+	for i, port := range []string{"50200", "50201"} {
+		insDir := filepath.Join(dir, "svc", []string{"1", "2"}[i])
+		assert.NoError(t, os.MkdirAll(insDir, 0755))
+		data, err := yaml.Marshal(program.Config{
+			Name: "ins",
+			Env:  []string{"PATH=/bin", v2context.KeyServiceDynamicPort + "=" + port},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(insDir, program.ProgramServiceYaml), data, 0755))
+	}
+	ports, err := loadServicePorts(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]int{"svc": {50200, 50201}}, ports)
+
+	_, err = loadServicePorts(filepath.Join(dir, "none"))
+	assert.Error(t, err)
+}
+
+func TestListAppVersions(t *testing.T) {
+	impl := &nativeImpl{runHostPath: t.TempDir()}
+	vers, err := impl.listAppVersions("baetyl-edge", "app")
+	assert.NoError(t, err)
+	assert.Len(t, vers, 0)
+
+	appDir := filepath.Join(impl.runHostPath, "baetyl-edge", "app")
+	now := time.Now()
+	for i, ver := range []string{"3", "12"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(appDir, ver), 0755))
+		mt := now.Add(time.Duration(i) * time.Second)
+		assert.NoError(t, os.Chtimes(filepath.Join(appDir, ver), mt, mt))
+	}
+	vers, err = impl.listAppVersions("baetyl-edge", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "12"}, vers)
+}
+
+func TestServicePortsKey(t *testing.T) {
+	app := &v1.Application{Name: "app", Type: v1.AppTypeContainer}
+	assert.Equal(t, "svc", servicePortsKey(app, "svc"))
+	app.Type = v1.AppTypeFunction
+	assert.Equal(t, "app", servicePortsKey(app, "svc"))
+}
+
+func TestRollbackFirstInstall(t *testing.T) {
+	impl := &nativeImpl{runHostPath: t.TempDir(), log: log.With(log.Any("ami", "native"))}
+	appVerDir := filepath.Join(impl.runHostPath, "baetyl-edge", "app", "1")
+	assert.NoError(t, os.MkdirAll(appVerDir, 0755))
+
+	// the failed version of the first install is left, so it is not installed again and again
+	err := impl.rollbackApp("baetyl-edge", &v1.Application{Name: "app", Version: "1"}, nil, errors.New("not running"))
+	rb, ok := ami.AsRollbackError(err)
+	assert.True(t, ok)
+	assert.Equal(t, v1.AppInfo{Name: "app", Version: "1"}, rb.App)
+	assert.Empty(t, rb.Previous.Version)
+	assert.EqualError(t, err, "app (app) version (1) failed without a version to roll back to: not running")
+	assert.DirExists(t, appVerDir)
+}
@@ -0,0 +1,56 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// NewExecProber creates Prober.
+func NewExecProber() ExecProber {
+	return execProber{}
+}
+
+// ExecProber is an interface that defines the Probe function for doing exec readiness/liveness checks.
+type ExecProber interface {
+	Probe(dir string, env []string, command []string, timeout time.Duration) (ProbeResult, string, error)
+}
+
+type execProber struct{}
+
+// Probe runs the command in the instance dir with the instance env.
+// If the command exits with 0, it returns Success
+// If the command exits with other codes or times out, it returns Failure.
+func (pr execProber) Probe(dir string, env []string, command []string, timeout time.Duration) (ProbeResult, string, error) {
+	if len(command) == 0 {
+		return Unknown, "", errors.New("No command specified")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	output := buf.String()
+	if len(output) > maxRespBodyLength {
+		output = output[:maxRespBodyLength]
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return Failure, fmt.Sprintf("command timed out after %s", timeout), nil
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return Failure, output, nil
+		}
+		return Unknown, output, errors.Trace(err)
+	}
+	return Success, output, nil
+}
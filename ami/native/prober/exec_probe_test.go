@@ -0,0 +1,35 @@
+//go:build !windows
+
+package prober
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecProber(t *testing.T) {
+	dir := t.TempDir()
+	pr := NewExecProber()
+
+	res, _, err := pr.Probe(dir, []string{"PROBE_VALUE=ok"}, []string{"sh", "-c", "test \"$PROBE_VALUE\" = ok && test \"$(pwd)\" = " + dir}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, Success, res)
+
+	res, out, err := pr.Probe(dir, nil, []string{"sh", "-c", "echo bad; exit 3"}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, Failure, res)
+	assert.Equal(t, "bad\n", out)
+
+	res, _, err = pr.Probe(dir, nil, []string{"sleep", "5"}, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, Failure, res)
+
+	res, _, err = pr.Probe(dir, nil, []string{"/not/exist"}, time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, Unknown, res)
+
+	_, _, err = pr.Probe(dir, nil, nil, time.Second)
+	assert.Error(t, err)
+}
@@ -27,8 +27,9 @@ const (
 	// Unknown ProbeResult
 	Unknown ProbeResult = "unknown"
 
-	maxProbeRetries = 3
-	localhost       = "127.0.0.1"
+	maxProbeRetries     = 3
+	localhost           = "127.0.0.1"
+	defaultProbeTimeout = time.Second
 )
 
 // Prober helps to check the liveness/readiness/startup of a container.
@@ -38,6 +39,13 @@ type prober struct {
 	// same host:port and transient failures. See #49740.
 	http HTTPProber
 	tcp  TCPProber
+	exec ExecProber
+}
+
+// target the instance to probe, exec probes run in its dir with its env
+type target struct {
+	dir string
+	env []string
 }
 
 func newProber() *prober {
@@ -45,15 +53,16 @@ func newProber() *prober {
 	return &prober{
 		http: NewHTTPProber(followNonLocalRedirects),
 		tcp:  NewTCPProber(),
+		exec: NewExecProber(),
 	}
 }
 
-func (pb *prober) probe(appName *probeKey, p *v1.Probe) (ProbeResult, error) {
+func (pb *prober) probe(appName *probeKey, p *v1.Probe, t *target) (ProbeResult, error) {
 	var err error
 	var output string
 	result := Unknown
 	for i := 0; i < maxProbeRetries; i++ {
-		result, output, err = pb.runProbe(appName, p)
+		result, output, err = pb.runProbe(appName, p, t)
 		if err == nil {
 			break
 		}
@@ -73,8 +82,15 @@ func (pb *prober) probe(appName *probeKey, p *v1.Probe) (ProbeResult, error) {
 	return Success, nil
 }
 
-func (pb *prober) runProbe(key *probeKey, p *v1.Probe) (ProbeResult, string, error) {
+func (pb *prober) runProbe(key *probeKey, p *v1.Probe, t *target) (ProbeResult, string, error) {
 	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	if p.Exec != nil {
+		log.L().Debug("Exec-Probe Command", log.Any("command", p.Exec.Command), log.Any("dir", t.dir))
+		return pb.exec.Probe(t.dir, t.env, p.Exec.Command, timeout)
+	}
 	if p.HTTPGet != nil {
 		scheme := strings.ToLower(string(p.HTTPGet.Scheme))
 		host := p.HTTPGet.Host
@@ -92,7 +108,7 @@ func (pb *prober) runProbe(key *probeKey, p *v1.Probe) (ProbeResult, string, err
 		return pb.http.Probe(u, headers, timeout)
 	}
 	if p.TCPSocket != nil {
-		port := p.HTTPGet.Port
+		port := p.TCPSocket.Port
 		if port.String() == "" {
 			return Unknown, "", errors.New("No port selected")
 		}
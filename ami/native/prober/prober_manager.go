@@ -1,24 +1,38 @@
 package prober
 
 import (
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	"github.com/baetyl/baetyl-go/v2/spec/v1"
+	gutils "github.com/baetyl/baetyl-go/v2/utils"
 	"github.com/kardianos/service"
 	"github.com/timshannon/bolthold"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/utils/clock"
 
+	"github.com/baetyl/baetyl/v2/program"
 	"github.com/baetyl/baetyl/v2/utils"
 )
 
 type Manager interface {
-	AddApp(svc service.Service, app *v1.Application)
+	AddApp(svc service.Service, app *v1.Application, ins Instance)
 	RemoveApp(info *v1.AppInfo)
-	CheckAndStart(svc service.Service, info *v1.AppInfo)
+	CheckAndStart(svc service.Service, info *v1.AppInfo, ins Instance)
 	CleanupApps(apps map[string]bool)
+	Probe(app *v1.Application, ins Instance) error
+	Ready(info *v1.AppInfo, ins Instance) bool
+}
+
+// Instance a service instance of the app to probe
+type Instance struct {
+	Service string
+	ID      string
+	Dir     string
 }
 
 type manager struct {
@@ -31,42 +45,55 @@ type manager struct {
 	store  *bolthold.Store
 	// count when collecting process status, if count >=maxProbeRetries, stop worker
 	status map[probeKey]int
+	// the results of readiness workers
+	readiness map[probeKey]bool
 }
 
 func NewManager(store *bolthold.Store) Manager {
 	return &manager{
-		workers: make(map[probeKey]*worker),
-		start:   clock.RealClock{}.Now(),
-		prober:  newProber(),
-		store:   store,
-		status:  make(map[probeKey]int),
-		log:     log.With(log.Any("native", "probe")),
+		workers:   make(map[probeKey]*worker),
+		start:     clock.RealClock{}.Now(),
+		prober:    newProber(),
+		store:     store,
+		status:    make(map[probeKey]int),
+		readiness: make(map[probeKey]bool),
+		log:       log.With(log.Any("native", "probe")),
 	}
 }
 
-func (m *manager) AddApp(svc service.Service, app *v1.Application) {
-	if app == nil || len(app.Services) == 0 {
+func (m *manager) AddApp(svc service.Service, app *v1.Application, ins Instance) {
+	if app == nil {
 		return
 	}
-	var p probeType
-	if app.Services[0].LivenessProbe != nil {
-		p = liveness
-	} else if app.Services[0].StartupProbe != nil {
-		p = startup
-	} else {
+	s := serviceOf(app, ins.Service)
+	if s == nil {
 		return
 	}
+	probes := map[probeType]*corev1.Probe{
+		liveness:  s.LivenessProbe,
+		startup:   s.StartupProbe,
+		readiness: s.ReadinessProbe,
+	}
+	var t *target
 	m.workerLock.Lock()
 	defer m.workerLock.Unlock()
-	key := probeKey{Name: app.Name, Version: app.Version, ProbeType: p}
-	if _, ok := m.workers[key]; ok {
-		return
+	for p, spec := range probes {
+		if spec == nil {
+			continue
+		}
+		key := probeKey{Name: app.Name, Version: app.Version, Service: ins.Service, Instance: ins.ID, ProbeType: p}
+		if _, ok := m.workers[key]; ok {
+			continue
+		}
+		if t == nil {
+			t = newTarget(ins.Dir)
+		}
+		w := newWorker(m, svc, key, spec, app, t)
+		m.workers[key] = w
+		m.status[key] = 0
+		m.log.Debug("add app", log.Any("app", key))
+		go w.run()
 	}
-	w := newWorker(m, svc, p, app)
-	m.workers[key] = w
-	m.status[key] = 0
-	m.log.Debug("add app", log.Any("app", key))
-	go w.run()
 }
 
 func (m *manager) RemoveApp(app *v1.AppInfo) {
@@ -75,18 +102,15 @@ func (m *manager) RemoveApp(app *v1.AppInfo) {
 	}
 	m.workerLock.Lock()
 	defer m.workerLock.Unlock()
-	livenessKey := probeKey{Name: app.Name, Version: app.Version, ProbeType: liveness}
-	if w, ok := m.workers[livenessKey]; ok {
-		w.stop()
-	}
-	startupKey := probeKey{Name: app.Name, Version: app.Version, ProbeType: startup}
-	if w, ok := m.workers[startupKey]; ok {
-		w.stop()
+	for key, w := range m.workers {
+		if key.Name == app.Name && key.Version == app.Version {
+			w.stop()
+		}
 	}
 }
 
 // CheckAndStart This is used for restarting baetyl-core, due to applying apps will not be called.
-func (m *manager) CheckAndStart(svc service.Service, info *v1.AppInfo) {
+func (m *manager) CheckAndStart(svc service.Service, info *v1.AppInfo, ins Instance) {
 	if m.store == nil {
 		return
 	}
@@ -100,7 +124,7 @@ func (m *manager) CheckAndStart(svc service.Service, info *v1.AppInfo) {
 		m.log.Error("failed to get app", log.Any("app", key), log.Error(err))
 		return
 	}
-	m.AddApp(svc, app)
+	m.AddApp(svc, app, ins)
 }
 
 // CleanupApps removes the workers when collecting the process status.
@@ -123,10 +147,80 @@ func (m *manager) CleanupApps(apps map[string]bool) {
 	}
 }
 
+// Probe runs the startup, liveness and readiness probes of a service instance once, it is used to verify a new app version
+func (m *manager) Probe(app *v1.Application, ins Instance) error {
+	s := serviceOf(app, ins.Service)
+	if s == nil {
+		return nil
+	}
+	var t *target
+	for _, p := range []struct {
+		t    probeType
+		spec *corev1.Probe
+	}{{startup, s.StartupProbe}, {liveness, s.LivenessProbe}, {readiness, s.ReadinessProbe}} {
+		if p.spec == nil {
+			continue
+		}
+		if t == nil {
+			t = newTarget(ins.Dir)
+		}
+		key := &probeKey{Name: app.Name, Version: app.Version, Service: ins.Service, Instance: ins.ID, ProbeType: p.t}
+		result, err := m.prober.probe(key, p.spec, t)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if result == Failure {
+			return errors.Errorf("service (%s) instance (%s) of app (%s) version (%s) failed probe", ins.Service, ins.ID, app.Name, app.Version)
+		}
+	}
+	return nil
+}
+
+// Ready returns false if the service instance has a readiness probe which has not succeeded
+func (m *manager) Ready(info *v1.AppInfo, ins Instance) bool {
+	key := probeKey{Name: info.Name, Version: info.Version, Service: ins.Service, Instance: ins.ID, ProbeType: readiness}
+	m.workerLock.RLock()
+	defer m.workerLock.RUnlock()
+	if _, ok := m.workers[key]; !ok {
+		return true
+	}
+	return m.readiness[key]
+}
+
+// Called by the readiness worker after probing.
+func (m *manager) setReady(key probeKey, ready bool) {
+	if key.ProbeType != readiness {
+		return
+	}
+	m.workerLock.Lock()
+	defer m.workerLock.Unlock()
+	m.readiness[key] = ready
+}
+
 // Called by the worker after exiting.
 func (m *manager) removeWorker(name probeKey) {
 	m.workerLock.Lock()
 	defer m.workerLock.Unlock()
 	delete(m.workers, name)
 	delete(m.status, name)
+	delete(m.readiness, name)
+}
+
+func serviceOf(app *v1.Application, name string) *v1.Service {
+	for i := range app.Services {
+		if app.Services[i].Name == name {
+			return &app.Services[i]
+		}
+	}
+	return nil
+}
+
+// newTarget loads the env of the instance from its program config
+func newTarget(dir string) *target {
+	t := &target{dir: dir}
+	var prgCfg program.Config
+	if err := gutils.LoadYAML(filepath.Join(dir, program.ProgramServiceYaml), &prgCfg); err == nil {
+		t.env = prgCfg.Env
+	}
+	return t
 }
@@ -0,0 +1,31 @@
+package prober
+
+import (
+	"testing"
+
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerReady(t *testing.T) {
+	m := NewManager(nil).(*manager)
+	info := &v1.AppInfo{Name: "app", Version: "v1"}
+	ins := Instance{Service: "svc", ID: "1"}
+	key := probeKey{Name: "app", Version: "v1", Service: "svc", Instance: "1", ProbeType: readiness}
+
+	// no readiness probe
+	assert.True(t, m.Ready(info, ins))
+
+	m.workers[key] = &worker{key: key}
+	assert.False(t, m.Ready(info, ins))
+	m.setReady(key, true)
+	assert.True(t, m.Ready(info, ins))
+	// other instances without readiness probe
+	assert.True(t, m.Ready(info, Instance{Service: "svc", ID: "2"}))
+	m.setReady(key, false)
+	assert.False(t, m.Ready(info, ins))
+
+	m.removeWorker(key)
+	assert.True(t, m.Ready(info, ins))
+	assert.NotContains(t, m.readiness, key)
+}
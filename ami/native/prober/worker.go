@@ -18,11 +18,14 @@ type probeType int
 const (
 	liveness probeType = iota
 	startup
+	readiness
 )
 
 type probeKey struct {
 	Name      string
 	Version   string
+	Service   string
+	Instance  string
 	ProbeType probeType
 }
 
@@ -35,6 +38,8 @@ type worker struct {
 	// The process to probe
 	svc          service.Service
 	app          *specV1.Application
+	key          probeKey
+	target       *target
 	probeManager *manager
 	log          *log.Logger
 	startedAt    time.Time
@@ -44,14 +49,16 @@ type worker struct {
 	resultRun int
 }
 
-func newWorker(m *manager, svc service.Service, probeType probeType, app *specV1.Application) *worker {
+func newWorker(m *manager, svc service.Service, key probeKey, spec *v1.Probe, app *specV1.Application, t *target) *worker {
 	return &worker{
-		stopCh:       make(chan struct{}, 1),        // Buffer so stop() can be non-blocking.
-		spec:         app.Services[0].LivenessProbe, // native only support one service
+		stopCh:       make(chan struct{}, 1), // Buffer so stop() can be non-blocking.
+		spec:         spec,
 		app:          app,
+		key:          key,
+		target:       t,
 		svc:          svc,
 		probeManager: m,
-		probeType:    probeType,
+		probeType:    key.ProbeType,
 		log:          m.log,
 		startedAt:    clock.RealClock{}.Now(),
 	}
@@ -70,8 +77,7 @@ func (w *worker) run() {
 	defer func() {
 		// Clean up.
 		probeTicker.Stop()
-		key := probeKey{Name: w.app.Name, Version: w.app.Version, ProbeType: w.probeType}
-		w.probeManager.removeWorker(key)
+		w.probeManager.removeWorker(w.key)
 	}()
 probeLoop:
 	for w.doProbe() {
@@ -98,7 +104,7 @@ func (w *worker) doProbe() (keepGoing bool) {
 	defer func() { recover() }() // Actually eat panics (HandleCrash takes care of logging)
 	defer runtime.HandleCrash(func(_ interface{}) { keepGoing = true })
 
-	key := &probeKey{Name: w.app.Name, Version: w.app.Version, ProbeType: w.probeType}
+	key := &w.key
 	status, ok := w.svc.Status()
 	if ok != nil {
 		w.log.Debug("No status for process", log.Any("key", key))
@@ -106,10 +112,11 @@ func (w *worker) doProbe() (keepGoing bool) {
 	}
 	if status == service.StatusStopped {
 		w.log.Debug("Process is terminated, exiting probe worker", log.Any("key", key))
+		w.probeManager.setReady(w.key, false)
 		return false
 	}
-	// Stop probing for liveness until process has started.
-	if w.probeType == liveness && status == service.StatusUnknown {
+	// Stop probing for liveness and readiness until process has started.
+	if w.probeType != startup && status == service.StatusUnknown {
 		w.log.Debug("No status for process", log.Any("key", key))
 		return true
 	}
@@ -123,7 +130,7 @@ func (w *worker) doProbe() (keepGoing bool) {
 		return true
 	}
 
-	result, err := w.probeManager.prober.probe(key, w.spec)
+	result, err := w.probeManager.prober.probe(key, w.spec, w.target)
 	if err != nil {
 		// Prober error, throw away the result.
 		return true
@@ -139,6 +146,11 @@ func (w *worker) doProbe() (keepGoing bool) {
 		// Success or failure is below threshold - leave the probe state unchanged.
 		return true
 	}
+	if w.probeType == readiness {
+		// The process is not restarted, it just doesn't serve until it is ready again.
+		w.probeManager.setReady(w.key, result == Success)
+		return true
+	}
 	if result == Failure {
 		// The process fails a check, it will need to be restarted.
 		// Stop probing and restart the process.
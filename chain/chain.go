@@ -25,7 +25,8 @@ const (
 	MsgTimeout = time.Minute * 10
 	Localhost  = "127.0.0.1"
 
-	ExitCmd = "exit\n"
+	ExitCmd   = "exit\n"
+	ResizeCmd = "resize"
 )
 
 //go:generate mockgen -destination=../mock/chain.go -package=mock -source=chain.go Chain
@@ -69,6 +70,7 @@ func NewChain(cfg config.Config, a ami.AMI, data map[string]string, needNativeOp
 	pipe := ami.Pipe{}
 	pipe.InReader, pipe.InWriter = io.Pipe()
 	pipe.OutReader, pipe.OutWriter = io.Pipe()
+	pipe.Resize = make(chan ami.TerminalSize, 1)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	pipe.Ctx = ctx
@@ -103,7 +105,7 @@ func NewChain(cfg config.Config, a ami.AMI, data map[string]string, needNativeOp
 		c.log.Debug("no container specified")
 	}
 
-	c.mode = v2context.RunMode()
+	c.mode = ami.RunMode()
 	cmd := []string{
 		"sh",
 		"-c",
@@ -128,6 +130,18 @@ func NewChain(cfg config.Config, a ami.AMI, data map[string]string, needNativeOp
 			Host: address,
 			Path: path,
 		}
+	} else if c.mode == ami.RunModeDocker {
+		// the instance is the container, which is exec'd with the default shell of the docker ami
+		opt.Command = nil
+		if command, ok := data["command"]; ok && command != "" {
+			opt.Command = []string{"sh", "-c", command}
+		}
+	} else if c.mode == v2context.RunModeNative && true == needNativeOptions && data["local"] == "true" {
+		// the local mode needs no sshd and credentials on the node, and runs the command given by the link
+		opt.NativeDebugOptions = ami.NativeDebugOptions{Local: true}
+		if command, ok := data["command"]; ok && command != "" {
+			opt.Command = []string{"sh", "-c", command}
+		}
 	} else if c.mode == v2context.RunModeNative && true == needNativeOptions {
 		port, ok := data["port"]
 		if !ok {
@@ -12,6 +12,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/baetyl/baetyl/v2/ami"
 	"github.com/baetyl/baetyl/v2/config"
 	"github.com/baetyl/baetyl/v2/mock"
 )
@@ -83,6 +84,35 @@ func TestNewChain(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestChainCommand(t *testing.T) {
+	cfg, ctl, data := initChainEnv(t)
+	a := mock.NewMockAMI(ctl)
+	data["command"] = "ls"
+
+	// the command of the link is only run by the local terminal of native mode
+	t.Setenv(context.KeyRunMode, context.RunModeKube)
+	c, err := NewChain(cfg, a, data, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", "/bin/sh"}, c.(*chain).debugOptions.Command)
+
+	t.Setenv(context.KeyRunMode, context.RunModeNative)
+	data["local"] = "true"
+	c, err = NewChain(cfg, a, data, true)
+	assert.NoError(t, err)
+	assert.True(t, c.(*chain).debugOptions.Local)
+	assert.Equal(t, []string{"sh", "-c", "ls"}, c.(*chain).debugOptions.Command)
+
+	t.Setenv(context.KeyRunMode, ami.RunModeDocker)
+	c, err = NewChain(cfg, a, data, true)
+	assert.NoError(t, err)
+	assert.False(t, c.(*chain).debugOptions.Local)
+	assert.Equal(t, []string{"sh", "-c", "ls"}, c.(*chain).debugOptions.Command)
+	delete(data, "command")
+	c, err = NewChain(cfg, a, data, true)
+	assert.NoError(t, err)
+	assert.Nil(t, c.(*chain).debugOptions.Command)
+}
+
 func TestChainMsg(t *testing.T) {
 	cfg, ctl, data := initChainEnv(t)
 	a := mock.NewMockAMI(ctl)
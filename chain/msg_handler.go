@@ -2,11 +2,13 @@ package chain
 
 import (
 	"bytes"
+	"strconv"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 
+	"github.com/baetyl/baetyl/v2/ami"
 	"github.com/baetyl/baetyl/v2/sync"
 )
 
@@ -55,12 +57,50 @@ func (h *chainHandler) OnMessage(msg interface{}) error {
 			}
 			return errors.Trace(err)
 		}
+	case v1.MessageCMD:
+		if m.Metadata["cmd"] != ResizeCmd {
+			h.log.Warn("remote debug command not support", log.Any("msg", m))
+			return nil
+		}
+		size, err := parseTerminalSize(m.Metadata)
+		if err != nil {
+			h.log.Warn("failed to parse terminal size", log.Any("msg", m), log.Error(err))
+			return nil
+		}
+		h.resize(size)
 	default:
 		h.log.Warn("remote debug message kind not support", log.Any("msg", m))
 	}
 	return nil
 }
 
+// resize keeps the latest size only, the stale one is dropped if it has not been applied
+func (h *chainHandler) resize(size ami.TerminalSize) {
+	for {
+		select {
+		case h.pipe.Resize <- size:
+			return
+		default:
+		}
+		select {
+		case <-h.pipe.Resize:
+		default:
+		}
+	}
+}
+
+func parseTerminalSize(metadata map[string]string) (ami.TerminalSize, error) {
+	rows, err := strconv.ParseUint(metadata["rows"], 10, 16)
+	if err != nil {
+		return ami.TerminalSize{}, errors.Trace(err)
+	}
+	cols, err := strconv.ParseUint(metadata["cols"], 10, 16)
+	if err != nil {
+		return ami.TerminalSize{}, errors.Trace(err)
+	}
+	return ami.TerminalSize{Rows: uint16(rows), Cols: uint16(cols)}, nil
+}
+
 // onExitMessage call cancel function before close
 func (h *chainHandler) onExitMessage() error {
 	return h.Cancel()
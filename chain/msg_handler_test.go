@@ -118,3 +118,26 @@ func (h *msgUpside) OnMessage(msg interface{}) error {
 func (h *msgUpside) OnTimeout() error {
 	return nil
 }
+
+func TestHandlerResize(t *testing.T) {
+	cha := &chain{
+		pipe: ami.Pipe{Resize: make(chan ami.TerminalSize, 1)},
+		log:  log.L().With(log.Any("chain", "test")),
+	}
+	cHandler := &chainHandler{chain: cha}
+
+	msg := &specV1.Message{
+		Kind:     specV1.MessageCMD,
+		Metadata: map[string]string{"cmd": ResizeCmd, "rows": "24", "cols": "80"},
+	}
+	assert.NoError(t, cHandler.OnMessage(msg))
+	// the stale size is replaced
+	msg.Metadata["cols"] = "100"
+	assert.NoError(t, cHandler.OnMessage(msg))
+	assert.Equal(t, ami.TerminalSize{Rows: 24, Cols: 100}, <-cha.pipe.Resize)
+
+	// bad size
+	msg.Metadata["rows"] = "x"
+	assert.NoError(t, cHandler.OnMessage(msg))
+	assert.Len(t, cha.pipe.Resize, 0)
+}
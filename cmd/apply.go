@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	gocontext "context"
 	"crypto/tls"
 	"encoding/json"
 	"io/ioutil"
@@ -31,13 +32,14 @@ var (
 	modes      = map[string]struct{}{
 		context.RunModeKube:   {},
 		context.RunModeNative: {},
+		ami.RunModeDocker:     {},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(applyCmd)
 	applyCmd.Flags().StringVarP(&file, "filename", "f", "", "The application mode file to apply, only support json format now.")
-	applyCmd.Flags().StringVarP(&mode, "mode", "m", "native", "The running mode of applications, supports 'kube' and 'native'.")
+	applyCmd.Flags().StringVarP(&mode, "mode", "m", "native", "The running mode of applications, supports 'kube', 'native' and 'docker'.")
 	applyCmd.Flags().BoolVar(&skipVerify, "skip-verify", false, "Indicates whether to skip certificate verify.")
 	applyCmd.MarkFlagRequired("filename")
 }
@@ -45,7 +47,7 @@ func init() {
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Apply baetyl applications.",
-	Long:  "Apply baetyl applications, can run applications in kube mode or native mode.",
+	Long:  "Apply baetyl applications, can run applications in kube mode, native mode or docker mode.",
 	Run: func(_ *cobra.Command, _ []string) {
 		apply()
 	},
@@ -127,7 +129,7 @@ func apply() {
 	objectHostPath := filepath.Join(hostPathLib, "object")
 	for _, cfg := range configs {
 		sync.FilterConfig(&cfg)
-		err = sync.DownloadConfig(cli, objectHostPath, &cfg)
+		err = sync.DownloadConfig(gocontext.Background(), cli, objectHostPath, &cfg)
 		if err != nil {
 			return
 		}
@@ -166,7 +168,7 @@ func apply() {
 		}
 
 		// apply app
-		err = am.ApplyApp(app.Namespace, app, configs, secrets)
+		err = am.ApplyApp(gocontext.Background(), app.Namespace, app, configs, secrets)
 		if err != nil {
 			return
 		}
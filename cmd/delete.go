@@ -21,7 +21,7 @@ var (
 func init() {
 	rootCmd.AddCommand(deleteCmd)
 	deleteCmd.Flags().StringVarP(&ns, "namespace", "n", "baetyl-edge-system", "The namespace of applications which will be deleted.")
-	deleteCmd.Flags().StringVarP(&mode, "mode", "m", "native", "The running mode of applications, supports 'kube' and 'native'.")
+	deleteCmd.Flags().StringVarP(&mode, "mode", "m", "native", "The running mode of applications, supports 'kube', 'native' and 'docker'.")
 }
 
 var deleteCmd = &cobra.Command{
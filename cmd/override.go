@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/http"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/spf13/cobra"
+
+	"github.com/baetyl/baetyl/v2/engine"
+)
+
+var overrideFile string
+
+func init() {
+	rootCmd.AddCommand(overrideCmd)
+	overrideCmd.PersistentFlags().StringVarP(&coreAddr, "address", "a", "", "The address of the core server, such as https://127.0.0.1:443, read from the core config if not set.")
+	overrideCmd.PersistentFlags().StringVarP(&coreConf, "conf", "c", "etc/baetyl/conf.yml", "The config file of the core, to find the server address and the node certificate.")
+	overrideCmd.PersistentFlags().BoolVar(&skipVerify, "skip-verify", false, "Indicates whether to skip certificate verify.")
+	overrideAppCmd.Flags().StringVarP(&overrideFile, "filename", "f", "", "The application file to install or update, supports json and yaml format.")
+	overrideAppCmd.MarkFlagRequired("filename")
+	overrideConfigCmd.Flags().StringVarP(&overrideFile, "filename", "f", "", "The configuration file to update, supports json and yaml format.")
+	overrideConfigCmd.MarkFlagRequired("filename")
+	overrideCmd.AddCommand(overrideListCmd, overrideAppCmd, overrideConfigCmd, overrideRemoveCmd, overrideRevertCmd)
+}
+
+var overrideCmd = &cobra.Command{
+	Use:   "override",
+	Short: "Change baetyl applications and configurations locally.",
+	Long: "Install, update or remove applications and update configurations locally, such as while the node is offline. " +
+		"The local changes are kept until they are reverted, or the cloud desires other versions of the applications and configurations.",
+}
+
+var overrideListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the local changes.",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		exitIfError(listOverrides())
+	},
+}
+
+var overrideAppCmd = &cobra.Command{
+	Use:   "app",
+	Short: "Install or update an application locally.",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		var app v1.Application
+		exitIfError(setOverride("apps", overrideFile, &app, func() string { return app.Name }))
+	},
+}
+
+var overrideConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Update a configuration locally, the applications using it are applied again.",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		var cfg v1.Configuration
+		exitIfError(setOverride("configs", overrideFile, &cfg, func() string { return cfg.Name }))
+	},
+}
+
+var overrideRemoveCmd = &cobra.Command{
+	Use:   "remove <application>",
+	Short: "Remove an application locally.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		exitIfError(sendOverride("PUT", "/engine/overrides/apps/"+args[0]+"?action="+engine.OverrideRemove, nil))
+	},
+}
+
+var overrideRevertCmd = &cobra.Command{
+	Use:       "revert <app|config> <name>",
+	Short:     "Revert the local change, the cloud desire is applied again.",
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: []string{"app", "config"},
+	Run: func(_ *cobra.Command, args []string) {
+		exitIfError(sendOverride("DELETE", "/engine/overrides/"+args[0]+"s/"+args[1], nil))
+	},
+}
+
+func exitIfError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func listOverrides() error {
+	cli, address, err := coreClient()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := cli.GetJSON(address + "/engine/overrides")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var overrides []engine.Override
+	if err = json.Unmarshal(data, &overrides); err != nil {
+		return errors.Trace(err)
+	}
+	if len(overrides) == 0 {
+		fmt.Println("No local changes.")
+	}
+	for _, o := range overrides {
+		printOverride(&o)
+	}
+	return nil
+}
+
+// setOverride sends the resource of the file, the name is read from the file
+func setOverride(kind, filename string, res interface{}, name func() string) error {
+	if err := utils.LoadYAML(filename, res); err != nil {
+		return errors.Trace(err)
+	}
+	if name() == "" {
+		return errors.Errorf("the name of the file (%s) is not set", filename)
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(sendOverride("PUT", "/engine/overrides/"+kind+"/"+name(), body))
+}
+
+func sendOverride(method, path string, body []byte) error {
+	cli, address, err := coreClient()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	res, err := cli.SendUrl(method, address+path, bytes.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := http.HandleResponse(res)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if method == "DELETE" {
+		fmt.Println("reverted, the cloud desire is applied again")
+		return nil
+	}
+	var o engine.Override
+	if err = json.Unmarshal(data, &o); err != nil {
+		return errors.Trace(err)
+	}
+	printOverride(&o)
+	return nil
+}
+
+func printOverride(o *engine.Override) {
+	kind := strings.ToLower(string(o.Kind))
+	base := o.Base
+	if base == "" {
+		base = "none"
+	}
+	if o.Action == engine.OverrideRemove {
+		fmt.Printf("%-8s %s %s (cloud version: %s)\n", o.Action, kind, o.Name, base)
+		return
+	}
+	fmt.Printf("%-8s %s %s: %s (cloud version: %s)\n", o.Action, kind, o.Name, o.Version, base)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/http"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/spf13/cobra"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/engine"
+)
+
+var (
+	coreAddr   string
+	coreConf   string
+	planOutput string
+)
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringVarP(&coreAddr, "address", "a", "", "The address of the core server, such as https://127.0.0.1:443, read from the core config if not set.")
+	planCmd.Flags().StringVarP(&coreConf, "conf", "c", "etc/baetyl/conf.yml", "The config file of the core, to find the server address and the node certificate.")
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", "text", "The output format, supports 'text' and 'json'.")
+	planCmd.Flags().BoolVar(&skipVerify, "skip-verify", false, "Indicates whether to skip certificate verify.")
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the plan of baetyl applications.",
+	Long:  "Show what the core will change for the desire of the node shadow without applying it, including the applications to install, update or delete, the resources to download and the conflicts.",
+	Run: func(_ *cobra.Command, _ []string) {
+		if err := plan(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func plan() error {
+	cli, address, err := coreClient()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := cli.GetJSON(address + "/engine/plan")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if planOutput == "json" {
+		fmt.Println(string(data))
+		return nil
+	}
+	var p engine.Plan
+	if err = json.Unmarshal(data, &p); err != nil {
+		return errors.Trace(err)
+	}
+	printPlan(&p)
+	return nil
+}
+
+// coreClient the client of the core server and its address, the address and the certificate are read from the core config
+func coreClient() (*http.Client, string, error) {
+	var cfg config.Config
+	if err := utils.LoadYAML(coreConf, &cfg); err != nil && coreAddr == "" {
+		return nil, "", errors.Trace(err)
+	}
+	address := coreAddr
+	if address == "" {
+		address = coreAddress(cfg.Server.Address, cfg.Node.Cert != "")
+	}
+	ops := http.NewClientOptions()
+	if strings.HasPrefix(address, "https://") {
+		if cfg.Node.Cert != "" {
+			tlsConfig, err := utils.NewTLSConfigClient(cfg.Node)
+			if err != nil {
+				return nil, "", errors.Trace(err)
+			}
+			ops.TLSConfig = tlsConfig
+		}
+		if skipVerify {
+			if ops.TLSConfig == nil {
+				ops.TLSConfig = &tls.Config{}
+			}
+			ops.TLSConfig.InsecureSkipVerify = true
+		}
+	}
+	return http.NewClient(ops), strings.TrimSuffix(address, "/"), nil
+}
+
+// coreAddress the local address of the core server, such as https://127.0.0.1:443 for 0.0.0.0:443
+func coreAddress(listen string, secure bool) string {
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+	if i := strings.Index(listen, "://"); i >= 0 {
+		scheme, listen = listen[:i+3], listen[i+3:]
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return scheme + listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
+
+func printPlan(p *engine.Plan) {
+	if len(p.Apps) == 0 {
+		fmt.Println("No changes. The applications are up to date with the desire.")
+	}
+	for _, app := range p.Apps {
+		kind := "application"
+		if app.System {
+			kind = "system application"
+		}
+		switch app.Action {
+		case engine.PlanUpdate:
+			fmt.Printf("%-8s %s %s: %s -> %s\n", app.Action, kind, app.Name, app.Previous, app.Version)
+		default:
+			fmt.Printf("%-8s %s %s: %s\n", app.Action, kind, app.Name, app.Version)
+		}
+	}
+	for _, d := range p.Downloads {
+		line := fmt.Sprintf("%-8s %s %s: %s", "download", strings.ToLower(string(d.Kind)), d.Name, d.Version)
+		if len(d.Objects) > 0 {
+			line += fmt.Sprintf(" (objects: %s)", strings.Join(d.Objects, ", "))
+		}
+		fmt.Println(line)
+	}
+	for _, c := range p.Conflicts {
+		fmt.Printf("%-8s application %s: %s: %s\n", "conflict", c.Name, c.Version, c.Reason)
+	}
+}
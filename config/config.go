@@ -47,10 +47,64 @@ type Config struct {
 type EngineConfig struct {
 	Report struct {
 		Interval time.Duration `yaml:"interval" json:"interval" default:"10s"`
+		// Delay the delay to report after the apps change, the changes in the delay are reported together
+		Delay time.Duration `yaml:"delay" json:"delay" default:"1s"`
+		// MinInterval the minimum interval between the reports of the app changes
+		MinInterval time.Duration `yaml:"minInterval" json:"minInterval" default:"5s"`
 	} `yaml:"report" json:"report"`
 	Clean struct {
 		Interval time.Duration `yaml:"interval" json:"interval" default:"10m"`
 	} `yaml:"clean" json:"clean"`
+	Policy struct {
+		// Path the file of the admission policy, which is reloaded once changed, all the apps are admitted if not found
+		Path string `yaml:"path" json:"path" default:"etc/baetyl/policy.yml"`
+	} `yaml:"policy" json:"policy"`
+	Resource struct {
+		// Disable disables checking the resources of the apps against the free resources of the nodes
+		Disable bool `yaml:"disable" json:"disable"`
+	} `yaml:"resource" json:"resource"`
+	Apply struct {
+		// Concurrency the number of the apps applied at the same time, the apps sharing host ports or depending on each other are applied one by one
+		Concurrency int `yaml:"concurrency" json:"concurrency" default:"4"`
+		// Timeout the deadline to apply each app, the app not applied in time is reported as failed and waited in the background
+		Timeout time.Duration `yaml:"timeout" json:"timeout" default:"10m"`
+	} `yaml:"apply" json:"apply"`
+	Health struct {
+		// Deadline the time for the new version of the apps to be running, the version failed or not running
+		// until the deadline is rolled back to the last known good version
+		Deadline time.Duration `yaml:"deadline" json:"deadline" default:"5m"`
+		// Disable disables rolling back the unhealthy versions
+		Disable bool `yaml:"disable" json:"disable"`
+	} `yaml:"health" json:"health"`
+	Maintenance struct {
+		// Windows the maintenance windows to apply the updates of the running apps, such as "0 22 * * 1-5 2h",
+		// the updates are applied at any time if not set
+		Windows []string `yaml:"windows" json:"windows"`
+	} `yaml:"maintenance" json:"maintenance"`
+}
+
+// AdmissionPolicy the node-local policy to admit the user apps before they are applied, the empty fields are not checked.
+// The yaml and helm apps are denied if any of the fields is set, since their services are not checked
+type AdmissionPolicy struct {
+	// Registries the allowed registries of the images, such as "docker.io" or "registry.example.com/team"
+	Registries []string `yaml:"registries" json:"registries"`
+	// DenyPrivileged denies the privileged services
+	DenyPrivileged bool `yaml:"denyPrivileged" json:"denyPrivileged"`
+	// DenyHostNetwork denies the apps and services using the host network
+	DenyHostNetwork bool `yaml:"denyHostNetwork" json:"denyHostNetwork"`
+	// HostPaths the allowed host paths to mount, including their sub paths
+	HostPaths []string `yaml:"hostPaths" json:"hostPaths"`
+	// MaxResources the maximum resource requests and limits of each service, such as cpu: "2", memory: 2Gi
+	MaxResources map[string]string `yaml:"maxResources" json:"maxResources"`
+	// ForbiddenEnv the forbidden environment variables of the services
+	ForbiddenEnv []ForbiddenEnv `yaml:"forbiddenEnv" json:"forbiddenEnv"`
+}
+
+// ForbiddenEnv matches the environment variables by the name and the regular expression of the value,
+// the variable is matched by the name only if the value is empty, and by the value only if the name is empty
+type ForbiddenEnv struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
 }
 
 type EventConfig struct {
@@ -66,16 +120,79 @@ type Publish struct {
 type AmiConfig struct {
 	Kube   KubeConfig   `yaml:"kube" json:"kube"`
 	Native NativeConfig `yaml:"native" json:"native"`
+	Docker DockerConfig `yaml:"docker" json:"docker"`
 }
 
 type KubeConfig struct {
 	OutCluster bool                `yaml:"outCluster" json:"outCluster"`
 	ConfPath   string              `yaml:"confPath" json:"confPath"`
 	LogConfig  KubernetesLogConfig `yaml:"logConfig" json:"logConfig"` // TODO: remove
+	Cache      KubeCacheConfig     `yaml:"cache" json:"cache"`
+	Helm       HelmConfig          `yaml:"helm" json:"helm"`
+	Network    KubeNetworkConfig   `yaml:"network" json:"network"`
+	PrePull    KubePrePullConfig   `yaml:"prePull" json:"prePull"`
+}
+
+// KubePrePullConfig the images of the new version are pulled on the nodes before the running version is replaced,
+// the new version is rolled out anyway if the images are not pulled in Timeout
+type KubePrePullConfig struct {
+	Disable bool          `yaml:"disable" json:"disable"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout" default:"10m"`
+}
+
+// KubeNetworkConfig the user apps are isolated from each other by network policies if EnableIsolation,
+// IngressClass is the default class of the ingresses generated for the apps
+type KubeNetworkConfig struct {
+	EnableIsolation bool   `yaml:"enableIsolation" json:"enableIsolation"`
+	IngressClass    string `yaml:"ingressClass" json:"ingressClass"`
+}
+
+// HelmConfig the helm releases are installed and upgraded atomically, a failed upgrade is rolled back
+// to the last deployed revision in Timeout. The charts delivered are kept in ChartCache to reinstall offline,
+// until another version of the app is released or the app is deleted
+type HelmConfig struct {
+	Timeout    time.Duration `yaml:"timeout" json:"timeout" default:"5m"`
+	HistoryMax int           `yaml:"historyMax" json:"historyMax" default:"10"`
+	ChartCache string        `yaml:"chartCache" json:"chartCache" default:"var/lib/baetyl/helm/charts"`
+}
+
+// KubeCacheConfig the app stats are collected from the informer cache of each namespace,
+// which falls back to listing from the api server if it is not synced in SyncTimeout
+type KubeCacheConfig struct {
+	Disable     bool          `yaml:"disable" json:"disable"`
+	Resync      time.Duration `yaml:"resync" json:"resync" default:"10m"`
+	SyncTimeout time.Duration `yaml:"syncTimeout" json:"syncTimeout" default:"30s"`
 }
 
 type NativeConfig struct {
-	PortsRange PortsRange `yaml:"portsRange" json:"portsRange"`
+	PortsRange PortsRange   `yaml:"portsRange" json:"portsRange"`
+	Update     UpdateConfig `yaml:"update" json:"update"`
+	Cgroup     CgroupConfig `yaml:"cgroup" json:"cgroup"`
+}
+
+// CgroupConfig each service instance is put into its own cgroup v2 under the slice, with systemd the slice
+// is a slice unit holding the service units of the instances, otherwise it is created under the root
+type CgroupConfig struct {
+	Disable bool   `yaml:"disable" json:"disable"`
+	Root    string `yaml:"root" json:"root" default:"/sys/fs/cgroup"`
+	Slice   string `yaml:"slice" json:"slice" default:"baetyl.slice"`
+	// the default pids limit of service instances if the app does not set limits.pids, 0 means no limit
+	PidsLimit int64 `yaml:"pidsLimit" json:"pidsLimit"`
+}
+
+// UpdateConfig the old versions of an app are removed once the new version is installed, and the engine rolls back
+// the unhealthy one. If Wait, they are removed once all instances of the new version keep running and passing probes
+// for MinReady, otherwise the new version is rolled back after Timeout, the probe delays and failure thresholds are added to Timeout
+type UpdateConfig struct {
+	Wait     bool          `yaml:"wait" json:"wait"`
+	MinReady time.Duration `yaml:"minReady" json:"minReady" default:"5s"`
+	Timeout  time.Duration `yaml:"timeout" json:"timeout" default:"60s"`
+}
+
+type DockerConfig struct {
+	Host       string `yaml:"host" json:"host" default:"unix:///var/run/docker.sock"`
+	APIVersion string `yaml:"apiVersion" json:"apiVersion"`
+	Network    string `yaml:"network" json:"network" default:"baetyl"`
 }
 
 type PortsRange struct {
@@ -159,6 +159,10 @@ func (c *Core) initRouter() fasthttp.RequestHandler {
 	router := routing.New()
 	router.Get("/node/stats", utils.Wrapper(c.nod.GetStats))
 	router.Get("/services/<service>/log", c.eng.GetServiceLog)
+	router.Get("/engine/plan", utils.Wrapper(c.eng.GetPlan))
+	router.Get("/engine/overrides", utils.Wrapper(c.eng.GetOverrides))
+	router.Put("/engine/overrides/<kind>/<name>", utils.Wrapper(c.eng.SetOverride))
+	router.Delete("/engine/overrides/<kind>/<name>", utils.Wrapper(c.eng.DeleteOverride))
 	router.Get("/node/properties", utils.Wrapper(c.nod.GetNodeProperties))
 	router.Put("/node/properties", utils.Wrapper(c.nod.UpdateNodeProperties))
 	router.Post("/agent/sts", utils.Wrapper(c.agt.SendRequest))
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	goerrors "errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	v2utils "github.com/baetyl/baetyl-go/v2/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+const defaultRegistry = "docker.io"
+
+// admissionError the app violates the admission policy, which is not applied
+type admissionError struct {
+	App        specv1.AppInfo
+	Violations []string
+}
+
+func (e *admissionError) Error() string {
+	return fmt.Sprintf("app (%s) version (%s) is rejected by policy: %s", e.App.Name, e.App.Version, strings.Join(e.Violations, "; "))
+}
+
+func asAdmissionError(err error) (*admissionError, bool) {
+	var ae *admissionError
+	if goerrors.As(err, &ae) {
+		return ae, true
+	}
+	return nil, false
+}
+
+type forbiddenEnv struct {
+	name  string
+	value *regexp.Regexp
+}
+
+type admissionPolicy struct {
+	config.AdmissionPolicy
+	maxResources map[string]resource.Quantity
+	forbiddenEnv []forbiddenEnv
+}
+
+// admission admits the apps by the policy file, which is reloaded once it is changed
+type admission struct {
+	path    string
+	modTime time.Time
+	size    int64
+	policy  *admissionPolicy
+	log     *log.Logger
+	mu      gosync.Mutex
+}
+
+func newAdmission(path string) *admission {
+	return &admission{path: path, log: log.With(log.Any("engine", "admission"))}
+}
+
+// admit checks the user app against the policy, the system apps are always admitted
+func (a *admission) admit(app *specv1.Application) error {
+	if a == nil || app.System {
+		return nil
+	}
+	p := a.current()
+	if p == nil {
+		return nil
+	}
+	if violations := p.check(app); len(violations) > 0 {
+		return &admissionError{App: specv1.AppInfo{Name: app.Name, Version: app.Version}, Violations: violations}
+	}
+	return nil
+}
+
+// active whether there is a policy to admit the apps
+func (a *admission) active() bool {
+	return a != nil && a.current() != nil
+}
+
+// checkAdmission holds the updates of the apps rejected by the policy as failed, which are checked on the
+// synced app specs before their resources are downloaded
+func (e *engineImpl) checkAdmission(infos []specv1.AppInfo, apps map[string]specv1.Application, stats map[string]specv1.AppStats, update map[string]specv1.AppInfo) {
+	for _, info := range infos {
+		if _, ok := update[info.Name]; !ok {
+			continue
+		}
+		app, ok := apps[info.Name]
+		if !ok {
+			// the app without the spec can not be checked, which is held until it is synced
+			if e.adm.active() {
+				delete(update, info.Name)
+				stat := stats[info.Name]
+				stat.Status = specv1.Pending
+				stat.Cause = fmt.Sprintf("app (%s) version (%s) is not admitted without the spec", info.Name, info.Version)
+				stats[info.Name] = stat
+			}
+			continue
+		}
+		err := e.adm.admit(&app)
+		if err == nil {
+			continue
+		}
+		delete(update, info.Name)
+		stat := stats[info.Name]
+		stat.Status = specv1.Failed
+		stat.Cause = err.Error()
+		stats[info.Name] = stat
+		if ae, ok := asAdmissionError(err); ok {
+			e.log.Warn("application is rejected by policy", log.Any("info", info), log.Any("violations", ae.Violations))
+		}
+	}
+}
+
+// current the policy of the latest file, the previous policy is kept if the file fails to load
+func (a *admission) current() *admissionPolicy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.log.Warn("failed to stat admission policy", log.Any("path", a.path), log.Error(err))
+			return a.policy
+		}
+		if a.policy != nil {
+			a.log.Info("admission policy is removed", log.Any("path", a.path))
+		}
+		a.policy, a.modTime, a.size = nil, time.Time{}, 0
+		return nil
+	}
+	if fi.ModTime().Equal(a.modTime) && fi.Size() == a.size {
+		return a.policy
+	}
+	a.modTime, a.size = fi.ModTime(), fi.Size()
+	p, err := loadAdmissionPolicy(a.path)
+	if err != nil {
+		a.log.Error("failed to load admission policy, the previous one is kept", log.Any("path", a.path), log.Error(err))
+		return a.policy
+	}
+	a.log.Info("admission policy is loaded", log.Any("path", a.path), log.Any("policy", p.AdmissionPolicy))
+	a.policy = p
+	return p
+}
+
+func loadAdmissionPolicy(file string) (*admissionPolicy, error) {
+	p := &admissionPolicy{maxResources: map[string]resource.Quantity{}}
+	if err := v2utils.LoadYAML(file, &p.AdmissionPolicy); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for n, v := range p.MaxResources {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, errors.Errorf("max resource %s (%s) is invalid: %s", n, v, err.Error())
+		}
+		p.maxResources[n] = q
+	}
+	for _, env := range p.ForbiddenEnv {
+		if env.Name == "" && env.Value == "" {
+			return nil, errors.New("forbidden env requires the name or the value")
+		}
+		fe := forbiddenEnv{name: env.Name}
+		if env.Value != "" {
+			re, err := regexp.Compile(env.Value)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			fe.value = re
+		}
+		p.forbiddenEnv = append(p.forbiddenEnv, fe)
+	}
+	return p, nil
+}
+
+// check returns the violations of the app
+func (p *admissionPolicy) check(app *specv1.Application) []string {
+	var violations []string
+	// the services of the yaml and helm apps are rendered by kubernetes, which are not checked here
+	if (app.Type == specv1.AppTypeYaml || app.Type == specv1.AppTypeHelm) && p.hasRules() {
+		return []string{fmt.Sprintf("app of type (%s) is denied when the policy has rules", app.Type)}
+	}
+	if p.DenyHostNetwork && app.HostNetwork {
+		violations = append(violations, "host network is denied")
+	}
+	if len(p.HostPaths) > 0 {
+		for _, v := range app.Volumes {
+			if v.HostPath != nil && !matchPathPrefix(path.Clean(v.HostPath.Path), p.HostPaths) {
+				violations = append(violations, fmt.Sprintf("host path (%s) of volume (%s) is not allowed", v.HostPath.Path, v.Name))
+			}
+		}
+	}
+	services := append(append([]specv1.Service{}, app.InitServices...), app.Services...)
+	for _, svc := range services {
+		violations = append(violations, p.checkService(&svc)...)
+	}
+	return violations
+}
+
+// hasRules whether any of the rules is set
+func (p *admissionPolicy) hasRules() bool {
+	return len(p.Registries) > 0 || p.DenyPrivileged || p.DenyHostNetwork || len(p.HostPaths) > 0 || len(p.maxResources) > 0 || len(p.forbiddenEnv) > 0
+}
+
+func (p *admissionPolicy) checkService(svc *specv1.Service) []string {
+	var violations []string
+	if len(p.Registries) > 0 && !matchPathPrefix(imageRepository(svc.Image), p.Registries) {
+		violations = append(violations, fmt.Sprintf("image (%s) of service (%s) is not from the allowed registries", svc.Image, svc.Name))
+	}
+	if p.DenyPrivileged && svc.SecurityContext != nil && svc.SecurityContext.Privileged {
+		violations = append(violations, fmt.Sprintf("service (%s) is privileged", svc.Name))
+	}
+	if p.DenyHostNetwork && svc.HostNetwork {
+		violations = append(violations, fmt.Sprintf("service (%s) uses the host network", svc.Name))
+	}
+	if svc.Resources != nil {
+		violations = append(violations, p.checkResources(svc.Name, "request", svc.Resources.Requests)...)
+		violations = append(violations, p.checkResources(svc.Name, "limit", svc.Resources.Limits)...)
+	}
+	for _, env := range svc.Env {
+		for _, fe := range p.forbiddenEnv {
+			if (fe.name == "" || fe.name == env.Name) && (fe.value == nil || fe.value.MatchString(env.Value)) {
+				violations = append(violations, fmt.Sprintf("env (%s) of service (%s) is forbidden", env.Name, svc.Name))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+func (p *admissionPolicy) checkResources(svcName, kind string, list map[string]string) []string {
+	var names []string
+	for n := range list {
+		if _, ok := p.maxResources[n]; ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	var violations []string
+	for _, n := range names {
+		max := p.maxResources[n]
+		q, err := resource.ParseQuantity(list[n])
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("resource %s %s (%s) of service (%s) is invalid", kind, n, list[n], svcName))
+			continue
+		}
+		if q.Cmp(max) > 0 {
+			violations = append(violations, fmt.Sprintf("resource %s %s (%s) of service (%s) exceeds the maximum (%s)", kind, n, list[n], svcName, max.String()))
+		}
+	}
+	return violations
+}
+
+// imageRepository the full repository of the image without the tag and the digest, such as docker.io/library/nginx
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return defaultRegistry + "/library/" + image
+	}
+	if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return defaultRegistry + "/" + image
+	}
+	return image
+}
+
+// matchPathPrefix whether the path is one of the prefixes or under them
+func matchPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") || prefix == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+const testPolicy = `
+registries:
+- docker.io/library
+- registry.example.com/team/
+denyPrivileged: true
+denyHostNetwork: true
+hostPaths:
+- /var/lib/baetyl/
+maxResources:
+  cpu: "1"
+  memory: 1Gi
+forbiddenEnv:
+- name: LD_PRELOAD
+- value: ^AKIA
+`
+
+func TestImageRepository(t *testing.T) {
+	cases := map[string]string{
+		"nginx":                              "docker.io/library/nginx",
+		"nginx:1.19":                         "docker.io/library/nginx",
+		"baetyl/broker:v2.2":                 "docker.io/baetyl/broker",
+		"registry.example.com/team/app:v1":   "registry.example.com/team/app",
+		"localhost:5000/app@sha256:abcd":     "localhost:5000/app",
+		"localhost/app":                      "localhost/app",
+		"registry.example.com:5000/app:v1.0": "registry.example.com:5000/app",
+	}
+	for image, expected := range cases {
+		assert.Equal(t, expected, imageRepository(image), image)
+	}
+}
+
+func TestAdmissionPolicyCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yml")
+	assert.NoError(t, os.WriteFile(file, []byte(testPolicy), 0644))
+	p, err := loadAdmissionPolicy(file)
+	assert.NoError(t, err)
+
+	app := &specv1.Application{
+		Name: "app",
+		Volumes: []specv1.Volume{
+			{Name: "data", VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/baetyl/data"}}},
+		},
+		Services: []specv1.Service{{
+			Name:      "s1",
+			Image:     "nginx:1.19",
+			Resources: &specv1.Resources{Limits: map[string]string{"cpu": "500m", "memory": "1Gi", "disk": "10Gi"}},
+			Env:       []specv1.Environment{{Name: "MODE", Value: "prod"}},
+		}},
+	}
+	assert.Empty(t, p.check(app))
+
+	app.HostNetwork = true
+	app.Volumes = append(app.Volumes, specv1.Volume{Name: "etc", VolumeSource: specv1.VolumeSource{HostPath: &specv1.HostPathVolumeSource{Path: "/var/lib/baetyl/../../etc"}}})
+	app.InitServices = []specv1.Service{{Name: "init", Image: "registry.example.com/team/init:v1"}}
+	app.Services = append(app.Services, specv1.Service{
+		Name:            "s2",
+		Image:           "registry.example.com/other/app:v1",
+		SecurityContext: &specv1.SecurityContext{Privileged: true},
+		Resources:       &specv1.Resources{Requests: map[string]string{"cpu": "2"}, Limits: map[string]string{"memory": "x"}},
+		Env:             []specv1.Environment{{Name: "LD_PRELOAD", Value: "/lib/a.so"}, {Name: "KEY", Value: "AKIA123"}},
+	})
+	assert.Equal(t, []string{
+		"host network is denied",
+		"host path (/var/lib/baetyl/../../etc) of volume (etc) is not allowed",
+		"image (registry.example.com/other/app:v1) of service (s2) is not from the allowed registries",
+		"service (s2) is privileged",
+		"resource request cpu (2) of service (s2) exceeds the maximum (1)",
+		"resource limit memory (x) of service (s2) is invalid",
+		"env (LD_PRELOAD) of service (s2) is forbidden",
+		"env (KEY) of service (s2) is forbidden",
+	}, p.check(app))
+
+	// the yaml and helm apps are not checked on their rendered services, which are denied with the rules
+	assert.Equal(t, []string{"app of type (yaml) is denied when the policy has rules"}, p.check(&specv1.Application{Name: "yaml", Type: specv1.AppTypeYaml}))
+	assert.Empty(t, (&admissionPolicy{}).check(&specv1.Application{Name: "helm", Type: specv1.AppTypeHelm}))
+
+	for _, invalid := range []string{"maxResources: {cpu: x}", "forbiddenEnv: [{value: \"(\"}]", "forbiddenEnv: [{}]"} {
+		assert.NoError(t, os.WriteFile(file, []byte(invalid), 0644))
+		_, err = loadAdmissionPolicy(file)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAdmissionReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yml")
+	adm := newAdmission(file)
+	app := &specv1.Application{Name: "app", Version: "1", HostNetwork: true}
+
+	// all the apps are admitted without the policy
+	assert.NoError(t, adm.admit(app))
+
+	assert.NoError(t, os.WriteFile(file, []byte("denyHostNetwork: true\n"), 0644))
+	err := adm.admit(app)
+	ae, ok := asAdmissionError(err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"host network is denied"}, ae.Violations)
+	assert.EqualError(t, err, "app (app) version (1) is rejected by policy: host network is denied")
+
+	// the system apps are always admitted
+	assert.NoError(t, adm.admit(&specv1.Application{Name: "baetyl-core", HostNetwork: true, System: true}))
+
+	// the previous policy is kept if the file is invalid
+	assert.NoError(t, os.WriteFile(file, []byte("maxResources: {cpu: x}\n"), 0644))
+	assert.Error(t, adm.admit(app))
+
+	assert.NoError(t, os.WriteFile(file, []byte("denyHostNetwork: false\n"), 0644))
+	assert.NoError(t, adm.admit(app))
+
+	assert.NoError(t, os.WriteFile(file, []byte("denyHostNetwork: true\n"), 0644))
+	assert.Error(t, adm.admit(app))
+	assert.NoError(t, os.Remove(file))
+	assert.NoError(t, adm.admit(app))
+
+	var nilAdm *admission
+	assert.NoError(t, nilAdm.admit(app))
+}
+
+func TestEngineImpl_checkAdmission(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yml")
+	assert.NoError(t, os.WriteFile(file, []byte("denyPrivileged: true\n"), 0644))
+	eng := engineImpl{
+		cfg: config.Config{},
+		adm: newAdmission(file),
+		log: log.With(log.Any("engine", "test")),
+	}
+
+	infos := []specv1.AppInfo{{Name: "app", Version: "2"}, {Name: "ok", Version: "1"}, {Name: "kept", Version: "1"}}
+	apps := map[string]specv1.Application{
+		"app":  {Name: "app", Version: "2", Services: []specv1.Service{{Name: "s1", SecurityContext: &specv1.SecurityContext{Privileged: true}}}},
+		"ok":   {Name: "ok", Version: "1"},
+		"kept": {Name: "kept", Version: "1", Services: []specv1.Service{{Name: "s1", SecurityContext: &specv1.SecurityContext{Privileged: true}}}},
+	}
+	// the rejected app is not applied, so its resources are not downloaded
+	update := map[string]specv1.AppInfo{"app": infos[0], "ok": infos[1]}
+	stats := map[string]specv1.AppStats{"app": {AppInfo: specv1.AppInfo{Name: "app", Version: "1"}, Status: specv1.Running}}
+	eng.checkAdmission(infos, apps, stats, update)
+	assert.Equal(t, map[string]specv1.AppInfo{"ok": infos[1]}, update)
+	assert.Equal(t, specv1.Failed, stats["app"].Status)
+	assert.Equal(t, "app (app) version (2) is rejected by policy: service (s1) is privileged", stats["app"].Cause)
+	assert.NotContains(t, stats, "kept")
+
+	// the app without the spec is held until it is synced
+	update = map[string]specv1.AppInfo{"new": {Name: "new", Version: "1"}}
+	eng.checkAdmission([]specv1.AppInfo{{Name: "new", Version: "1"}}, apps, stats, update)
+	assert.Empty(t, update)
+	assert.Equal(t, specv1.Pending, stats["new"].Status)
+	assert.Equal(t, "app (new) version (1) is not admitted without the spec", stats["new"].Cause)
+
+	// all the apps are applied without the policy
+	assert.NoError(t, os.Remove(file))
+	update = map[string]specv1.AppInfo{"new": {Name: "new", Version: "1"}}
+	eng.checkAdmission([]specv1.AppInfo{{Name: "new", Version: "1"}}, apps, stats, update)
+	assert.Len(t, update, 1)
+}
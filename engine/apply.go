@@ -0,0 +1,269 @@
+package engine
+
+import (
+	gocontext "context"
+	goerrors "errors"
+	"fmt"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+// applyTask the app being applied, which may keep running in the background after its deadline
+type applyTask struct {
+	ns      string
+	info    specv1.AppInfo
+	cancel  gocontext.CancelFunc
+	started time.Time
+	mu      gosync.Mutex
+	// late is set once the caller stops waiting, the result is recorded and reported in the next round then
+	late bool
+	err  error
+}
+
+// applyResult the result of applying the app, written to the stats by the caller
+type applyResult struct {
+	info specv1.AppInfo
+	err  error
+}
+
+// applyGroups groups the apps to apply, the apps sharing host ports in the running or desired versions
+// and the apps depending on each other are in the same group, which are applied one by one in the order
+// of the dependencies. The groups are applied concurrently
+func (e *engineImpl) applyGroups(infos map[string]specv1.AppInfo, stats map[string]specv1.AppStats) [][]specv1.AppInfo {
+	parent := map[string]string{}
+	var find func(n string) string
+	find = func(n string) string {
+		if parent[n] != n {
+			parent[n] = find(parent[n])
+		}
+		return parent[n]
+	}
+	union := func(a, b string) {
+		if ra, rb := find(a), find(b); ra != rb {
+			if ra > rb {
+				ra, rb = rb, ra
+			}
+			parent[rb] = ra
+		}
+	}
+	ports := map[int32]string{}
+	deps := map[string][]string{}
+	for name, info := range infos {
+		parent[name] = name
+		// each app is a group if the specs can not be looked up
+		if e.sto == nil {
+			continue
+		}
+		var app specv1.Application
+		if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), &app); err == nil {
+			deps[name] = dependencies(&app)
+			addHostPorts(ports, &app, name, union)
+		}
+		if stat, ok := stats[name]; ok && stat.Version != "" && stat.Version != info.Version {
+			var running specv1.Application
+			if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, name, stat.Version), &running); err == nil {
+				addHostPorts(ports, &running, name, union)
+			}
+		}
+	}
+	for name, ds := range deps {
+		for _, dep := range ds {
+			if _, ok := infos[dep]; ok {
+				union(name, dep)
+			}
+		}
+	}
+	members := map[string][]string{}
+	for name := range infos {
+		root := find(name)
+		members[root] = append(members[root], name)
+	}
+	var roots []string
+	for root := range members {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	var groups [][]specv1.AppInfo
+	for _, root := range roots {
+		names := members[root]
+		sort.Strings(names)
+		var group []specv1.AppInfo
+		visited := map[string]bool{}
+		var visit func(n string)
+		visit = func(n string) {
+			if visited[n] {
+				return
+			}
+			visited[n] = true
+			for _, dep := range deps[n] {
+				if _, ok := infos[dep]; ok {
+					visit(dep)
+				}
+			}
+			group = append(group, infos[n])
+		}
+		for _, n := range names {
+			visit(n)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func addHostPorts(ports map[int32]string, app *specv1.Application, name string, union func(a, b string)) {
+	for _, svc := range app.Services {
+		for _, p := range svc.Ports {
+			if p.HostPort == 0 {
+				continue
+			}
+			if other, ok := ports[p.HostPort]; ok {
+				union(name, other)
+			} else {
+				ports[p.HostPort] = name
+			}
+		}
+	}
+}
+
+// applyGroup applies the apps of the group one by one, the rest of the group waits if the app is
+// not applied in its deadline or cancelled, since it may be still applied in the background
+func (e *engineImpl) applyGroup(ns string, group []specv1.AppInfo, results chan<- applyResult) {
+	for i, info := range group {
+		err := e.applyWithDeadline(ns, info)
+		results <- applyResult{info: info, err: err}
+		if err != nil && (goerrors.Is(err, gocontext.DeadlineExceeded) || goerrors.Is(err, gocontext.Canceled)) {
+			for _, rest := range group[i+1:] {
+				results <- applyResult{info: rest, err: &waitingError{reason: fmt.Sprintf("waiting for the apply of app (%s) version (%s) to finish", info.Name, info.Version)}}
+			}
+			return
+		}
+	}
+}
+
+// applyWithDeadline applies the app with the deadline, the task is cancelled once the desire changes. The sync and
+// the ami stop at the steps checking the context, so the app may keep applying in the background after the caller
+// stops waiting, and its result is recorded by recordLate then
+func (e *engineImpl) applyWithDeadline(ns string, info specv1.AppInfo) error {
+	var ctx gocontext.Context
+	var cancel gocontext.CancelFunc
+	timeout := e.cfg.Engine.Apply.Timeout
+	if timeout > 0 {
+		ctx, cancel = gocontext.WithTimeout(gocontext.Background(), timeout)
+	} else {
+		ctx, cancel = gocontext.WithCancel(gocontext.Background())
+	}
+	task := &applyTask{ns: ns, info: info, cancel: cancel, started: time.Now()}
+	e.applying.Store(info.Name, task)
+	res := make(chan error, 1)
+	go func() {
+		defer cancel()
+		err := e.applyApp(ctx, ns, info)
+		task.mu.Lock()
+		late := task.late
+		if !late {
+			// the task is removed before the result is sent, not to remove the task of the next round
+			e.applying.Delete(info.Name)
+			res <- err
+		}
+		task.mu.Unlock()
+		if late {
+			e.recordLate(task, err)
+			e.applying.Delete(info.Name)
+		}
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		task.mu.Lock()
+		defer task.mu.Unlock()
+		// the task is cancelled once it returns, the result is sent before
+		select {
+		case err := <-res:
+			return err
+		default:
+		}
+		task.late = true
+		if goerrors.Is(ctx.Err(), gocontext.DeadlineExceeded) {
+			return errors.Trace(fmt.Errorf("app (%s) version (%s) is not applied in %s: %w", info.Name, info.Version, timeout, ctx.Err()))
+		}
+		return errors.Trace(fmt.Errorf("app (%s) version (%s) is cancelled: %w", info.Name, info.Version, ctx.Err()))
+	}
+}
+
+// recordLate records the result of the app applied after the caller stops waiting, the rollback and the forced
+// update are recorded at once, and the failure is reported in the next round
+func (e *engineImpl) recordLate(task *applyTask, err error) {
+	if err == nil {
+		e.log.Info("application is applied after the deadline", log.Any("app", task.info.Name), log.Any("version", task.info.Version))
+		e.appliedForced(task.info)
+		return
+	}
+	e.log.Warn("application is not applied after the deadline", log.Any("app", task.info.Name), log.Any("version", task.info.Version), log.Error(err))
+	if rb, ok := ami.AsRollbackError(err); ok {
+		e.recordRollback(task.ns, rb)
+	}
+	task.err = err
+	e.late.Store(task.info.Name, task)
+}
+
+// reportLate reports the failures of the apps applied after the caller stopped waiting in the last rounds
+func (e *engineImpl) reportLate(ns string, stats map[string]specv1.AppStats) {
+	e.late.Range(func(k, v interface{}) bool {
+		task := v.(*applyTask)
+		if task.ns != ns {
+			return true
+		}
+		e.late.Delete(k)
+		e.applyFailed(task.info, task.err, stats)
+		return true
+	})
+}
+
+// skipApplying keeps the apps still applied in the background from being applied again, and cancels
+// the tasks of the versions not desired any more, such as the desire changes in the middle of applying.
+// The new versions are applied after the cancelled tasks finish
+func (e *engineImpl) skipApplying(ns string, infos []specv1.AppInfo, update map[string]specv1.AppInfo, stats map[string]specv1.AppStats) {
+	desired := map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		desired[info.Name] = info
+	}
+	e.applying.Range(func(k, v interface{}) bool {
+		name, task := k.(string), v.(*applyTask)
+		if task.ns != ns {
+			return true
+		}
+		cause := fmt.Sprintf("applying version (%s) since %s", task.info.Version, task.started.Format(time.RFC3339))
+		if info, ok := desired[name]; !ok || info.Version != task.info.Version {
+			e.log.Info("cancel applying application", log.Any("app", name), log.Any("version", task.info.Version))
+			task.cancel()
+			cause = fmt.Sprintf("waiting for the apply of version (%s) to be cancelled", task.info.Version)
+		}
+		if _, ok := update[name]; !ok {
+			return true
+		}
+		delete(update, name)
+		stat := stats[name]
+		stat.Status = specv1.Pending
+		stat.Cause = cause
+		stats[name] = stat
+		return true
+	})
+}
+
+// waitingError the app is not applied since the app applied before it in the group is not finished
+type waitingError struct {
+	reason string
+}
+
+func (e *waitingError) Error() string {
+	return e.reason
+}
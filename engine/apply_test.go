@@ -0,0 +1,172 @@
+package engine
+
+import (
+	gocontext "context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/mock"
+	"github.com/baetyl/baetyl/v2/store"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+func TestEngineImpl_applyGroups(t *testing.T) {
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	eng := &engineImpl{sto: sto, log: log.With(log.Any("engine", "test"))}
+
+	port := func(name, version string, hostPort int32) specv1.Application {
+		return specv1.Application{Name: name, Version: version, Services: []specv1.Service{{
+			Name:  "s",
+			Ports: []specv1.ContainerPort{{HostPort: hostPort, ContainerPort: 80}},
+		}}}
+	}
+	apps := []specv1.Application{
+		port("a", "v2", 80),
+		port("b", "v1", 80),
+		port("b", "v2", 81),
+		{Name: "c", Version: "v1", Labels: map[string]string{ami.AppDependsOn: "d"}},
+		{Name: "d", Version: "v1"},
+		{Name: "e", Version: "v1"},
+	}
+	for i := range apps {
+		assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, apps[i].Name, apps[i].Version), &apps[i]))
+	}
+	infos := map[string]specv1.AppInfo{
+		"a": {Name: "a", Version: "v2"},
+		"b": {Name: "b", Version: "v2"},
+		"c": {Name: "c", Version: "v1"},
+		"d": {Name: "d", Version: "v1"},
+		"e": {Name: "e", Version: "v1"},
+		"f": {Name: "f", Version: "v1"},
+	}
+	// the running version of b still holds the port 80 which the new version of a takes
+	stats := map[string]specv1.AppStats{"b": {AppInfo: specv1.AppInfo{Name: "b", Version: "v1"}, Status: specv1.Running}}
+	assert.Equal(t, [][]specv1.AppInfo{
+		{infos["a"], infos["b"]},
+		{infos["d"], infos["c"]},
+		{infos["e"]},
+		{infos["f"]},
+	}, eng.applyGroups(infos, stats))
+}
+
+func TestEngineImpl_applyAppsTimeout(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockSync := mock.NewMockSync(mockCtl)
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	eng := &engineImpl{
+		cfg: config.Config{},
+		syn: mockSync,
+		sto: sto,
+		log: log.With(log.Any("engine", "test")),
+	}
+	eng.cfg.Engine.Apply.Concurrency = 2
+	eng.cfg.Engine.Apply.Timeout = 50 * time.Millisecond
+
+	slow, fast := specv1.AppInfo{Name: "slow", Version: "v1"}, specv1.AppInfo{Name: "fast", Version: "v1"}
+	release := make(chan struct{})
+	mockSync.EXPECT().SyncResource(gomock.Any(), slow).DoAndReturn(func(gocontext.Context, specv1.AppInfo) error {
+		<-release
+		return nil
+	}).Times(1)
+	mockSync.EXPECT().SyncResource(gomock.Any(), fast).Return(errors.New("sync failed")).Times(1)
+
+	stats := map[string]specv1.AppStats{}
+	eng.applyApps("default", map[string]specv1.AppInfo{"slow": slow, "fast": fast}, stats)
+	assert.Equal(t, "app (slow) version (v1) is not applied in 50ms: context deadline exceeded", stats["slow"].Cause)
+	assert.Equal(t, "sync failed", stats["fast"].Cause)
+
+	// the app still applied in the background is not applied again
+	update := map[string]specv1.AppInfo{"slow": slow}
+	stats = map[string]specv1.AppStats{}
+	eng.skipApplying("default", []specv1.AppInfo{slow}, update, stats)
+	assert.Empty(t, update)
+	assert.Equal(t, specv1.Pending, stats["slow"].Status)
+	assert.Contains(t, stats["slow"].Cause, "applying version (v1) since")
+	// the other namespace is not affected
+	update = map[string]specv1.AppInfo{"slow": slow}
+	eng.skipApplying("baetyl-edge-system", []specv1.AppInfo{slow}, update, stats)
+	assert.Len(t, update, 1)
+
+	// the task is cancelled once a new version is desired, which is applied after the task finishes
+	v2 := specv1.AppInfo{Name: "slow", Version: "v2"}
+	update = map[string]specv1.AppInfo{"slow": v2}
+	stats = map[string]specv1.AppStats{}
+	eng.skipApplying("default", []specv1.AppInfo{v2}, update, stats)
+	assert.Empty(t, update)
+	assert.Equal(t, "waiting for the apply of version (v1) to be cancelled", stats["slow"].Cause)
+	close(release)
+	assert.Eventually(t, func() bool {
+		_, ok := eng.applying.Load("slow")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	update = map[string]specv1.AppInfo{"slow": v2}
+	eng.skipApplying("default", []specv1.AppInfo{v2}, update, stats)
+	assert.Len(t, update, 1)
+
+	// the result of the cancelled task is reported in the next round
+	stats = map[string]specv1.AppStats{}
+	eng.reportLate("default", stats)
+	assert.Contains(t, stats["slow"].Cause, "context deadline exceeded")
+	stats = map[string]specv1.AppStats{}
+	eng.reportLate("default", stats)
+	assert.Empty(t, stats)
+}
+
+func TestEngineImpl_applyGroup(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockSync := mock.NewMockSync(mockCtl)
+	eng := &engineImpl{
+		cfg: config.Config{},
+		syn: mockSync,
+		log: log.With(log.Any("engine", "test")),
+	}
+	eng.cfg.Engine.Apply.Timeout = 20 * time.Millisecond
+
+	a, b := specv1.AppInfo{Name: "a", Version: "v1"}, specv1.AppInfo{Name: "b", Version: "v1"}
+	// the sync stops once the task is timed out
+	mockSync.EXPECT().SyncResource(gomock.Any(), a).DoAndReturn(func(ctx gocontext.Context, _ specv1.AppInfo) error {
+		<-ctx.Done()
+		return errors.New("sync failed")
+	}).Times(1)
+
+	// the app after the timed out app in the group waits
+	results := make(chan applyResult, 2)
+	eng.applyGroup("default", []specv1.AppInfo{a, b}, results)
+	res := <-results
+	assert.Contains(t, res.err.Error(), "is not applied in 20ms")
+	res = <-results
+	assert.Equal(t, b, res.info)
+	we, ok := res.err.(*waitingError)
+	assert.True(t, ok)
+	assert.Equal(t, "waiting for the apply of app (a) version (v1) to finish", we.reason)
+
+	stats := map[string]specv1.AppStats{}
+	eng.applyApps("default", map[string]specv1.AppInfo{}, stats)
+	assert.Empty(t, stats)
+	assert.Eventually(t, func() bool {
+		_, ok := eng.applying.Load("a")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// the failure after the deadline is not dropped
+	stats = map[string]specv1.AppStats{}
+	eng.reportLate("baetyl-edge-system", stats)
+	assert.Empty(t, stats)
+	eng.reportLate("default", stats)
+	assert.Equal(t, "sync failed", stats["a"].Cause)
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+// the cause prefix of the apps kept from being applied until their dependencies are running
+const waitingForDependency = "waiting for dependency"
+
+// dependencies the apps the app depends on
+func dependencies(app *specv1.Application) []string {
+	var deps []string
+	for _, dep := range strings.Split(app.Labels[ami.AppDependsOn], ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// checkDependencies keeps the apps from being applied until the apps they depend on run the desired versions,
+// so the apps are applied in the topological order of the dependencies round by round. The dependencies
+// not desired in the namespace are looked up in the other namespace, and the apps in a cycle are never applied
+func checkDependencies(infos []specv1.AppInfo, apps map[string]specv1.Application, stats, others map[string]specv1.AppStats, update map[string]specv1.AppInfo) {
+	desired := map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		desired[info.Name] = info
+	}
+	cycles := dependencyCycles(infos, apps)
+	del := map[string]struct{}{}
+	for _, info := range infos {
+		if _, ok := update[info.Name]; !ok {
+			continue
+		}
+		app, ok := apps[info.Name]
+		if !ok {
+			continue
+		}
+		status, cause := specv1.Pending, ""
+		if cycle, ok := cycles[app.Name]; ok {
+			status, cause = specv1.Failed, fmt.Sprintf("dependency cycle (%s)", cycle)
+		} else {
+			var waiting []string
+			for _, dep := range dependencies(&app) {
+				if state := dependencyState(dep, desired, stats, others, update); state != "" {
+					waiting = append(waiting, state)
+				}
+			}
+			if len(waiting) == 0 {
+				continue
+			}
+			cause = fmt.Sprintf("%s: %s", waitingForDependency, strings.Join(waiting, ", "))
+		}
+		del[app.Name] = struct{}{}
+		stat, ok := stats[app.Name]
+		if !ok {
+			stat = specv1.AppStats{AppInfo: specv1.AppInfo{Name: app.Name, Version: app.Version}}
+		}
+		stat.Status = status
+		stat.Cause = cause
+		stats[app.Name] = stat
+	}
+	for n := range del {
+		delete(update, n)
+		delete(apps, n)
+	}
+}
+
+// dependencyState describes why the dependency is not ready, empty if it runs the desired version
+func dependencyState(dep string, desired map[string]specv1.AppInfo, stats, others map[string]specv1.AppStats, update map[string]specv1.AppInfo) string {
+	stat, ok := others[dep]
+	if info, found := desired[dep]; found {
+		if _, ok := update[dep]; ok {
+			return fmt.Sprintf("%s (version %s is not applied)", dep, info.Version)
+		}
+		stat, ok = stats[dep]
+	}
+	if !ok {
+		return fmt.Sprintf("%s (not found)", dep)
+	}
+	if stat.Status != specv1.Running {
+		status := stat.Status
+		if status == "" {
+			status = specv1.Unknown
+		}
+		return fmt.Sprintf("%s (%s)", dep, status)
+	}
+	return ""
+}
+
+// dependencyCycles finds the apps in the dependency cycles, with the cycle such as "a -> b -> a"
+func dependencyCycles(infos []specv1.AppInfo, apps map[string]specv1.Application) map[string]string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	cycles := map[string]string{}
+	state := map[string]int{}
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		app := apps[name]
+		for _, dep := range dependencies(&app) {
+			if _, ok := apps[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case 0:
+				visit(dep)
+			case visiting:
+				i := len(path) - 1
+				for path[i] != dep {
+					i--
+				}
+				cycle := strings.Join(append(append([]string{}, path[i:]...), dep), " -> ")
+				for _, n := range path[i:] {
+					if _, ok := cycles[n]; !ok {
+						cycles[n] = cycle
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, info := range infos {
+		if _, ok := apps[info.Name]; ok && state[info.Name] == 0 {
+			visit(info.Name)
+		}
+	}
+	return cycles
+}
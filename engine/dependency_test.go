@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"testing"
+
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	app := func(name, version, deps string) specv1.Application {
+		return specv1.Application{Name: name, Version: version, Labels: map[string]string{ami.AppDependsOn: deps}}
+	}
+	infos := []specv1.AppInfo{
+		{Name: "broker", Version: "v2"},
+		{Name: "adapter", Version: "v1"},
+		{Name: "analytics", Version: "v1"},
+		{Name: "rule", Version: "v1"},
+		{Name: "x", Version: "v1"},
+		{Name: "y", Version: "v1"},
+		{Name: "z", Version: "v1"},
+		{Name: "self", Version: "v1"},
+	}
+	apps := map[string]specv1.Application{
+		"broker":    app("broker", "v2", ""),
+		"adapter":   app("adapter", "v1", "broker, baetyl-function"),
+		"analytics": app("analytics", "v1", "adapter"),
+		"rule":      app("rule", "v1", "baetyl-function,"),
+		"x":         app("x", "v1", "y"),
+		"y":         app("y", "v1", "z"),
+		"z":         app("z", "v1", "y,missing"),
+		"self":      app("self", "v1", "self"),
+	}
+	stats := map[string]specv1.AppStats{
+		"broker": {AppInfo: specv1.AppInfo{Name: "broker", Version: "v1"}, Status: specv1.Running},
+	}
+	others := map[string]specv1.AppStats{
+		"baetyl-function": {AppInfo: specv1.AppInfo{Name: "baetyl-function", Version: "v1"}, Status: specv1.Running},
+	}
+	update := map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		update[info.Name] = info
+	}
+
+	checkDependencies(infos, apps, stats, others, update)
+	// the broker and the apps depending on the running apps only are applied first
+	assert.Equal(t, map[string]specv1.AppInfo{"broker": infos[0], "rule": infos[3]}, update)
+	assert.Equal(t, specv1.Running, stats["broker"].Status)
+	assert.Equal(t, specv1.Pending, stats["adapter"].Status)
+	assert.Equal(t, "waiting for dependency: broker (version v2 is not applied)", stats["adapter"].Cause)
+	assert.Equal(t, "waiting for dependency: adapter (version v1 is not applied)", stats["analytics"].Cause)
+	assert.Equal(t, "waiting for dependency: y (version v1 is not applied)", stats["x"].Cause)
+	assert.Equal(t, specv1.Failed, stats["y"].Status)
+	assert.Equal(t, "dependency cycle (y -> z -> y)", stats["y"].Cause)
+	assert.Equal(t, "dependency cycle (y -> z -> y)", stats["z"].Cause)
+	assert.Equal(t, "dependency cycle (self -> self)", stats["self"].Cause)
+	assert.NotContains(t, apps, "adapter")
+
+	// the adapter is applied once the broker runs the new version
+	apps["adapter"] = app("adapter", "v1", "broker, baetyl-function")
+	update = map[string]specv1.AppInfo{"adapter": infos[1]}
+	stats = map[string]specv1.AppStats{
+		"broker": {AppInfo: specv1.AppInfo{Name: "broker", Version: "v2"}, Status: specv1.Pending},
+	}
+	checkDependencies(infos, apps, stats, others, update)
+	assert.Empty(t, update)
+	assert.Equal(t, "waiting for dependency: broker (Pending)", stats["adapter"].Cause)
+
+	apps["adapter"] = app("adapter", "v1", "broker, baetyl-function")
+	update = map[string]specv1.AppInfo{"adapter": infos[1]}
+	stats["broker"] = specv1.AppStats{AppInfo: specv1.AppInfo{Name: "broker", Version: "v2"}, Status: specv1.Running}
+	checkDependencies(infos, apps, stats, map[string]specv1.AppStats{}, update)
+	assert.Empty(t, update)
+	assert.Equal(t, "waiting for dependency: baetyl-function (not found)", stats["adapter"].Cause)
+
+	apps["adapter"] = app("adapter", "v1", "broker, baetyl-function")
+	update = map[string]specv1.AppInfo{"adapter": infos[1]}
+	checkDependencies(infos, apps, stats, others, update)
+	assert.Contains(t, update, "adapter")
+}
@@ -1,11 +1,14 @@
 package engine
 
 import (
+	gocontext "context"
 	"crypto/md5"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	gosync "sync"
@@ -39,12 +42,19 @@ const (
 	SystemCertSecretPrefix = "baetyl-cert-secret-"
 )
 
+// reportKeyAppDiffs the report key of the diffs of the dry run apps
+const reportKeyAppDiffs = "appdiffs"
+
 //go:generate mockgen -destination=../mock/engine.go -package=mock -source=engine.go Engine
 
 type Engine interface {
 	Start()
 	ReportAndDesire() error
 	GetServiceLog(ctx *routing.Context) error
+	GetPlan(ctx *routing.Context) (interface{}, error)
+	GetOverrides(ctx *routing.Context) (interface{}, error)
+	SetOverride(ctx *routing.Context) (interface{}, error)
+	DeleteOverride(ctx *routing.Context) (interface{}, error)
 	Collect(ns string, isSys bool, desire specv1.Desire) specv1.Report
 	Close()
 }
@@ -66,11 +76,17 @@ type engineImpl struct {
 	downsideChan    <-chan interface{}
 	downsideProcess pubsub.Processor
 	chains          gosync.Map
+	diffs           gosync.Map // app name -> *ami.AppDiff
+	adm             *admission
+	forced          gosync.Map    // app name -> version forced to apply regardless of the maintenance windows
+	applying        gosync.Map    // app name -> *applyTask
+	late            gosync.Map    // app name -> *applyTask failed after the caller stopped waiting
+	trigger         chan struct{} // reports at once, such as for the forced updates and the local overrides
 	tomb            v2utils.Tomb
 }
 
 func NewEngine(cfg config.Config, sto *bh.Store, nod node.Node, syn sync.Sync, agentClient agent.AgentClient) (Engine, error) {
-	mode := context.RunMode()
+	mode := ami.RunMode()
 	log.L().Info("app running mode", log.Any("mode", mode))
 
 	hostPathLib, err := context.HostPathLib()
@@ -106,6 +122,8 @@ func NewEngine(cfg config.Config, sto *bh.Store, nod node.Node, syn sync.Sync, a
 		agentClient:    agentClient,
 		pb:             pl.(plugin.Pubsub),
 		chains:         gosync.Map{},
+		adm:            newAdmission(cfg.Engine.Policy.Path),
+		trigger:        make(chan struct{}, 1),
 		log:            log.With(),
 	}
 	return eng, nil
@@ -148,11 +166,32 @@ func (e *engineImpl) GetServiceLog(ctx *routing.Context) error {
 		http.RespondMsg(ctx, 400, "RequestParamInvalid", err.Error())
 		return nil
 	}
-	bytes, err := e.ami.FetchLog(ns, service, container, tail, since)
+	query, err := parseLogQuery(string(ctx.QueryArgs().Peek("follow")),
+		string(ctx.QueryArgs().Peek("sinceTime")), string(ctx.QueryArgs().Peek("regex")), &since)
+	if err != nil {
+		http.RespondMsg(ctx, 400, "RequestParamInvalid", err.Error())
+		return nil
+	}
+	fetch := grepTailLines(tail, query.regex)
+	if query.follow {
+		e.streamServiceLog(ctx, &ami.LogsOptions{
+			Namespace:    ns,
+			Name:         service,
+			Container:    container,
+			TailLines:    &fetch,
+			SinceSeconds: &since,
+			Follow:       true,
+		}, query.regex, tail)
+		return nil
+	}
+	bytes, err := e.ami.FetchLog(ns, service, container, fetch, since)
 	if err != nil {
 		http.RespondMsg(ctx, 500, "UnknownError", err.Error())
 		return nil
 	}
+	if query.regex != nil {
+		bytes = lastLines(filterLines(bytes, query.regex), tail)
+	}
 	http.Respond(ctx, 200, bytes)
 	return nil
 }
@@ -161,23 +200,83 @@ func (e *engineImpl) reporting() error {
 	e.log.Info("engine starts to report")
 	defer e.log.Info("engine has stopped reporting")
 
+	report := func() {
+		err := e.reportAndDesireAsync(true)
+		if err != nil {
+			e.log.Error("failed to report local shadow", log.Error(err))
+		} else {
+			e.log.Debug("engine reports local shadow")
+		}
+	}
+	var changes <-chan struct{}
+	if w, ok := e.ami.(ami.Watcher); ok {
+		changes = w.Changes()
+	}
+	var delay <-chan time.Time
+	var last time.Time
 	t := time.NewTicker(e.cfg.Engine.Report.Interval)
 	defer t.Stop()
 	for {
 		select {
 		case <-t.C:
-			err := e.reportAndDesireAsync(true)
-			if err != nil {
-				e.log.Error("failed to report local shadow", log.Error(err))
-			} else {
-				e.log.Debug("engine reports local shadow")
+			report()
+		case <-changes:
+			// the changes in the delay are reported together, and no more often than the minimum interval
+			if delay == nil {
+				wait := e.cfg.Engine.Report.Delay
+				if gap := e.cfg.Engine.Report.MinInterval - time.Since(last); gap > wait {
+					wait = gap
+				}
+				delay = time.After(wait)
+			}
+		case <-delay:
+			delay = nil
+			last = time.Now()
+			e.log.Debug("engine reports app changes")
+			if err := e.reportStats(); err != nil {
+				e.log.Error("failed to report app stats", log.Error(err))
 			}
+		case <-e.trigger:
+			e.log.Debug("engine reports at once")
+			report()
+			t.Reset(e.cfg.Engine.Report.Interval)
 		case <-e.tomb.Dying():
 			return nil
 		}
 	}
 }
 
+// reportStats reports the stats of the apps without applying them, the causes of the last report are kept
+// for the apps of the same versions, which are checked again at the next report
+func (e *engineImpl) reportStats() error {
+	node, err := e.nod.Get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, isSys := range []bool{true, false} {
+		ns := context.EdgeNamespace()
+		if isSys {
+			ns = context.EdgeSystemNamespace()
+		}
+		r := e.Collect(ns, isSys, node.Desire)
+		prev := map[string]specv1.AppStats{}
+		for _, s := range node.Report.AppStats(isSys) {
+			prev[s.Name] = s
+		}
+		stats := r.AppStats(isSys)
+		for i, s := range stats {
+			if p, ok := prev[s.Name]; ok && s.Cause == "" && p.Cause != "" && p.Version == s.Version {
+				stats[i].Status, stats[i].Cause = p.Status, p.Cause
+			}
+		}
+		r.SetAppStats(isSys, stats)
+		if _, err = e.nod.Report(r, false); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
 func (e *engineImpl) reportAndDesireAsync(delete bool) error {
 	node, err := e.nod.Get()
 	if err != nil {
@@ -276,6 +375,11 @@ func (e *engineImpl) reportAndApply(isSys, delete bool, desire specv1.Desire) er
 	}
 	r := e.Collect(ns, isSys, desire)
 	e.log.Debug("collect stats of node and apps", log.Any("report", r))
+	e.checkHealth(ns, r.AppStats(isSys), time.Now())
+
+	if !isSys {
+		r[reportKeyLocalOverrides] = e.localOverrides()
+	}
 
 	rapps := r.AppInfos(isSys)
 	delta, err := e.nod.Report(r, false)
@@ -283,24 +387,31 @@ func (e *engineImpl) reportAndApply(isSys, delete bool, desire specv1.Desire) er
 		return errors.Trace(err)
 	}
 	// if apps are updated, to apply new apps
-	if delta == nil {
-		return nil
+	var dapps []specv1.AppInfo
+	if delta != nil {
+		dapps = specv1.Desire(delta).AppInfos(isSys)
+	}
+	// the local overrides and the rollbacks are applied even if the desire is not changed
+	unchanged := dapps == nil
+	if unchanged && (!isSys && len(e.overrides()) > 0 || e.rollingBack(ns, rapps)) {
+		dapps = desire.AppInfos(isSys)
 	}
 	// in the case of cloud data synchronization, return from here
-	dapps := specv1.Desire(delta).AppInfos(isSys)
 	if dapps == nil {
 		return nil
 	}
+	if !isSys {
+		e.reconcileOverrides(dapps)
+		dapps = e.overrideApps(dapps)
+	}
+	e.clearRollbacks(ns, dapps)
+	dapps = e.rollbackApps(ns, dapps)
+	if del, update := getDeleteAndUpdate(dapps, rapps); unchanged && len(del) == 0 && len(update) == 0 {
+		return nil
+	}
 
 	e.log.Debug("before filter", log.Any("dapps", dapps), log.Any("rapps", rapps))
-	switch os.Getenv(context.KeySvcName) {
-	case specv1.BaetylCore:
-		dapps = filterAppNotLike(dapps, []string{specv1.BaetylCore})
-		rapps = filterAppNotLike(rapps, []string{specv1.BaetylCore})
-	case specv1.BaetylInit:
-		dapps = filterAppLike(dapps, []string{specv1.BaetylCore})
-		rapps = filterAppLike(rapps, []string{specv1.BaetylCore})
-	}
+	dapps, rapps = filterServiceApps(dapps, rapps)
 	e.log.Debug("after filter", log.Any("dapps", dapps), log.Any("rapps", rapps))
 
 	del, update := getDeleteAndUpdate(dapps, rapps)
@@ -310,14 +421,31 @@ func (e *engineImpl) reportAndApply(isSys, delete bool, desire specv1.Desire) er
 	for _, s := range r.AppStats(isSys) {
 		stats[s.Name] = s
 	}
-	appData, err := e.syn.SyncApps(dapps)
+	appData, unsynced, err := e.syncApps(dapps)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	e.holdUnsynced(unsynced, stats, update)
 	// will remove invalid app info in update
 	// multiple apps change to multiple containers , remove checkService
 	// checkService(dapps, appData, stats, update)
+	e.skipRolledBack(ns, update, stats)
+	// the apps are admitted by the node-local policy before their resources are downloaded
+	e.checkAdmission(dapps, appData, stats, update)
+	others := e.otherAppStats(isSys)
+	checkDependencies(dapps, appData, stats, others, update)
+	e.clearForced(dapps)
+	e.checkMaintenance(rapps, appData, stats, update, e.nodeWindows(desire), time.Now())
 	checkMultiAppPort(dapps, appData, stats, update)
+	if !e.cfg.Engine.Resource.Disable {
+		checkResources(dapps, appData, e.runningSpecs(stats, others), r["nodestats"], stats, others, update)
+	}
+	e.skipApplying(ns, dapps, update, stats)
+	e.reportLate(ns, stats)
+	e.skipDiffed(update, stats)
+	if !isSys {
+		r[reportKeyAppDiffs] = e.appDiffs()
+	}
 	if err = e.reportAppStatsIfNeed(isSys, r, stats); err != nil {
 		return errors.Trace(err)
 	}
@@ -330,6 +458,9 @@ func (e *engineImpl) reportAndApply(isSys, delete bool, desire specv1.Desire) er
 		}
 	}
 	e.applyApps(ns, update, stats)
+	if !isSys {
+		r[reportKeyAppDiffs] = e.appDiffs()
+	}
 	if err = e.reportAppStatsIfNeed(isSys, r, stats); err != nil {
 		return errors.Trace(err)
 	}
@@ -337,6 +468,20 @@ func (e *engineImpl) reportAndApply(isSys, delete bool, desire specv1.Desire) er
 	return nil
 }
 
+// otherAppStats the app stats of the other namespace in the node shadow, which the apps may depend on
+func (e *engineImpl) otherAppStats(isSys bool) map[string]specv1.AppStats {
+	res := map[string]specv1.AppStats{}
+	shadow, err := e.nod.Get()
+	if err != nil {
+		e.log.Warn("failed to get node shadow", log.Error(err))
+		return res
+	}
+	for _, s := range shadow.Report.AppStats(!isSys) {
+		res[s.Name] = s
+	}
+	return res
+}
+
 func (e *engineImpl) reportAppStatsIfNeed(isSys bool, r specv1.Report, stats map[string]specv1.AppStats) error {
 	if len(stats) == 0 {
 		return nil
@@ -376,26 +521,126 @@ func getDeleteAndUpdate(desires, reports []specv1.AppInfo) (map[string]specv1.Ap
 	return del, update
 }
 
+// applyApps applies the apps by the workers of the concurrency limit, the results are written to the stats
 func (e *engineImpl) applyApps(ns string, infos map[string]specv1.AppInfo, stats map[string]specv1.AppStats) {
+	groups := e.applyGroups(infos, stats)
+	workers := e.cfg.Engine.Apply.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	queue := make(chan []specv1.AppInfo, len(groups))
+	for _, g := range groups {
+		queue <- g
+	}
+	close(queue)
+	results := make(chan applyResult, len(infos))
 	var wg gosync.WaitGroup
-	for _, info := range infos {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(wg *gosync.WaitGroup, info specv1.AppInfo) {
-			if err := e.applyApp(ns, info); err != nil {
-				e.log.Error("failed to apply application", log.Any("info", info), log.Error(err))
-				stat := stats[info.Name]
-				stat.Cause += err.Error()
-				stats[info.Name] = stat
+		go func() {
+			defer wg.Done()
+			for g := range queue {
+				e.applyGroup(ns, g, results)
 			}
-			wg.Done()
-		}(&wg, info)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for res := range results {
+		info, err := res.info, res.err
+		if err == nil {
+			e.appliedForced(info)
+			continue
+		}
+		if rb, ok := ami.AsRollbackError(err); ok {
+			e.recordRollback(ns, rb)
+		}
+		e.applyFailed(info, err, stats)
+	}
+}
+
+// applyFailed writes the failure of applying the app to the stats
+func (e *engineImpl) applyFailed(info specv1.AppInfo, err error, stats map[string]specv1.AppStats) {
+	stat := stats[info.Name]
+	if pe, ok := ami.AsPendingError(err); ok {
+		e.log.Info("application is pending", log.Any("info", info), log.Any("reason", pe.Reason))
+		stat.Status = specv1.Pending
+		stat.Cause = pe.Reason
+	} else if we, ok := err.(*waitingError); ok {
+		e.log.Info("application is waiting", log.Any("info", info), log.Any("reason", we.reason))
+		stat.Status = specv1.Pending
+		stat.Cause = we.reason
+	} else {
+		e.log.Error("failed to apply application", log.Any("info", info), log.Error(err))
+		stat.Cause += err.Error()
+	}
+	stats[info.Name] = stat
+}
+
+// skipDiffed keeps the running version of the dry run apps already previewed,
+// until a new version is desired
+func (e *engineImpl) skipDiffed(update map[string]specv1.AppInfo, stats map[string]specv1.AppStats) {
+	e.diffs.Range(func(k, v interface{}) bool {
+		name, diff := k.(string), v.(*ami.AppDiff)
+		info, ok := update[name]
+		if !ok || info.Version != diff.App.Version {
+			e.diffs.Delete(name)
+			return true
+		}
+		delete(update, name)
+		stat := stats[name]
+		stat.Cause = fmt.Sprintf("dry run of version (%s): %s", diff.App.Version, diff.Summary())
+		stats[name] = stat
+		return true
+	})
+}
+
+func (e *engineImpl) diffApp(ns string, app *specv1.Application, cfgs map[string]specv1.Configuration) error {
+	differ, ok := e.ami.(ami.Differ)
+	if !ok {
+		return errors.Errorf("dry run of app (%s) is not supported in mode (%s)", app.Name, e.mode)
+	}
+	diff, err := differ.DiffApp(ns, *app, cfgs)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	wg.Wait()
+	e.diffs.Store(app.Name, diff)
+	e.log.Info("app is previewed by dry run", log.Any("app", app.Name), log.Any("version", app.Version), log.Any("diff", diff.Summary()))
+	return nil
 }
 
-func (e *engineImpl) applyApp(ns string, info specv1.AppInfo) error {
-	if err := e.syn.SyncResource(info); err != nil {
-		e.log.Error("failed to sync resource", log.Any("info", info), log.Error(err))
+// appDiffs the diffs of the dry run apps to report, sorted by app name
+func (e *engineImpl) appDiffs() []*ami.AppDiff {
+	res := []*ami.AppDiff{}
+	e.diffs.Range(func(_, v interface{}) bool {
+		res = append(res, v.(*ami.AppDiff))
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].App.Name < res[j].App.Name
+	})
+	return res
+}
+
+func (e *engineImpl) applyApp(ctx gocontext.Context, ns string, info specv1.AppInfo) error {
+	// the last known good version rolled back to is applied from its snapshot, without the cloud
+	if snap := e.rollbackSnapshot(info); snap != nil {
+		return errors.Trace(e.deployApp(ctx, ns, &snap.App, snap.Configs, snap.Secrets))
+	}
+	// the local versions are stored when overridden
+	if !isLocalVersion(info.Version) {
+		if err := e.syn.SyncResource(ctx, info); err != nil {
+			e.log.Error("failed to sync resource", log.Any("info", info), log.Error(err))
+			return errors.Trace(err)
+		}
+	}
+	// the app is not applied once the task is cancelled or timed out while syncing
+	if err := ctx.Err(); err != nil {
 		return errors.Trace(err)
 	}
 	key := utils.MakeKey(specv1.KindApplication, info.Name, info.Version)
@@ -404,61 +649,93 @@ func (e *engineImpl) applyApp(ns string, info specv1.AppInfo) error {
 	if err != nil {
 		return errors.Errorf("failed to get app name: (%s) version: (%s) with error: %s", app.Name, app.Version, err.Error())
 	}
-
-	if customNs, ok := app.Labels[specv1.CustomAppNsLabel]; ok && customNs != "" && app.Type == specv1.AppTypeYaml {
-		appInfo, err := e.getCustomAppInfo()
-		if err != nil {
-			appInfo = &kube.YamlAppInfo{
-				AppInfo: map[string]kube.CustomInfo{},
-			}
-			err = e.storeCustomAppInfo(appInfo)
-			if err != nil {
-				return errors.Errorf("failed to init custom app info: (%s) version: (%s) with error: %s", app.Name, app.Version, err.Error())
-			}
-		}
-		appInfo.AppInfo[info.Name] = kube.CustomInfo{AppInfo: info, Namespace: customNs}
-		err = e.storeCustomAppInfo(appInfo)
-		if err != nil {
-			return errors.Errorf("failed to store custom app info: (%s) version: (%s) with error: %s", app.Name, app.Version, err.Error())
-		}
+	cfgs, secs, err := e.appVolumes(app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the snapshot is copied before the app is revised, to roll back to if the next version is unhealthy
+	snap, err := newAppSnapshot(app, cfgs, secs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = e.deployApp(ctx, ns, app, cfgs, secs); err != nil {
+		return errors.Trace(err)
+	}
+	if !isDryRun(app) {
+		e.startTrial(ns, snap)
 	}
+	return nil
+}
+
+// appVolumes the configs and secrets of the app volumes found in the store
+func (e *engineImpl) appVolumes(app *specv1.Application) (map[string]specv1.Configuration, map[string]specv1.Secret, error) {
 	cfgs := make(map[string]specv1.Configuration)
 	secs := make(map[string]specv1.Secret)
 	for _, v := range app.Volumes {
 		if cfg := v.VolumeSource.Config; cfg != nil {
-			key = utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version)
+			key := utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version)
 			if key == "" {
-				return errors.Errorf("failed to get config name: (%s) version: (%s)", cfg.Name, cfg.Version)
+				return nil, nil, errors.Errorf("failed to get config name: (%s) version: (%s)", cfg.Name, cfg.Version)
 			}
 			var config specv1.Configuration
-			if err = e.sto.Get(key, &config); err != nil {
-				return errors.Errorf("failed to get config name: (%s) version: (%s) with error: %s", cfg.Name, cfg.Version, err.Error())
+			if err := e.sto.Get(key, &config); err != nil {
+				return nil, nil, errors.Errorf("failed to get config name: (%s) version: (%s) with error: %s", cfg.Name, cfg.Version, err.Error())
 			}
 			cfgs[config.Name] = config
 		} else if sec := v.VolumeSource.Secret; sec != nil {
-			key = utils.MakeKey(specv1.KindSecret, sec.Name, sec.Version)
+			key := utils.MakeKey(specv1.KindSecret, sec.Name, sec.Version)
 			if key == "" {
-				return errors.Errorf("failed to get secret name: (%s) version: (%s)", sec.Name, sec.Version)
+				return nil, nil, errors.Errorf("failed to get secret name: (%s) version: (%s)", sec.Name, sec.Version)
 			}
 			var secret specv1.Secret
-			if err = e.sto.Get(key, &secret); err != nil {
-				return errors.Errorf("failed to get secret name: (%s) version: (%s) with error: %s", sec.Name, sec.Version, err.Error())
+			if err := e.sto.Get(key, &secret); err != nil {
+				return nil, nil, errors.Errorf("failed to get secret name: (%s) version: (%s) with error: %s", sec.Name, sec.Version, err.Error())
 			}
 			secs[secret.Name] = secret
 		}
 	}
-	if err = sync.PrepareApp(e.hostHostPath, e.objectHostPath, app, cfgs); err != nil {
+	return cfgs, secs, nil
+}
+
+func isDryRun(app *specv1.Application) bool {
+	return app.Type == specv1.AppTypeYaml && app.Labels[kube.YamlDryRun] == "true"
+}
+
+// deployApp revises the app and applies it by ami
+func (e *engineImpl) deployApp(ctx gocontext.Context, ns string, app *specv1.Application, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) error {
+	if err := sync.PrepareApp(e.hostHostPath, e.objectHostPath, app, cfgs); err != nil {
 		e.log.Error("failed to revise applications", log.Any("app", app), log.Error(err))
 		return errors.Trace(err)
 	}
 	// inject system cert
 	if e.sec != nil && !strings.Contains(app.Name, specv1.BaetylCore) && !strings.Contains(app.Name, specv1.BaetylInit) {
-		if err = e.injectCert(app, secs); err != nil {
+		if err := e.injectCert(app, secs); err != nil {
 			return errors.Trace(err)
 		}
 	}
+	// the dry run app is previewed only, the running version is kept
+	if isDryRun(app) {
+		return errors.Trace(e.diffApp(ns, app, cfgs))
+	}
+	if customNs, ok := app.Labels[specv1.CustomAppNsLabel]; ok && customNs != "" && app.Type == specv1.AppTypeYaml {
+		appInfo, err := e.getCustomAppInfo()
+		if err != nil {
+			appInfo = &kube.YamlAppInfo{
+				AppInfo: map[string]kube.CustomInfo{},
+			}
+			err = e.storeCustomAppInfo(appInfo)
+			if err != nil {
+				return errors.Errorf("failed to init custom app info: (%s) version: (%s) with error: %s", app.Name, app.Version, err.Error())
+			}
+		}
+		appInfo.AppInfo[app.Name] = kube.CustomInfo{AppInfo: specv1.AppInfo{Name: app.Name, Version: app.Version}, Namespace: customNs}
+		err = e.storeCustomAppInfo(appInfo)
+		if err != nil {
+			return errors.Errorf("failed to store custom app info: (%s) version: (%s) with error: %s", app.Name, app.Version, err.Error())
+		}
+	}
 	// apply app
-	return errors.Trace(e.ami.ApplyApp(ns, *app, cfgs, secs))
+	return errors.Trace(e.ami.ApplyApp(ctx, ns, *app, cfgs, secs))
 }
 
 func (e *engineImpl) injectCert(app *specv1.Application, secs map[string]specv1.Secret) error {
@@ -575,6 +852,12 @@ func (e *engineImpl) Close() {
 	if e.downsideProcess != nil {
 		e.downsideProcess.Close()
 	}
+	// the ami which watches the apps stops watching
+	if c, ok := e.ami.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			e.log.Warn("failed to close ami", log.Error(err))
+		}
+	}
 }
 
 func genSystemCert(sec security.Security) error {
@@ -632,6 +915,19 @@ func filterDesire(desire specv1.Desire, like, notLike []string) specv1.Desire {
 	return ds
 }
 
+// filterServiceApps the apps managed by the running service, baetyl-core is managed by baetyl-init and the others by baetyl-core
+func filterServiceApps(dapps, rapps []specv1.AppInfo) ([]specv1.AppInfo, []specv1.AppInfo) {
+	switch os.Getenv(context.KeySvcName) {
+	case specv1.BaetylCore:
+		dapps = filterAppNotLike(dapps, []string{specv1.BaetylCore})
+		rapps = filterAppNotLike(rapps, []string{specv1.BaetylCore})
+	case specv1.BaetylInit:
+		dapps = filterAppLike(dapps, []string{specv1.BaetylCore})
+		rapps = filterAppLike(rapps, []string{specv1.BaetylCore})
+	}
+	return dapps, rapps
+}
+
 func filterAppLike(apps []specv1.AppInfo, like []string) []specv1.AppInfo {
 	if like == nil {
 		return apps
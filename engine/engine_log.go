@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	gocontext "context"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+const (
+	// logGrepLines the last lines searched by the regex, so that the tail lines are counted after filtering
+	logGrepLines = 10000
+	// logBacklogQuiet the backlog of a followed log ends once no line is read for the time
+	logBacklogQuiet = 200 * time.Millisecond
+	// logBacklogWait the longest time to hold the backlog of a followed log which never goes quiet
+	logBacklogWait = 2 * time.Second
+)
+
+// logQuery the query of the service log besides the tail lines and since seconds
+type logQuery struct {
+	follow bool
+	regex  *regexp.Regexp
+}
+
+// parseLogQuery parses the follow, sinceTime and regex params, the since time is converted to since seconds
+func parseLogQuery(follow, sinceTime, regex string, sinceSeconds *int64) (*logQuery, error) {
+	q := &logQuery{}
+	var err error
+	if follow != "" {
+		if q.follow, err = strconv.ParseBool(follow); err != nil {
+			return nil, errors.Errorf("The request parameter is invalid.(%s)", "follow is invalid")
+		}
+	}
+	if sinceTime != "" {
+		if *sinceSeconds > 0 {
+			return nil, errors.Errorf("The request parameter is invalid.(%s)", "only one of sinceSeconds and sinceTime can be specified")
+		}
+		t, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return nil, errors.Errorf("The request parameter is invalid.(%s)", "sinceTime is invalid")
+		}
+		// the logs since a future time are empty unless they are followed
+		*sinceSeconds = int64(time.Since(t)/time.Second) + 1
+		if *sinceSeconds < 1 {
+			*sinceSeconds = 1
+		}
+	}
+	if regex != "" {
+		if q.regex, err = regexp.Compile(regex); err != nil {
+			return nil, errors.Errorf("The request parameter is invalid.(%s)", "regex is invalid")
+		}
+	}
+	return q, nil
+}
+
+// filterLines keeps the lines matching the regex
+func filterLines(data []byte, re *regexp.Regexp) []byte {
+	if re == nil {
+		return data
+	}
+	var buf bytes.Buffer
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && re.Match(line) {
+			buf.Write(line)
+		}
+		if err != nil {
+			return buf.Bytes()
+		}
+	}
+}
+
+// grepTailLines returns the lines to read for the tail lines, all the lines are searched by the regex before the tail lines are taken
+func grepTailLines(tail int64, re *regexp.Regexp) int64 {
+	if re == nil || tail <= 0 || tail >= logGrepLines {
+		return tail
+	}
+	return logGrepLines
+}
+
+// lastLines keeps the last lines of the data
+func lastLines(data []byte, n int64) []byte {
+	if n <= 0 {
+		return data
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+// streamServiceLog follows the service log in a chunked response until the client goes away,
+// the backlog of the log is held until it goes quiet if the tail lines are counted after filtering
+func (e *engineImpl) streamServiceLog(ctx *routing.Context, opt *ami.LogsOptions, re *regexp.Regexp, tail int64) {
+	pipe := ami.Pipe{}
+	pipe.OutReader, pipe.OutWriter = io.Pipe()
+	pipe.Ctx, pipe.Cancel = gocontext.WithCancel(gocontext.Background())
+	go func() {
+		err := e.ami.RemoteLogs(opt, pipe)
+		if err != nil {
+			e.log.Warn("failed to follow service log", log.Any("service", opt.Name), log.Error(err))
+		}
+		pipe.OutWriter.CloseWithError(err)
+	}()
+
+	ctx.SetStatusCode(200)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer pipe.Cancel()
+		defer pipe.OutReader.Close()
+		lines := make(chan []byte)
+		go func() {
+			defer close(lines)
+			reader := bufio.NewReader(pipe.OutReader)
+			for {
+				line, err := reader.ReadBytes('\n')
+				if len(line) > 0 {
+					select {
+					case lines <- line:
+					case <-pipe.Ctx.Done():
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		held := re != nil && tail > 0
+		deadline := time.Now().Add(logBacklogWait)
+		quiet := time.NewTimer(logBacklogQuiet)
+		defer quiet.Stop()
+		var backlog [][]byte
+		for {
+			var line []byte
+			var ok, timeout bool
+			select {
+			case line, ok = <-lines:
+			default:
+				// each flush is sent as a chunk, the lines read together are sent together
+				if !held && w.Flush() != nil {
+					return
+				}
+				select {
+				case line, ok = <-lines:
+				case <-quiet.C:
+					timeout = true
+				}
+			}
+			if held && (timeout || !ok || time.Now().After(deadline)) {
+				held = false
+				for _, l := range backlog {
+					if _, err := w.Write(l); err != nil {
+						return
+					}
+				}
+				backlog = nil
+			}
+			if timeout {
+				continue
+			}
+			if !ok {
+				w.Flush()
+				return
+			}
+			if held {
+				if !quiet.Stop() {
+					<-quiet.C
+				}
+				quiet.Reset(logBacklogQuiet)
+			}
+			if re != nil && !re.Match(line) {
+				continue
+			}
+			if held {
+				if backlog = append(backlog, line); int64(len(backlog)) > tail {
+					backlog = backlog[1:]
+				}
+				continue
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -1,10 +1,12 @@
 package engine
 
 import (
+	gocontext "context"
 	"crypto/md5"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	bh "github.com/timshannon/bolthold"
 	"github.com/valyala/fasthttp"
 
+	"github.com/baetyl/baetyl/v2/ami"
 	"github.com/baetyl/baetyl/v2/ami/kube"
 	"github.com/baetyl/baetyl/v2/utils"
 
@@ -181,7 +184,7 @@ func TestApplyApp(t *testing.T) {
 		log: log.With(log.Any("engine", "test")),
 	}
 	assert.NotNil(t, eng)
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(nil)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(nil)
 	app := specv1.Application{
 		Name:     "app1",
 		Version:  "v1",
@@ -219,28 +222,28 @@ func TestApplyApp(t *testing.T) {
 	k = utils.MakeKey(specv1.KindSecret, "sec1", "s1")
 	err = sto.Upsert(k, sec)
 	assert.NoError(t, err)
-	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 	info := specv1.AppInfo{Name: "app1", Version: "v1"}
-	err = eng.applyApp(ns, info)
+	err = eng.applyApp(gocontext.Background(), ns, info)
 	assert.NoError(t, err)
 
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(errors.New("failed to sync resource"))
-	err = eng.applyApp(ns, info)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(errors.New("failed to sync resource"))
+	err = eng.applyApp(gocontext.Background(), ns, info)
 	assert.Error(t, err)
 
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(nil)
-	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply configuration"))
-	err = eng.applyApp(ns, info)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(nil)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply configuration"))
+	err = eng.applyApp(gocontext.Background(), ns, info)
 	assert.Error(t, err)
 
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(nil)
-	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply secret"))
-	err = eng.applyApp(ns, info)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(nil)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply secret"))
+	err = eng.applyApp(gocontext.Background(), ns, info)
 	assert.Error(t, err)
 
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(nil)
-	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply application"))
-	err = eng.applyApp(ns, info)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(nil)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to apply application"))
+	err = eng.applyApp(gocontext.Background(), ns, info)
 	assert.Error(t, err)
 	eng.Close()
 }
@@ -286,9 +289,9 @@ func TestReportAndApply(t *testing.T) {
 	assert.NoError(t, err)
 	app3 := specv1.Application{Name: "app3", Version: "v3"}
 	err = sto.Upsert(utils.MakeKey(specv1.KindApplication, "app3", "v3"), app3)
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(nil)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(nil)
 	mockSync.EXPECT().SyncApps(gomock.Any()).Return(nil, nil)
-	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 	mockAmi.EXPECT().DeleteApp(gomock.Any(), gomock.Any()).Return(nil)
 	err = eng.reportAndApply(false, true, nil)
 	assert.NoError(t, err)
@@ -422,6 +425,75 @@ func TestGetServiceLog(t *testing.T) {
 	assert.Equal(t, resp4.StatusCode(), 400)
 }
 
+func TestGetServiceLogFilter(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockAmi := mock.NewMockAMI(mockCtl)
+	e := engineImpl{
+		ami: mockAmi,
+		cfg: config.Config{},
+		log: log.With(log.Any("engine", "any")),
+	}
+
+	router := routing.New()
+	router.Get("/services/<service>/log", e.GetServiceLog)
+	go fasthttp.ListenAndServe(":50031", router.HandleRequest)
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(path string) *fasthttp.Response {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI("http://127.0.0.1:50031" + path)
+		req.Header.SetMethod("GET")
+		assert.NoError(t, fasthttp.Do(req, resp))
+		return resp
+	}
+
+	mockAmi.EXPECT().FetchLog("baetyl-edge", "service1", "", int64(logGrepLines), int64(60)).Return([]byte("info a\nerror b\ninfo c\nerror d"), nil).Times(1)
+	resp := get("/services/service1/log?tailLines=10&sinceSeconds=60&regex=%5Eerror")
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, "error b\nerror d", string(resp.Body()))
+
+	// the tail lines are counted after filtering
+	mockAmi.EXPECT().FetchLog("baetyl-edge", "service1", "", int64(logGrepLines), int64(60)).Return([]byte("error a\nerror b\ninfo c\ninfo d\n"), nil).Times(1)
+	resp = get("/services/service1/log?tailLines=2&sinceSeconds=60&regex=%5Eerror")
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, "error a\nerror b\n", string(resp.Body()))
+
+	// follow in chunked response
+	mockAmi.EXPECT().RemoteLogs(gomock.Any(), gomock.Any()).DoAndReturn(func(opt *ami.LogsOptions, pipe ami.Pipe) error {
+		assert.Equal(t, "service1", opt.Name)
+		assert.True(t, opt.Follow)
+		assert.Equal(t, int64(logGrepLines), *opt.TailLines)
+		assert.True(t, *opt.SinceSeconds > 0)
+		_, err := pipe.OutWriter.Write([]byte("error a\ninfo a\nerror b\n"))
+		assert.NoError(t, err)
+		_, err = pipe.OutWriter.Write([]byte("error c\n"))
+		assert.NoError(t, err)
+		// the lines after the backlog are all sent
+		time.Sleep(2 * logBacklogQuiet)
+		_, err = pipe.OutWriter.Write([]byte("error d\nerror e\n"))
+		assert.NoError(t, err)
+		return nil
+	}).Times(1)
+	resp = get("/services/service1/log?tailLines=2&follow=true&regex=error&sinceTime=" + time.Now().Add(-time.Minute).Format(time.RFC3339))
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, "error b\nerror c\nerror d\nerror e\n", string(resp.Body()))
+	assert.Equal(t, "chunked", string(resp.Header.Peek("Transfer-Encoding")))
+
+	for _, q := range []string{"follow=x", "regex=%5B", "sinceTime=x", "sinceTime=2023-01-01T00:00:00Z&sinceSeconds=10"} {
+		resp = get("/services/service1/log?" + q)
+		assert.Equal(t, 400, resp.StatusCode(), q)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	assert.Equal(t, "b\nc\n", string(lastLines([]byte("a\nb\nc\n"), 2)))
+	assert.Equal(t, "b\nc", string(lastLines([]byte("a\nb\nc"), 2)))
+	assert.Equal(t, "a\nb", string(lastLines([]byte("a\nb"), 5)))
+	assert.Equal(t, "a\nb", string(lastLines([]byte("a\nb"), 0)))
+}
+
 func TestInjectCert(t *testing.T) {
 	nod, _, sto := prepare(t)
 	mockCtl := gomock.NewController(t)
@@ -597,13 +669,142 @@ func TestEngineImpl_applyApps(t *testing.T) {
 		"core": {},
 	}
 
-	mockSync.EXPECT().SyncResource(gomock.Any()).Return(os.ErrInvalid).Times(1)
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(os.ErrInvalid).Times(1)
 
 	eng.applyApps(ns, infos, stats)
 
 	assert.Equal(t, stats["core"].Cause, os.ErrInvalid.Error())
 }
 
+func TestEngineImpl_skipRolledBack(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockSync := mock.NewMockSync(mockCtl)
+	mockAmi := mock.NewMockAMI(mockCtl)
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	eng := engineImpl{
+		cfg: config.Config{},
+		syn: mockSync,
+		ami: mockAmi,
+		sto: sto,
+		log: log.With(log.Any("engine", "test")),
+	}
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, "app", "1"), &specv1.Application{Name: "app", Version: "1"}))
+
+	ns := "default"
+	rb := &ami.RollbackError{
+		App:      specv1.AppInfo{Name: "app", Version: "2"},
+		Previous: specv1.AppInfo{Name: "app", Version: "1"},
+		Err:      errors.New("probe failed"),
+	}
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(fmt.Errorf("apply: %w", rb)).Times(1)
+
+	stats := map[string]specv1.AppStats{}
+	eng.applyApps(ns, map[string]specv1.AppInfo{"app": rb.App}, stats)
+	assert.Contains(t, stats["app"].Cause, "rolled back to version (1)")
+
+	// the previous version kept running by ami is desired instead, the failed version is not applied again
+	dapps := eng.rollbackApps(ns, []specv1.AppInfo{rb.App})
+	assert.Equal(t, []specv1.AppInfo{rb.Previous}, dapps)
+	_, update := getDeleteAndUpdate(dapps, []specv1.AppInfo{rb.Previous})
+	stats = map[string]specv1.AppStats{}
+	eng.skipRolledBack(ns, update, stats)
+	assert.Len(t, update, 0)
+	assert.Equal(t, rb.Error(), stats["app"].Cause)
+
+	// a new version is desired
+	dapps = []specv1.AppInfo{{Name: "app", Version: "3"}}
+	eng.clearRollbacks(ns, dapps)
+	assert.Equal(t, dapps, eng.rollbackApps(ns, dapps))
+	update = map[string]specv1.AppInfo{"app": dapps[0]}
+	stats = map[string]specv1.AppStats{}
+	eng.skipRolledBack(ns, update, stats)
+	assert.Len(t, update, 1)
+	assert.Empty(t, stats["app"].Cause)
+
+	// the failed version without a version to roll back to is left, and not applied again
+	eng.recordRollback(ns, &ami.RollbackError{App: dapps[0], Previous: specv1.AppInfo{Name: "app"}, Err: errors.New("probe failed")})
+	assert.Equal(t, dapps, eng.rollbackApps(ns, dapps))
+	eng.skipRolledBack(ns, update, stats)
+	assert.Len(t, update, 0)
+	assert.Equal(t, "app (app) version (3) failed without a version to roll back to: probe failed", stats["app"].Cause)
+	h, err := eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Nil(t, h.Good)
+	assert.False(t, eng.rollingBack(ns, dapps))
+}
+
+func TestEngineImpl_applyPendingApps(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockSync := mock.NewMockSync(mockCtl)
+	eng := engineImpl{
+		cfg: config.Config{},
+		syn: mockSync,
+		log: log.With(log.Any("engine", "test")),
+	}
+
+	pe := &ami.PendingError{App: specv1.AppInfo{Name: "app", Version: "2"}, Reason: "pre-pulling images (image:v2): 0/1 nodes"}
+	mockSync.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(fmt.Errorf("apply: %w", pe)).Times(1)
+
+	stats := map[string]specv1.AppStats{"app": {AppInfo: specv1.AppInfo{Name: "app", Version: "1"}, Status: specv1.Running}}
+	eng.applyApps("default", map[string]specv1.AppInfo{"app": pe.App}, stats)
+	assert.Equal(t, specv1.Pending, stats["app"].Status)
+	assert.Equal(t, pe.Reason, stats["app"].Cause)
+}
+
+// differAMI the ami which previews the apps
+type differAMI struct {
+	*mock.MockAMI
+	diff *ami.AppDiff
+}
+
+func (d *differAMI) DiffApp(_ string, app specv1.Application, _ map[string]specv1.Configuration) (*ami.AppDiff, error) {
+	d.diff.App = specv1.AppInfo{Name: app.Name, Version: app.Version}
+	return d.diff, nil
+}
+
+func TestEngineImpl_skipDiffed(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockAmi := mock.NewMockAMI(mockCtl)
+	eng := engineImpl{
+		mode: "native",
+		cfg:  config.Config{},
+		ami:  mockAmi,
+		log:  log.With(log.Any("engine", "test")),
+	}
+	app := &specv1.Application{Name: "app", Version: "2", Type: specv1.AppTypeYaml}
+	err := eng.diffApp("default", app, nil)
+	assert.EqualError(t, err, "dry run of app (app) is not supported in mode (native)")
+
+	eng.ami = &differAMI{MockAMI: mockAmi, diff: &ami.AppDiff{Objects: []ami.ObjectDiff{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "cm1", Action: ami.DiffUpdate, Fields: []string{"data.a"}},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "cm2", Action: ami.DiffPrune},
+	}}}
+	assert.NoError(t, eng.diffApp("default", app, nil))
+	diffs := eng.appDiffs()
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, "2", diffs[0].App.Version)
+
+	// the previewed version is not applied
+	update := map[string]specv1.AppInfo{"app": {Name: "app", Version: "2"}}
+	stats := map[string]specv1.AppStats{}
+	eng.skipDiffed(update, stats)
+	assert.Len(t, update, 0)
+	assert.Equal(t, "dry run of version (2): 0 to create, 1 to update, 1 to prune", stats["app"].Cause)
+
+	// a new version is desired
+	update = map[string]specv1.AppInfo{"app": {Name: "app", Version: "3"}}
+	stats = map[string]specv1.AppStats{}
+	eng.skipDiffed(update, stats)
+	assert.Len(t, update, 1)
+	assert.Empty(t, stats["app"].Cause)
+	assert.Len(t, eng.appDiffs(), 0)
+}
+
 func Test_FilterDesire(t *testing.T) {
 	// case 0
 	like := []string{"core", "broker", "rule"}
@@ -665,3 +866,31 @@ func TestGenSystemCert(t *testing.T) {
 	err := genSystemCert(mockSec)
 	assert.Error(t, err, os.ErrExist)
 }
+
+func TestEngineImpl_reportStats(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockAmi := mock.NewMockAMI(mockCtl)
+	mockNode := mock.NewMockNode(mockCtl)
+	mockSync := mock.NewMockSync(mockCtl)
+	e := engineImpl{
+		ami: mockAmi,
+		nod: mockNode,
+		syn: mockSync,
+		cfg: config.Config{},
+		log: log.With(log.Any("engine", "test")),
+	}
+
+	// the stats of both namespaces are reported, and nothing is synced or applied
+	mockNode.EXPECT().Get().Return(&specv1.Node{}, nil).Times(1)
+	mockAmi.EXPECT().CollectNodeInfo().Return(nil, nil).Times(2)
+	mockAmi.EXPECT().CollectNodeStats().Return(nil, nil).Times(2)
+	mockAmi.EXPECT().StatsApps(context.EdgeSystemNamespace()).Return(nil, nil).Times(1)
+	mockAmi.EXPECT().StatsApps(context.EdgeNamespace()).Return(nil, nil).Times(1)
+	mockAmi.EXPECT().GetModeInfo().Return(nil, nil).Times(2)
+	mockNode.EXPECT().Report(gomock.Any(), false).Return(nil, nil).Times(2)
+	assert.NoError(t, e.reportStats())
+
+	mockNode.EXPECT().Get().Return(nil, errors.New("failed to get node")).Times(1)
+	assert.Error(t, e.reportStats())
+}
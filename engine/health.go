@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+// appSnapshot the app with its configs and secrets, which is copied to apply the app again without the cloud
+type appSnapshot struct {
+	App     specv1.Application              `json:"app"`
+	Configs map[string]specv1.Configuration `json:"configs,omitempty"`
+	Secrets map[string]specv1.Secret        `json:"secrets,omitempty"`
+}
+
+// appHealth the health record of the app persisted in the store, keyed by the app name
+type appHealth struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Good the last known good version, which has been running until the health deadline
+	Good *appSnapshot `json:"good,omitempty"`
+	// Trial the version applied and not healthy yet, which is rolled back to the good version if it fails
+	Trial   *appSnapshot `json:"trial,omitempty"`
+	Applied time.Time    `json:"applied,omitempty"`
+	// Failed the version rolled back, which is not applied again until a new version is desired
+	Failed string `json:"failed,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// newAppSnapshot copies the app, configs and secrets, which are revised when the app is applied
+func newAppSnapshot(app *specv1.Application, cfgs map[string]specv1.Configuration, secs map[string]specv1.Secret) (*appSnapshot, error) {
+	return copySnapshot(&appSnapshot{App: *app, Configs: cfgs, Secrets: secs})
+}
+
+func copySnapshot(snap *appSnapshot) (*appSnapshot, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := new(appSnapshot)
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return res, nil
+}
+
+// watchHealth checks whether the health of the app is watched, the jobs are not running
+// all the time, and the core and init can not roll back themselves
+func watchHealth(app *specv1.Application) bool {
+	return app.Workload != specv1.WorkloadJob && !strings.Contains(app.Name, specv1.BaetylCore) && !strings.Contains(app.Name, specv1.BaetylInit)
+}
+
+func (e *engineImpl) getHealth(name string) (*appHealth, error) {
+	h := new(appHealth)
+	if err := e.sto.Get(name, h); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return h, nil
+}
+
+func (e *engineImpl) healths(ns string) []*appHealth {
+	var res []*appHealth
+	err := e.sto.ForEach(nil, func(h *appHealth) error {
+		if h.Namespace == ns {
+			res = append(res, h)
+		}
+		return nil
+	})
+	if err != nil {
+		e.log.Warn("failed to list app health", log.Error(err))
+	}
+	return res
+}
+
+// startTrial starts to watch the health of the version just applied
+func (e *engineImpl) startTrial(ns string, snap *appSnapshot) {
+	if e.cfg.Engine.Health.Disable || !watchHealth(&snap.App) {
+		return
+	}
+	h, err := e.getHealth(snap.App.Name)
+	if err != nil {
+		h = &appHealth{Name: snap.App.Name}
+	}
+	if h.Good != nil && h.Good.App.Version == snap.App.Version {
+		return
+	}
+	h.Namespace, h.Trial, h.Applied = ns, snap, time.Now()
+	if err = e.sto.Upsert(h.Name, h); err != nil {
+		e.log.Warn("failed to store app health", log.Any("app", h.Name), log.Error(err))
+	}
+}
+
+// checkHealth keeps the last known good version of the apps, and marks the version on trial failed if it
+// fails or is not running until the health deadline, which is rolled back by rollbackApps
+func (e *engineImpl) checkHealth(ns string, appStats []specv1.AppStats, now time.Time) {
+	if e.cfg.Engine.Health.Disable {
+		return
+	}
+	stats := map[string]specv1.AppStats{}
+	for _, s := range appStats {
+		stats[s.Name] = s
+	}
+	records := map[string]*appHealth{}
+	for _, h := range e.healths(ns) {
+		records[h.Name] = h
+	}
+	for name, stat := range stats {
+		// the running apps not applied by the engine, such as before the core restarts, are good too
+		h, ok := records[name]
+		if stat.Status != specv1.Running || stat.Version == "" || (ok && (h.Trial != nil || h.Failed == stat.Version || h.Good != nil && h.Good.App.Version == stat.Version)) {
+			continue
+		}
+		if !ok {
+			h = &appHealth{Name: name, Namespace: ns}
+		}
+		snap, err := e.storedSnapshot(stat.AppInfo)
+		if err != nil || !watchHealth(&snap.App) {
+			continue
+		}
+		h.Good = snap
+		if err = e.sto.Upsert(h.Name, h); err != nil {
+			e.log.Warn("failed to store app health", log.Any("app", h.Name), log.Error(err))
+		}
+	}
+	deadline := e.cfg.Engine.Health.Deadline
+	for _, h := range records {
+		// the app still applied in the background is checked once the apply finishes
+		if _, ok := e.applying.Load(h.Name); h.Trial == nil || ok {
+			continue
+		}
+		stat, ok := stats[h.Name]
+		healthy := ok && stat.Version == h.Trial.App.Version && (stat.Status == specv1.Running || stat.Status == specv1.Succeeded)
+		var reason string
+		if ok && stat.Version == h.Trial.App.Version && stat.Status == specv1.Failed {
+			reason = "failed"
+		} else if now.Sub(h.Applied) < deadline {
+			continue
+		} else if !healthy {
+			reason = fmt.Sprintf("not running in %s", deadline)
+		}
+		if reason == "" {
+			h.Good, h.Trial = h.Trial, nil
+			e.log.Info("application is healthy", log.Any("app", h.Name), log.Any("version", h.Good.App.Version))
+		} else {
+			if !ok {
+				reason += " (not found)"
+			} else if stat.Cause != "" {
+				reason += fmt.Sprintf(" (%s: %s)", stat.Status, stat.Cause)
+			} else if stat.Status != "" {
+				reason += fmt.Sprintf(" (%s)", stat.Status)
+			}
+			failed := h.Trial.App.Version
+			h.Trial, h.Failed, h.Reason = nil, failed, reason
+			if h.Good == nil {
+				e.log.Warn("application is unhealthy without a version to roll back to", log.Any("app", h.Name), log.Any("version", failed), log.Any("reason", reason))
+			} else {
+				e.log.Warn("application is unhealthy and to be rolled back", log.Any("app", h.Name), log.Any("version", failed), log.Any("previous", h.Good.App.Version), log.Any("reason", reason))
+			}
+		}
+		if err := e.sto.Upsert(h.Name, h); err != nil {
+			e.log.Warn("failed to store app health", log.Any("app", h.Name), log.Error(err))
+		}
+	}
+}
+
+// recordRollback records the version rolled back by ami, which is not applied again until a new version is desired
+func (e *engineImpl) recordRollback(ns string, rb *ami.RollbackError) {
+	h, err := e.getHealth(rb.App.Name)
+	if err != nil {
+		h = &appHealth{Name: rb.App.Name}
+	}
+	h.Namespace, h.Trial, h.Failed, h.Reason = ns, nil, rb.App.Version, rb.Err.Error()
+	// the previous version kept running by ami is the version to keep, the failed version
+	// is left as it is if there is no previous version
+	if prev := rb.Previous.Version; prev == "" {
+		h.Good = nil
+	} else if h.Good == nil || h.Good.App.Version != prev {
+		h.Good, err = e.storedSnapshot(rb.Previous)
+		if err != nil {
+			e.log.Warn("failed to find the previous version rolled back to", log.Any("app", h.Name), log.Any("version", prev), log.Error(err))
+		}
+	}
+	if err = e.sto.Upsert(h.Name, h); err != nil {
+		e.log.Warn("failed to store app health", log.Any("app", h.Name), log.Error(err))
+	}
+}
+
+// rollbackApps the apps desired after the rollbacks, the failed versions are replaced by the last known
+// good versions, which are applied as the other apps
+func (e *engineImpl) rollbackApps(ns string, infos []specv1.AppInfo) []specv1.AppInfo {
+	failed := map[string]*appHealth{}
+	for _, h := range e.healths(ns) {
+		if h.Failed != "" && h.Good != nil {
+			failed[h.Name] = h
+		}
+	}
+	if len(failed) == 0 {
+		return infos
+	}
+	res := make([]specv1.AppInfo, 0, len(infos))
+	for _, info := range infos {
+		if h, ok := failed[info.Name]; ok && h.Failed == info.Version {
+			info.Version = h.Good.App.Version
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+// rollingBack checks whether a failed version is still running, which is rolled back even if the desire is not changed
+func (e *engineImpl) rollingBack(ns string, rapps []specv1.AppInfo) bool {
+	running := map[string]string{}
+	for _, r := range rapps {
+		running[r.Name] = r.Version
+	}
+	for _, h := range e.healths(ns) {
+		if h.Failed != "" && h.Good != nil && running[h.Name] != h.Good.App.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// rollbackSnapshot the snapshot to apply if the version is the last known good version rolled back to
+func (e *engineImpl) rollbackSnapshot(info specv1.AppInfo) *appSnapshot {
+	if e.sto == nil {
+		return nil
+	}
+	h, err := e.getHealth(info.Name)
+	if err != nil || h.Failed == "" || h.Good == nil || h.Good.App.Version != info.Version {
+		return nil
+	}
+	snap, err := copySnapshot(h.Good)
+	if err != nil {
+		e.log.Warn("failed to copy the snapshot of app", log.Any("app", info.Name), log.Error(err))
+		return nil
+	}
+	return snap
+}
+
+// storedSnapshot the snapshot of the app version found in the store
+func (e *engineImpl) storedSnapshot(info specv1.AppInfo) (*appSnapshot, error) {
+	app := new(specv1.Application)
+	if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), app); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfgs, secs, err := e.appVolumes(app)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &appSnapshot{App: *app, Configs: cfgs, Secrets: secs}, nil
+}
+
+// clearRollbacks deletes the records of the apps not desired any more, and clears the failed versions once
+// new versions are desired
+func (e *engineImpl) clearRollbacks(ns string, infos []specv1.AppInfo) {
+	desired := map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		desired[info.Name] = info
+	}
+	for _, h := range e.healths(ns) {
+		info, ok := desired[h.Name]
+		if !ok {
+			if err := e.sto.Delete(h.Name, appHealth{}); err != nil {
+				e.log.Warn("failed to delete app health", log.Any("app", h.Name), log.Error(err))
+			}
+			continue
+		}
+		if h.Failed == "" || info.Version == h.Failed {
+			continue
+		}
+		h.Failed, h.Reason = "", ""
+		if err := e.sto.Upsert(h.Name, h); err != nil {
+			e.log.Warn("failed to store app health", log.Any("app", h.Name), log.Error(err))
+		}
+	}
+}
+
+// skipRolledBack reports the rollbacks, the failed versions without a version to roll back to are not applied
+// again, and left as they are until a new version is desired
+func (e *engineImpl) skipRolledBack(ns string, update map[string]specv1.AppInfo, stats map[string]specv1.AppStats) {
+	for _, h := range e.healths(ns) {
+		if h.Failed == "" {
+			continue
+		}
+		rb := &ami.RollbackError{
+			App:      specv1.AppInfo{Name: h.Name, Version: h.Failed},
+			Previous: specv1.AppInfo{Name: h.Name},
+			Err:      errors.New(h.Reason),
+		}
+		if h.Good != nil {
+			rb.Previous.Version = h.Good.App.Version
+		}
+		if info, ok := update[h.Name]; ok {
+			if info.Version == h.Failed {
+				delete(update, h.Name)
+			} else {
+				e.log.Info("roll back application", log.Any("app", h.Name), log.Any("version", h.Failed), log.Any("previous", info.Version))
+			}
+		}
+		stat := stats[h.Name]
+		stat.Cause = rb.Error()
+		stats[h.Name] = stat
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	gocontext "context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/mock"
+	"github.com/baetyl/baetyl/v2/store"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+func TestEngineImpl_checkHealth(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockAmi := mock.NewMockAMI(mockCtl)
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	eng := &engineImpl{
+		cfg:            config.Config{},
+		ami:            mockAmi,
+		sto:            sto,
+		hostHostPath:   t.TempDir(),
+		objectHostPath: t.TempDir(),
+		log:            log.With(log.Any("engine", "test")),
+	}
+	eng.cfg.Engine.Health.Deadline = 5 * time.Minute
+	ns := "baetyl-edge"
+
+	cfg := specv1.Configuration{Name: "cfg", Version: "1", Data: map[string]string{"a": "b"}}
+	v1 := specv1.Application{Name: "app", Version: "v1", Volumes: []specv1.Volume{
+		{Name: "cfg", VolumeSource: specv1.VolumeSource{Config: &specv1.ObjectReference{Name: "cfg", Version: "1"}}},
+	}}
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, v1.Name, v1.Version), &v1))
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version), &cfg))
+	stat := func(version string, status specv1.Status, cause string) []specv1.AppStats {
+		return []specv1.AppStats{{AppInfo: specv1.AppInfo{Name: "app", Version: version}, Status: status, Cause: cause}}
+	}
+
+	// the running version is kept as the last known good version
+	now := time.Now()
+	eng.checkHealth(ns, stat("v1", specv1.Running, ""), now)
+	h, err := eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", h.Good.App.Version)
+	assert.Equal(t, cfg, h.Good.Configs["cfg"])
+
+	// the new version is watched until the deadline
+	v2 := specv1.Application{Name: "app", Version: "v2"}
+	snap, err := newAppSnapshot(&v2, nil, nil)
+	assert.NoError(t, err)
+	eng.startTrial(ns, snap)
+	eng.checkHealth(ns, stat("v2", specv1.Pending, "back-off"), now.Add(time.Minute))
+	h, err = eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", h.Trial.App.Version)
+
+	// the version not running until the deadline is marked failed, and rolled back to the good version
+	eng.checkHealth(ns, stat("v2", specv1.Pending, "back-off"), now.Add(6*time.Minute))
+	h, err = eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Nil(t, h.Trial)
+	assert.Equal(t, "v1", h.Good.App.Version)
+	assert.Equal(t, "v2", h.Failed)
+	assert.Equal(t, "not running in 5m0s (Pending: back-off)", h.Reason)
+	rapps := []specv1.AppInfo{{Name: "app", Version: "v2"}}
+	assert.True(t, eng.rollingBack(ns, rapps))
+	dapps := []specv1.AppInfo{{Name: "app", Version: "v2"}}
+	dapps = eng.rollbackApps(ns, dapps)
+	assert.Equal(t, []specv1.AppInfo{{Name: "app", Version: "v1"}}, dapps)
+
+	// the good version is applied as the other apps, from its snapshot with the configs
+	_, update := getDeleteAndUpdate(dapps, rapps)
+	stats := map[string]specv1.AppStats{}
+	eng.skipRolledBack(ns, update, stats)
+	assert.Equal(t, map[string]specv1.AppInfo{"app": {Name: "app", Version: "v1"}}, update)
+	assert.Equal(t, "app (app) version (v2) rolled back to version (v1): not running in 5m0s (Pending: back-off)", stats["app"].Cause)
+	mockAmi.EXPECT().ApplyApp(gomock.Any(), ns, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ gocontext.Context, _ string, app specv1.Application, cfgs map[string]specv1.Configuration, _ map[string]specv1.Secret) error {
+			assert.Equal(t, "v1", app.Version)
+			assert.Contains(t, cfgs, "cfg")
+			return nil
+		}).Times(1)
+	stats = map[string]specv1.AppStats{}
+	eng.applyApps(ns, update, stats)
+	assert.Empty(t, stats)
+
+	// the failed version is not applied again once the good version is running
+	rapps = []specv1.AppInfo{{Name: "app", Version: "v1"}}
+	assert.False(t, eng.rollingBack(ns, rapps))
+	_, update = getDeleteAndUpdate(eng.rollbackApps(ns, []specv1.AppInfo{{Name: "app", Version: "v2"}}), rapps)
+	assert.Empty(t, update)
+
+	// a new version is desired, which is promoted once running until the deadline
+	dapps = []specv1.AppInfo{{Name: "app", Version: "v3"}}
+	eng.clearRollbacks(ns, dapps)
+	assert.Equal(t, dapps, eng.rollbackApps(ns, dapps))
+	v3 := specv1.Application{Name: "app", Version: "v3"}
+	snap, err = newAppSnapshot(&v3, nil, nil)
+	assert.NoError(t, err)
+	eng.startTrial(ns, snap)
+	eng.checkHealth(ns, stat("v3", specv1.Running, ""), time.Now().Add(6*time.Minute))
+	h, err = eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Nil(t, h.Trial)
+	assert.Empty(t, h.Failed)
+	assert.Equal(t, "v3", h.Good.App.Version)
+
+	// the failed version is rolled back at once
+	v4 := specv1.Application{Name: "app", Version: "v4"}
+	snap, err = newAppSnapshot(&v4, nil, nil)
+	assert.NoError(t, err)
+	eng.startTrial(ns, snap)
+	eng.checkHealth(ns, stat("v4", specv1.Failed, ""), time.Now())
+	h, err = eng.getHealth("app")
+	assert.NoError(t, err)
+	assert.Equal(t, "v4", h.Failed)
+	assert.Equal(t, "failed (Failed)", h.Reason)
+
+	// the record is deleted once the app is not desired
+	eng.clearRollbacks(ns, nil)
+	_, err = eng.getHealth("app")
+	assert.Error(t, err)
+}
@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
+)
+
+const (
+	// NodeMaintenanceWindows the node property of the maintenance windows separated by ";", which overrides the config
+	NodeMaintenanceWindows = "baetyl-maintenance-windows"
+	// MessageCommandForceApply the downside command to apply the updates held by the maintenance windows at once,
+	// the metadata name is the app to apply, all the apps are applied if not set
+	MessageCommandForceApply = "forceApply"
+
+	// the cause prefix of the updates held until the maintenance windows
+	pendingMaintenance = "pending update"
+	// the longest window is a week, which bounds the search of the latest opening
+	maxWindowDuration = 7 * 24 * time.Hour
+)
+
+// window the maintenance window opened by the cron schedule, such as "0 22 * * 1-5 2h" opened from 22:00 to 24:00 on weekdays
+type window struct {
+	spec     string
+	minutes  []bool
+	hours    []bool
+	days     []bool
+	months   []bool
+	weekdays []bool
+	// anyDay is set if the day of month or the day of week is "*", the both days are matched then,
+	// otherwise either of them is matched as cron does
+	anyDay   bool
+	duration time.Duration
+}
+
+// parseWindow parses the window of 5 cron fields (minute, hour, day of month, month, day of week) and the duration
+func parseWindow(spec string) (*window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return nil, errors.Errorf("maintenance window (%s) should be 5 cron fields and a duration", spec)
+	}
+	w := &window{spec: strings.Join(fields, " ")}
+	var err error
+	for i, f := range []struct {
+		res      *[]bool
+		min, max int
+	}{{&w.minutes, 0, 59}, {&w.hours, 0, 23}, {&w.days, 1, 31}, {&w.months, 1, 12}, {&w.weekdays, 0, 7}} {
+		if *f.res, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return nil, errors.Errorf("maintenance window (%s) is invalid: %s", spec, err.Error())
+		}
+	}
+	// sunday is either 0 or 7
+	w.weekdays[0] = w.weekdays[0] || w.weekdays[7]
+	w.anyDay = fields[2] == "*" || fields[4] == "*"
+	if w.duration, err = time.ParseDuration(fields[5]); err != nil {
+		return nil, errors.Errorf("maintenance window (%s) is invalid: %s", spec, err.Error())
+	}
+	if w.duration <= 0 || w.duration > maxWindowDuration {
+		return nil, errors.Errorf("maintenance window (%s) is invalid: duration should be in (0, %s]", spec, maxWindowDuration)
+	}
+	return w, nil
+}
+
+// parseCronField parses the field of the lists, ranges and steps, such as "*/15", "1-5" or "0,30"
+func parseCronField(field string, min, max int) ([]bool, error) {
+	res := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("step of (%s) is invalid", part)
+			}
+			rng = part[:i]
+		}
+		start, end := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("value of (%s) is invalid", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("value of (%s) is invalid", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value of (%s) is out of range [%d, %d]", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			res[v] = true
+		}
+	}
+	return res, nil
+}
+
+// matches checks whether the window opens at the minute
+func (w *window) matches(t time.Time) bool {
+	return w.minutes[t.Minute()] && w.hours[t.Hour()] && w.matchesDay(t)
+}
+
+func (w *window) matchesDay(t time.Time) bool {
+	if !w.months[t.Month()] {
+		return false
+	}
+	day, weekday := w.days[t.Day()], w.weekdays[t.Weekday()]
+	if w.anyDay {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// contains checks whether the time is in the window opened in the duration before, the latest opening
+// is found back by the matched days, hours and minutes
+func (w *window) contains(t time.Time) bool {
+	from := t.Add(-w.duration)
+	for i := 0; ; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()-i, 0, 0, 0, 0, t.Location())
+		if !day.AddDate(0, 0, 1).After(from) {
+			return false
+		}
+		if !w.matchesDay(day) {
+			continue
+		}
+		maxHour, maxMinute := 23, 59
+		if i == 0 {
+			maxHour, maxMinute = t.Hour(), t.Minute()
+		}
+		for h := maxHour; h >= 0; h-- {
+			if !w.hours[h] {
+				continue
+			}
+			m := 59
+			if h == maxHour {
+				m = maxMinute
+			}
+			for ; m >= 0; m-- {
+				if w.minutes[m] {
+					return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, t.Location()).After(from)
+				}
+			}
+		}
+	}
+}
+
+// parseWindows parses the windows, the specs may be separated by ";"
+func parseWindows(specs []string) ([]*window, error) {
+	var res []*window
+	for _, spec := range specs {
+		for _, s := range strings.Split(spec, ";") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			w, err := parseWindow(s)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			res = append(res, w)
+		}
+	}
+	return res, nil
+}
+
+// nodeWindows the maintenance windows of the node property in the desire, or of the config if not set
+func (e *engineImpl) nodeWindows(desire specv1.Desire) []string {
+	if props, ok := desire[specv1.KeyNodeProps].(map[string]interface{}); ok {
+		if v, ok := props[NodeMaintenanceWindows].(string); ok && strings.TrimSpace(v) != "" {
+			return []string{v}
+		}
+	}
+	return e.cfg.Engine.Maintenance.Windows
+}
+
+// checkMaintenance holds the updates of the running apps outside their maintenance windows as pending, the
+// app label overrides the node windows. The new apps are installed at once since nothing is restarted, and
+// the updates forced by the downside command are applied at once as well
+func (e *engineImpl) checkMaintenance(rapps []specv1.AppInfo, apps map[string]specv1.Application, stats map[string]specv1.AppStats, update map[string]specv1.AppInfo, nodeWindows []string, now time.Time) {
+	running := map[string]string{}
+	for _, r := range rapps {
+		running[r.Name] = r.Version
+	}
+	// the windows are parsed once per round, the apps may share the same label
+	parsed := map[string][]*window{}
+	invalid := map[string]error{}
+	for name, info := range update {
+		if _, ok := running[name]; !ok {
+			continue
+		}
+		if v, ok := e.forced.Load(name); ok && v.(string) == info.Version {
+			continue
+		}
+		specs := nodeWindows
+		if app, ok := apps[name]; ok && strings.TrimSpace(app.Labels[ami.AppMaintenanceWindow]) != "" {
+			specs = []string{app.Labels[ami.AppMaintenanceWindow]}
+		}
+		key := strings.Join(specs, ";")
+		windows, ok := parsed[key]
+		err := invalid[key]
+		if !ok && err == nil {
+			if windows, err = parseWindows(specs); err != nil {
+				invalid[key] = err
+			} else {
+				parsed[key] = windows
+			}
+		}
+		if err != nil {
+			e.log.Warn("failed to parse maintenance windows, the update is not held", log.Any("app", name), log.Error(err))
+			continue
+		}
+		if len(windows) == 0 || inWindows(windows, now) {
+			continue
+		}
+		delete(update, name)
+		delete(apps, name)
+		var descs []string
+		for _, w := range windows {
+			descs = append(descs, w.spec)
+		}
+		stat, ok := stats[name]
+		if !ok {
+			stat = specv1.AppStats{AppInfo: specv1.AppInfo{Name: name, Version: running[name]}}
+		}
+		stat.Status = specv1.Pending
+		stat.Cause = fmt.Sprintf("%s to version (%s) until the maintenance window (%s)", pendingMaintenance, info.Version, strings.Join(descs, "; "))
+		stats[name] = stat
+	}
+}
+
+// clearForced clears the forced updates of the versions not desired any more, the forced updates held by
+// the other checks, such as the dependencies, are kept until they are applied
+func (e *engineImpl) clearForced(infos []specv1.AppInfo) {
+	desired := map[string]string{}
+	for _, info := range infos {
+		desired[info.Name] = info.Version
+	}
+	e.forced.Range(func(k, v interface{}) bool {
+		if ver, ok := desired[k.(string)]; ok && ver != v.(string) {
+			e.forced.Delete(k)
+		}
+		return true
+	})
+}
+
+// appliedForced clears the forced update once the version is applied, so a new version is held again
+func (e *engineImpl) appliedForced(info specv1.AppInfo) {
+	if v, ok := e.forced.Load(info.Name); ok && v.(string) == info.Version {
+		e.forced.Delete(info.Name)
+	}
+}
+
+func inWindows(windows []*window, t time.Time) bool {
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// forceApply forces the desired versions of the app, or of all the apps if the name is empty, to be applied
+// regardless of the maintenance windows, and reports at once
+func (e *engineImpl) forceApply(name string) error {
+	shadow, err := e.nod.Get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	found := false
+	for _, isSys := range []bool{true, false} {
+		for _, info := range shadow.Desire.AppInfos(isSys) {
+			if name == "" || info.Name == name {
+				e.forced.Store(info.Name, info.Version)
+				found = true
+			}
+		}
+	}
+	if !found && name != "" {
+		return errors.Errorf("app (%s) is not desired", name)
+	}
+	e.triggerReport()
+	return nil
+}
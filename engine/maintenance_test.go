@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/config"
+)
+
+func TestParseWindow(t *testing.T) {
+	// 2021-06-07 is a monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 6, day, hour, min, 30, 0, time.Local)
+	}
+	w, err := parseWindow("0 22 * * 1-5 2h")
+	assert.NoError(t, err)
+	assert.True(t, w.contains(at(7, 22, 0)))
+	assert.True(t, w.contains(at(7, 23, 59)))
+	assert.False(t, w.contains(at(8, 0, 0)))
+	assert.False(t, w.contains(at(7, 21, 59)))
+	// the window opened on friday night is still open on saturday
+	w, err = parseWindow("30 23 * * 5 1h")
+	assert.NoError(t, err)
+	assert.True(t, w.contains(at(12, 0, 15)))
+	assert.False(t, w.contains(at(12, 0, 30)))
+
+	w, err = parseWindow("*/15 2 1,15 * 0 10m")
+	assert.NoError(t, err)
+	assert.True(t, w.contains(at(1, 2, 45)))
+	assert.True(t, w.contains(at(6, 2, 9)))
+	assert.False(t, w.contains(at(6, 2, 10)))
+	assert.False(t, w.contains(at(7, 2, 0)))
+	// sunday is either 0 or 7
+	w, err = parseWindow("0 0 * * 7 1h")
+	assert.NoError(t, err)
+	assert.True(t, w.contains(at(6, 0, 30)))
+
+	// the latest opening is the same as the one found minute by minute
+	for _, spec := range []string{"*/15 2 1,15 * 0 10m", "30 23 * * 5 25h", "0 0 29 2 * 168h", "5,55 */6 * 1-3 1-5 3h"} {
+		w, err = parseWindow(spec)
+		assert.NoError(t, err)
+		for i := 0; i < 500; i++ {
+			tm := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local).Add(time.Duration(i) * 97 * time.Minute)
+			expected := false
+			for s := tm.Truncate(time.Minute); s.After(tm.Add(-w.duration)); s = s.Add(-time.Minute) {
+				if w.matches(s) {
+					expected = true
+					break
+				}
+			}
+			assert.Equal(t, expected, w.contains(tm), "%s at %s", spec, tm)
+		}
+	}
+
+	for _, spec := range []string{"0 22 * * 1-5", "60 22 * * * 1h", "0 22 * * 5-1 1h", "0 22 * * */0 1h", "0 22 * * * 0s", "0 22 * * * 8d", "a 22 * * * 1h"} {
+		_, err = parseWindow(spec)
+		assert.Error(t, err, spec)
+	}
+	ws, err := parseWindows([]string{"0 22 * * 1-5 2h; 0 0 * * 0,6 24h", ""})
+	assert.NoError(t, err)
+	assert.Len(t, ws, 2)
+	assert.True(t, inWindows(ws, at(5, 12, 0)))
+}
+
+func TestEngineImpl_checkMaintenance(t *testing.T) {
+	eng := &engineImpl{log: log.With(log.Any("engine", "test"))}
+	eng.cfg.Engine.Maintenance.Windows = []string{"0 22 * * * 2h"}
+	assert.Equal(t, []string{"0 22 * * * 2h"}, eng.nodeWindows(specv1.Desire{}))
+	windows := eng.nodeWindows(specv1.Desire{specv1.KeyNodeProps: map[string]interface{}{NodeMaintenanceWindows: "0 2 * * * 1h"}})
+	assert.Equal(t, []string{"0 2 * * * 1h"}, windows)
+
+	now := time.Date(2021, 6, 7, 12, 0, 0, 0, time.Local)
+	rapps := []specv1.AppInfo{{Name: "a", Version: "v1"}, {Name: "b", Version: "v1"}, {Name: "c", Version: "v1"}}
+	infos := []specv1.AppInfo{{Name: "a", Version: "v2"}, {Name: "b", Version: "v2"}, {Name: "c", Version: "v2"}, {Name: "d", Version: "v1"}}
+	newUpdate := func() map[string]specv1.AppInfo {
+		update := map[string]specv1.AppInfo{}
+		for _, info := range infos {
+			update[info.Name] = info
+		}
+		return update
+	}
+	apps := map[string]specv1.Application{
+		"a": {Name: "a", Version: "v2"},
+		"b": {Name: "b", Version: "v2", Labels: map[string]string{ami.AppMaintenanceWindow: "0 11 * * * 2h"}},
+		"c": {Name: "c", Version: "v2", Labels: map[string]string{ami.AppMaintenanceWindow: "invalid"}},
+		"d": {Name: "d", Version: "v1"},
+	}
+	stats := map[string]specv1.AppStats{
+		"a": {AppInfo: specv1.AppInfo{Name: "a", Version: "v1"}, Status: specv1.Running},
+	}
+	update := newUpdate()
+	eng.checkMaintenance(rapps, apps, stats, update, windows, now)
+	// the new app, the app in its own window and the app of the invalid window are applied
+	assert.Equal(t, map[string]specv1.AppInfo{"b": infos[1], "c": infos[2], "d": infos[3]}, update)
+	assert.NotContains(t, apps, "a")
+	assert.Equal(t, specv1.Pending, stats["a"].Status)
+	assert.Equal(t, "v1", stats["a"].Version)
+	assert.Equal(t, "pending update to version (v2) until the maintenance window (0 2 * * * 1h)", stats["a"].Cause)
+
+	// the forced update is applied at once
+	eng.forced.Store("a", "v2")
+	update = newUpdate()
+	eng.clearForced(infos)
+	eng.checkMaintenance(rapps, apps, stats, update, windows, now)
+	assert.Contains(t, update, "a")
+
+	// the forced update held by the other checks is kept until it is applied
+	eng.clearForced(infos)
+	_, ok := eng.forced.Load("a")
+	assert.True(t, ok)
+	eng.appliedForced(specv1.AppInfo{Name: "a", Version: "v1"})
+	_, ok = eng.forced.Load("a")
+	assert.True(t, ok)
+
+	// the forced update is cleared once applied, and the next version is held again
+	eng.appliedForced(infos[0])
+	_, ok = eng.forced.Load("a")
+	assert.False(t, ok)
+	update = newUpdate()
+	eng.checkMaintenance(rapps, apps, stats, update, windows, now)
+	assert.NotContains(t, update, "a")
+
+	// the forced update of the version not desired any more is cleared
+	eng.forced.Store("a", "v2")
+	eng.clearForced([]specv1.AppInfo{{Name: "a", Version: "v3"}})
+	_, ok = eng.forced.Load("a")
+	assert.False(t, ok)
+
+	// no windows, the updates are applied at any time
+	update = newUpdate()
+	eng.checkMaintenance(rapps, apps, stats, update, nil, now)
+	assert.Contains(t, update, "a")
+
+	eng = &engineImpl{cfg: config.Config{}, log: log.With(log.Any("engine", "test"))}
+	assert.Nil(t, eng.nodeWindows(nil))
+}
@@ -96,6 +96,11 @@ func (h *handlerDownside) OnMessage(msg interface{}) error {
 			if err != nil {
 				return errors.Trace(err)
 			}
+		case MessageCommandForceApply:
+			err := h.forceApplyApps(key, m)
+			if err != nil {
+				return errors.Trace(err)
+			}
 		default:
 			h.log.Debug("unknown command", log.Any("cmd", m.Metadata["cmd"]))
 		}
@@ -394,6 +399,16 @@ func (h *handlerDownside) describe(key string, m *v1.Message) error {
 	return nil
 }
 
+func (h *handlerDownside) forceApplyApps(key string, m *v1.Message) error {
+	if err := h.forceApply(m.Metadata["name"]); err != nil {
+		h.publishFailedMsg(key, err.Error(), m)
+		return errors.Trace(err)
+	}
+	h.log.Info("force to apply apps", log.Any("app", m.Metadata["name"]))
+	h.publishSuccessMsg(key, m)
+	return nil
+}
+
 func assembleUrl(req *v1.RPCRequest) string {
 	url := req.App
 	if !strings.Contains(url, PrefixHTTP) && !strings.Contains(url, PrefixHTTPS) {
@@ -0,0 +1,418 @@
+package engine
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+// the actions of the local overrides
+const (
+	OverrideApply  = "apply"
+	OverrideRemove = "remove"
+)
+
+const (
+	// reportKeyLocalOverrides the report key of the local overrides, the apps and configs are reported as locally modified
+	reportKeyLocalOverrides = "localoverrides"
+	// localVersionMark marks the versions of the apps and configs changed locally, such as "12-local-1a2b3c4d"
+	localVersionMark = "-local-"
+)
+
+// Override the local change of a user app or config, which is persisted and takes precedence over the cloud
+// desire while the link is down. The base is the version the cloud desires when the override is made, and
+// the cloud takes precedence again once it desires another version, so the latest change wins. The apps
+// using the overridden configs are applied as the local versions too
+type Override struct {
+	Kind    specv1.Kind           `json:"kind"`
+	Name    string                `json:"name"`
+	Action  string                `json:"action"`
+	Version string                `json:"version,omitempty"`
+	Base    string                `json:"base,omitempty"`
+	Time    time.Time             `json:"time"`
+	App     *specv1.Application   `json:"app,omitempty"`
+	Config  *specv1.Configuration `json:"config,omitempty"`
+}
+
+func overrideKey(kind specv1.Kind, name string) string {
+	return string(kind) + "-" + name
+}
+
+// localVersion the local version of the resource changed from the base version
+func localVersion(base string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if i := strings.Index(base, localVersionMark); i >= 0 {
+		base = base[:i]
+	}
+	if base == "" {
+		base = "0"
+	}
+	sum := md5.Sum(data)
+	return base + localVersionMark + hex.EncodeToString(sum[:])[:8], nil
+}
+
+func isLocalVersion(version string) bool {
+	return strings.Contains(version, localVersionMark)
+}
+
+// overrides the local overrides sorted by the kinds and names
+func (e *engineImpl) overrides() []*Override {
+	var res []*Override
+	err := e.sto.ForEach(nil, func(o *Override) error {
+		res = append(res, o)
+		return nil
+	})
+	if err != nil {
+		e.log.Warn("failed to list local overrides", log.Error(err))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// GetOverrides returns the local overrides
+func (e *engineImpl) GetOverrides(_ *routing.Context) (interface{}, error) {
+	res := e.overrides()
+	if res == nil {
+		res = []*Override{}
+	}
+	return res, nil
+}
+
+// SetOverride installs or updates the app, removes the app with the action "remove", or updates the config locally,
+// the kind of the path is "apps" or "configs"
+func (e *engineImpl) SetOverride(ctx *routing.Context) (interface{}, error) {
+	kind, name := ctx.Param("kind"), ctx.Param("name")
+	shadow, err := e.nod.Get()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dapps := shadow.Desire.AppInfos(false)
+	o := &Override{Name: name, Action: OverrideApply, Time: time.Now()}
+	switch kind {
+	case "apps":
+		o.Kind = specv1.KindApplication
+		for _, info := range dapps {
+			if info.Name == name {
+				o.Base = info.Version
+			}
+		}
+		if string(ctx.QueryArgs().Peek("action")) == OverrideRemove {
+			o.Action = OverrideRemove
+			break
+		}
+		var app specv1.Application
+		if err = json.Unmarshal(ctx.Request.Body(), &app); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = e.prepareOverrideApp(o, &app); err != nil {
+			return nil, errors.Trace(err)
+		}
+	case "configs":
+		o.Kind = specv1.KindConfiguration
+		o.Base = e.cloudConfigVersion(dapps, name)
+		var cfg specv1.Configuration
+		if err = json.Unmarshal(ctx.Request.Body(), &cfg); err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.Name = name
+		if o.Version, err = localVersion(o.Base, cfg.Data); err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.Version = o.Version
+		if err = e.sto.Upsert(utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version), &cfg); err != nil {
+			return nil, errors.Trace(err)
+		}
+		o.Config = &cfg
+	default:
+		return nil, errors.Errorf("kind (%s) of the local override is not supported, should be apps or configs", kind)
+	}
+	if err = e.sto.Upsert(overrideKey(o.Kind, o.Name), o); err != nil {
+		return nil, errors.Trace(err)
+	}
+	e.log.Info("override locally", log.Any("kind", o.Kind), log.Any("name", o.Name), log.Any("action", o.Action), log.Any("version", o.Version))
+	e.triggerReport()
+	return o, nil
+}
+
+// prepareOverrideApp versions the app locally and stores it, the configs and secrets of the volumes
+// should be found in the store, the configs without versions refer to the local configs
+func (e *engineImpl) prepareOverrideApp(o *Override, app *specv1.Application) error {
+	if app.Name != "" && app.Name != o.Name {
+		return errors.Errorf("app name (%s) is not the name (%s) of the path", app.Name, o.Name)
+	}
+	app.Name = o.Name
+	app.Version = ""
+	for i := range app.Volumes {
+		if cfg := app.Volumes[i].Config; cfg != nil {
+			if cfg.Version == "" {
+				local := new(Override)
+				if err := e.sto.Get(overrideKey(specv1.KindConfiguration, cfg.Name), local); err != nil {
+					return errors.Errorf("config (%s) of volume (%s) has no version and is not overridden locally", cfg.Name, app.Volumes[i].Name)
+				}
+				cfg.Version = local.Version
+			}
+			if err := e.sto.Get(utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version), &specv1.Configuration{}); err != nil {
+				return errors.Errorf("config (%s) version (%s) of volume (%s) is not found", cfg.Name, cfg.Version, app.Volumes[i].Name)
+			}
+		} else if sec := app.Volumes[i].Secret; sec != nil {
+			if err := e.sto.Get(utils.MakeKey(specv1.KindSecret, sec.Name, sec.Version), &specv1.Secret{}); err != nil {
+				return errors.Errorf("secret (%s) version (%s) of volume (%s) is not found", sec.Name, sec.Version, app.Volumes[i].Name)
+			}
+		}
+	}
+	version, err := localVersion(o.Base, app)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	app.Version = version
+	if err = e.sto.Upsert(utils.MakeKey(specv1.KindApplication, app.Name, app.Version), app); err != nil {
+		return errors.Trace(err)
+	}
+	o.Version, o.App = version, app
+	return nil
+}
+
+// DeleteOverride deletes the local override, the cloud desire is applied again
+func (e *engineImpl) DeleteOverride(ctx *routing.Context) (interface{}, error) {
+	kind, name := ctx.Param("kind"), ctx.Param("name")
+	var k specv1.Kind
+	switch kind {
+	case "apps":
+		k = specv1.KindApplication
+	case "configs":
+		k = specv1.KindConfiguration
+	default:
+		return nil, errors.Errorf("kind (%s) of the local override is not supported, should be apps or configs", kind)
+	}
+	if err := e.sto.Delete(overrideKey(k, name), Override{}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	e.log.Info("revert local override", log.Any("kind", k), log.Any("name", name))
+	e.triggerReport()
+	return map[string]string{"kind": string(k), "name": name}, nil
+}
+
+// cloudConfigVersion the version of the config the cloud apps refer to, empty if not referred or not synced
+func (e *engineImpl) cloudConfigVersion(infos []specv1.AppInfo, name string) string {
+	for _, info := range infos {
+		if isLocalVersion(info.Version) {
+			continue
+		}
+		var app specv1.Application
+		if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), &app); err != nil {
+			continue
+		}
+		for _, v := range app.Volumes {
+			if cfg := v.Config; cfg != nil && cfg.Name == name {
+				return cfg.Version
+			}
+		}
+	}
+	return ""
+}
+
+// reconcileOverrides drops the overrides superseded by the cloud, which desires other versions than the bases
+func (e *engineImpl) reconcileOverrides(infos []specv1.AppInfo) {
+	desired := map[string]string{}
+	for _, info := range infos {
+		desired[info.Name] = info.Version
+	}
+	for _, o := range e.overrides() {
+		var current string
+		switch o.Kind {
+		case specv1.KindApplication:
+			current = desired[o.Name]
+		case specv1.KindConfiguration:
+			if current = e.cloudConfigVersion(infos, o.Name); current == "" {
+				continue
+			}
+		}
+		if current == o.Base {
+			continue
+		}
+		e.log.Info("local override is superseded by the cloud", log.Any("kind", o.Kind), log.Any("name", o.Name), log.Any("base", o.Base), log.Any("desire", current))
+		if err := e.sto.Delete(overrideKey(o.Kind, o.Name), Override{}); err != nil {
+			e.log.Warn("failed to delete local override", log.Any("name", o.Name), log.Error(err))
+		}
+	}
+}
+
+// overrideApps the user apps desired after the local overrides, the apps using the overridden
+// configs are revised to the local versions
+func (e *engineImpl) overrideApps(infos []specv1.AppInfo) []specv1.AppInfo {
+	ovs := e.overrides()
+	if len(ovs) == 0 {
+		return infos
+	}
+	apps := map[string]*Override{}
+	cfgs := map[string]*Override{}
+	for _, o := range ovs {
+		if o.Kind == specv1.KindApplication {
+			apps[o.Name] = o
+		} else {
+			cfgs[o.Name] = o
+		}
+	}
+	res := []specv1.AppInfo{}
+	for _, info := range infos {
+		if o, ok := apps[info.Name]; ok {
+			delete(apps, info.Name)
+			if o.Action == OverrideRemove {
+				continue
+			}
+			info.Version = o.Version
+		}
+		res = append(res, info)
+	}
+	for _, o := range ovs {
+		if _, ok := apps[o.Name]; ok && o.Kind == specv1.KindApplication && o.Action == OverrideApply {
+			res = append(res, specv1.AppInfo{Name: o.Name, Version: o.Version})
+		}
+	}
+	if len(cfgs) == 0 {
+		return res
+	}
+	for i, info := range res {
+		derived, err := e.deriveApp(info, cfgs)
+		if err != nil {
+			e.log.Warn("failed to revise app to the local configs", log.Any("app", info.Name), log.Error(err))
+			continue
+		}
+		res[i] = derived
+	}
+	return res
+}
+
+// deriveApp revises the app to use the local configs, the revised app is stored as a local version
+func (e *engineImpl) deriveApp(info specv1.AppInfo, cfgs map[string]*Override) (specv1.AppInfo, error) {
+	app := new(specv1.Application)
+	if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), app); err != nil {
+		// the app not synced yet is revised once it is synced
+		return info, nil
+	}
+	var locals []string
+	for i := range app.Volumes {
+		if cfg := app.Volumes[i].Config; cfg != nil {
+			if o, ok := cfgs[cfg.Name]; ok && cfg.Version != o.Version {
+				cfg.Version = o.Version
+				locals = append(locals, cfg.Name+"="+o.Version)
+			}
+		}
+	}
+	if len(locals) == 0 {
+		return info, nil
+	}
+	version, err := localVersion(info.Version, append([]string{info.Version}, locals...))
+	if err != nil {
+		return info, errors.Trace(err)
+	}
+	app.Version = version
+	key := utils.MakeKey(specv1.KindApplication, app.Name, app.Version)
+	if err = e.sto.Get(key, &specv1.Application{}); err != nil {
+		if err = e.sto.Upsert(key, app); err != nil {
+			return info, errors.Trace(err)
+		}
+	}
+	return specv1.AppInfo{Name: info.Name, Version: version}, nil
+}
+
+// localOverrides the local overrides to report, without the specs
+func (e *engineImpl) localOverrides() []Override {
+	res := []Override{}
+	for _, o := range e.overrides() {
+		o.App, o.Config = nil, nil
+		res = append(res, *o)
+	}
+	return res
+}
+
+// syncApps syncs the apps of the cloud, the local versions and the apps synced before are found in the store,
+// so the node keeps working while the link is down. The apps neither synced nor found are returned with the causes
+func (e *engineImpl) syncApps(infos []specv1.AppInfo) (map[string]specv1.Application, map[string]string, error) {
+	apps := map[string]specv1.Application{}
+	var remote []specv1.AppInfo
+	for _, info := range infos {
+		// the last known good versions rolled back to are kept in the snapshots
+		if snap := e.rollbackSnapshot(info); snap != nil {
+			apps[info.Name] = snap.App
+			continue
+		}
+		if !isLocalVersion(info.Version) {
+			remote = append(remote, info)
+			continue
+		}
+		var app specv1.Application
+		if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), &app); err != nil {
+			return nil, nil, errors.Errorf("failed to get local app (%s) version (%s): %s", info.Name, info.Version, err.Error())
+		}
+		apps[info.Name] = app
+	}
+	if len(remote) == 0 {
+		return apps, nil, nil
+	}
+	res, err := e.syn.SyncApps(remote)
+	if err != nil {
+		e.log.Warn("failed to sync apps, the apps synced before are used", log.Error(err))
+	}
+	unsynced := map[string]string{}
+	for _, info := range remote {
+		if app, ok := res[info.Name]; ok {
+			apps[info.Name] = app
+			continue
+		}
+		var app specv1.Application
+		if e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), &app) == nil {
+			apps[info.Name] = app
+			continue
+		}
+		cause := fmt.Sprintf("app (%s) version (%s) is not synced", info.Name, info.Version)
+		if err != nil {
+			cause += ": " + err.Error()
+		}
+		unsynced[info.Name] = cause
+	}
+	return apps, unsynced, nil
+}
+
+// holdUnsynced holds the updates of the apps not synced as pending, which are not checked without their specs
+func (e *engineImpl) holdUnsynced(unsynced map[string]string, stats map[string]specv1.AppStats, update map[string]specv1.AppInfo) {
+	for name, cause := range unsynced {
+		info, ok := update[name]
+		if !ok {
+			continue
+		}
+		delete(update, name)
+		stat := stats[name]
+		stat.Status = specv1.Pending
+		stat.Cause = cause
+		stats[name] = stat
+		e.log.Warn("application is not synced", log.Any("info", info), log.Any("cause", cause))
+	}
+}
+
+// triggerReport reports and applies at once
+func (e *engineImpl) triggerReport() {
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
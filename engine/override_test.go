@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/golang/mock/gomock"
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/mock"
+	"github.com/baetyl/baetyl/v2/store"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+func TestEngineImpl_overrides(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	mockNode := mock.NewMockNode(mockCtl)
+	mockSync := mock.NewMockSync(mockCtl)
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	eng := &engineImpl{
+		cfg:     config.Config{},
+		sto:     sto,
+		nod:     mockNode,
+		syn:     mockSync,
+		trigger: make(chan struct{}, 1),
+		log:     log.With(log.Any("engine", "test")),
+	}
+	mockNode.EXPECT().Get().Return(&specv1.Node{Desire: specv1.Desire{}}, nil).AnyTimes()
+
+	router := routing.New()
+	router.Get("/engine/overrides", utils.Wrapper(eng.GetOverrides))
+	router.Put("/engine/overrides/<kind>/<name>", utils.Wrapper(eng.SetOverride))
+	router.Delete("/engine/overrides/<kind>/<name>", utils.Wrapper(eng.DeleteOverride))
+	request := func(method, uri, body string) (int, []byte) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.SetBodyString(body)
+		router.HandleRequest(ctx)
+		return ctx.Response.StatusCode(), ctx.Response.Body()
+	}
+
+	web := specv1.Application{Name: "web", Version: "2", Volumes: []specv1.Volume{
+		{Name: "cfg", VolumeSource: specv1.VolumeSource{Config: &specv1.ObjectReference{Name: "cfg", Version: "1"}}},
+	}}
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, web.Name, web.Version), &web))
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, "old", "1"), &specv1.Application{Name: "old", Version: "1"}))
+
+	// the config is updated, and the local app refers to it without the version
+	code, body := request("PUT", "/engine/overrides/configs/cfg", `{"data":{"a":"b"}}`)
+	assert.Equal(t, 200, code, string(body))
+	var cfgOverride Override
+	assert.NoError(t, json.Unmarshal(body, &cfgOverride))
+	assert.True(t, strings.HasPrefix(cfgOverride.Version, "0-local-"))
+	code, body = request("PUT", "/engine/overrides/apps/local", `{"name":"local","volumes":[{"name":"cfg","config":{"name":"cfg"}}]}`)
+	assert.Equal(t, 200, code, string(body))
+	var appOverride Override
+	assert.NoError(t, json.Unmarshal(body, &appOverride))
+	assert.Equal(t, cfgOverride.Version, appOverride.App.Volumes[0].Config.Version)
+	code, _ = request("PUT", "/engine/overrides/apps/old?action=remove", "")
+	assert.Equal(t, 200, code)
+	code, body = request("PUT", "/engine/overrides/apps/bad", `{"name":"other"}`)
+	assert.Equal(t, 500, code)
+	assert.Contains(t, string(body), "app name (other) is not the name (bad) of the path")
+	code, _ = request("PUT", "/engine/overrides/secrets/sec", `{}`)
+	assert.Equal(t, 500, code)
+	assert.Len(t, eng.trigger, 1)
+
+	code, body = request("GET", "/engine/overrides", "")
+	assert.Equal(t, 200, code)
+	var list []Override
+	assert.NoError(t, json.Unmarshal(body, &list))
+	assert.Len(t, list, 3)
+	assert.Len(t, eng.localOverrides(), 3)
+	assert.Nil(t, eng.localOverrides()[0].App)
+
+	// the removed app is not desired, and the app using the local config is revised to a local version
+	dapps := []specv1.AppInfo{{Name: "old", Version: "1"}, {Name: "web", Version: "2"}}
+	res := eng.overrideApps(dapps)
+	assert.Len(t, res, 2)
+	assert.Equal(t, "web", res[0].Name)
+	assert.True(t, strings.HasPrefix(res[0].Version, "2-local-"))
+	assert.Equal(t, specv1.AppInfo{Name: "local", Version: appOverride.Version}, res[1])
+	var derived specv1.Application
+	assert.NoError(t, sto.Get(utils.MakeKey(specv1.KindApplication, "web", res[0].Version), &derived))
+	assert.Equal(t, cfgOverride.Version, derived.Volumes[0].Config.Version)
+
+	// the local versions are found in the store, and the apps synced before are used while the link is down
+	mockSync.EXPECT().SyncApps([]specv1.AppInfo{{Name: "old", Version: "1"}}).Return(nil, errors.New("link is down")).Times(1)
+	apps, unsynced, err := eng.syncApps([]specv1.AppInfo{{Name: "old", Version: "1"}, res[0], res[1]})
+	assert.NoError(t, err)
+	assert.Len(t, apps, 3)
+	assert.Empty(t, unsynced)
+
+	// the apps neither synced nor found in the store are held as pending
+	mockSync.EXPECT().SyncApps([]specv1.AppInfo{{Name: "new", Version: "1"}}).Return(nil, errors.New("link is down")).Times(1)
+	apps, unsynced, err = eng.syncApps([]specv1.AppInfo{{Name: "new", Version: "1"}, res[1]})
+	assert.NoError(t, err)
+	assert.Len(t, apps, 1)
+	assert.Equal(t, map[string]string{"new": "app (new) version (1) is not synced: link is down"}, unsynced)
+	stats := map[string]specv1.AppStats{}
+	update := map[string]specv1.AppInfo{"new": {Name: "new", Version: "1"}, "local": res[1]}
+	eng.holdUnsynced(unsynced, stats, update)
+	assert.Equal(t, map[string]specv1.AppInfo{"local": res[1]}, update)
+	assert.Equal(t, specv1.Pending, stats["new"].Status)
+	assert.Equal(t, unsynced["new"], stats["new"].Cause)
+
+	// the overrides are kept until the cloud desires other versions
+	for _, o := range eng.overrides() {
+		if o.Name == "old" || o.Name == "cfg" {
+			o.Base = "1"
+			assert.NoError(t, sto.Upsert(overrideKey(o.Kind, o.Name), o))
+		}
+	}
+	eng.reconcileOverrides(dapps)
+	assert.Len(t, eng.overrides(), 3)
+	web.Version = "3"
+	web.Volumes[0].Config.Version = "2"
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, web.Name, web.Version), &web))
+	eng.reconcileOverrides([]specv1.AppInfo{{Name: "old", Version: "2"}, {Name: "web", Version: "3"}})
+	list = nil
+	for _, o := range eng.overrides() {
+		list = append(list, *o)
+	}
+	assert.Len(t, list, 1)
+	assert.Equal(t, "local", list[0].Name)
+
+	// the override is reverted
+	code, _ = request("DELETE", "/engine/overrides/apps/local", "")
+	assert.Equal(t, 200, code)
+	assert.Empty(t, eng.overrides())
+	assert.Equal(t, dapps, eng.overrideApps(dapps))
+}
+
+func TestLocalVersion(t *testing.T) {
+	v1, err := localVersion("12", map[string]string{"a": "b"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(v1, "12-local-"))
+	assert.True(t, isLocalVersion(v1))
+	assert.False(t, isLocalVersion("12"))
+	v2, err := localVersion(v1, map[string]string{"a": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, v1, v2)
+	v3, err := localVersion("", map[string]string{"a": "c"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(v3, "0-local-"))
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	v2utils "github.com/baetyl/baetyl-go/v2/utils"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+// the actions of the apps in the plan
+const (
+	PlanInstall = "install"
+	PlanUpdate  = "update"
+	PlanDelete  = "delete"
+)
+
+// Plan the changes the engine makes for the desire of the node shadow, which are computed without applying
+type Plan struct {
+	Apps      []PlanApp      `json:"apps"`
+	Downloads []PlanDownload `json:"downloads"`
+	Conflicts []PlanConflict `json:"conflicts"`
+}
+
+// PlanApp the app to install, update or delete
+type PlanApp struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Previous string `json:"previous,omitempty"`
+	Action   string `json:"action"`
+	System   bool   `json:"system"`
+}
+
+// PlanDownload the resource to download before the app is applied, the objects are the config objects not downloaded yet
+type PlanDownload struct {
+	Kind    specv1.Kind `json:"kind"`
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Objects []string    `json:"objects,omitempty"`
+}
+
+// PlanConflict the app kept from being applied, such as by the dependencies, the maintenance windows, the port conflicts or the admission policy
+type PlanConflict struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+}
+
+// GetPlan returns the plan of the current node shadow
+func (e *engineImpl) GetPlan(_ *routing.Context) (interface{}, error) {
+	shadow, err := e.nod.Get()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &Plan{Apps: []PlanApp{}, Downloads: []PlanDownload{}, Conflicts: []PlanConflict{}}
+	for _, isSys := range []bool{true, false} {
+		ns := context.EdgeNamespace()
+		if isSys {
+			ns = context.EdgeSystemNamespace()
+		}
+		dapps := shadow.Desire.AppInfos(isSys)
+		if !isSys {
+			dapps = e.overrideApps(dapps)
+		}
+		dapps = e.rollbackApps(ns, dapps)
+		dapps, rapps := filterServiceApps(dapps, shadow.Report.AppInfos(isSys))
+		others := map[string]specv1.AppStats{}
+		for _, s := range shadow.Report.AppStats(!isSys) {
+			others[s.Name] = s
+		}
+		e.planApps(p, ns, isSys, dapps, rapps, shadow.Report.AppStats(isSys), others, shadow.Report["nodestats"], e.nodeWindows(shadow.Desire))
+	}
+	return p, nil
+}
+
+// planApps plans the apps of the namespace as reportAndApply does
+func (e *engineImpl) planApps(p *Plan, ns string, isSys bool, dapps, rapps []specv1.AppInfo, appStats []specv1.AppStats, others map[string]specv1.AppStats, nodeStats interface{}, windows []string) {
+	del, update := getDeleteAndUpdate(dapps, rapps)
+	running := map[string]string{}
+	for _, r := range rapps {
+		running[r.Name] = r.Version
+	}
+	for _, name := range sortedAppNames(del) {
+		p.Apps = append(p.Apps, PlanApp{Name: name, Version: del[name].Version, Action: PlanDelete, System: isSys})
+	}
+	for _, name := range sortedAppNames(update) {
+		app := PlanApp{Name: name, Version: update[name].Version, Action: PlanInstall, System: isSys}
+		if prev, ok := running[name]; ok {
+			app.Action, app.Previous = PlanUpdate, prev
+		}
+		p.Apps = append(p.Apps, app)
+	}
+
+	// the apps not synced yet are downloaded, and checked once they are found in the store
+	apps := map[string]specv1.Application{}
+	for _, info := range dapps {
+		var app specv1.Application
+		if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, info.Name, info.Version), &app); err != nil {
+			if _, ok := update[info.Name]; ok {
+				p.Downloads = append(p.Downloads, PlanDownload{Kind: specv1.KindApplication, Name: info.Name, Version: info.Version})
+			}
+			continue
+		}
+		apps[info.Name] = app
+		if _, ok := update[info.Name]; ok {
+			e.planVolumes(p, &app)
+		}
+	}
+
+	// the causes of the reported stats are cleared to tell the conflicts found by the checks
+	stats := map[string]specv1.AppStats{}
+	for _, s := range appStats {
+		s.Cause = ""
+		instances := map[string]specv1.InstanceStats{}
+		for k, ins := range s.InstanceStats {
+			ins.Cause = ""
+			instances[k] = ins
+		}
+		s.InstanceStats = instances
+		stats[s.Name] = s
+	}
+	pending := map[string]specv1.AppInfo{}
+	for k, v := range update {
+		pending[k] = v
+	}
+	e.skipRolledBack(ns, pending, stats)
+	// the apps not synced yet are admitted once they are downloaded
+	var synced []specv1.AppInfo
+	for _, info := range dapps {
+		if _, ok := apps[info.Name]; ok {
+			synced = append(synced, info)
+		}
+	}
+	e.checkAdmission(synced, apps, stats, pending)
+	checkDependencies(dapps, apps, stats, others, pending)
+	e.checkMaintenance(rapps, apps, stats, pending, windows, time.Now())
+	checkMultiAppPort(dapps, apps, stats, pending)
+	if !e.cfg.Engine.Resource.Disable {
+		checkResources(dapps, apps, e.runningSpecs(stats, others), nodeStats, stats, others, pending)
+	}
+	for _, name := range sortedAppNames(update) {
+		info := update[name]
+		if _, ok := pending[name]; !ok {
+			reason := stats[name].Cause
+			if reason == "" {
+				reason = stats[name].InstanceStats[name].Cause
+			}
+			p.Conflicts = append(p.Conflicts, PlanConflict{Name: name, Version: info.Version, Reason: reason})
+		}
+	}
+}
+
+// planVolumes plans the configs and secrets of the app not found in the store, and the config objects not downloaded
+func (e *engineImpl) planVolumes(p *Plan, app *specv1.Application) {
+	for _, v := range app.Volumes {
+		if cfg := v.VolumeSource.Config; cfg != nil {
+			var config specv1.Configuration
+			if err := e.sto.Get(utils.MakeKey(specv1.KindConfiguration, cfg.Name, cfg.Version), &config); err != nil {
+				p.addDownload(PlanDownload{Kind: specv1.KindConfiguration, Name: cfg.Name, Version: cfg.Version})
+				continue
+			}
+			if v2utils.DirExists(filepath.Join(e.objectHostPath, config.Name, config.Version)) {
+				continue
+			}
+			var objects []string
+			for k := range config.Data {
+				if specv1.IsConfigObject(k) {
+					objects = append(objects, strings.TrimPrefix(k, specv1.PrefixConfigObject))
+				}
+			}
+			if len(objects) > 0 {
+				sort.Strings(objects)
+				p.addDownload(PlanDownload{Kind: specv1.KindConfiguration, Name: cfg.Name, Version: cfg.Version, Objects: objects})
+			}
+		} else if sec := v.VolumeSource.Secret; sec != nil {
+			var secret specv1.Secret
+			if err := e.sto.Get(utils.MakeKey(specv1.KindSecret, sec.Name, sec.Version), &secret); err != nil {
+				p.addDownload(PlanDownload{Kind: specv1.KindSecret, Name: sec.Name, Version: sec.Version})
+			}
+		}
+	}
+}
+
+// addDownload adds the download once, the configs and secrets may be shared by the apps
+func (p *Plan) addDownload(d PlanDownload) {
+	for _, exist := range p.Downloads {
+		if exist.Kind == d.Kind && exist.Name == d.Name && exist.Version == d.Version {
+			return
+		}
+	}
+	p.Downloads = append(p.Downloads, d)
+}
+
+func sortedAppNames(infos map[string]specv1.AppInfo) []string {
+	var names []string
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/config"
+	"github.com/baetyl/baetyl/v2/store"
+	"github.com/baetyl/baetyl/v2/utils"
+)
+
+func TestEngineImpl_planApps(t *testing.T) {
+	sto, err := store.NewBoltHold(filepath.Join(t.TempDir(), "core.db"))
+	assert.NoError(t, err)
+	defer sto.Close()
+	policy := filepath.Join(t.TempDir(), "policy.yml")
+	assert.NoError(t, os.WriteFile(policy, []byte("denyPrivileged: true\n"), 0644))
+	eng := &engineImpl{
+		cfg:            config.Config{},
+		sto:            sto,
+		objectHostPath: t.TempDir(),
+		adm:            newAdmission(policy),
+		log:            log.With(log.Any("engine", "test")),
+	}
+
+	port := func(name, version string, hostPort int32) specv1.Application {
+		return specv1.Application{Name: name, Version: version, Services: []specv1.Service{{
+			Name:  "s",
+			Ports: []specv1.ContainerPort{{HostPort: hostPort, ContainerPort: 80}},
+		}}}
+	}
+	apps := []specv1.Application{
+		port("a", "v1", 80),
+		port("b", "v1", 80),
+		{Name: "c", Version: "v2", Volumes: []specv1.Volume{
+			{Name: "cfg", VolumeSource: specv1.VolumeSource{Config: &specv1.ObjectReference{Name: "cfg", Version: "1"}}},
+			{Name: "sec", VolumeSource: specv1.VolumeSource{Secret: &specv1.ObjectReference{Name: "sec", Version: "1"}}},
+		}},
+		// the app rejected by policy is reported with the rejection, even if its port collides too
+		{Name: "d", Version: "v1", Services: []specv1.Service{{
+			Name:            "s",
+			SecurityContext: &specv1.SecurityContext{Privileged: true},
+			Ports:           []specv1.ContainerPort{{HostPort: 80, ContainerPort: 80}},
+		}}},
+	}
+	for i := range apps {
+		assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindApplication, apps[i].Name, apps[i].Version), &apps[i]))
+	}
+	dapps := []specv1.AppInfo{
+		{Name: "a", Version: "v1"},
+		{Name: "b", Version: "v1"},
+		{Name: "c", Version: "v2"},
+		{Name: "d", Version: "v1"},
+		{Name: "e", Version: "v1"},
+	}
+	rapps := []specv1.AppInfo{
+		{Name: "a", Version: "v1"},
+		{Name: "c", Version: "v1"},
+		{Name: "f", Version: "v1"},
+	}
+	stats := []specv1.AppStats{{AppInfo: specv1.AppInfo{Name: "a", Version: "v1"}, Status: specv1.Running, Cause: "old cause"}}
+
+	p := &Plan{}
+	eng.planApps(p, "baetyl-edge", false, dapps, rapps, stats, nil, nil, nil)
+	assert.Equal(t, []PlanApp{
+		{Name: "f", Version: "v1", Action: PlanDelete},
+		{Name: "b", Version: "v1", Action: PlanInstall},
+		{Name: "c", Version: "v2", Previous: "v1", Action: PlanUpdate},
+		{Name: "d", Version: "v1", Action: PlanInstall},
+		{Name: "e", Version: "v1", Action: PlanInstall},
+	}, p.Apps)
+	assert.Equal(t, []PlanDownload{
+		{Kind: specv1.KindConfiguration, Name: "cfg", Version: "1"},
+		{Kind: specv1.KindSecret, Name: "sec", Version: "1"},
+		{Kind: specv1.KindApplication, Name: "e", Version: "v1"},
+	}, p.Downloads)
+	assert.Equal(t, []PlanConflict{
+		{Name: "b", Version: "v1", Reason: "port [80] in application [b] collide with service [a]"},
+		{Name: "d", Version: "v1", Reason: "app (d) version (v1) is rejected by policy: service (s) is privileged"},
+	}, p.Conflicts)
+
+	// the downloaded resources are not planned again
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindConfiguration, "cfg", "1"), &specv1.Configuration{Name: "cfg", Version: "1"}))
+	assert.NoError(t, sto.Upsert(utils.MakeKey(specv1.KindSecret, "sec", "1"), &specv1.Secret{Name: "sec", Version: "1"}))
+	p = &Plan{}
+	eng.planApps(p, "baetyl-edge", false, dapps, rapps, stats, nil, nil, nil)
+	assert.Equal(t, []PlanDownload{{Kind: specv1.KindApplication, Name: "e", Version: "v1"}}, p.Downloads)
+}
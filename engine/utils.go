@@ -2,10 +2,23 @@ package engine
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/mitchellh/mapstructure"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/baetyl/baetyl/v2/ami"
+	"github.com/baetyl/baetyl/v2/utils"
 )
 
+// insufficientResources the cause prefix of the apps which cannot fit in the free resources of the nodes
+const insufficientResources = "insufficient resources"
+
+// the resource names of the apps reported by other names in the node stats
+var resourceAliases = map[string]string{"ephemeral-storage": "disk"}
+
 func checkService(infos []specv1.AppInfo, apps map[string]specv1.Application, stats map[string]specv1.AppStats, update map[string]specv1.AppInfo) {
 	svcs := make(map[string][]string)
 	for _, info := range infos {
@@ -186,6 +199,9 @@ func checkMultiAppPort(infos []specv1.AppInfo, apps map[string]specv1.Applicatio
 					InstanceStats: map[string]specv1.InstanceStats{},
 				}
 			}
+			if stat.InstanceStats == nil {
+				stat.InstanceStats = map[string]specv1.InstanceStats{}
+			}
 			iStat, ok := stat.InstanceStats[app.Name]
 			if !ok {
 				iStat = specv1.InstanceStats{AppName: app.Name, Status: specv1.Unknown}
@@ -238,6 +254,9 @@ func checkMultiAppPort(infos []specv1.AppInfo, apps map[string]specv1.Applicatio
 					InstanceStats: map[string]specv1.InstanceStats{},
 				}
 			}
+			if stat.InstanceStats == nil {
+				stat.InstanceStats = map[string]specv1.InstanceStats{}
+			}
 			iStat, ok := stat.InstanceStats[aName]
 			if !ok {
 				iStat = specv1.InstanceStats{AppName: aName, Status: specv1.Unknown}
@@ -254,6 +273,313 @@ func checkMultiAppPort(infos []specv1.AppInfo, apps map[string]specv1.Applicatio
 	}
 }
 
+// checkResources keeps the apps which cannot fit in the free resources of the nodes from being applied.
+// The free resources are the allocatable resources of the nodes minus the requests, or the limits if not
+// set, of the running apps found in the running specs, and the running versions of the updated apps give
+// back their resources. The resources not reported by the node stats, such as gpu without the gpu stats,
+// are not checked
+func checkResources(infos []specv1.AppInfo, apps, running map[string]specv1.Application, nodeStats interface{}, stats, others map[string]specv1.AppStats, update map[string]specv1.AppInfo) {
+	free := allocatableResources(nodeStats)
+	if len(free) == 0 {
+		return
+	}
+	var nodes []string
+	for n := range free {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	instances := func(name string) map[string]specv1.InstanceStats {
+		if s, ok := stats[name]; ok {
+			return s.InstanceStats
+		}
+		return others[name].InstanceStats
+	}
+	for name, app := range running {
+		addDemand(free, nodes, &app, instances(name), -1)
+	}
+	// the apps are checked in the desired order, the resources of the apps fit are reserved
+	for _, info := range infos {
+		if _, ok := update[info.Name]; !ok {
+			continue
+		}
+		app, ok := apps[info.Name]
+		if !ok || app.System {
+			continue
+		}
+		demand := podDemand(&app)
+		if len(demand) == 0 {
+			continue
+		}
+		// the running version gives back its resources once replaced
+		prev, replaced := running[app.Name]
+		if replaced {
+			addDemand(free, nodes, &prev, instances(app.Name), 1)
+		}
+		cause := reserveResources(free, nodes, demand, appReplicas(&app))
+		if cause == "" {
+			continue
+		}
+		if replaced {
+			addDemand(free, nodes, &prev, instances(app.Name), -1)
+		}
+		stat, ok := stats[app.Name]
+		if !ok {
+			stat = specv1.AppStats{AppInfo: specv1.AppInfo{Name: app.Name, Version: app.Version}}
+		}
+		stat.Status = specv1.Pending
+		stat.Cause = fmt.Sprintf("%s for version (%s): %s", insufficientResources, app.Version, cause)
+		stats[app.Name] = stat
+		delete(update, app.Name)
+		delete(apps, app.Name)
+	}
+}
+
+// allocatableResources the allocatable resources of each node by the node stats, the capacity is taken
+// if the allocatable resources are not reported
+func allocatableResources(nodeStats interface{}) map[string]map[string]float64 {
+	nodes, ok := nodeStats.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	free := map[string]map[string]float64{}
+	for name, v := range nodes {
+		var ns *specv1.NodeStats
+		switch st := v.(type) {
+		case *specv1.NodeStats:
+			ns = st
+		case specv1.NodeStats:
+			ns = &st
+		default:
+			// the node stats in the shadow
+			var decoded specv1.NodeStats
+			if err := mapstructure.Decode(v, &decoded); err == nil {
+				ns = &decoded
+			}
+		}
+		if ns == nil {
+			continue
+		}
+		list := ns.Capacity
+		if ext, ok := ns.Extension.(map[string]interface{}); ok {
+			if allocatable := stringMap(ext[ami.NodeStatsAllocatable]); len(allocatable) > 0 {
+				list = allocatable
+			}
+		}
+		res := map[string]float64{}
+		for n, c := range list {
+			q, err := resource.ParseQuantity(c)
+			if err != nil {
+				continue
+			}
+			res[n] = q.AsApproximateFloat64()
+		}
+		if len(res) > 0 {
+			free[name] = res
+		}
+	}
+	return free
+}
+
+// stringMap the map of strings, which is decoded as the map of interfaces from the shadow
+func stringMap(v interface{}) map[string]string {
+	switch m := v.(type) {
+	case map[string]string:
+		return m
+	case map[string]interface{}:
+		res := map[string]string{}
+		for k, val := range m {
+			if s, ok := val.(string); ok {
+				res[k] = s
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// addDemand adds the demand of the app instances to the free resources of their nodes, the instances are
+// on the only node if their nodes are not reported
+func addDemand(free map[string]map[string]float64, nodes []string, app *specv1.Application, instances map[string]specv1.InstanceStats, sign float64) {
+	demand := podDemand(app)
+	if len(demand) == 0 {
+		return
+	}
+	placed := map[string]int{}
+	for _, ins := range instances {
+		node := ins.NodeName
+		if _, ok := free[node]; !ok {
+			if len(nodes) != 1 {
+				continue
+			}
+			node = nodes[0]
+		}
+		placed[node]++
+	}
+	if len(placed) == 0 && len(nodes) == 1 {
+		placed[nodes[0]] = appReplicas(app)
+	}
+	for node, count := range placed {
+		for n, v := range demand {
+			if f, ok := free[node][n]; ok {
+				free[node][n] = f + sign*v*float64(count)
+			}
+		}
+	}
+}
+
+func appReplicas(app *specv1.Application) int {
+	if app.Replica < 1 || app.Workload == specv1.WorkloadDaemonSet {
+		return 1
+	}
+	return app.Replica
+}
+
+// runningSpecs the specs of the running versions of the apps found in the store
+func (e *engineImpl) runningSpecs(stats ...map[string]specv1.AppStats) map[string]specv1.Application {
+	res := map[string]specv1.Application{}
+	if e.sto == nil {
+		return res
+	}
+	for _, m := range stats {
+		for name, s := range m {
+			if s.Version == "" {
+				continue
+			}
+			var app specv1.Application
+			if err := e.sto.Get(utils.MakeKey(specv1.KindApplication, name, s.Version), &app); err == nil {
+				res[name] = app
+			}
+		}
+	}
+	return res
+}
+
+// podDemand the resources requested by an instance of the app, the limit is taken if the request is not set.
+// The init services run one by one before the services, so the largest one counts if larger than the services
+func podDemand(app *specv1.Application) map[string]float64 {
+	demand := map[string]float64{}
+	for _, svc := range app.Services {
+		for n, v := range serviceDemand(&svc) {
+			demand[n] += v
+		}
+	}
+	for _, svc := range app.InitServices {
+		for n, v := range serviceDemand(&svc) {
+			if v > demand[n] {
+				demand[n] = v
+			}
+		}
+	}
+	return demand
+}
+
+func serviceDemand(svc *specv1.Service) map[string]float64 {
+	demand := map[string]float64{}
+	if svc.Resources == nil {
+		return demand
+	}
+	for _, list := range []map[string]string{svc.Resources.Limits, svc.Resources.Requests} {
+		for n, v := range list {
+			q, err := resource.ParseQuantity(v)
+			if err != nil || q.IsZero() {
+				continue
+			}
+			if alias, ok := resourceAliases[n]; ok {
+				n = alias
+			}
+			demand[n] = q.AsApproximateFloat64()
+		}
+	}
+	return demand
+}
+
+// reserveResources reserves the demand of the replicas on the nodes with the most room,
+// the shortage is described if the replicas cannot fit
+func reserveResources(free map[string]map[string]float64, nodes []string, demand map[string]float64, replicas int) string {
+	fit := map[string]int{}
+	total := 0
+	for _, node := range nodes {
+		fit[node] = fitReplicas(free[node], demand, replicas)
+		total += fit[node]
+	}
+	if total < replicas {
+		return describeShortage(free, demand, replicas, total)
+	}
+	for remaining := replicas; remaining > 0; {
+		most := nodes[0]
+		for _, node := range nodes {
+			if fit[node] > fit[most] {
+				most = node
+			}
+		}
+		k := fit[most]
+		if k > remaining {
+			k = remaining
+		}
+		for n, v := range demand {
+			if _, ok := free[most][n]; ok {
+				free[most][n] -= v * float64(k)
+			}
+		}
+		fit[most] -= k
+		remaining -= k
+	}
+	return ""
+}
+
+// fitReplicas how many replicas fit in the free resources of the node, at most the replicas
+func fitReplicas(free, demand map[string]float64, replicas int) int {
+	fit := replicas
+	for n, v := range demand {
+		f, ok := free[n]
+		if !ok {
+			continue
+		}
+		k := 0
+		if f > 0 {
+			k = int(f / v)
+		}
+		if k < fit {
+			fit = k
+		}
+	}
+	return fit
+}
+
+func describeShortage(free map[string]map[string]float64, demand map[string]float64, replicas, fit int) string {
+	var names []string
+	for n := range demand {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var shortages []string
+	for _, n := range names {
+		var total float64
+		var reported bool
+		for _, res := range free {
+			if f, ok := res[n]; ok && f > 0 {
+				total += f
+			}
+			_, ok := res[n]
+			reported = reported || ok
+		}
+		if need := demand[n] * float64(replicas); reported && need > total {
+			shortages = append(shortages, fmt.Sprintf("%s requested %s x %d, %s free", n, formatResource(n, demand[n]), replicas, formatResource(n, total)))
+		}
+	}
+	if len(shortages) == 0 {
+		return fmt.Sprintf("%d of %d replicas fit on the nodes", fit, replicas)
+	}
+	return strings.Join(shortages, "; ")
+}
+
+func formatResource(name string, v float64) string {
+	if name == "cpu" {
+		return resource.NewMilliQuantity(int64(v*1000), resource.DecimalSI).String()
+	}
+	return resource.NewQuantity(int64(v), resource.BinarySI).String()
+}
+
 // ensuring apps have same order in report and desire list
 func alignApps(reApps, deApps []specv1.AppInfo) []specv1.AppInfo {
 	if len(reApps) == 0 || len(deApps) == 0 {
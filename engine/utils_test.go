@@ -6,6 +6,8 @@ import (
 
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
 )
 
 func TestCheckService(t *testing.T) {
@@ -487,3 +489,104 @@ func TestCheckApps(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckResources(t *testing.T) {
+	nodeStats := map[string]interface{}{
+		// the usage changes all the time, which is not taken
+		"node1": &specv1.NodeStats{
+			Capacity:  map[string]string{"cpu": "4", "memory": "8Gi"},
+			Usage:     map[string]string{"cpu": "3", "memory": "7Gi"},
+			Extension: map[string]interface{}{ami.NodeStatsAllocatable: map[string]string{"cpu": "3500m", "memory": "7Gi"}},
+		},
+		"node2": &specv1.NodeStats{
+			Capacity: map[string]string{"cpu": "2", "memory": "4Gi"},
+			Usage:    map[string]string{"cpu": "2", "memory": "4Gi"},
+		},
+	}
+	svc := func(cpu, memory string) specv1.Service {
+		return specv1.Service{Name: "s", Resources: &specv1.Resources{
+			Requests: map[string]string{"cpu": cpu, "memory": memory},
+			Limits:   map[string]string{"cpu": "8", "nvidia.com/gpu": "1"},
+		}}
+	}
+	infos := []specv1.AppInfo{
+		{Name: "app1", Version: "v1"},
+		{Name: "app2", Version: "v1"},
+		{Name: "app3", Version: "v2"},
+		{Name: "app4", Version: "v1"},
+		{Name: "app5", Version: "v1"},
+	}
+	apps := map[string]specv1.Application{
+		// 2 replicas fit on node1
+		"app1": {Name: "app1", Version: "v1", Replica: 2, Services: []specv1.Service{svc("1", "1Gi")}},
+		// the free memory is enough in total but no node has room for an instance
+		"app2": {Name: "app2", Version: "v1", Services: []specv1.Service{svc("100m", "5Gi")}},
+		// the running version gives back its requests
+		"app3": {Name: "app3", Version: "v2", Services: []specv1.Service{svc("1500m", "1Gi")}},
+		"app4": {Name: "app4", Version: "v1", Replica: 2, Services: []specv1.Service{svc("2", "1Gi")}},
+		"app5": {Name: "app5", Version: "v1", System: true, Services: []specv1.Service{svc("8", "1Gi")}},
+	}
+	// the running apps reserve their requests on their nodes, the free resources are 3 cpu and 6Gi on node1, 1 cpu and 3Gi on node2
+	running := map[string]specv1.Application{
+		"app3": {Name: "app3", Version: "v1", Services: []specv1.Service{svc("1", "1Gi")}},
+		"sys":  {Name: "sys", Version: "v1", System: true, Services: []specv1.Service{svc("500m", "1Gi")}},
+	}
+	stats := map[string]specv1.AppStats{
+		"app3": {AppInfo: specv1.AppInfo{Name: "app3", Version: "v1"}, Status: specv1.Running, InstanceStats: map[string]specv1.InstanceStats{
+			"app3-1": {NodeName: "node2", Usage: map[string]string{"cpu": "2"}},
+		}},
+	}
+	others := map[string]specv1.AppStats{
+		"sys": {AppInfo: specv1.AppInfo{Name: "sys", Version: "v1"}, Status: specv1.Running, InstanceStats: map[string]specv1.InstanceStats{
+			"sys-1": {NodeName: "node1"},
+		}},
+	}
+	update := map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		update[info.Name] = info
+	}
+	checkResources(infos, apps, running, nodeStats, stats, others, update)
+	assert.Len(t, update, 3)
+	assert.Contains(t, update, "app1")
+	assert.Contains(t, update, "app3")
+	assert.Contains(t, update, "app5")
+	assert.Equal(t, specv1.Pending, stats["app2"].Status)
+	assert.Equal(t, "insufficient resources for version (v1): 0 of 1 replicas fit on the nodes", stats["app2"].Cause)
+	assert.Equal(t, specv1.Pending, stats["app4"].Status)
+	assert.Equal(t, "insufficient resources for version (v1): cpu requested 2 x 2, 1500m free", stats["app4"].Cause)
+	assert.Equal(t, specv1.Running, stats["app3"].Status)
+	assert.NotContains(t, apps, "app4")
+
+	// the result is the same whatever the usage is
+	nodeStats["node2"].(*specv1.NodeStats).Usage = map[string]string{"cpu": "0", "memory": "0"}
+	apps["app2"] = specv1.Application{Name: "app2", Version: "v1", Services: []specv1.Service{svc("100m", "5Gi")}}
+	apps["app4"] = specv1.Application{Name: "app4", Version: "v1", Replica: 2, Services: []specv1.Service{svc("2", "1Gi")}}
+	update = map[string]specv1.AppInfo{}
+	for _, info := range infos {
+		update[info.Name] = info
+	}
+	stats = map[string]specv1.AppStats{"app3": stats["app3"]}
+	checkResources(infos, apps, running, nodeStats, stats, others, update)
+	assert.Len(t, update, 3)
+	assert.NotContains(t, update, "app4")
+
+	// nothing is checked without the node stats
+	update = map[string]specv1.AppInfo{"app4": infos[3]}
+	apps["app4"] = specv1.Application{Name: "app4", Version: "v1", Replica: 2, Services: []specv1.Service{svc("2", "1Gi")}}
+	checkResources(infos, apps, nil, nil, map[string]specv1.AppStats{}, nil, update)
+	assert.Contains(t, update, "app4")
+}
+
+func TestAllocatableResources(t *testing.T) {
+	// the node stats decoded from the shadow
+	free := allocatableResources(map[string]interface{}{
+		"node1": map[string]interface{}{
+			"capacity":  map[string]interface{}{"cpu": "4", "memory": "8Gi"},
+			"extension": map[string]interface{}{ami.NodeStatsAllocatable: map[string]interface{}{"cpu": "3500m"}},
+		},
+		"node2": map[string]interface{}{
+			"capacity": map[string]interface{}{"cpu": "2"},
+		},
+	})
+	assert.Equal(t, map[string]map[string]float64{"node1": {"cpu": 3.5}, "node2": {"cpu": 2}}, free)
+}
@@ -11,7 +11,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/baetyl/baetyl-go/v2/context"
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/http"
 	"github.com/baetyl/baetyl-go/v2/log"
@@ -82,7 +81,7 @@ func NewActivate(cfg *config.Config) (*Activate, error) {
 		active.attrs[a.Name] = a.Value
 	}
 
-	active.ami, err = ami.NewAMI(context.RunMode(), cfg.AMI, nil)
+	active.ami, err = ami.NewAMI(ami.RunMode(), cfg.AMI, nil)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
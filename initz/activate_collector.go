@@ -11,8 +11,8 @@ import (
 	specV1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/shirou/gopsutil/v3/host"
 
+	"github.com/baetyl/baetyl/v2/ami"
 	"github.com/baetyl/baetyl/v2/ami/kube"
-
 	"github.com/baetyl/baetyl/v2/config"
 )
 
@@ -56,6 +56,11 @@ func (active *Activate) collect() (string, error) {
 		if !ok {
 			return "", errors.Trace(ErrGetMasterNodeInfo)
 		}
+	} else if mode == ami.RunModeDocker {
+		// docker engine only reports the node it runs on
+		for _, v := range infos {
+			info = v
+		}
 	}
 
 	nodeInfo, ok := info.(*specV1.NodeInfo)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	_ "github.com/baetyl/baetyl/v2/ami/docker"
 	_ "github.com/baetyl/baetyl/v2/ami/kube"
 	_ "github.com/baetyl/baetyl/v2/ami/native"
 	"github.com/baetyl/baetyl/v2/cmd"
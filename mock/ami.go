@@ -37,17 +37,17 @@ func (m *MockAMI) EXPECT() *MockAMIMockRecorder {
 }
 
 // ApplyApp mocks base method.
-func (m *MockAMI) ApplyApp(arg0 string, arg1 v1.Application, arg2 map[string]v1.Configuration, arg3 map[string]v1.Secret) error {
+func (m *MockAMI) ApplyApp(arg0 context.Context, arg1 string, arg2 v1.Application, arg3 map[string]v1.Configuration, arg4 map[string]v1.Secret) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ApplyApp", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "ApplyApp", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ApplyApp indicates an expected call of ApplyApp.
-func (mr *MockAMIMockRecorder) ApplyApp(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockAMIMockRecorder) ApplyApp(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyApp", reflect.TypeOf((*MockAMI)(nil).ApplyApp), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyApp", reflect.TypeOf((*MockAMI)(nil).ApplyApp), arg0, arg1, arg2, arg3, arg4)
 }
 
 // CollectNodeInfo mocks base method.
@@ -74,6 +74,66 @@ func (mr *MockEngineMockRecorder) GetServiceLog(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetServiceLog", reflect.TypeOf((*MockEngine)(nil).GetServiceLog), ctx)
 }
 
+// GetPlan mocks base method.
+func (m *MockEngine) GetPlan(ctx *routing.Context) (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPlan", ctx)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPlan indicates an expected call of GetPlan.
+func (mr *MockEngineMockRecorder) GetPlan(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPlan", reflect.TypeOf((*MockEngine)(nil).GetPlan), ctx)
+}
+
+// GetOverrides mocks base method.
+func (m *MockEngine) GetOverrides(ctx *routing.Context) (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOverrides", ctx)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOverrides indicates an expected call of GetOverrides.
+func (mr *MockEngineMockRecorder) GetOverrides(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOverrides", reflect.TypeOf((*MockEngine)(nil).GetOverrides), ctx)
+}
+
+// SetOverride mocks base method.
+func (m *MockEngine) SetOverride(ctx *routing.Context) (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetOverride", ctx)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetOverride indicates an expected call of SetOverride.
+func (mr *MockEngineMockRecorder) SetOverride(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetOverride", reflect.TypeOf((*MockEngine)(nil).SetOverride), ctx)
+}
+
+// DeleteOverride mocks base method.
+func (m *MockEngine) DeleteOverride(ctx *routing.Context) (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOverride", ctx)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteOverride indicates an expected call of DeleteOverride.
+func (mr *MockEngineMockRecorder) DeleteOverride(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOverride", reflect.TypeOf((*MockEngine)(nil).DeleteOverride), ctx)
+}
+
 // Collect mocks base method.
 func (m *MockEngine) Collect(ns string, isSys bool, desire v1.Desire) v1.Report {
 	m.ctrl.T.Helper()
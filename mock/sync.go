@@ -5,6 +5,7 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
 
 	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
@@ -140,15 +141,15 @@ func (mr *MockSyncMockRecorder) SyncDeviceModels(device ...interface{}) *gomock.
 }
 
 // SyncResource mocks base method.
-func (m *MockSync) SyncResource(arg0 v1.AppInfo) error {
+func (m *MockSync) SyncResource(arg0 context.Context, arg1 v1.AppInfo) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SyncResource", arg0)
+	ret := m.ctrl.Call(m, "SyncResource", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SyncResource indicates an expected call of SyncResource.
-func (mr *MockSyncMockRecorder) SyncResource(arg0 interface{}) *gomock.Call {
+func (mr *MockSyncMockRecorder) SyncResource(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncResource", reflect.TypeOf((*MockSync)(nil).SyncResource), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncResource", reflect.TypeOf((*MockSync)(nil).SyncResource), arg0, arg1)
 }
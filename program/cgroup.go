@@ -0,0 +1,181 @@
+package program
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+const (
+	CgroupControllers = "cgroup.controllers"
+	CgroupSubtree     = "cgroup.subtree_control"
+	CgroupProcs       = "cgroup.procs"
+	CgroupKill        = "cgroup.kill"
+	CgroupCPUMax      = "cpu.max"
+	CgroupCPUStat     = "cpu.stat"
+	CgroupMemoryMax   = "memory.max"
+	CgroupMemoryCur   = "memory.current"
+	CgroupPidsMax     = "pids.max"
+	CgroupPidsCur     = "pids.current"
+
+	// CgroupCPUPeriod the default period of cpu.max in microseconds
+	CgroupCPUPeriod = 100000
+	cgroupUnlimited = "max"
+)
+
+var (
+	ErrCgroupUnavailable = errors.New("cgroup v2 is unavailable")
+	cgroupSubtree        = []string{"cpu", "memory", "pids"}
+)
+
+// Cgroup a cgroup v2 directory which holds the processes of a service instance
+type Cgroup struct {
+	Path string
+}
+
+// CgroupLimits the resource limits of a cgroup, zero means no limit
+type CgroupLimits struct {
+	CPUQuota int64 // microseconds per CgroupCPUPeriod
+	Memory   int64 // bytes
+	Pids     int64
+}
+
+// CgroupStats the resource usage of a cgroup
+type CgroupStats struct {
+	CPUUsage time.Duration // cumulative
+	Memory   uint64
+	Pids     uint64
+}
+
+// NewCgroup creates the cgroup of a service instance under the slice of the unified hierarchy mounted at root,
+// it is only used if no service manager such as systemd owns the hierarchy
+func NewCgroup(root, slice, name string) (*Cgroup, error) {
+	if !utils.FileExists(filepath.Join(root, CgroupControllers)) {
+		return nil, errors.Trace(ErrCgroupUnavailable)
+	}
+	parent := filepath.Join(root, slice)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// processes only live in the leaves, so the controllers can be delegated down to them
+	for _, dir := range []string{root, parent} {
+		if err := enableControllers(dir); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	cg := &Cgroup{Path: filepath.Join(parent, name)}
+	if err := os.MkdirAll(cg.Path, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cg, nil
+}
+
+func enableControllers(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, CgroupControllers))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	available := map[string]bool{}
+	for _, c := range strings.Fields(string(data)) {
+		available[c] = true
+	}
+	var ctrls []string
+	for _, c := range cgroupSubtree {
+		if available[c] {
+			ctrls = append(ctrls, "+"+c)
+		}
+	}
+	if len(ctrls) == 0 {
+		return nil
+	}
+	return errors.Trace(os.WriteFile(filepath.Join(dir, CgroupSubtree), []byte(strings.Join(ctrls, " ")), 0644))
+}
+
+// SetLimits writes the limits into the controller files
+func (c *Cgroup) SetLimits(l CgroupLimits) error {
+	cpuMax := cgroupUnlimited
+	if l.CPUQuota > 0 {
+		cpuMax = strconv.FormatInt(l.CPUQuota, 10)
+	}
+	files := map[string]string{
+		CgroupCPUMax:    cpuMax + " " + strconv.Itoa(CgroupCPUPeriod),
+		CgroupMemoryMax: formatLimit(l.Memory),
+		CgroupPidsMax:   formatLimit(l.Pids),
+	}
+	for name, value := range files {
+		if err := os.WriteFile(filepath.Join(c.Path, name), []byte(value), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Join moves the process into the cgroup, the processes forked later are in the cgroup too
+func (c *Cgroup) Join(pid int) error {
+	return errors.Trace(os.WriteFile(filepath.Join(c.Path, CgroupProcs), []byte(strconv.Itoa(pid)), 0644))
+}
+
+// Stats reads the resource usage from the controller files
+func (c *Cgroup) Stats() (*CgroupStats, error) {
+	stats := &CgroupStats{}
+	data, err := os.ReadFile(filepath.Join(c.Path, CgroupCPUStat))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			stats.CPUUsage = time.Duration(usec) * time.Microsecond
+		}
+	}
+	if stats.Memory, err = readUint(filepath.Join(c.Path, CgroupMemoryCur)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if stats.Pids, err = readUint(filepath.Join(c.Path, CgroupPidsCur)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return stats, nil
+}
+
+// Remove kills the processes left in the cgroup and removes it
+func (c *Cgroup) Remove() error {
+	if !utils.DirExists(c.Path) {
+		return nil
+	}
+	// cgroup.kill is supported since linux 5.14
+	os.WriteFile(filepath.Join(c.Path, CgroupKill), []byte("1"), 0644)
+	var err error
+	for i := 0; i < 10; i++ {
+		// the cgroup is busy until the killed processes exit
+		if err = os.Remove(c.Path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.Trace(err)
+}
+
+func formatLimit(v int64) string {
+	if v <= 0 {
+		return cgroupUnlimited
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func readUint(p string) (uint64, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v, errors.Trace(err)
+}
@@ -0,0 +1,51 @@
+package program
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroup(t *testing.T) {
+	root := t.TempDir()
+	_, err := NewCgroup(root, "baetyl.slice", "ins")
+	assert.ErrorIs(t, err, ErrCgroupUnavailable)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, CgroupControllers), []byte("cpuset cpu io memory pids"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "baetyl.slice"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "baetyl.slice", CgroupControllers), []byte("cpu memory"), 0644))
+	cg, err := NewCgroup(root, "baetyl.slice", "ins")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "baetyl.slice", "ins"), cg.Path)
+	data, err := os.ReadFile(filepath.Join(root, CgroupSubtree))
+	assert.NoError(t, err)
+	assert.Equal(t, "+cpu +memory +pids", string(data))
+	data, err = os.ReadFile(filepath.Join(root, "baetyl.slice", CgroupSubtree))
+	assert.NoError(t, err)
+	assert.Equal(t, "+cpu +memory", string(data))
+
+	assert.NoError(t, cg.SetLimits(CgroupLimits{CPUQuota: 50000, Memory: 1024}))
+	for name, expected := range map[string]string{CgroupCPUMax: "50000 100000", CgroupMemoryMax: "1024", CgroupPidsMax: "max"} {
+		data, err = os.ReadFile(filepath.Join(cg.Path, name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+
+	assert.NoError(t, cg.Join(os.Getpid()))
+	data, err = os.ReadFile(filepath.Join(cg.Path, CgroupProcs))
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(data))
+
+	_, err = cg.Stats()
+	assert.Error(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(cg.Path, CgroupCPUStat), []byte("usage_usec 1500\nuser_usec 1000\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cg.Path, CgroupMemoryCur), []byte("4096\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(cg.Path, CgroupPidsCur), []byte("3\n"), 0644))
+	stats, err := cg.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, &CgroupStats{CPUUsage: 1500 * time.Microsecond, Memory: 4096, Pids: 3}, stats)
+}
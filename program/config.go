@@ -1,6 +1,17 @@
 package program
 
-import "github.com/baetyl/baetyl-go/v2/log"
+import (
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// the restart policies of the program
+const (
+	RestartAlways    = "Always"
+	RestartOnFailure = "OnFailure"
+	RestartNever     = "Never"
+)
 
 // Config is the program config.
 type Config struct {
@@ -12,10 +23,29 @@ type Config struct {
 	Exec string   `yaml:"exec" json:"exec"`
 	Args []string `yaml:"args" json:"args"`
 	Env  []string `yaml:"env" json:"env"`
+	// Cgroup the cgroup v2 path the program joins, empty means no cgroup
+	Cgroup  string        `yaml:"cgroup" json:"cgroup"`
+	Restart RestartConfig `yaml:"restart" json:"restart"`
+	// StopSignal is sent to the process group of the program on stop, which is killed if it does not exit in StopTimeout
+	StopSignal  string        `yaml:"stopSignal" json:"stopSignal" default:"SIGTERM"`
+	StopTimeout time.Duration `yaml:"stopTimeout" json:"stopTimeout" default:"10s"`
 
 	Logger log.Config `yaml:"logger" json:"logger"`
 }
 
+// RestartConfig the program is restarted with exponential backoff according to the policy,
+// it is in crash loop if it exits within MinUptime for CrashLoopThreshold times in a row
+type RestartConfig struct {
+	Policy             string        `yaml:"policy" json:"policy" default:"Always"`
+	MinBackoff         time.Duration `yaml:"minBackoff" json:"minBackoff" default:"1s"`
+	MaxBackoff         time.Duration `yaml:"maxBackoff" json:"maxBackoff" default:"5m"`
+	Factor             float64       `yaml:"factor" json:"factor" default:"2"`
+	MinUptime          time.Duration `yaml:"minUptime" json:"minUptime" default:"10s"`
+	CrashLoopThreshold int           `yaml:"crashLoopThreshold" json:"crashLoopThreshold" default:"5"`
+	// MaxRetries the max restart count on failure, 0 means no limit
+	MaxRetries int `yaml:"maxRetries" json:"maxRetries"`
+}
+
 type Entry struct {
 	Entry string `yaml:"entry" json:"entry" validate:"nonzero"`
 }
@@ -0,0 +1,10 @@
+package program
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setSubreaper makes the program runner adopt the orphaned grandchildren, so that they can be reaped
+func setSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
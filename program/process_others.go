@@ -0,0 +1,7 @@
+//go:build !linux
+
+package program
+
+func setSubreaper() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+
+package program
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignal(t *testing.T) {
+	sig, err := ParseSignal("SIGTERM")
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.SIGTERM, sig)
+	sig, err = ParseSignal("int")
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.SIGINT, sig)
+	_, err = ParseSignal("SIGXXX")
+	assert.Error(t, err)
+}
+
+func startProgram(t *testing.T, script string, stopTimeout time.Duration) (*Program, chan struct{}) {
+	dir := t.TempDir()
+	p := &Program{
+		cfg: Config{
+			Dir:         dir,
+			Args:        []string{"-c", script},
+			Restart:     RestartConfig{Policy: RestartNever},
+			StopSignal:  "SIGTERM",
+			StopTimeout: stopTimeout,
+		},
+		exit: make(chan struct{}),
+		log:  io.Discard,
+	}
+	// adopt the orphans as the program runner does
+	assert.NoError(t, setSubreaper())
+	done := make(chan struct{})
+	go func() {
+		p.run("/bin/sh")
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		st, err := LoadStatus(dir)
+		return err == nil && st.State == StateRunning
+	}, time.Second, 10*time.Millisecond)
+	// wait for the shell to set up its traps
+	time.Sleep(100 * time.Millisecond)
+	return p, done
+}
+
+func TestProgramStopGracefully(t *testing.T) {
+	p, done := startProgram(t, `trap 'echo term > stopped; exit 0' TERM; while true; do sleep 0.01; done`, 5*time.Second)
+	start := time.Now()
+	assert.NoError(t, p.Stop(nil))
+	<-done
+	assert.True(t, time.Since(start) < 5*time.Second)
+	data, err := os.ReadFile(filepath.Join(p.cfg.Dir, "stopped"))
+	assert.NoError(t, err)
+	assert.Equal(t, "term", strings.TrimSpace(string(data)))
+}
+
+func TestProgramStopTimeout(t *testing.T) {
+	p, done := startProgram(t, `trap '' TERM; sleep 100 & echo $! > child; while true; do sleep 0.01; done`, 200*time.Millisecond)
+	start := time.Now()
+	assert.NoError(t, p.Stop(nil))
+	<-done
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// the grandchild in the process group is killed too
+	data, err := os.ReadFile(filepath.Join(p.cfg.Dir, "child"))
+	assert.NoError(t, err)
+	pid := strings.TrimSpace(string(data))
+	// and reaped before the program returns, no zombie is left
+	_, err = os.Stat(filepath.Join("/proc", pid))
+	assert.True(t, os.IsNotExist(err))
+}
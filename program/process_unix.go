@@ -0,0 +1,86 @@
+//go:build !windows
+
+package program
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// ParseSignal parses the signal name, the prefix SIG is optional
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signals[name]
+	if !ok {
+		return nil, errors.Errorf("signal (%s) is not supported", name)
+	}
+	return sig, nil
+}
+
+// setProcessGroup runs the program in its own process group, so that the signals reach all its processes
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup sends the signal to the process group led by the process
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.Errorf("signal (%s) is not supported", sig)
+	}
+	err := syscall.Kill(-p.Pid, s)
+	if err == syscall.ESRCH {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// reapGroup waits the killed processes of the group until they are all gone or the timeout,
+// the orphans among them are adopted and reaped by the program runner as the subreaper
+func reapGroup(pgid int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		for {
+			var ws syscall.WaitStatus
+			pid, err := syscall.Wait4(-pgid, &ws, syscall.WNOHANG, nil)
+			if err != nil || pid <= 0 {
+				break
+			}
+		}
+		// the group is gone once its last process, zombie or not, is reaped
+		if syscall.Kill(-pgid, 0) == syscall.ESRCH || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// reapOrphans waits the exited grandchildren adopted by the program runner,
+// it must not be called while the program is running, which is waited by exec.Cmd
+func reapOrphans() {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package program
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ParseSignal the processes can only be killed on windows
+func ParseSignal(_ string) (os.Signal, error) {
+	return os.Kill, nil
+}
+
+func setProcessGroup(_ *exec.Cmd) {}
+
+func signalGroup(p *os.Process, _ os.Signal) error {
+	if err := p.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+func reapGroup(_ int, _ time.Duration) {}
+
+func reapOrphans() {}
@@ -3,21 +3,31 @@ package program
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/kardianos/service"
 )
 
+// reapTimeout how long the killed processes of the group are waited after the program exits
+const reapTimeout = 5 * time.Second
+
 type Program struct {
-	cfg  Config
-	cmd  *exec.Cmd
-	svc  service.Service
-	exit chan struct{}
-	log  io.Writer
+	cfg    Config
+	cmd    *exec.Cmd
+	done   chan struct{} // closed once the running program and its process group exit
+	svc    service.Service
+	exit   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	status Status
+	log    io.Writer
 }
 
 func (p *Program) Start(s service.Service) error {
@@ -28,39 +38,208 @@ func (p *Program) Start(s service.Service) error {
 		return errors.Trace(err)
 	}
 
+	// join the cgroup before the program starts, so that all its processes are limited
+	if p.cfg.Cgroup != "" {
+		cg := &Cgroup{Path: p.cfg.Cgroup}
+		if err = cg.Join(os.Getpid()); err != nil {
+			fmt.Fprintln(p.log, "Program failed to join cgroup:", err)
+		}
+	}
+
+	if err = setSubreaper(); err != nil {
+		fmt.Fprintln(p.log, "Program failed to set child subreaper:", err)
+	}
+
+	go p.run(fullExec)
+	return nil
+}
+
+func (p *Program) command(fullExec string) *exec.Cmd {
+	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" && strings.Contains(fullExec, ".py") {
-		p.cmd = exec.Command("python", fullExec)
+		cmd = exec.Command("python", fullExec)
 	} else if runtime.GOOS == "windows" && strings.Contains(fullExec, ".js") {
-		p.cmd = exec.Command("node", fullExec)
+		cmd = exec.Command("node", fullExec)
 	} else {
-		p.cmd = exec.Command(fullExec, p.cfg.Args...)
-		p.cmd.Dir = p.cfg.Dir
+		cmd = exec.Command(fullExec, p.cfg.Args...)
+		cmd.Dir = p.cfg.Dir
 	}
-	p.cmd.Env = append(os.Environ(), p.cfg.Env...)
-	p.cmd.Stderr = p.log
-	p.cmd.Stdout = p.log
-
-	go p.run()
-	return nil
+	cmd.Env = append(os.Environ(), p.cfg.Env...)
+	cmd.Stderr = p.log
+	cmd.Stdout = p.log
+	setProcessGroup(cmd)
+	return cmd
 }
-func (p *Program) run() {
+
+// run starts the program and restarts it according to the restart policy until the service stops
+func (p *Program) run(fullExec string) {
 	fmt.Fprintln(p.log, "Program starting", p.cfg.DisplayName)
-	defer p.Stop(p.svc)
+	rc := p.cfg.Restart
+	// the count of quick exits in a row
+	var failures int
+	for {
+		started := time.Now()
+		code, err := p.runOnce(fullExec)
+		select {
+		case <-p.exit:
+			return
+		default:
+		}
+		if err != nil {
+			fmt.Fprintln(p.log, "Program error:", err)
+			p.status.Error = err.Error()
+		} else {
+			p.status.Error = ""
+		}
+		p.status.Pid = 0
+		p.status.ExitCode = code
+		p.status.ExitTime = time.Now()
+		if time.Since(started) >= rc.MinUptime {
+			failures = 0
+		}
+		failures++
 
-	err := p.cmd.Run()
+		if !shouldRestart(rc, code, p.status.RestartCount) {
+			p.status.State = StateCompleted
+			if code != 0 {
+				p.status.State = StateFailed
+			}
+			p.writeStatus()
+			fmt.Fprintln(p.log, "Program exited", p.cfg.DisplayName, "state:", p.status.State)
+			return
+		}
+		p.status.State = StateBackOff
+		if rc.CrashLoopThreshold > 0 && failures >= rc.CrashLoopThreshold {
+			p.status.State = StateCrashLoopBackOff
+		}
+		p.writeStatus()
+
+		delay := backoff(rc, failures)
+		fmt.Fprintln(p.log, "Program exited with code", code, "restarting in", delay)
+		select {
+		case <-p.exit:
+			return
+		case <-time.After(delay):
+		}
+		p.status.RestartCount++
+	}
+}
+
+// runOnce runs the program and waits it to exit, the exit code is -1 if it fails to start
+func (p *Program) runOnce(fullExec string) (int, error) {
+	cmd := p.command(fullExec)
+	p.mu.Lock()
+	select {
+	case <-p.exit:
+		p.mu.Unlock()
+		return -1, nil
+	default:
+	}
+	err := cmd.Start()
 	if err != nil {
-		fmt.Fprintln(p.log, "Program error:", err)
+		p.mu.Unlock()
+		return -1, errors.Trace(err)
+	}
+	done := make(chan struct{})
+	p.cmd, p.done = cmd, done
+	p.mu.Unlock()
+
+	p.status.State = StateRunning
+	p.status.Pid = cmd.Process.Pid
+	p.status.StartTime = time.Now()
+	p.writeStatus()
+
+	err = cmd.Wait()
+	// the processes left in the group are killed and reaped, they won't work without the program
+	if e := signalGroup(cmd.Process, os.Kill); e != nil {
+		fmt.Fprintln(p.log, "Program failed to kill process group:", e)
+	}
+	reapGroup(cmd.Process.Pid, reapTimeout)
+	reapOrphans()
+	p.mu.Lock()
+	p.cmd = nil
+	p.mu.Unlock()
+	close(done)
+
+	if cmd.ProcessState == nil {
+		return -1, errors.Trace(err)
 	}
-	return
+	code := cmd.ProcessState.ExitCode()
+	if _, ok := err.(*exec.ExitError); ok {
+		// the exit code tells what happened
+		err = nil
+	}
+	return code, errors.Trace(err)
 }
+
+func (p *Program) writeStatus() {
+	if err := WriteStatus(p.cfg.Dir, &p.status); err != nil {
+		fmt.Fprintln(p.log, "Program failed to write status:", err)
+	}
+}
+
 func (p *Program) Stop(s service.Service) error {
-	close(p.exit)
+	p.once.Do(func() { close(p.exit) })
 
 	fmt.Fprintln(p.log, "Program stopping", p.cfg.DisplayName)
-	if p.cmd.Process != nil {
-		p.cmd.Process.Kill()
+	p.mu.Lock()
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return nil
 	}
-
-	os.Exit(0)
+	p.terminate(cmd.Process, done)
 	return nil
 }
+
+// terminate sends the stop signal to the process group of the program, and kills the group after the stop timeout
+func (p *Program) terminate(proc *os.Process, done <-chan struct{}) {
+	sig, err := ParseSignal(p.cfg.StopSignal)
+	if err != nil {
+		fmt.Fprintln(p.log, "Program stop signal is invalid:", err)
+		sig = os.Kill
+	}
+	if err = signalGroup(proc, sig); err != nil {
+		fmt.Fprintln(p.log, "Program failed to signal process group:", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(p.cfg.StopTimeout):
+		fmt.Fprintln(p.log, "Program is not stopped in", p.cfg.StopTimeout, "and is killed")
+		if err = signalGroup(proc, os.Kill); err != nil {
+			fmt.Fprintln(p.log, "Program failed to kill process group:", err)
+		}
+		<-done
+	}
+}
+
+// shouldRestart decides whether to restart the program by the policy after it exits
+func shouldRestart(rc RestartConfig, code, restarts int) bool {
+	switch rc.Policy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		if code == 0 {
+			return false
+		}
+		return rc.MaxRetries <= 0 || restarts < rc.MaxRetries
+	default:
+		return true
+	}
+}
+
+// backoff returns the delay before the next restart, which grows exponentially with the quick exits in a row
+func backoff(rc RestartConfig, failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	factor := rc.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	delay := float64(rc.MinBackoff) * math.Pow(factor, float64(failures-1))
+	if rc.MaxBackoff > 0 && delay > float64(rc.MaxBackoff) {
+		return rc.MaxBackoff
+	}
+	return time.Duration(delay)
+}
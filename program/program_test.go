@@ -0,0 +1,82 @@
+package program
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRestart(t *testing.T) {
+	assert.True(t, shouldRestart(RestartConfig{Policy: RestartAlways}, 0, 10))
+	assert.True(t, shouldRestart(RestartConfig{}, 1, 10))
+	assert.False(t, shouldRestart(RestartConfig{Policy: RestartNever}, 1, 0))
+	assert.False(t, shouldRestart(RestartConfig{Policy: RestartOnFailure}, 0, 0))
+	assert.True(t, shouldRestart(RestartConfig{Policy: RestartOnFailure}, 1, 10))
+	assert.True(t, shouldRestart(RestartConfig{Policy: RestartOnFailure, MaxRetries: 2}, 1, 1))
+	assert.False(t, shouldRestart(RestartConfig{Policy: RestartOnFailure, MaxRetries: 2}, 1, 2))
+}
+
+func TestBackoff(t *testing.T) {
+	rc := RestartConfig{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, Factor: 2}
+	assert.Equal(t, time.Second, backoff(rc, 0))
+	assert.Equal(t, time.Second, backoff(rc, 1))
+	assert.Equal(t, 4*time.Second, backoff(rc, 3))
+	assert.Equal(t, 10*time.Second, backoff(rc, 5))
+	assert.Equal(t, 10*time.Second, backoff(rc, 5000))
+}
+
+func TestProgramRun(t *testing.T) {
+	dir := t.TempDir()
+	p := &Program{
+		cfg: Config{
+			Dir:  dir,
+			Args: []string{"-c", "exit 3"},
+			Restart: RestartConfig{
+				Policy:             RestartOnFailure,
+				MinBackoff:         time.Millisecond,
+				MaxBackoff:         10 * time.Millisecond,
+				Factor:             2,
+				MinUptime:          time.Minute,
+				CrashLoopThreshold: 2,
+				MaxRetries:         2,
+			},
+		},
+		exit: make(chan struct{}),
+		log:  io.Discard,
+	}
+	p.run("/bin/sh")
+	st, err := LoadStatus(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, StateFailed, st.State)
+	assert.Equal(t, 3, st.ExitCode)
+	assert.Equal(t, 2, st.RestartCount)
+
+	p.cfg.Args = []string{"-c", "exit 0"}
+	p.status = Status{}
+	p.run("/bin/sh")
+	st, err = LoadStatus(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, StateCompleted, st.State)
+	assert.Equal(t, 0, st.RestartCount)
+
+	// crash loop
+	p.cfg.Args = []string{"-c", "exit 1"}
+	p.cfg.Restart.Policy = RestartAlways
+	p.status = Status{}
+	done := make(chan struct{})
+	go func() {
+		p.run("/bin/sh")
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		st, err = LoadStatus(dir)
+		return err == nil && st.State == StateCrashLoopBackOff
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Stop(nil))
+	<-done
+
+	_, err = LoadStatus(t.TempDir())
+	assert.Error(t, err)
+}
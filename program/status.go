@@ -0,0 +1,60 @@
+package program
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const ProgramStatusYaml = "status.yml"
+
+// the states of the program
+const (
+	StateRunning          = "Running"
+	StateBackOff          = "BackOff"
+	StateCrashLoopBackOff = "CrashLoopBackOff"
+	StateCompleted        = "Completed"
+	StateFailed           = "Failed"
+)
+
+// Status the running status of the program, which is written into the status file in the program dir
+type Status struct {
+	State        string    `yaml:"state" json:"state"`
+	Pid          int       `yaml:"pid" json:"pid"`
+	RestartCount int       `yaml:"restartCount" json:"restartCount"`
+	ExitCode     int       `yaml:"exitCode" json:"exitCode"`
+	ExitTime     time.Time `yaml:"exitTime,omitempty" json:"exitTime,omitempty"`
+	Error        string    `yaml:"error,omitempty" json:"error,omitempty"`
+	StartTime    time.Time `yaml:"startTime" json:"startTime"`
+	UpdateTime   time.Time `yaml:"updateTime" json:"updateTime"`
+}
+
+// LoadStatus reads the status file in the program dir
+func LoadStatus(dir string) (*Status, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ProgramStatusYaml))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	st := new(Status)
+	if err = yaml.Unmarshal(data, st); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return st, nil
+}
+
+// WriteStatus replaces the status file in the program dir atomically
+func WriteStatus(dir string, st *Status) error {
+	st.UpdateTime = time.Now()
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmp := filepath.Join(dir, ProgramStatusYaml+".tmp")
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, filepath.Join(dir, ProgramStatusYaml)))
+}
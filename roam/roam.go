@@ -32,7 +32,7 @@ type roamImpl struct {
 }
 
 func NewRoam(cfg config.Config) (Roam, error) {
-	mode := context.RunMode()
+	mode := ami.RunMode()
 
 	am, err := ami.NewAMI(mode, cfg.AMI, nil)
 	if err != nil {
@@ -6,6 +6,8 @@ import (
 
 	"github.com/baetyl/baetyl-go/v2/context"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
 )
 
 // TODO: move to the right place
@@ -33,7 +35,7 @@ func PrepareApp(hostPath, objectPath string, app *specv1.Application, cfgs map[s
 			},
 			{
 				Name:  context.KeyRunMode,
-				Value: context.RunMode(),
+				Value: ami.RunMode(),
 			},
 		}
 		app.Services[i].Env = append(app.Services[i].Env, env...)
@@ -6,8 +6,17 @@ import (
 	"github.com/baetyl/baetyl-go/v2/context"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl/v2/ami"
 )
 
+func TestPrepareAppRunMode(t *testing.T) {
+	t.Setenv(context.KeyRunMode, ami.RunModeDocker)
+	app := &specv1.Application{Name: "app1", Services: []specv1.Service{{Name: "s0"}}}
+	assert.NoError(t, PrepareApp(t.TempDir(), t.TempDir(), app, nil))
+	assert.Contains(t, app.Services[0].Env, specv1.Environment{Name: context.KeyRunMode, Value: ami.RunModeDocker})
+}
+
 func TestSync_PrepareApp(t *testing.T) {
 	t.Setenv(context.KeyNodeName, "node01")
 
@@ -98,7 +107,7 @@ func TestSync_PrepareApp(t *testing.T) {
 					},
 					{
 						Name:  context.KeyRunMode,
-						Value: context.RunMode(),
+						Value: ami.RunMode(),
 					},
 				},
 			},
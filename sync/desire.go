@@ -1,10 +1,14 @@
 package sync
 
 import (
+	gocontext "context"
+
 	"github.com/baetyl/baetyl-go/v2/context"
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	specv1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+
+	"github.com/baetyl/baetyl/v2/ami"
 )
 
 func (s *sync) SyncApps(infos []specv1.AppInfo) (map[string]specv1.Application, error) {
@@ -26,7 +30,8 @@ func (s *sync) SyncApps(infos []specv1.AppInfo) (map[string]specv1.Application,
 	return apps, nil
 }
 
-func (s *sync) SyncResource(info specv1.AppInfo) error {
+// SyncResource syncs the app with its configs and secrets, which stops before the next step once the context is done
+func (s *sync) SyncResource(ctx gocontext.Context, info specv1.AppInfo) error {
 	appInfo := map[string]string{info.Name: info.Version}
 	crds, err := s.syncResourceValues(s.genResourceInfos(specv1.KindApplication, appInfo))
 	if err != nil {
@@ -51,6 +56,9 @@ func (s *sync) SyncResource(info specv1.AppInfo) error {
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
 	crds, err = s.syncResourceValues(s.genResourceInfos(specv1.KindConfiguration, cInfo))
 	if err != nil {
 		s.log.Error("failed to sync configuration resource", log.Error(err))
@@ -66,6 +74,9 @@ func (s *sync) SyncResource(info specv1.AppInfo) error {
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
 	crds, err = s.syncResourceValues(s.genResourceInfos(specv1.KindSecret, sInfo))
 	if err != nil {
 		s.log.Error("failed to sync secret resource", log.Error(err))
@@ -81,7 +92,7 @@ func (s *sync) SyncResource(info specv1.AppInfo) error {
 	}
 
 	for _, app := range apps {
-		err = s.processVolumes(app.Volumes, configs, secrets)
+		err = s.processVolumes(ctx, app.Volumes, configs, secrets)
 		if err != nil {
 			s.log.Error("failed to process volumes", log.Error(err))
 			return errors.Trace(err)
@@ -106,7 +117,7 @@ func (s *sync) syncResourceValues(crds []specv1.ResourceInfo) ([]specv1.Resource
 		Content:  specv1.LazyValue{Value: specv1.DesireRequest{Infos: crds}},
 	}
 	// only for native mode
-	if context.RunMode() == context.RunModeNative {
+	if ami.RunMode() == context.RunModeNative {
 		msg.Metadata["x-baetyl-platform"] = context.PlatformString()
 	}
 	res, err := s.link.Request(msg)
@@ -122,10 +133,10 @@ func (s *sync) syncResourceValues(crds []specv1.ResourceInfo) ([]specv1.Resource
 	return desire.Values, nil
 }
 
-func (s *sync) processVolumes(volumes []specv1.Volume, configs map[string]*specv1.Configuration, secrets map[string]*specv1.Secret) error {
+func (s *sync) processVolumes(ctx gocontext.Context, volumes []specv1.Volume, configs map[string]*specv1.Configuration, secrets map[string]*specv1.Secret) error {
 	for i := range volumes {
 		if cfg := volumes[i].VolumeSource.Config; cfg != nil && configs[cfg.Name] != nil {
-			err := s.processConfiguration(configs[cfg.Name])
+			err := s.processConfiguration(ctx, configs[cfg.Name])
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -139,8 +150,8 @@ func (s *sync) processVolumes(volumes []specv1.Volume, configs map[string]*specv
 	return nil
 }
 
-func (s *sync) processConfiguration(cfg *specv1.Configuration) error {
-	err := DownloadConfig(s.download, s.cfg.Sync.Download.Path, cfg)
+func (s *sync) processConfiguration(ctx gocontext.Context, cfg *specv1.Configuration) error {
+	err := DownloadConfig(ctx, s.download, s.cfg.Sync.Download.Path, cfg)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	gocontext "context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -100,13 +101,13 @@ func TestSyncProcessConfiguration(t *testing.T) {
 		log:      log.With(log.Any("test", "sync")),
 	}
 	cfg := &specv1.Configuration{Name: "cfg", Version: "c1"}
-	err = syn.processConfiguration(cfg)
+	err = syn.processConfiguration(gocontext.Background(), cfg)
 	assert.NoError(t, err)
 	var expectedCfg specv1.Configuration
 	err = sto.Get(makeKey(specv1.KindConfiguration, "cfg", "c1"), &expectedCfg)
 	assert.NoError(t, err)
 	cfg.Name = ""
-	err = syn.processConfiguration(cfg)
+	err = syn.processConfiguration(gocontext.Background(), cfg)
 	assert.Error(t, err)
 	cfg.Name = "cfg"
 
@@ -124,7 +125,7 @@ func TestSyncProcessConfiguration(t *testing.T) {
 	cfg.Data = map[string]string{
 		"_object_file2": string(objData),
 	}
-	err = syn.processConfiguration(cfg)
+	err = syn.processConfiguration(gocontext.Background(), cfg)
 	assert.NoError(t, err)
 	hostPath := filepath.Join(dir, "cfg", "c1")
 	data, err := os.ReadFile(filepath.Join(hostPath, "file2"))
@@ -134,7 +135,7 @@ func TestSyncProcessConfiguration(t *testing.T) {
 	cfg.Data = map[string]string{
 		"_object_file3": "wrong",
 	}
-	err = syn.processConfiguration(cfg)
+	err = syn.processConfiguration(gocontext.Background(), cfg)
 	assert.Error(t, err)
 }
 
@@ -265,7 +266,7 @@ func TestSyncResources(t *testing.T) {
 		nod:   nod,
 	}
 
-	err = syn.SyncResource(specv1.AppInfo{Name: "desire-app", Version: "v1"})
+	err = syn.SyncResource(gocontext.Background(), specv1.AppInfo{Name: "desire-app", Version: "v1"})
 	var appRes specv1.Application
 	err = sto.Get(makeKey(specv1.KindApplication, appName, appVer), &appRes)
 	assert.NoError(t, err)
@@ -280,6 +281,6 @@ func TestSyncResources(t *testing.T) {
 	assert.Equal(t, secRes, sec)
 
 	link.EXPECT().Request(gomock.Any()).Return(nil, errors.New("failed to sync resource"))
-	err = syn.SyncResource(specv1.AppInfo{})
+	err = syn.SyncResource(gocontext.Background(), specv1.AppInfo{})
 	assert.Error(t, err)
 }
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	gocontext "context"
 	"encoding/json"
 	"io"
 	gohttp "net/http"
@@ -39,7 +40,8 @@ func FilterConfig(cfg *specv1.Configuration) {
 	}
 }
 
-func DownloadConfig(cli *http.Client, objectPath string, cfg *specv1.Configuration) error {
+// DownloadConfig downloads the objects of the config, the download is stopped once the context is done
+func DownloadConfig(ctx gocontext.Context, cli *http.Client, objectPath string, cfg *specv1.Configuration) error {
 	for k, v := range cfg.Data {
 		if !specv1.IsConfigObject(k) {
 			continue
@@ -69,7 +71,7 @@ func DownloadConfig(cli *http.Client, objectPath string, cfg *specv1.Configurati
 			filename = filepath.Join(dir, strings.TrimPrefix(k, specv1.PrefixConfigObject))
 		}
 
-		err = downloadObject(cli, obj, dir, filename, obj.Unpack)
+		err = downloadObject(ctx, cli, obj, dir, filename, obj.Unpack)
 		if err != nil {
 			os.RemoveAll(dir)
 			return errors.Trace(err)
@@ -91,7 +93,7 @@ func DownloadConfig(cli *http.Client, objectPath string, cfg *specv1.Configurati
 	return nil
 }
 
-func downloadObject(cli *http.Client, obj *specv1.ConfigurationObject, dir, name, unpack string) error {
+func downloadObject(ctx gocontext.Context, cli *http.Client, obj *specv1.ConfigurationObject, dir, name, unpack string) error {
 	lockfile, err := os.OpenFile(name+".baetyl-lock", os.O_CREATE|os.O_RDWR, 0755)
 	if err != nil {
 		return err
@@ -122,11 +124,18 @@ func downloadObject(cli *http.Client, obj *specv1.ConfigurationObject, dir, name
 	if obj.Token != "" {
 		headers["x-bce-security-token"] = obj.Token
 	}
+	if err = ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
 	log.L().Debug("start get file", log.Any("name", name))
 	resp, err := cli.GetURL(obj.URL, headers)
 	if err != nil || resp == nil {
 		// retry
-		time.Sleep(time.Second)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
 		resp, err = cli.GetURL(obj.URL, headers)
 		if err != nil || resp == nil {
 			return errors.Errorf("failed to download config object (%s) url (%s): %v", name, obj.URL, err)
@@ -136,6 +145,16 @@ func downloadObject(cli *http.Client, obj *specv1.ConfigurationObject, dir, name
 		return errors.Errorf("failed to download config object (%s): [%d] %s", name, resp.StatusCode, resp.Status)
 	}
 	defer resp.Body.Close()
+	// the body is closed to stop the download once the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
 	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0755)
 	if err = file.Truncate(0); err != nil {
 		return errors.Trace(err)
@@ -150,6 +169,9 @@ func downloadObject(cli *http.Client, obj *specv1.ConfigurationObject, dir, name
 
 	log.L().Debug("begin to download file ", log.Any("name", name))
 	if _, err = io.Copy(file, io.TeeReader(resp.Body, counter)); err != nil {
+		if ctx.Err() != nil {
+			return errors.Trace(ctx.Err())
+		}
 		log.L().Error("failed to download config object file", log.Error(err))
 		return errors.Errorf("failed to download config object file (%s): %v", name, err)
 	}
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	gocontext "context"
 	"fmt"
 	"math/rand"
 	"os"
@@ -93,25 +94,25 @@ func TestObject_DownloadObject(t *testing.T) {
 		MD5: md5,
 	}
 	// already exist
-	err = downloadObject(cli, obj, dir, file1, "")
+	err = downloadObject(gocontext.Background(), cli, obj, dir, file1, "")
 	assert.NoError(t, err)
 
 	// normal download
 	file2 := filepath.Join(dir, "file2")
-	err = downloadObject(cli, obj, dir, file2, "")
+	err = downloadObject(gocontext.Background(), cli, obj, dir, file2, "")
 	assert.NoError(t, err)
 
 	// invalid url
 	file3 := filepath.Join(dir, "invalidUrl")
 	obj.URL = "http:xxx"
-	err = downloadObject(cli, obj, dir, file3, "")
+	err = downloadObject(gocontext.Background(), cli, obj, dir, file3, "")
 	assert.Error(t, err)
 	obj.URL = objMs.URL
 
 	// not zip file
 	file4 := filepath.Join(dir, "file4")
 	obj.MD5 = md5
-	err = downloadObject(cli, obj, dir, file4, "zip")
+	err = downloadObject(gocontext.Background(), cli, obj, dir, file4, "zip")
 	assert.Error(t, err)
 
 	// download file not exist (multiple routine)
@@ -121,7 +122,7 @@ func TestObject_DownloadObject(t *testing.T) {
 		wg.Add(1)
 		go func(wg *gosync.WaitGroup) {
 			time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
-			err := downloadObject(cli, obj, dir, file5, "")
+			err := downloadObject(gocontext.Background(), cli, obj, dir, file5, "")
 			assert.NoError(t, err)
 			wg.Done()
 		}(&wg)
@@ -138,7 +139,7 @@ func TestObject_DownloadObject(t *testing.T) {
 		wg.Add(1)
 		go func(wg *gosync.WaitGroup) {
 			time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
-			err := downloadObject(cli, obj, dir, file6, "")
+			err := downloadObject(gocontext.Background(), cli, obj, dir, file6, "")
 			assert.NoError(t, err)
 			wg.Done()
 		}(&wg)
@@ -155,7 +156,7 @@ func TestObject_DownloadObject(t *testing.T) {
 		wg.Add(1)
 		go func(wg *gosync.WaitGroup) {
 			time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
-			err := downloadObject(cli, obj, dir, file7, "")
+			err := downloadObject(gocontext.Background(), cli, obj, dir, file7, "")
 			assert.NoError(t, err)
 			wg.Done()
 		}(&wg)
@@ -164,4 +165,11 @@ func TestObject_DownloadObject(t *testing.T) {
 	res, err = os.ReadFile(file7)
 	assert.NoError(t, err)
 	assert.Equal(t, res, content)
+
+	// the download is stopped once the context is done
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+	err = downloadObject(ctx, cli, obj, dir, filepath.Join(dir, "file8"), "")
+	assert.ErrorIs(t, err, gocontext.Canceled)
+	assert.NoFileExists(t, filepath.Join(dir, "file8"))
 }
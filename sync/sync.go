@@ -1,6 +1,7 @@
 package sync
 
 import (
+	gocontext "context"
 	"os"
 	"time"
 
@@ -55,7 +56,7 @@ type Sync interface {
 	Start()
 	Close()
 	Report(r v1.Report) (v1.Desire, error)
-	SyncResource(v1.AppInfo) error
+	SyncResource(gocontext.Context, v1.AppInfo) error
 	SyncApps(infos []v1.AppInfo) (map[string]v1.Application, error)
 	LinkState(ctx *routing.Context) (interface{}, error)
 	SyncDeviceModels(device ...string) (map[string]DeviceModel, error)